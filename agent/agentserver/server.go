@@ -21,23 +21,45 @@ import (
 	"net/http"
 	_ "net/http/pprof" // Registers /debug/pprof endpoints in http.DefaultServeMux.
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/memsize"
 
 	"github.com/pressly/chi"
 	"github.com/uber-go/tally"
 )
 
 // Config defines Server configuration.
-type Config struct{}
+type Config struct {
+
+	// EnableStreaming streams blobs to clients as their pieces are downloaded,
+	// instead of waiting for blobs to finish downloading before serving them.
+	EnableStreaming bool `yaml:"enable_streaming"`
+
+	// StreamChunkSize is the number of bytes a streaming download waits for
+	// before writing them to the client.
+	StreamChunkSize int64 `yaml:"stream_chunk_size"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.StreamChunkSize == 0 {
+		c.StreamChunkSize = int64(4 * memsize.MB)
+	}
+	return c
+}
 
 // Server defines the agent HTTP server.
 type Server struct {
@@ -47,6 +69,7 @@ type Server struct {
 	sched     scheduler.ReloadableScheduler
 	tags      tagclient.Client
 	dockerCli dockerdaemon.DockerClient
+	netevents *networkevent.RingBuffer
 }
 
 // New creates a new Server.
@@ -56,13 +79,16 @@ func New(
 	cads *store.CADownloadStore,
 	sched scheduler.ReloadableScheduler,
 	tags tagclient.Client,
-	dockerCli dockerdaemon.DockerClient) *Server {
+	dockerCli dockerdaemon.DockerClient,
+	netevents *networkevent.RingBuffer) *Server {
+
+	config = config.applyDefaults()
 
 	stats = stats.Tagged(map[string]string{
 		"module": "agentserver",
 	})
 
-	return &Server{config, stats, cads, sched, tags, dockerCli}
+	return &Server{config, stats, cads, sched, tags, dockerCli, netevents}
 }
 
 // Handler returns the HTTP handler.
@@ -88,6 +114,8 @@ func (s *Server) Handler() http.Handler {
 
 	r.Get("/x/blacklist", handler.Wrap(s.getBlacklistHandler))
 
+	r.Get("/x/networkevents", handler.Wrap(s.getNetworkEventsHandler))
+
 	// Serves /debug/pprof endpoints.
 	r.Mount("/", http.DefaultServeMux)
 
@@ -124,6 +152,21 @@ func (s *Server) downloadBlobHandler(w http.ResponseWriter, r *http.Request) err
 	f, err := s.cads.Cache().GetFileReader(d.Hex())
 	if err != nil {
 		if os.IsNotExist(err) || s.cads.InDownloadError(err) {
+			policy := httputil.GetQueryArg(r, "piece_request_policy", "")
+			if policy != "" {
+				if err := piecerequest.ValidatePolicy(policy); err != nil {
+					return handler.Errorf("%s", err).Status(http.StatusBadRequest)
+				}
+			}
+			if offset, length, ok := parseByteRange(r.Header.Get("Range")); ok {
+				return s.serveRange(w, r, namespace, d, offset, length, policy)
+			}
+			if s.config.EnableStreaming {
+				if policy == "" {
+					policy = piecerequest.SequentialPolicy
+				}
+				return s.streamBlob(w, namespace, d, policy)
+			}
 			if err := s.sched.Download(namespace, d); err != nil {
 				if err == scheduler.ErrTorrentNotFound {
 					return handler.ErrorStatus(http.StatusNotFound)
@@ -138,12 +181,138 @@ func (s *Server) downloadBlobHandler(w http.ResponseWriter, r *http.Request) err
 			return handler.Errorf("store: %s", err)
 		}
 	}
-	if _, err := io.Copy(w, f); err != nil {
-		return fmt.Errorf("copy file: %s", err)
+	defer f.Close()
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+// streamBlob serves a blob which is not yet cached while it downloads, writing
+// each chunk to the client as soon as the pieces covering it are downloaded.
+// This overlaps the transfer with whatever the client does with the bytes,
+// e.g. decompressing layers. The torrent uses the given piece request policy,
+// which is sequential unless the client requests otherwise.
+func (s *Server) streamBlob(
+	w http.ResponseWriter, namespace string, d core.Digest, policy string) error {
+
+	chunk := s.config.StreamChunkSize
+
+	// The full download runs alongside the chunks, such that download metrics
+	// are recorded once the whole blob completes.
+	done := make(chan error, 1)
+	go func() { done <- s.sched.Download(namespace, d) }()
+
+	// Errors before any bytes are written can still be reported to the client.
+	if err := s.sched.DownloadRange(namespace, d, 0, chunk, policy); err != nil {
+		<-done
+		if err == scheduler.ErrTorrentNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("download torrent: %s", err)
+	}
+	// The blob may be moved to the cache at any time, so read it from
+	// whichever directory it is in.
+	f, err := s.cads.Any().GetFileReader(d.Hex())
+	if err != nil {
+		return handler.Errorf("store: %s", err)
+	}
+	defer f.Close()
+	info, err := s.cads.Any().GetFileStat(d.Hex())
+	if err != nil {
+		return handler.Errorf("store: %s", err)
+	}
+	size := info.Size()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+
+	for offset := int64(0); offset < size; offset += chunk {
+		if offset > 0 {
+			if err := s.sched.DownloadRange(namespace, d, offset, chunk, policy); err != nil {
+				// Headers have already been sent, so the client can only
+				// detect the failure from the truncated body.
+				log.With("namespace", namespace, "digest", d).Errorf(
+					"Error streaming blob at offset %d: %s", offset, err)
+				<-done
+				return nil
+			}
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(f, offset, chunk)); err != nil {
+			// The download continues in the background.
+			log.With("namespace", namespace, "digest", d).Infof(
+				"Error writing streamed blob at offset %d: %s", offset, err)
+			return nil
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	// All pieces are downloaded, so the full download completes promptly.
+	if err := <-done; err != nil {
+		log.With("namespace", namespace, "digest", d).Errorf(
+			"Error completing streamed blob: %s", err)
 	}
 	return nil
 }
 
+// serveRange serves a range request for a blob which is not yet cached by
+// downloading only the pieces covering the range, such that lazy-pulling
+// clients are not blocked on the full blob. The rest of the blob continues
+// downloading in the background, using the given piece request policy if set.
+func (s *Server) serveRange(
+	w http.ResponseWriter, r *http.Request,
+	namespace string, d core.Digest, offset, length int64, policy string) error {
+
+	if err := s.sched.DownloadRange(namespace, d, offset, length, policy); err != nil {
+		if err == scheduler.ErrTorrentNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("download torrent range: %s", err)
+	}
+	// The blob may be moved to the cache at any time, so read it from
+	// whichever directory it is in.
+	f, err := s.cads.Any().GetFileReader(d.Hex())
+	if err != nil {
+		return handler.Errorf("store: %s", err)
+	}
+	defer f.Close()
+
+	// Avoid sniffing the content type, since the start of the blob may not be
+	// downloaded yet.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+// parseByteRange parses a Range header of a single "bytes=<first>-[<last>]"
+// range. Returns a negative length if last is omitted. Suffix and multipart
+// ranges are not supported.
+func parseByteRange(h string) (offset, length int64, ok bool) {
+	if !strings.HasPrefix(h, "bytes=") {
+		return 0, 0, false
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(h, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+	if parts[1] == "" {
+		return first, -1, true
+	}
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last - first + 1, true
+}
+
 func (s *Server) deleteBlobHandler(w http.ResponseWriter, r *http.Request) error {
 	d, err := parseDigest(r)
 	if err != nil {
@@ -155,7 +324,8 @@ func (s *Server) deleteBlobHandler(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
-// preloadTagHandler triggers docker daemon to download specified docker image.
+// preloadTagHandler triggers the container runtime (docker daemon or
+// containerd) to download specified docker image.
 func (s *Server) preloadTagHandler(w http.ResponseWriter, r *http.Request) error {
 	tag, err := httputil.ParseParam(r, "tag")
 	if err != nil {
@@ -169,7 +339,7 @@ func (s *Server) preloadTagHandler(w http.ResponseWriter, r *http.Request) error
 	if err := s.dockerCli.PullImage(
 		context.Background(), repo, tag); err != nil {
 
-		return handler.Errorf("trigger docker pull: %s", err)
+		return handler.Errorf("trigger image pull: %s", err)
 	}
 	return nil
 }
@@ -205,6 +375,40 @@ func (s *Server) getBlacklistHandler(w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+// getNetworkEventsHandler returns recent network events, optionally filtered
+// by torrent info hash, event names, time and count.
+func (s *Server) getNetworkEventsHandler(w http.ResponseWriter, r *http.Request) error {
+	params := r.URL.Query()
+	q := networkevent.Query{
+		Torrent: params.Get("torrent"),
+	}
+	for _, name := range params["event"] {
+		q.Names = append(q.Names, networkevent.Name(name))
+	}
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return handler.Errorf("parse since: %s", err).Status(http.StatusBadRequest)
+		}
+		q.Since = t
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return handler.Errorf("parse limit: %s", err).Status(http.StatusBadRequest)
+		}
+		q.Limit = n
+	}
+	events := s.netevents.Query(q)
+	if events == nil {
+		events = []*networkevent.Event{}
+	}
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
 func parseDigest(r *http.Request) (core.Digest, error) {
 	raw, err := httputil.ParseParam(r, "digest")
 	if err != nil {
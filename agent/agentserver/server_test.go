@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"testing"
 	"time"
@@ -28,12 +29,15 @@ import (
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
 	mocktagclient "github.com/uber/kraken/mocks/build-index/tagclient"
 	mockdockerdaemon "github.com/uber/kraken/mocks/lib/dockerdaemon"
 	mockscheduler "github.com/uber/kraken/mocks/lib/torrent/scheduler"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/memsize"
 	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -46,6 +50,7 @@ type serverMocks struct {
 	sched     *mockscheduler.MockReloadableScheduler
 	tags      *mocktagclient.MockClient
 	dockerCli *mockdockerdaemon.MockDockerClient
+	netevents *networkevent.RingBuffer
 	cleanup   *testutil.Cleanup
 }
 
@@ -64,11 +69,17 @@ func newServerMocks(t *testing.T) (*serverMocks, func()) {
 
 	dockerCli := mockdockerdaemon.NewMockDockerClient(ctrl)
 
-	return &serverMocks{cads, sched, tags, dockerCli, &cleanup}, cleanup.Run
+	netevents := networkevent.NewRingBuffer(networkevent.RingBufferConfig{})
+
+	return &serverMocks{cads, sched, tags, dockerCli, netevents, &cleanup}, cleanup.Run
 }
 
 func (m *serverMocks) startServer() string {
-	s := New(Config{}, tally.NoopScope, m.cads, m.sched, m.tags, m.dockerCli)
+	return m.startServerWithConfig(Config{})
+}
+
+func (m *serverMocks) startServerWithConfig(config Config) string {
+	s := New(config, tally.NoopScope, m.cads, m.sched, m.tags, m.dockerCli, m.netevents)
 	addr, stop := testutil.StartServer(s.Handler())
 	m.cleanup.Add(stop)
 	return addr
@@ -133,6 +144,74 @@ func TestDownload(t *testing.T) {
 	require.Equal(string(blob.Content), string(result))
 }
 
+func TestDownloadStreaming(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().DownloadRange(
+		namespace, blob.Digest, int64(0), int64(4*memsize.MB),
+		piecerequest.SequentialPolicy).DoAndReturn(
+		func(namespace string, d core.Digest, offset, length int64, policy string) error {
+			return store.RunDownload(mocks.cads, d, blob.Content)
+		})
+	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(nil)
+
+	addr := mocks.startServerWithConfig(Config{EnableStreaming: true})
+	c := agentclient.New(addr)
+
+	r, err := c.Download(namespace, blob.Digest)
+	require.NoError(err)
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(string(blob.Content), string(result))
+}
+
+func TestDownloadStreamsChunks(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(64, 16)
+
+	require.NoError(mocks.cads.CreateDownloadFile(blob.Digest.Hex(), int64(len(blob.Content))))
+
+	// Each chunk is only written once requested, as if its pieces were
+	// downloading.
+	var calls []*gomock.Call
+	for offset := int64(0); offset < 64; offset += 16 {
+		calls = append(calls, mocks.sched.EXPECT().DownloadRange(
+			namespace, blob.Digest, offset, int64(16),
+			piecerequest.SequentialPolicy).DoAndReturn(
+			func(namespace string, d core.Digest, offset, length int64, policy string) error {
+				f, err := mocks.cads.GetDownloadFileReadWriter(d.Hex())
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = f.WriteAt(blob.Content[offset:offset+length], offset)
+				return err
+			}))
+	}
+	gomock.InOrder(calls...)
+	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(nil)
+
+	addr := mocks.startServerWithConfig(Config{EnableStreaming: true, StreamChunkSize: 16})
+	c := agentclient.New(addr)
+
+	r, err := c.Download(namespace, blob.Digest)
+	require.NoError(err)
+	result, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.Equal(string(blob.Content), string(result))
+}
+
 func TestDownloadNotFound(t *testing.T) {
 	require := require.New(t)
 
@@ -152,6 +231,28 @@ func TestDownloadNotFound(t *testing.T) {
 	require.True(httputil.IsNotFound(err))
 }
 
+func TestDownloadStreamingNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().DownloadRange(
+		namespace, blob.Digest, int64(0), gomock.Any(), gomock.Any()).Return(
+		scheduler.ErrTorrentNotFound)
+	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(scheduler.ErrTorrentNotFound)
+
+	addr := mocks.startServerWithConfig(Config{EnableStreaming: true})
+	c := agentclient.New(addr)
+
+	_, err := c.Download(namespace, blob.Digest)
+	require.Error(err)
+	require.True(httputil.IsNotFound(err))
+}
+
 func TestDownloadUnknownError(t *testing.T) {
 	require := require.New(t)
 
@@ -171,6 +272,160 @@ func TestDownloadUnknownError(t *testing.T) {
 	require.True(httputil.IsStatus(err, 500))
 }
 
+func TestDownloadRange(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(256, 16)
+
+	mocks.sched.EXPECT().DownloadRange(namespace, blob.Digest, int64(32), int64(64), "").DoAndReturn(
+		func(namespace string, d core.Digest, offset, length int64, policy string) error {
+			// Only write the requested range, as the rest of the blob
+			// would still be downloading.
+			if err := mocks.cads.CreateDownloadFile(d.Hex(), int64(len(blob.Content))); err != nil {
+				return err
+			}
+			f, err := mocks.cads.GetDownloadFileReadWriter(d.Hex())
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = f.WriteAt(blob.Content[offset:offset+length], offset)
+			return err
+		})
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s", addr, url.PathEscape(namespace), blob.Digest),
+		httputil.SendHeaders(map[string]string{"Range": "bytes=32-95"}),
+		httputil.SendAcceptedCodes(http.StatusPartialContent))
+	require.NoError(err)
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(string(blob.Content[32:96]), string(result))
+}
+
+func TestDownloadRangeCached(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(256, 16)
+
+	require.NoError(store.RunDownload(mocks.cads, blob.Digest, blob.Content))
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s", addr, url.PathEscape(namespace), blob.Digest),
+		httputil.SendHeaders(map[string]string{"Range": "bytes=200-"}),
+		httputil.SendAcceptedCodes(http.StatusPartialContent))
+	require.NoError(err)
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(string(blob.Content[200:]), string(result))
+}
+
+func TestDownloadRangeNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().DownloadRange(
+		namespace, blob.Digest, int64(0), int64(-1), "").Return(scheduler.ErrTorrentNotFound)
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s", addr, url.PathEscape(namespace), blob.Digest),
+		httputil.SendHeaders(map[string]string{"Range": "bytes=0-"}))
+	require.True(httputil.IsNotFound(err))
+}
+
+func TestDownloadRangeWithPieceRequestPolicy(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().DownloadRange(
+		namespace, blob.Digest, int64(0), int64(-1), piecerequest.SequentialPolicy).Return(
+		scheduler.ErrTorrentNotFound)
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s?piece_request_policy=%s",
+			addr, url.PathEscape(namespace), blob.Digest, piecerequest.SequentialPolicy),
+		httputil.SendHeaders(map[string]string{"Range": "bytes=0-"}))
+	require.True(httputil.IsNotFound(err))
+}
+
+func TestDownloadInvalidPieceRequestPolicy(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/blobs/%s?piece_request_policy=foo",
+			addr, url.PathEscape(namespace), blob.Digest))
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header string
+		offset int64
+		length int64
+		ok     bool
+	}{
+		{"bytes=0-99", 0, 100, true},
+		{"bytes=100-", 100, -1, true},
+		{"bytes=5-5", 5, 1, true},
+		{"bytes=-100", 0, 0, false},
+		{"bytes=0-1,5-9", 0, 0, false},
+		{"bytes=10-5", 0, 0, false},
+		{"items=0-99", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			require := require.New(t)
+
+			offset, length, ok := parseByteRange(test.header)
+			require.Equal(test.ok, ok)
+			require.Equal(test.offset, offset)
+			require.Equal(test.length, length)
+		})
+	}
+}
+
 func TestHealthHandler(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -245,6 +500,47 @@ func TestGetBlacklistHandler(t *testing.T) {
 	require.Equal(blacklist, result)
 }
 
+func TestGetNetworkEventsHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	self := core.PeerIDFixture()
+	peer := core.PeerIDFixture()
+
+	mocks.netevents.Write(networkevent.RequestPieceEvent(h1, self, peer, 1))
+	mocks.netevents.Write(networkevent.PieceRequestTimeoutEvent(h1, self, peer, 1))
+	mocks.netevents.Write(networkevent.PieceRequestTimeoutEvent(h2, self, peer, 2))
+
+	addr := mocks.startServer()
+
+	getEvents := func(query string) []*networkevent.Event {
+		resp, err := httputil.Get(fmt.Sprintf("http://%s/x/networkevents?%s", addr, query))
+		require.NoError(err)
+		defer resp.Body.Close()
+		var result []*networkevent.Event
+		require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	require.Len(getEvents(""), 3)
+
+	result := getEvents(fmt.Sprintf(
+		"torrent=%s&event=%s&limit=10", h1, networkevent.PieceRequestTimeout))
+	require.Len(result, 1)
+	require.Equal(networkevent.PieceRequestTimeout, result[0].Name)
+	require.Equal(h1.String(), result[0].Torrent)
+
+	require.Empty(getEvents("since=" + time.Now().Add(time.Hour).Format(time.RFC3339)))
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/x/networkevents?limit=abc", addr))
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
 func TestDeleteBlobHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -20,8 +20,10 @@ import (
 	"time"
 
 	"github.com/uber/kraken/agent/agentserver"
+	"github.com/uber/kraken/agent/prefetcher"
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/containerd"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
 	"github.com/uber/kraken/lib/store"
@@ -29,10 +31,12 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/tracker/prefetchclient"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/log"
 	"github.com/uber/kraken/utils/netutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
@@ -40,6 +44,7 @@ import (
 // Flags defines agent CLI flags.
 type Flags struct {
 	PeerIP            string
+	PeerSecondaryIP   string
 	PeerPort          int
 	AgentServerPort   int
 	AgentRegistryPort int
@@ -54,6 +59,10 @@ func ParseFlags() *Flags {
 	var flags Flags
 	flag.StringVar(
 		&flags.PeerIP, "peer-ip", "", "ip which peer will announce itself as")
+	flag.StringVar(
+		&flags.PeerSecondaryIP, "peer-secondary-ip", "",
+		"ip in the other family which a dual-stack peer will also announce itself as, "+
+			"or \"auto\" to detect it")
 	flag.IntVar(
 		&flags.PeerPort, "peer-port", 0, "port which peer will announce itself as")
 	flag.IntVar(
@@ -160,13 +169,35 @@ func Run(flags *Flags, opts ...Option) {
 	if err != nil {
 		log.Fatalf("Failed to create peer context: %s", err)
 	}
+	if flags.PeerSecondaryIP == "auto" {
+		ip, err := netutil.GetSecondaryLocalIP(pctx.IP)
+		if err != nil {
+			log.Fatalf("Error getting secondary local ip: %s", err)
+		}
+		flags.PeerSecondaryIP = ip
+	}
+	if flags.PeerSecondaryIP != "" {
+		if err := pctx.SetSecondaryIP(flags.PeerSecondaryIP); err != nil {
+			log.Fatalf("Invalid secondary peer ip: %s", err)
+		}
+	}
+	for _, a := range config.Advertise {
+		ip, err := a.resolve()
+		if err != nil {
+			log.Fatalf("Error resolving advertised address of network %s: %s", a.Network, err)
+		}
+		if err := pctx.AddNetworkAddress(a.Network, ip); err != nil {
+			log.Fatalf("Invalid advertised address: %s", err)
+		}
+	}
 
 	cads, err := store.NewCADownloadStore(config.CADownloadStore, stats)
 	if err != nil {
 		log.Fatalf("Failed to create local store: %s", err)
 	}
 
-	netevents, err := networkevent.NewProducer(config.NetworkEvent)
+	recentNetevents := networkevent.NewRingBuffer(config.NetworkEvent.RingBuffer)
+	netevents, err := networkevent.NewProducer(config.NetworkEvent, recentNetevents)
 	if err != nil {
 		log.Fatalf("Failed to create network event producer: %s", err)
 	}
@@ -193,7 +224,8 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error building build-index upstream: %s", err)
 	}
 
-	tagClient := tagclient.NewClusterClient(buildIndexes, tls)
+	tagClient := tagclient.NewCachedClient(
+		config.TagCache, stats, clock.New(), tagclient.NewClusterClient(buildIndexes, tls))
 
 	transferer := transfer.NewReadOnlyTransferer(stats, cads, tagClient, sched)
 
@@ -203,13 +235,37 @@ func Run(flags *Flags, opts ...Option) {
 	}
 
 	registryAddr := fmt.Sprintf("127.0.0.1:%d", flags.AgentRegistryPort)
-	dockerCli, err := dockerdaemon.NewDockerClient(config.DockerDaemon, registryAddr)
+	var dockerCli dockerdaemon.DockerClient
+	if config.Containerd.Enabled {
+		dockerCli, err = containerd.NewClient(config.Containerd, registryAddr)
+		if err != nil {
+			log.Fatalf("failed to init containerd client for preload: %s", err)
+		}
+	} else {
+		dockerCli, err = dockerdaemon.NewDockerClient(config.DockerDaemon, registryAddr)
+		if err != nil {
+			log.Fatalf("failed to init docker client for preload: %s", err)
+		}
+	}
+	if err := containerd.WriteHostsFiles(config.Containerd, registryAddr); err != nil {
+		log.Fatalf("Failed to write containerd hosts files: %s", err)
+	}
+
+	prefetch, err := prefetcher.New(
+		config.Prefetcher,
+		stats,
+		clock.New(),
+		prefetchclient.New(trackers, tls),
+		tagClient,
+		sched,
+		cads)
 	if err != nil {
-		log.Fatalf("failed to init docker client for preload: %s", err)
+		log.Fatalf("Error creating prefetcher: %s", err)
 	}
+	prefetch.Start()
 
 	agentServer := agentserver.New(
-		config.AgentServer, stats, cads, sched, tagClient, dockerCli)
+		config.AgentServer, stats, cads, sched, tagClient, dockerCli, recentNetevents)
 	addr := fmt.Sprintf(":%d", flags.AgentServerPort)
 	log.Infof("Starting agent server on %s", addr)
 	go func() {
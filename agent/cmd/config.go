@@ -14,8 +14,13 @@
 package cmd
 
 import (
+	"errors"
+
 	"github.com/uber/kraken/agent/agentserver"
+	"github.com/uber/kraken/agent/prefetcher"
+	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/containerd"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/dockerregistry"
 	"github.com/uber/kraken/lib/store"
@@ -25,6 +30,7 @@ import (
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/netutil"
 
 	"go.uber.org/zap"
 )
@@ -40,10 +46,36 @@ type Config struct {
 	NetworkEvent    networkevent.Config            `yaml:"network_event"`
 	Tracker         upstream.PassiveHashRingConfig `yaml:"tracker"`
 	BuildIndex      upstream.PassiveConfig         `yaml:"build_index"`
+	TagCache        tagclient.CacheConfig          `yaml:"tag_cache"`
 	AgentServer     agentserver.Config             `yaml:"agentserver"`
 	RegistryBackup  string                         `yaml:"registry_backup"`
 	Nginx           nginx.Config                   `yaml:"nginx"`
 	TLS             httputil.TLSConfig             `yaml:"tls"`
 	AllowedCidrs    []string                       `yaml:"allowed_cidrs"`
 	DockerDaemon    dockerdaemon.Config            `yaml:"docker_daemon"`
+	Containerd      containerd.Config              `yaml:"containerd"`
+	Prefetcher      prefetcher.Config              `yaml:"prefetcher"`
+	Advertise       []AdvertisedAddressConfig      `yaml:"advertise"`
+}
+
+// AdvertisedAddressConfig defines an address which a multi-homed agent
+// announces on a named network, in addition to its peer ip. Peers on the same
+// network connect to the agent through this address.
+type AdvertisedAddressConfig struct {
+	Network string `yaml:"network"`
+
+	// Exactly one of IP and Interface must be set. If Interface is set, the
+	// address is resolved from the named local interface.
+	IP        string `yaml:"ip"`
+	Interface string `yaml:"interface"`
+}
+
+func (c AdvertisedAddressConfig) resolve() (string, error) {
+	if (c.IP == "") == (c.Interface == "") {
+		return "", errors.New("exactly one of ip and interface must be set")
+	}
+	if c.IP != "" {
+		return c.IP, nil
+	}
+	return netutil.GetInterfaceIP(c.Interface)
 }
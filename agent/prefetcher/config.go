@@ -0,0 +1,51 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetcher
+
+import "time"
+
+// Config defines Prefetcher configuration.
+type Config struct {
+
+	// Disable disables polling the tracker for prefetches.
+	Disable bool `yaml:"disable"`
+
+	// Hostname is the name prefetch host selectors are matched against.
+	// Defaults to the hostname reported by the kernel.
+	Hostname string `yaml:"hostname"`
+
+	// DefaultInterval is the polling interval used until the tracker returns
+	// an interval.
+	DefaultInterval time.Duration `yaml:"default_interval"`
+
+	// MaxInterval caps the polling interval returned by the tracker.
+	MaxInterval time.Duration `yaml:"max_interval"`
+
+	// RetryInterval is the duration to wait before running a prefetch again
+	// which the tracker still reports as pending, e.g. because it failed.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.DefaultInterval == 0 {
+		c.DefaultInterval = 5 * time.Second
+	}
+	if c.MaxInterval == 0 {
+		c.MaxInterval = time.Minute
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 5 * time.Minute
+	}
+	return c
+}
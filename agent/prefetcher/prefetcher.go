@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetcher
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/dockerutil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// Prefetcher polls the tracker for prefetches targeting the local host at the
+// tracker's announce interval, and downloads the manifest and layers of each
+// prefetched image through the scheduler, such that later pulls are served
+// from the local cache.
+type Prefetcher struct {
+	config   Config
+	stats    tally.Scope
+	clk      clock.Clock
+	client   prefetchclient.Client
+	tags     tagclient.Client
+	sched    scheduler.Scheduler
+	cads     *store.CADownloadStore
+	hostname string
+
+	// Last attempt of each pending prefetch, keyed by id. Pruned to the
+	// prefetches the tracker still reports as pending on every poll.
+	attempts map[string]time.Time
+
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a new Prefetcher.
+func New(
+	config Config,
+	stats tally.Scope,
+	clk clock.Clock,
+	client prefetchclient.Client,
+	tags tagclient.Client,
+	sched scheduler.Scheduler,
+	cads *store.CADownloadStore) (*Prefetcher, error) {
+
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "prefetcher",
+	})
+
+	hostname := config.Hostname
+	if hostname == "" {
+		var err error
+		hostname, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("hostname: %s", err)
+		}
+	}
+
+	return &Prefetcher{
+		config:   config,
+		stats:    stats,
+		clk:      clk,
+		client:   client,
+		tags:     tags,
+		sched:    sched,
+		cads:     cads,
+		hostname: hostname,
+		attempts: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start starts polling in a background goroutine.
+func (p *Prefetcher) Start() {
+	if p.config.Disable {
+		log.Warn("Prefetcher disabled")
+		return
+	}
+	p.wg.Add(1)
+	go p.loop()
+}
+
+// Stop stops polling and waits for the current prefetch to finish.
+func (p *Prefetcher) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+	})
+}
+
+func (p *Prefetcher) loop() {
+	defer p.wg.Done()
+
+	for {
+		interval := p.poll()
+		select {
+		case <-p.clk.After(interval):
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// poll fetches pending prefetches from the tracker and runs each one which
+// has not been attempted within the retry interval. Returns the interval to
+// wait before polling again.
+func (p *Prefetcher) poll() time.Duration {
+	resp, err := p.client.Pending(p.hostname)
+	if err != nil {
+		log.Errorf("Error getting pending prefetches: %s", err)
+		p.stats.Counter("poll_errors").Inc(1)
+		return p.config.DefaultInterval
+	}
+	pending := make(map[string]bool, len(resp.Prefetches))
+	for _, pf := range resp.Prefetches {
+		pending[pf.ID] = true
+	}
+	for id := range p.attempts {
+		if !pending[id] {
+			delete(p.attempts, id)
+		}
+	}
+	for _, pf := range resp.Prefetches {
+		if last, ok := p.attempts[pf.ID]; ok && p.clk.Now().Sub(last) < p.config.RetryInterval {
+			continue
+		}
+		p.attempts[pf.ID] = p.clk.Now()
+		p.prefetch(pf)
+	}
+	interval := resp.Interval
+	if interval <= 0 || interval > p.config.MaxInterval {
+		interval = p.config.DefaultInterval
+	}
+	return interval
+}
+
+// prefetch runs pf and reports its progress to the tracker.
+func (p *Prefetcher) prefetch(pf *prefetchclient.PendingPrefetch) {
+	logger := log.With("id", pf.ID, "tag", pf.Tag)
+
+	p.report(pf.ID, prefetchstore.StateRunning, nil)
+
+	start := p.clk.Now()
+	if err := p.download(pf.Tag); err != nil {
+		logger.Errorf("Error prefetching: %s", err)
+		p.stats.Counter("prefetch_failures").Inc(1)
+		p.report(pf.ID, prefetchstore.StateFailed, err)
+		return
+	}
+	logger.Info("Prefetch complete")
+	p.stats.Timer("prefetch_time").Record(p.clk.Now().Sub(start))
+	p.report(pf.ID, prefetchstore.StateComplete, nil)
+}
+
+func (p *Prefetcher) report(id, state string, err error) {
+	status := &prefetchstore.HostStatus{
+		State:     state,
+		UpdatedAt: p.clk.Now().UTC(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if err := p.client.UpdateStatus(id, p.hostname, status); err != nil {
+		log.With("id", id).Errorf("Error reporting prefetch status %s: %s", state, err)
+	}
+}
+
+// download resolves tag and downloads the manifest and all blobs it references.
+// Blobs are downloaded under the repository of tag as namespace, matching
+// the namespace the registry uses when the image is later pulled.
+func (p *Prefetcher) download(tag string) error {
+	namespace, err := prefetchstore.ParseTag(tag)
+	if err != nil {
+		return err
+	}
+
+	d, err := p.tags.Get(tag)
+	if err != nil {
+		return fmt.Errorf("get tag: %s", err)
+	}
+	if err := p.sched.Download(namespace, d); err != nil {
+		return fmt.Errorf("download manifest: %s", err)
+	}
+	refs, err := p.manifestReferences(d)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := p.sched.Download(namespace, ref); err != nil {
+			return fmt.Errorf("download blob %s: %s", ref, err)
+		}
+	}
+	return nil
+}
+
+func (p *Prefetcher) manifestReferences(d core.Digest) ([]core.Digest, error) {
+	f, err := p.cads.Cache().GetFileReader(d.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %s", err)
+	}
+	defer f.Close()
+	manifest, _, err := dockerutil.ParseManifestV2(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest: %s", err)
+	}
+	refs, err := dockerutil.GetManifestReferences(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("get manifest references: %s", err)
+	}
+	return refs, nil
+}
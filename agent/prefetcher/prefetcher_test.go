@@ -0,0 +1,261 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetcher
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store"
+	mocktagclient "github.com/uber/kraken/mocks/build-index/tagclient"
+	mockscheduler "github.com/uber/kraken/mocks/lib/torrent/scheduler"
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/dockerutil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _hostname = "agent-1"
+
+// fakeClient is an in-memory prefetchclient.Client which records statuses.
+type fakeClient struct {
+	sync.Mutex
+	pending  []*prefetchstore.Prefetch
+	interval time.Duration
+	statuses map[string][]string
+}
+
+func newFakeClient(pending ...*prefetchstore.Prefetch) *fakeClient {
+	return &fakeClient{pending: pending, statuses: make(map[string][]string)}
+}
+
+func (c *fakeClient) Create(tag, hosts string) (*prefetchstore.Prefetch, error) {
+	return nil, errors.New("unimplemented")
+}
+
+func (c *fakeClient) Get(id string) (*prefetchstore.Prefetch, error) {
+	return nil, errors.New("unimplemented")
+}
+
+func (c *fakeClient) Pending(hostname string) (*prefetchclient.PendingResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	resp := &prefetchclient.PendingResponse{Interval: c.interval}
+	for _, p := range c.pending {
+		resp.Prefetches = append(resp.Prefetches, prefetchclient.NewPendingPrefetch(p, hostname))
+	}
+	return resp, nil
+}
+
+func (c *fakeClient) UpdateStatus(id, hostname string, status *prefetchstore.HostStatus) error {
+	c.Lock()
+	defer c.Unlock()
+	c.statuses[id] = append(c.statuses[id], status.State)
+	return nil
+}
+
+type prefetcherMocks struct {
+	ctrl  *gomock.Controller
+	tags  *mocktagclient.MockClient
+	sched *mockscheduler.MockScheduler
+	cads  *store.CADownloadStore
+	clk   *clock.Mock
+}
+
+func newPrefetcherMocks(t *testing.T) (*prefetcherMocks, func()) {
+	var cleanup testutil.Cleanup
+
+	ctrl := gomock.NewController(t)
+	cleanup.Add(ctrl.Finish)
+
+	cads, c := store.CADownloadStoreFixture()
+	cleanup.Add(c)
+
+	return &prefetcherMocks{
+		ctrl:  ctrl,
+		tags:  mocktagclient.NewMockClient(ctrl),
+		sched: mockscheduler.NewMockScheduler(ctrl),
+		cads:  cads,
+		clk:   clock.NewMock(),
+	}, cleanup.Run
+}
+
+func (m *prefetcherMocks) new(client prefetchclient.Client) *Prefetcher {
+	p, err := New(
+		Config{Hostname: _hostname}, tally.NoopScope, m.clk, client, m.tags, m.sched, m.cads)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestPrefetcherDownloadsManifestAndLayers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newPrefetcherMocks(t)
+	defer cleanup()
+
+	pf := prefetchstore.PrefetchFixture(".*")
+	namespace := "namespace-foo/repo-bar"
+
+	config := core.DigestFixture()
+	layer1 := core.DigestFixture()
+	layer2 := core.DigestFixture()
+	manifest, raw := dockerutil.ManifestFixture(config, layer1, layer2)
+
+	client := newFakeClient(pf)
+	client.interval = 3 * time.Second
+
+	gomock.InOrder(
+		mocks.tags.EXPECT().Get(pf.Tag).Return(manifest, nil),
+		mocks.sched.EXPECT().Download(namespace, manifest).DoAndReturn(
+			func(namespace string, d core.Digest) error {
+				return store.RunDownload(mocks.cads, d, raw)
+			}),
+		mocks.sched.EXPECT().Download(namespace, config).Return(nil),
+		mocks.sched.EXPECT().Download(namespace, layer1).Return(nil),
+		mocks.sched.EXPECT().Download(namespace, layer2).Return(nil),
+	)
+
+	p := mocks.new(client)
+
+	require.Equal(3*time.Second, p.poll())
+	require.Equal(
+		[]string{prefetchstore.StateRunning, prefetchstore.StateComplete},
+		client.statuses[pf.ID])
+
+	// Prefetches are not attempted again within the retry interval.
+	p.poll()
+	require.Len(client.statuses[pf.ID], 2)
+}
+
+func TestPrefetcherReportsFailure(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newPrefetcherMocks(t)
+	defer cleanup()
+
+	pf := prefetchstore.PrefetchFixture(".*")
+
+	client := newFakeClient(pf)
+
+	mocks.tags.EXPECT().Get(pf.Tag).Return(core.Digest{}, errors.New("some error"))
+
+	p := mocks.new(client)
+
+	require.Equal(p.config.DefaultInterval, p.poll())
+	require.Equal(
+		[]string{prefetchstore.StateRunning, prefetchstore.StateFailed},
+		client.statuses[pf.ID])
+}
+
+func TestPrefetcherRetriesFailedPrefetches(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newPrefetcherMocks(t)
+	defer cleanup()
+
+	pf := prefetchstore.PrefetchFixture(".*")
+
+	client := newFakeClient(pf)
+
+	gomock.InOrder(
+		mocks.tags.EXPECT().Get(pf.Tag).Return(core.Digest{}, errors.New("some error")),
+		mocks.tags.EXPECT().Get(pf.Tag).Return(core.Digest{}, errors.New("some error")),
+	)
+
+	p := mocks.new(client)
+
+	p.poll()
+	require.Len(client.statuses[pf.ID], 2)
+
+	mocks.clk.Add(p.config.RetryInterval - time.Second)
+	p.poll()
+	require.Len(client.statuses[pf.ID], 2)
+
+	mocks.clk.Add(time.Second)
+	p.poll()
+	require.Equal(
+		[]string{
+			prefetchstore.StateRunning, prefetchstore.StateFailed,
+			prefetchstore.StateRunning, prefetchstore.StateFailed,
+		},
+		client.statuses[pf.ID])
+}
+
+func TestPrefetcherForgetsPrefetchesNoLongerPending(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newPrefetcherMocks(t)
+	defer cleanup()
+
+	pf := prefetchstore.PrefetchFixture(".*")
+
+	client := newFakeClient(pf)
+
+	mocks.tags.EXPECT().Get(pf.Tag).Return(core.Digest{}, errors.New("some error"))
+
+	p := mocks.new(client)
+
+	p.poll()
+	require.Len(p.attempts, 1)
+
+	client.pending = nil
+	p.poll()
+	require.Empty(p.attempts)
+}
+
+func TestPrefetcherRegistryHostWithPort(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newPrefetcherMocks(t)
+	defer cleanup()
+
+	pf := prefetchstore.PrefetchFixture(".*")
+	pf.Tag = "localhost:5000/namespace-foo/repo-bar:0001"
+	namespace := "localhost:5000/namespace-foo/repo-bar"
+
+	config := core.DigestFixture()
+	layer1 := core.DigestFixture()
+	layer2 := core.DigestFixture()
+	manifest, raw := dockerutil.ManifestFixture(config, layer1, layer2)
+
+	client := newFakeClient(pf)
+
+	gomock.InOrder(
+		mocks.tags.EXPECT().Get(pf.Tag).Return(manifest, nil),
+		mocks.sched.EXPECT().Download(namespace, manifest).DoAndReturn(
+			func(namespace string, d core.Digest) error {
+				return store.RunDownload(mocks.cads, d, raw)
+			}),
+		mocks.sched.EXPECT().Download(namespace, config).Return(nil),
+		mocks.sched.EXPECT().Download(namespace, layer1).Return(nil),
+		mocks.sched.EXPECT().Download(namespace, layer2).Return(nil),
+	)
+
+	p := mocks.new(client)
+
+	p.poll()
+	require.Equal(
+		[]string{prefetchstore.StateRunning, prefetchstore.StateComplete},
+		client.statuses[pf.ID])
+}
@@ -21,9 +21,11 @@ import (
 	"github.com/uber/kraken/build-index/tagstore"
 	"github.com/uber/kraken/build-index/tagtype"
 	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/tagreplication"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
@@ -178,10 +180,16 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error building remotes from configuration: %s", err)
 	}
 
+	notifier, err := notification.New(config.Notification, stats, localDB)
+	if err != nil {
+		log.Fatalf("Error creating notifier: %s", err)
+	}
+
 	tagReplicationExecutor := tagreplication.NewExecutor(
 		stats,
 		originClient,
-		tagclient.NewProvider(tls))
+		tagclient.NewProvider(tls),
+		notifier)
 	tagReplicationStore, err := tagreplication.NewStore(localDB, remotes)
 	if err != nil {
 		log.Fatalf("Error creating tag replication store: %s", err)
@@ -199,7 +207,7 @@ func Run(flags *Flags, opts ...Option) {
 		config.WriteBack,
 		stats,
 		writeback.NewStore(localDB),
-		writeback.NewExecutor(stats, ss, backends))
+		writeback.NewExecutor(stats, ss, backends, notifier))
 	if err != nil {
 		log.Fatalf("Error creating write-back manager: %s", err)
 	}
@@ -211,6 +219,11 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error creating tag type manager: %s", err)
 	}
 
+	verifier, err := contenttrust.New(config.ContentTrust)
+	if err != nil {
+		log.Fatalf("Error creating content trust verifier: %s", err)
+	}
+
 	server := tagserver.New(
 		config.TagServer,
 		stats,
@@ -222,7 +235,9 @@ func Run(flags *Flags, opts ...Option) {
 		remotes,
 		tagReplicationManager,
 		tagclient.NewProvider(tls),
-		depResolver)
+		depResolver,
+		notifier,
+		verifier)
 	go func() {
 		log.Fatal(server.ListenAndServe())
 	}()
@@ -18,7 +18,9 @@ import (
 	"github.com/uber/kraken/build-index/tagstore"
 	"github.com/uber/kraken/build-index/tagtype"
 	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/tagreplication"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/upstream"
@@ -46,6 +48,8 @@ type Config struct {
 	TagStore       tagstore.Config              `yaml:"tag_store"`
 	Store          store.SimpleStoreConfig      `yaml:"store"`
 	WriteBack      persistedretry.Config        `yaml:"writeback"`
+	Notification   notification.Config          `yaml:"notification"`
+	ContentTrust   contenttrust.Config          `yaml:"content_trust"`
 	Nginx          nginx.Config                 `yaml:"nginx"`
 	TLS            httputil.TLSConfig           `yaml:"tls"`
 }
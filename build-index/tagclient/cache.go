@@ -0,0 +1,174 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/build-index/tagmodels"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// CacheConfig defines CachedClient configuration.
+type CacheConfig struct {
+
+	// Disable disables caching of tag lookups.
+	Disable bool `yaml:"disable"`
+
+	// TTL is how long a lookup is served from cache before it is refreshed.
+	// Mutations of a tag are observed within TTL, plus the TTL of the
+	// build-index nginx cache.
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxEntries bounds the number of cached tags.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+func (c CacheConfig) applyDefaults() CacheConfig {
+	if c.TTL == 0 {
+		c.TTL = 5 * time.Second
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = 10000
+	}
+	return c
+}
+
+type cacheEntry struct {
+	record    tagmodels.TagRecord
+	expiresAt time.Time
+}
+
+// cachedClient caches tag lookups for a short TTL. Refreshed lookups are
+// checked against the cached version, such that a lookup never resolves to an
+// older record than previously observed, e.g. when a lagging build-index
+// replica serves the refresh.
+type cachedClient struct {
+	Client
+
+	config CacheConfig
+	stats  tally.Scope
+	clk    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewCachedClient wraps c with a cache of tag lookups.
+func NewCachedClient(config CacheConfig, stats tally.Scope, clk clock.Clock, c Client) Client {
+	if config.Disable {
+		log.Warn("Tag cache disabled")
+		return c
+	}
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "tagcache",
+	})
+
+	return &cachedClient{
+		Client:  c,
+		config:  config,
+		stats:   stats,
+		clk:     clk,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *cachedClient) Get(tag string) (core.Digest, error) {
+	r, err := c.GetRecord(tag)
+	if err != nil {
+		return core.Digest{}, err
+	}
+	return r.Digest, nil
+}
+
+func (c *cachedClient) GetRecord(tag string) (tagmodels.TagRecord, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[tag]
+	c.mu.Unlock()
+
+	if ok && c.clk.Now().Before(cached.expiresAt) {
+		c.stats.Counter("hits").Inc(1)
+		return cached.record, nil
+	}
+	c.stats.Counter("misses").Inc(1)
+
+	r, err := c.Client.GetRecord(tag)
+	if err != nil {
+		return tagmodels.TagRecord{}, err
+	}
+	// Unversioned records, i.e. from build-indexes which predate tag
+	// versions, cannot be ordered and are always accepted.
+	if ok && cached.record.Version != 0 && r.Version != 0 && cached.record.Newer(r) {
+		c.stats.Counter("stale_lookups").Inc(1)
+		log.With("tag", tag, "cached", cached.record, "lookup", r).Info(
+			"Ignoring stale tag lookup")
+		r = cached.record
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[tag]; !ok && len(c.entries) >= c.config.MaxEntries {
+		c.evict()
+	}
+	c.entries[tag] = cacheEntry{r, c.clk.Now().Add(c.config.TTL)}
+	return r, nil
+}
+
+func (c *cachedClient) Put(tag string, d core.Digest) error {
+	defer c.invalidate(tag)
+	return c.Client.Put(tag, d)
+}
+
+func (c *cachedClient) PutAndReplicate(tag string, d core.Digest) error {
+	defer c.invalidate(tag)
+	return c.Client.PutAndReplicate(tag, d)
+}
+
+func (c *cachedClient) PutRecordAndReplicate(tag string, r tagmodels.TagRecord) error {
+	defer c.invalidate(tag)
+	return c.Client.PutRecordAndReplicate(tag, r)
+}
+
+func (c *cachedClient) invalidate(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, tag)
+}
+
+// evict removes expired entries, or a random entry if none have expired. Must
+// be called with c.mu held.
+func (c *cachedClient) evict() {
+	now := c.clk.Now()
+	for tag, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, tag)
+		}
+	}
+	if len(c.entries) < c.config.MaxEntries {
+		return
+	}
+	for tag := range c.entries {
+		delete(c.entries, tag)
+		return
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagclient_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/build-index/tagmodels"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/mocks/build-index/tagclient"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _testTTL = 5 * time.Second
+
+type cacheMocks struct {
+	ctrl   *gomock.Controller
+	clk    *clock.Mock
+	client *mocktagclient.MockClient
+}
+
+func newCacheMocks(t *testing.T) (*cacheMocks, func()) {
+	ctrl := gomock.NewController(t)
+	return &cacheMocks{
+		ctrl:   ctrl,
+		clk:    clock.NewMock(),
+		client: mocktagclient.NewMockClient(ctrl),
+	}, ctrl.Finish
+}
+
+func (m *cacheMocks) new() Client {
+	return NewCachedClient(CacheConfig{TTL: _testTTL}, tally.NoopScope, m.clk, m.client)
+}
+
+func TestCachedClientServesLookupsUntilTTL(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newCacheMocks(t)
+	defer cleanup()
+
+	client := mocks.new()
+
+	tag := core.TagFixture()
+	r1 := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 1}
+	r2 := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+
+	mocks.client.EXPECT().GetRecord(tag).Return(r1, nil)
+
+	for i := 0; i < 3; i++ {
+		d, err := client.Get(tag)
+		require.NoError(err)
+		require.Equal(r1.Digest, d)
+	}
+
+	mocks.clk.Add(_testTTL + time.Second)
+
+	mocks.client.EXPECT().GetRecord(tag).Return(r2, nil)
+
+	d, err := client.Get(tag)
+	require.NoError(err)
+	require.Equal(r2.Digest, d)
+}
+
+func TestCachedClientIgnoresStaleLookups(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newCacheMocks(t)
+	defer cleanup()
+
+	client := mocks.new()
+
+	tag := core.TagFixture()
+	newer := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+	older := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 1}
+
+	mocks.client.EXPECT().GetRecord(tag).Return(newer, nil)
+
+	r, err := client.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(newer, r)
+
+	mocks.clk.Add(_testTTL + time.Second)
+
+	// A lagging replica still serves the older record.
+	mocks.client.EXPECT().GetRecord(tag).Return(older, nil)
+
+	r, err = client.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(newer, r)
+}
+
+func TestCachedClientAcceptsUnversionedLookups(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newCacheMocks(t)
+	defer cleanup()
+
+	client := mocks.new()
+
+	tag := core.TagFixture()
+	versioned := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+	unversioned := tagmodels.TagRecord{Digest: core.DigestFixture()}
+
+	mocks.client.EXPECT().GetRecord(tag).Return(versioned, nil)
+
+	_, err := client.GetRecord(tag)
+	require.NoError(err)
+
+	mocks.clk.Add(_testTTL + time.Second)
+
+	mocks.client.EXPECT().GetRecord(tag).Return(unversioned, nil)
+
+	r, err := client.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(unversioned, r)
+}
+
+func TestCachedClientPutInvalidates(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newCacheMocks(t)
+	defer cleanup()
+
+	client := mocks.new()
+
+	tag := core.TagFixture()
+	r1 := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 1}
+	r2 := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+
+	mocks.client.EXPECT().GetRecord(tag).Return(r1, nil)
+
+	_, err := client.GetRecord(tag)
+	require.NoError(err)
+
+	mocks.client.EXPECT().Put(tag, r2.Digest).Return(nil)
+	mocks.client.EXPECT().GetRecord(tag).Return(r2, nil)
+
+	require.NoError(client.Put(tag, r2.Digest))
+
+	r, err := client.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(r2, r)
+}
+
+func TestCachedClientDisabled(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newCacheMocks(t)
+	defer cleanup()
+
+	client := NewCachedClient(CacheConfig{Disable: true}, tally.NoopScope, mocks.clk, mocks.client)
+
+	tag := core.TagFixture()
+	d := core.DigestFixture()
+
+	mocks.client.EXPECT().Get(tag).Return(d, nil).Times(2)
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Get(tag)
+		require.NoError(err)
+		require.Equal(d, result)
+	}
+}
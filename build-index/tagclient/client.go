@@ -28,6 +28,7 @@ import (
 	"github.com/uber/kraken/build-index/tagmodels"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/healthcheck"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/httputil"
 )
 
@@ -40,7 +41,9 @@ var (
 type Client interface {
 	Put(tag string, d core.Digest) error
 	PutAndReplicate(tag string, d core.Digest) error
+	PutRecordAndReplicate(tag string, r tagmodels.TagRecord) error
 	Get(tag string) (core.Digest, error)
+	GetRecord(tag string) (tagmodels.TagRecord, error)
 	Has(tag string) (bool, error)
 	List(prefix string) ([]string, error)
 	ListWithPagination(prefix string, filter ListFilter) (tagmodels.ListResponse, error)
@@ -49,9 +52,13 @@ type Client interface {
 	Replicate(tag string) error
 	Origin() (string, error)
 
+	PutReferrer(repo string, d core.Digest) error
+	GetReferrers(
+		repo string, subject core.Digest, artifactType string) (*dockerutil.ImageIndex, error)
+
 	DuplicateReplicate(
-		tag string, d core.Digest, dependencies core.DigestList, delay time.Duration) error
-	DuplicatePut(tag string, d core.Digest, delay time.Duration) error
+		tag string, r tagmodels.TagRecord, dependencies core.DigestList, delay time.Duration) error
+	DuplicatePut(tag string, r tagmodels.TagRecord, delay time.Duration) error
 }
 
 type singleClient struct {
@@ -86,27 +93,56 @@ func (c *singleClient) PutAndReplicate(tag string, d core.Digest) error {
 	return err
 }
 
+// PutRecordAndReplicate puts r if it is newer than the tag's current record
+// on the server, and replicates it if so.
+func (c *singleClient) PutRecordAndReplicate(tag string, r tagmodels.TagRecord) error {
+	_, err := httputil.Put(
+		fmt.Sprintf(
+			"http://%s/tags/%s/digest/%s?replicate=true&version=%d",
+			c.addr, url.PathEscape(tag), r.Digest.String(), r.Version),
+		httputil.SendTimeout(30*time.Second),
+		httputil.SendTLS(c.tls))
+	return err
+}
+
 func (c *singleClient) Get(tag string) (core.Digest, error) {
+	r, err := c.GetRecord(tag)
+	if err != nil {
+		return core.Digest{}, err
+	}
+	return r.Digest, nil
+}
+
+// GetRecord returns the digest and version of tag. Servers which do not
+// version tags return version 0.
+func (c *singleClient) GetRecord(tag string) (tagmodels.TagRecord, error) {
 	resp, err := httputil.Get(
 		fmt.Sprintf("http://%s/tags/%s", c.addr, url.PathEscape(tag)),
 		httputil.SendTimeout(10*time.Second),
 		httputil.SendTLS(c.tls))
 	if err != nil {
 		if httputil.IsNotFound(err) {
-			return core.Digest{}, ErrTagNotFound
+			return tagmodels.TagRecord{}, ErrTagNotFound
 		}
-		return core.Digest{}, err
+		return tagmodels.TagRecord{}, err
 	}
 	defer resp.Body.Close()
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return core.Digest{}, fmt.Errorf("read body: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("read body: %s", err)
 	}
 	d, err := core.ParseSHA256Digest(string(b))
 	if err != nil {
-		return core.Digest{}, fmt.Errorf("new digest: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("new digest: %s", err)
 	}
-	return d, nil
+	var version int64
+	if v := resp.Header.Get(tagmodels.TagVersionHeader); v != "" {
+		version, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return tagmodels.TagRecord{}, fmt.Errorf("parse version: %s", err)
+		}
+	}
+	return tagmodels.TagRecord{Digest: d, Version: version}, nil
 }
 
 func (c *singleClient) Has(tag string) (bool, error) {
@@ -226,19 +262,20 @@ func (c *singleClient) Replicate(tag string) error {
 type DuplicateReplicateRequest struct {
 	Dependencies core.DigestList `json:"dependencies"`
 	Delay        time.Duration   `json:"delay"`
+	Version      int64           `json:"version"`
 }
 
 func (c *singleClient) DuplicateReplicate(
-	tag string, d core.Digest, dependencies core.DigestList, delay time.Duration) error {
+	tag string, r tagmodels.TagRecord, dependencies core.DigestList, delay time.Duration) error {
 
-	b, err := json.Marshal(DuplicateReplicateRequest{dependencies, delay})
+	b, err := json.Marshal(DuplicateReplicateRequest{dependencies, delay, r.Version})
 	if err != nil {
 		return fmt.Errorf("json marshal: %s", err)
 	}
 	_, err = httputil.Post(
 		fmt.Sprintf(
 			"http://%s/internal/duplicate/remotes/tags/%s/digest/%s",
-			c.addr, url.PathEscape(tag), d.String()),
+			c.addr, url.PathEscape(tag), r.Digest.String()),
 		httputil.SendBody(bytes.NewReader(b)),
 		httputil.SendTimeout(10*time.Second),
 		httputil.SendRetry(),
@@ -248,18 +285,19 @@ func (c *singleClient) DuplicateReplicate(
 
 // DuplicatePutRequest defines a DuplicatePut request body.
 type DuplicatePutRequest struct {
-	Delay time.Duration `json:"delay"`
+	Delay   time.Duration `json:"delay"`
+	Version int64         `json:"version"`
 }
 
-func (c *singleClient) DuplicatePut(tag string, d core.Digest, delay time.Duration) error {
-	b, err := json.Marshal(DuplicatePutRequest{delay})
+func (c *singleClient) DuplicatePut(tag string, r tagmodels.TagRecord, delay time.Duration) error {
+	b, err := json.Marshal(DuplicatePutRequest{delay, r.Version})
 	if err != nil {
 		return fmt.Errorf("json marshal: %s", err)
 	}
 	_, err = httputil.Put(
 		fmt.Sprintf(
 			"http://%s/internal/duplicate/tags/%s/digest/%s",
-			c.addr, url.PathEscape(tag), d.String()),
+			c.addr, url.PathEscape(tag), r.Digest.String()),
 		httputil.SendBody(bytes.NewReader(b)),
 		httputil.SendTimeout(10*time.Second),
 		httputil.SendRetry(),
@@ -283,6 +321,46 @@ func (c *singleClient) Origin() (string, error) {
 	return string(b), nil
 }
 
+// PutReferrer adds the manifest d of repo to the referrers index of its
+// subject. No-op if the manifest has no subject.
+func (c *singleClient) PutReferrer(repo string, d core.Digest) error {
+	_, err := httputil.Put(
+		fmt.Sprintf(
+			"http://%s/repositories/%s/referrers/%s",
+			c.addr, url.PathEscape(repo), d.String()),
+		httputil.SendTimeout(30*time.Second),
+		httputil.SendTLS(c.tls))
+	return err
+}
+
+// GetReferrers returns the referrers index of subject in repo, optionally
+// filtered by artifactType.
+func (c *singleClient) GetReferrers(
+	repo string, subject core.Digest, artifactType string) (*dockerutil.ImageIndex, error) {
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   c.addr,
+		Path:   fmt.Sprintf("/repositories/%s/referrers/%s", url.PathEscape(repo), subject),
+	}
+	if artifactType != "" {
+		u.RawQuery = url.Values{"artifactType": {artifactType}}.Encode()
+	}
+	resp, err := httputil.Get(
+		u.String(),
+		httputil.SendTimeout(10*time.Second),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %s", err)
+	}
+	return dockerutil.ParseImageIndex(b)
+}
+
 type clusterClient struct {
 	hosts healthcheck.List
 	tls   *tls.Config
@@ -319,6 +397,10 @@ func (cc *clusterClient) PutAndReplicate(tag string, d core.Digest) error {
 	return cc.do(func(c Client) error { return c.PutAndReplicate(tag, d) })
 }
 
+func (cc *clusterClient) PutRecordAndReplicate(tag string, r tagmodels.TagRecord) error {
+	return cc.do(func(c Client) error { return c.PutRecordAndReplicate(tag, r) })
+}
+
 func (cc *clusterClient) Get(tag string) (d core.Digest, err error) {
 	err = cc.do(func(c Client) error {
 		d, err = c.Get(tag)
@@ -327,6 +409,14 @@ func (cc *clusterClient) Get(tag string) (d core.Digest, err error) {
 	return
 }
 
+func (cc *clusterClient) GetRecord(tag string) (r tagmodels.TagRecord, err error) {
+	err = cc.do(func(c Client) error {
+		r, err = c.GetRecord(tag)
+		return err
+	})
+	return
+}
+
 func (cc *clusterClient) Has(tag string) (ok bool, err error) {
 	err = cc.do(func(c Client) error {
 		ok, err = c.Has(tag)
@@ -383,12 +473,29 @@ func (cc *clusterClient) Origin() (origin string, err error) {
 	return
 }
 
+func (cc *clusterClient) PutReferrer(repo string, d core.Digest) error {
+	return cc.do(func(c Client) error { return c.PutReferrer(repo, d) })
+}
+
+func (cc *clusterClient) GetReferrers(
+	repo string, subject core.Digest, artifactType string) (
+	index *dockerutil.ImageIndex, err error) {
+
+	err = cc.do(func(c Client) error {
+		index, err = c.GetReferrers(repo, subject, artifactType)
+		return err
+	})
+	return
+}
+
 func (cc *clusterClient) DuplicateReplicate(
-	tag string, d core.Digest, dependencies core.DigestList, delay time.Duration) error {
+	tag string, r tagmodels.TagRecord, dependencies core.DigestList, delay time.Duration) error {
 
 	return errors.New("duplicate replicate not supported on cluster client")
 }
 
-func (cc *clusterClient) DuplicatePut(tag string, d core.Digest, delay time.Duration) error {
+func (cc *clusterClient) DuplicatePut(
+	tag string, r tagmodels.TagRecord, delay time.Duration) error {
+
 	return errors.New("duplicate put not supported on cluster client")
 }
@@ -17,6 +17,10 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/uber/kraken/core"
 )
 
 const (
@@ -57,3 +61,49 @@ func (resp ListResponse) GetOffset() (string, error) {
 	}
 	return offset, nil
 }
+
+// TagVersionHeader is the header in which tag lookups return the version of
+// the tag.
+const TagVersionHeader = "Kraken-Tag-Version"
+
+// TagRecord is a versioned tag. Versions order the mutations of a tag across
+// build-index replicas, where the record with the highest version wins.
+type TagRecord struct {
+	Digest  core.Digest `json:"digest"`
+	Version int64       `json:"version"`
+}
+
+// Newer returns true if r supersedes other. Ties are broken by digest, such
+// that replicas converge on the same record.
+func (r TagRecord) Newer(other TagRecord) bool {
+	if r.Version != other.Version {
+		return r.Version > other.Version
+	}
+	return r.Digest.String() > other.Digest.String()
+}
+
+// Serialize converts r to the content of tag files, which are persisted on
+// disk and written back to the backend. The version follows the digest on a
+// separate line, such that the backend copy of a tag never supersedes a newer
+// record.
+func (r TagRecord) Serialize() []byte {
+	return []byte(fmt.Sprintf("%s\n%d", r.Digest, r.Version))
+}
+
+// DeserializeTagRecord parses the content of a tag file. Tag files written
+// before versioning only contain the digest, and resolve to version 0.
+func DeserializeTagRecord(b []byte) (TagRecord, error) {
+	parts := strings.SplitN(string(b), "\n", 2)
+	d, err := core.ParseSHA256Digest(parts[0])
+	if err != nil {
+		return TagRecord{}, fmt.Errorf("parse digest: %s", err)
+	}
+	var version int64
+	if len(parts) == 2 {
+		version, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return TagRecord{}, fmt.Errorf("parse version: %s", err)
+		}
+	}
+	return TagRecord{Digest: d, Version: version}, nil
+}
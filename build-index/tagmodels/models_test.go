@@ -0,0 +1,53 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagmodels
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagRecordSerialization(t *testing.T) {
+	require := require.New(t)
+
+	r := TagRecord{Digest: core.DigestFixture(), Version: 1234}
+
+	result, err := DeserializeTagRecord(r.Serialize())
+	require.NoError(err)
+	require.Equal(r, result)
+}
+
+func TestDeserializeUnversionedTagRecord(t *testing.T) {
+	require := require.New(t)
+
+	d := core.DigestFixture()
+
+	result, err := DeserializeTagRecord([]byte(d.String()))
+	require.NoError(err)
+	require.Equal(TagRecord{Digest: d}, result)
+}
+
+func TestDeserializeTagRecordErrors(t *testing.T) {
+	d := core.DigestFixture()
+
+	for _, b := range []string{"", "foo", d.String() + "\nfoo"} {
+		t.Run(b, func(t *testing.T) {
+			_, err := DeserializeTagRecord([]byte(b))
+			require.Error(t, err)
+		})
+	}
+}
@@ -24,6 +24,14 @@ type Config struct {
 	Listener                  listener.Config `yaml:"listener"`
 	DuplicateReplicateStagger time.Duration   `yaml:"duplicate_replicate_stagger"`
 	DuplicatePutStagger       time.Duration   `yaml:"duplicate_put_stagger"`
+
+	// Results of signature verification are cached per tag, digest and key
+	// set for VerificationCacheTTL if trusted, and for
+	// UntrustedVerificationCacheTTL otherwise. At most VerificationCacheSize
+	// results are cached.
+	VerificationCacheTTL          time.Duration `yaml:"verification_cache_ttl"`
+	UntrustedVerificationCacheTTL time.Duration `yaml:"untrusted_verification_cache_ttl"`
+	VerificationCacheSize         int           `yaml:"verification_cache_size"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -33,5 +41,14 @@ func (c Config) applyDefaults() Config {
 	if c.DuplicatePutStagger == 0 {
 		c.DuplicatePutStagger = 20 * time.Minute
 	}
+	if c.VerificationCacheTTL == 0 {
+		c.VerificationCacheTTL = 10 * time.Minute
+	}
+	if c.UntrustedVerificationCacheTTL == 0 {
+		c.UntrustedVerificationCacheTTL = 30 * time.Second
+	}
+	if c.VerificationCacheSize == 0 {
+		c.VerificationCacheSize = 100000
+	}
 	return c
 }
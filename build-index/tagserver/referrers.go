@@ -0,0 +1,269 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/uber/kraken/build-index/tagmodels"
+	"github.com/uber/kraken/build-index/tagstore"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/dockerutil"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// putReferrerHandler adds a manifest to the referrers index of its subject.
+// The index is stored as a regular tag following the referrers tag schema,
+// so registries which do not support the referrers API can still resolve it.
+func (s *Server) putReferrerHandler(w http.ResponseWriter, r *http.Request) error {
+	repo, err := httputil.ParseParam(r, "repo")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+
+	manifest, err := s.downloadBlob(repo, d)
+	if err != nil {
+		return err
+	}
+	subject, desc, ok, err := dockerutil.ParseReferrer(d, manifest)
+	if err != nil {
+		return handler.Errorf("parse manifest: %s", err).Status(http.StatusBadRequest)
+	}
+	if !ok {
+		// Manifest does not refer to anything.
+		return nil
+	}
+
+	s.referrersMu.Lock()
+	defer s.referrersMu.Unlock()
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	index, err := s.getReferrers(repo, tag)
+	if err != nil {
+		return err
+	}
+	if !index.Add(desc) {
+		return nil
+	}
+	indexDigest, err := s.uploadReferrers(repo, index)
+	if err != nil {
+		return err
+	}
+	deps, err := referrersDeps(indexDigest, index)
+	if err != nil {
+		return err
+	}
+	record, err := s.putTag(tag, indexDigest, deps)
+	if err != nil {
+		return err
+	}
+	if err := s.replicateTag(tag, record, deps); err != nil {
+		return err
+	}
+	s.stats.Counter("referrers_added").Inc(1)
+	return nil
+}
+
+// getReferrersHandler returns the referrers index of a subject manifest,
+// optionally filtered by the artifactType query argument. Returns an empty
+// index if the subject has no referrers.
+func (s *Server) getReferrersHandler(w http.ResponseWriter, r *http.Request) error {
+	repo, err := httputil.ParseParam(r, "repo")
+	if err != nil {
+		return err
+	}
+	subject, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	index, err := s.getReferrers(repo, tag)
+	if err != nil {
+		return err
+	}
+	if artifactType := r.URL.Query().Get("artifactType"); artifactType != "" {
+		index = index.Filter(artifactType)
+	}
+
+	w.Header().Set("Content-Type", dockerutil.MediaTypeImageIndex)
+	if err := json.NewEncoder(w).Encode(index); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) getReferrers(repo, tag string) (*dockerutil.ImageIndex, error) {
+	d, err := s.store.Get(tag)
+	if err != nil {
+		if err == tagstore.ErrTagNotFound {
+			return dockerutil.NewImageIndex(), nil
+		}
+		return nil, handler.Errorf("storage: %s", err)
+	}
+	return s.getReferrersIndex(repo, d)
+}
+
+func (s *Server) getReferrersIndex(repo string, d core.Digest) (*dockerutil.ImageIndex, error) {
+	b, err := s.downloadBlob(repo, d)
+	if err != nil {
+		return nil, err
+	}
+	index, err := dockerutil.ParseImageIndex(b)
+	if err != nil {
+		return nil, handler.Errorf("parse referrers index %s: %s", d, err)
+	}
+	return index, nil
+}
+
+func (s *Server) uploadReferrers(repo string, index *dockerutil.ImageIndex) (core.Digest, error) {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return core.Digest{}, handler.Errorf("json marshal: %s", err)
+	}
+	d, err := core.NewDigester().FromBytes(b)
+	if err != nil {
+		return core.Digest{}, handler.Errorf("compute index digest: %s", err)
+	}
+	if err := s.localOriginClient.UploadBlob(repo, d, bytes.NewReader(b)); err != nil {
+		return core.Digest{}, handler.Errorf("upload index: %s", err)
+	}
+	return d, nil
+}
+
+// referrersDeps returns the blobs a referrers tag pointing at index depends on.
+func referrersDeps(indexDigest core.Digest, index *dockerutil.ImageIndex) (core.DigestList, error) {
+	deps := core.DigestList{indexDigest}
+	for _, m := range index.Manifests {
+		md, err := core.ParseSHA256Digest(m.Digest)
+		if err != nil {
+			return nil, handler.Errorf("parse referrer digest: %s", err)
+		}
+		deps = append(deps, md)
+	}
+	return deps, nil
+}
+
+// mergeReferrersRecord merges a record of a referrers tag, which was put on
+// another build-index replica, with the local record of the tag. Since
+// replicas update referrers indexes independently, concurrent referrer puts
+// to different replicas would otherwise overwrite each other's entries under
+// last-writer-wins. If neither record contains all entries of the other, or
+// the record containing all entries is older, the union of both indexes is
+// put under a version newer than both, and duplicated and replicated in place
+// of record. Returns true if record was superseded this way.
+func (s *Server) mergeReferrersRecord(tag string, record tagmodels.TagRecord) (bool, error) {
+	if _, ok := dockerutil.ParseReferrersTag(tag); !ok {
+		return false, nil
+	}
+	repo := tag[:strings.LastIndex(tag, ":")]
+
+	s.referrersMu.Lock()
+	defer s.referrersMu.Unlock()
+
+	cur, err := s.store.GetRecord(tag)
+	if err != nil {
+		if err == tagstore.ErrTagNotFound {
+			return false, nil
+		}
+		return false, handler.Errorf("storage: %s", err)
+	}
+	if cur.Digest == record.Digest {
+		return false, nil
+	}
+	curIndex, err := s.getReferrersIndex(repo, cur.Digest)
+	if err != nil {
+		return false, err
+	}
+	index, err := s.getReferrersIndex(repo, record.Digest)
+	if err != nil {
+		return false, err
+	}
+	hasCur := contains(index, curIndex)
+	hasRecord := contains(curIndex, index)
+	if (hasCur && record.Newer(cur)) || (hasRecord && cur.Newer(record)) {
+		// The newer record already contains all entries, and is resolved by
+		// last-writer-wins.
+		return false, nil
+	}
+
+	merged := tagmodels.TagRecord{Version: cur.Version + 1}
+	if record.Version >= cur.Version {
+		merged.Version = record.Version + 1
+	}
+	var mergedIndex *dockerutil.ImageIndex
+	switch {
+	case hasCur:
+		merged.Digest, mergedIndex = record.Digest, index
+	case hasRecord:
+		merged.Digest, mergedIndex = cur.Digest, curIndex
+	default:
+		mergedIndex = curIndex
+		for _, m := range index.Manifests {
+			mergedIndex.Add(m)
+		}
+		merged.Digest, err = s.uploadReferrers(repo, mergedIndex)
+		if err != nil {
+			return false, err
+		}
+	}
+	deps, err := referrersDeps(merged.Digest, mergedIndex)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.store.PutRecord(tag, merged, 0); err != nil {
+		return false, handler.Errorf("storage: %s", err)
+	}
+	s.stats.Counter("referrers_merged").Inc(1)
+	s.duplicatePut(tag, merged)
+	if err := s.replicateTag(tag, merged, deps); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// contains returns true if index contains all entries of other.
+func contains(index, other *dockerutil.ImageIndex) bool {
+	digests := make(map[string]bool)
+	for _, m := range index.Manifests {
+		digests[m.Digest] = true
+	}
+	for _, m := range other.Manifests {
+		if !digests[m.Digest] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) downloadBlob(namespace string, d core.Digest) ([]byte, error) {
+	var b bytes.Buffer
+	if err := s.localOriginClient.DownloadBlob(namespace, d, &b); err != nil {
+		if err == blobclient.ErrBlobNotFound {
+			return nil, handler.Errorf("blob %s not found", d).Status(http.StatusNotFound)
+		}
+		return nil, handler.Errorf("download blob: %s", err)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,360 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/build-index/tagmodels"
+	"github.com/uber/kraken/build-index/tagstore"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
+	"github.com/uber/kraken/lib/persistedretry/tagreplication"
+	"github.com/uber/kraken/mocks/build-index/tagclient"
+	"github.com/uber/kraken/utils/dockerutil"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/mockutil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func referrersIndexFixture(
+	t *testing.T, manifests ...[]byte) (*dockerutil.ImageIndex, core.Digest, []byte) {
+
+	index := dockerutil.NewImageIndex()
+	for _, m := range manifests {
+		d, err := core.NewDigester().FromBytes(m)
+		require.NoError(t, err)
+		_, desc, ok, err := dockerutil.ParseReferrer(d, m)
+		require.NoError(t, err)
+		require.True(t, ok)
+		index.Add(desc)
+	}
+	b, err := json.Marshal(index)
+	require.NoError(t, err)
+	d, err := core.NewDigester().FromBytes(b)
+	require.NoError(t, err)
+	return index, d, b
+}
+
+func TestPutReferrer(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	sigDigest, sig := dockerutil.ReferrerManifestFixture(subject, "application/vnd.dev.sig")
+
+	_, oldDigest, oldIndex := referrersIndexFixture(t, sbom)
+	_, newDigest, newIndex := referrersIndexFixture(t, sbom, sig)
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	deps := core.DigestList{newDigest, sbomDigest, sigDigest}
+	record := tagmodels.TagRecord{Digest: newDigest, Version: 1}
+	task := tagreplication.NewTask(tag, newDigest, deps, _testRemote, 0)
+	task.Version = record.Version
+	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
+
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, sigDigest, mockutil.MatchWriter(sig)).Return(nil)
+	mocks.store.EXPECT().Get(tag).Return(oldDigest, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, oldDigest, mockutil.MatchWriter(oldIndex)).Return(nil)
+	mocks.originClient.EXPECT().UploadBlob(
+		repo, newDigest, mockutil.MatchReader(newIndex)).Return(nil)
+	for _, d := range deps {
+		mocks.originClient.EXPECT().Stat(tag, d).Return(core.NewBlobInfo(256), nil)
+	}
+	mocks.store.EXPECT().Put(tag, newDigest, time.Duration(0)).Return(record, nil)
+	mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient).Times(2)
+	neighborClient.EXPECT().DuplicatePut(
+		tag, record, mocks.config.DuplicateReplicateStagger).Return(nil)
+	mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil)
+	neighborClient.EXPECT().DuplicateReplicate(
+		tag, record, deps, mocks.config.DuplicateReplicateStagger).Return(nil)
+
+	require.NoError(newClusterClient(addr).PutReferrer(repo, sigDigest))
+}
+
+func TestPutReferrerAlreadyIndexed(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	_, indexDigest, index := referrersIndexFixture(t, sbom)
+
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, sbomDigest, mockutil.MatchWriter(sbom)).Return(nil)
+	mocks.store.EXPECT().Get(
+		fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))).Return(indexDigest, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, indexDigest, mockutil.MatchWriter(index)).Return(nil)
+
+	require.NoError(newClusterClient(addr).PutReferrer(repo, sbomDigest))
+}
+
+func TestPutReferrerNoSubject(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	d, manifest := dockerutil.ManifestFixture(
+		core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, d, mockutil.MatchWriter(manifest)).Return(nil)
+
+	require.NoError(newClusterClient(addr).PutReferrer(repo, d))
+}
+
+func TestGetReferrers(t *testing.T) {
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	_, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	_, sig := dockerutil.ReferrerManifestFixture(subject, "application/vnd.dev.sig")
+	expected, indexDigest, index := referrersIndexFixture(t, sbom, sig)
+
+	t.Run("all", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		mocks.store.EXPECT().Get(tag).Return(indexDigest, nil)
+		mocks.originClient.EXPECT().DownloadBlob(
+			repo, indexDigest, mockutil.MatchWriter(index)).Return(nil)
+
+		result, err := newClusterClient(addr).GetReferrers(repo, subject, "")
+		require.NoError(err)
+		require.Equal(expected, result)
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		mocks.store.EXPECT().Get(tag).Return(indexDigest, nil)
+		mocks.originClient.EXPECT().DownloadBlob(
+			repo, indexDigest, mockutil.MatchWriter(index)).Return(nil)
+
+		result, err := newClusterClient(addr).GetReferrers(
+			repo, subject, "application/vnd.dev.sig")
+		require.NoError(err)
+		require.Len(result.Manifests, 1)
+		require.Equal(expected.Manifests[1], result.Manifests[0])
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		mocks.store.EXPECT().Get(tag).Return(core.Digest{}, tagstore.ErrTagNotFound)
+
+		resp, err := httputil.Get(fmt.Sprintf(
+			"http://%s/repositories/%s/referrers/%s", addr, "some%2Frepo", subject))
+		require.NoError(err)
+		defer resp.Body.Close()
+		require.Equal(http.StatusOK, resp.StatusCode)
+		require.Equal(dockerutil.MediaTypeImageIndex, resp.Header.Get("Content-Type"))
+
+		var result dockerutil.ImageIndex
+		require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+		require.Equal(dockerutil.NewImageIndex(), &result)
+	})
+}
+
+func TestGeneratedReferrersIndexIsNotVerified(t *testing.T) {
+	require := require.New(t)
+
+	config, c := contenttrust.ConfigFixture(contenttrust.NewKeyFixture())
+	defer c()
+
+	verifier, err := contenttrust.New(config)
+	require.NoError(err)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	mocks.verifier = verifier
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	_, indexDigest, index := referrersIndexFixture(t, sbom)
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+
+	mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: indexDigest}, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, indexDigest, mockutil.MatchWriter(index)).Return(nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, sbomDigest, mockutil.MatchWriter(sbom)).Return(nil)
+
+	result, err := newClusterClient(addr).Get(tag)
+	require.NoError(err)
+	require.Equal(indexDigest, result)
+}
+
+func TestPushedReferrersTagIsVerified(t *testing.T) {
+	require := require.New(t)
+
+	config, c := contenttrust.ConfigFixture(contenttrust.NewKeyFixture())
+	defer c()
+
+	verifier, err := contenttrust.New(config)
+	require.NoError(err)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	mocks.verifier = verifier
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	d, manifest := dockerutil.ManifestFixture(
+		core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+
+	// An unsigned image pushed under a referrers tag.
+	mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: d}, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, d, mockutil.MatchWriter(manifest)).Return(nil)
+	mocks.store.EXPECT().Get(contenttrust.SignatureTag(repo, d)).Return(
+		core.Digest{}, tagstore.ErrTagNotFound)
+
+	_, err = newClusterClient(addr).Get(tag)
+	require.Error(err)
+	require.True(httputil.IsStatus(err, http.StatusForbidden))
+}
+
+func TestDuplicatePutMergesConcurrentReferrers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	sigDigest, sig := dockerutil.ReferrerManifestFixture(subject, "application/vnd.dev.sig")
+
+	// Local and remote replicas each added a different referrer.
+	_, localDigest, localIndex := referrersIndexFixture(t, sbom)
+	_, remoteDigest, remoteIndex := referrersIndexFixture(t, sig)
+	_, mergedDigest, mergedIndex := referrersIndexFixture(t, sbom, sig)
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	local := tagmodels.TagRecord{Digest: localDigest, Version: 5}
+	remote := tagmodels.TagRecord{Digest: remoteDigest, Version: 7}
+	merged := tagmodels.TagRecord{Digest: mergedDigest, Version: 8}
+	deps := core.DigestList{mergedDigest, sbomDigest, sigDigest}
+	task := tagreplication.NewTask(tag, mergedDigest, deps, _testRemote, 0)
+	task.Version = merged.Version
+	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
+
+	mocks.store.EXPECT().GetRecord(tag).Return(local, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, localDigest, mockutil.MatchWriter(localIndex)).Return(nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, remoteDigest, mockutil.MatchWriter(remoteIndex)).Return(nil)
+	mocks.originClient.EXPECT().UploadBlob(
+		repo, mergedDigest, mockutil.MatchReader(mergedIndex)).Return(nil)
+	mocks.store.EXPECT().PutRecord(tag, merged, time.Duration(0)).Return(true, nil)
+	mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient).Times(2)
+	neighborClient.EXPECT().DuplicatePut(tag, merged, gomock.Any()).Return(nil)
+	mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil)
+	neighborClient.EXPECT().DuplicateReplicate(tag, merged, deps, gomock.Any()).Return(nil)
+
+	require.NoError(tagclient.NewSingleClient(addr, nil).DuplicatePut(tag, remote, 0))
+}
+
+func TestDuplicatePutAppliesNewerReferrersSuperset(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	_, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	_, sig := dockerutil.ReferrerManifestFixture(subject, "application/vnd.dev.sig")
+
+	_, localDigest, localIndex := referrersIndexFixture(t, sbom)
+	_, remoteDigest, remoteIndex := referrersIndexFixture(t, sbom, sig)
+
+	tag := fmt.Sprintf("%s:%s", repo, dockerutil.ReferrersTag(subject))
+	local := tagmodels.TagRecord{Digest: localDigest, Version: 5}
+	remote := tagmodels.TagRecord{Digest: remoteDigest, Version: 7}
+
+	mocks.store.EXPECT().GetRecord(tag).Return(local, nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, localDigest, mockutil.MatchWriter(localIndex)).Return(nil)
+	mocks.originClient.EXPECT().DownloadBlob(
+		repo, remoteDigest, mockutil.MatchWriter(remoteIndex)).Return(nil)
+	mocks.store.EXPECT().PutRecord(tag, remote, time.Duration(0)).Return(true, nil)
+
+	require.NoError(tagclient.NewSingleClient(addr, nil).DuplicatePut(tag, remote, 0))
+}
@@ -22,6 +22,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uber/kraken/build-index/tagclient"
@@ -31,9 +32,11 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/tagreplication"
 	"github.com/uber/kraken/origin/blobclient"
 	"github.com/uber/kraken/utils/handler"
@@ -41,6 +44,7 @@ import (
 	"github.com/uber/kraken/utils/listener"
 	"github.com/uber/kraken/utils/log"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/pressly/chi"
 	chimiddleware "github.com/pressly/chi/middleware"
 	"github.com/uber-go/tally"
@@ -63,6 +67,16 @@ type Server struct {
 
 	// For checking if a tag has all dependent blobs.
 	depResolver tagtype.DependencyResolver
+
+	notifier notification.Notifier
+
+	// For verifying image signatures before resolving tags.
+	verifier      contenttrust.Verifier
+	trustFetcher  contenttrust.Fetcher
+	verifications *verificationCache
+
+	// Serializes read-modify-write updates of referrers indexes.
+	referrersMu sync.Mutex
 }
 
 // New creates a new Server.
@@ -77,7 +91,9 @@ func New(
 	remotes tagreplication.Remotes,
 	tagReplicationManager persistedretry.Manager,
 	provider tagclient.Provider,
-	depResolver tagtype.DependencyResolver) *Server {
+	depResolver tagtype.DependencyResolver,
+	notifier notification.Notifier,
+	verifier contenttrust.Verifier) *Server {
 
 	config = config.applyDefaults()
 
@@ -97,6 +113,10 @@ func New(
 		tagReplicationManager: tagReplicationManager,
 		provider:              provider,
 		depResolver:           depResolver,
+		notifier:              notifier,
+		verifier:              verifier,
+		trustFetcher:          &trustFetcher{store, localOriginClient},
+		verifications:         newVerificationCache(clock.New(), config),
 	}
 }
 
@@ -115,6 +135,9 @@ func (s *Server) Handler() http.Handler {
 
 	r.Get("/repositories/{repo}/tags", handler.Wrap(s.listRepositoryHandler))
 
+	r.Put("/repositories/{repo}/referrers/{digest}", handler.Wrap(s.putReferrerHandler))
+	r.Get("/repositories/{repo}/referrers/{digest}", handler.Wrap(s.getReferrersHandler))
+
 	r.Get("/list/*", handler.Wrap(s.listHandler))
 
 	r.Post("/remotes/tags/{tag}", handler.Wrap(s.replicateTagHandler))
@@ -158,20 +181,43 @@ func (s *Server) putTagHandler(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return handler.Errorf("parse query arg `replicate`: %s", err)
 	}
+	// Replicated puts carry the version of the record being replicated,
+	// whereas client puts are assigned a new version.
+	version, err := strconv.ParseInt(httputil.GetQueryArg(r, "version", "0"), 10, 64)
+	if err != nil {
+		return handler.Errorf("parse query arg `version`: %s", err).Status(http.StatusBadRequest)
+	}
 
 	deps, err := s.depResolver.Resolve(tag, d)
 	if err != nil {
 		return fmt.Errorf("resolve dependencies: %s", err)
 	}
-	if err := s.putTag(tag, d, deps); err != nil {
-		return err
+	var record tagmodels.TagRecord
+	if version == 0 {
+		record, err = s.putTag(tag, d, deps)
+		if err != nil {
+			return err
+		}
+	} else {
+		record = tagmodels.TagRecord{Digest: d, Version: version}
+		applied, err := s.putTagRecord(tag, record, deps)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			// A newer record has already been put, which is responsible for
+			// its own replication.
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
 	}
 
 	if replicate {
-		if err := s.replicateTag(tag, d, deps); err != nil {
+		if err := s.replicateTag(tag, record, deps); err != nil {
 			return err
 		}
 	}
+	s.notifier.Notify(notification.NewTagEvent(notification.ActionPush, tag, d))
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
@@ -192,8 +238,22 @@ func (s *Server) duplicatePutTagHandler(w http.ResponseWriter, r *http.Request)
 	}
 	delay := req.Delay
 
-	if err := s.store.Put(tag, d, delay); err != nil {
-		return handler.Errorf("storage: %s", err)
+	if req.Version == 0 {
+		// Neighbors which predate tag versions do not send a version.
+		if _, err := s.store.Put(tag, d, delay); err != nil {
+			return handler.Errorf("storage: %s", err)
+		}
+	} else {
+		record := tagmodels.TagRecord{Digest: d, Version: req.Version}
+		merged, err := s.mergeReferrersRecord(tag, record)
+		if err != nil {
+			return err
+		}
+		if !merged {
+			if _, err := s.store.PutRecord(tag, record, delay); err != nil {
+				return handler.Errorf("storage: %s", err)
+			}
+		}
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -206,20 +266,52 @@ func (s *Server) getTagHandler(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	d, err := s.store.Get(tag)
+	record, err := s.store.GetRecord(tag)
 	if err != nil {
 		if err == tagstore.ErrTagNotFound {
 			return handler.ErrorStatus(http.StatusNotFound)
 		}
 		return handler.Errorf("storage: %s", err)
 	}
+	d := record.Digest
+
+	if err := s.verifyTag(tag, d); err != nil {
+		return err
+	}
 
+	w.Header().Set(tagmodels.TagVersionHeader, strconv.FormatInt(record.Version, 10))
 	if _, err := io.WriteString(w, d.String()); err != nil {
 		return handler.Errorf("write digest: %s", err)
 	}
 	return nil
 }
 
+func (s *Server) verifyTag(tag string, d core.Digest) error {
+	k := verificationKey{tag, d, s.verifier.KeySet()}
+	r, ok := s.verifications.get(k)
+	err := r.err
+	if ok {
+		s.stats.Counter("verification_cache_hits").Inc(1)
+	} else {
+		repo := tag
+		if i := strings.LastIndex(tag, ":"); i != -1 {
+			repo = tag[:i]
+		}
+		err = contenttrust.VerifyTag(s.verifier, s.trustFetcher, tag, repo, d)
+		if _, untrusted := err.(*contenttrust.UntrustedError); err == nil || untrusted {
+			s.verifications.put(k, err)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*contenttrust.UntrustedError); ok {
+			s.stats.Counter("untrusted_tags").Inc(1)
+			return handler.Errorf("%s", err).Status(http.StatusForbidden)
+		}
+		return handler.Errorf("verify signature: %s", err)
+	}
+	return nil
+}
+
 func (s *Server) hasTagHandler(w http.ResponseWriter, r *http.Request) error {
 	tag, err := httputil.ParseParam(r, "tag")
 	if err != nil {
@@ -321,18 +413,18 @@ func (s *Server) replicateTagHandler(w http.ResponseWriter, r *http.Request) err
 		return err
 	}
 
-	d, err := s.store.Get(tag)
+	record, err := s.store.GetRecord(tag)
 	if err != nil {
 		if err == tagstore.ErrTagNotFound {
 			return handler.ErrorStatus(http.StatusNotFound)
 		}
 		return handler.Errorf("storage: %s", err)
 	}
-	deps, err := s.depResolver.Resolve(tag, d)
+	deps, err := s.depResolver.Resolve(tag, record.Digest)
 	if err != nil {
 		return fmt.Errorf("resolve dependencies: %s", err)
 	}
-	if err := s.replicateTag(tag, d, deps); err != nil {
+	if err := s.replicateTag(tag, record, deps); err != nil {
 		return err
 	}
 	w.WriteHeader(http.StatusOK)
@@ -357,6 +449,7 @@ func (s *Server) duplicateReplicateTagHandler(w http.ResponseWriter, r *http.Req
 
 	for _, dest := range destinations {
 		task := tagreplication.NewTask(tag, d, req.Dependencies, dest, req.Delay)
+		task.Version = req.Version
 		if err := s.tagReplicationManager.Add(task); err != nil {
 			return handler.Errorf("add replicate task: %s", err)
 		}
@@ -372,7 +465,52 @@ func (s *Server) getOriginHandler(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
-func (s *Server) putTag(tag string, d core.Digest, deps core.DigestList) error {
+// putTag points tag at d under a new version, and duplicates the resulting
+// record to neighbors.
+func (s *Server) putTag(
+	tag string, d core.Digest, deps core.DigestList) (tagmodels.TagRecord, error) {
+
+	if err := s.checkDependencies(tag, deps); err != nil {
+		return tagmodels.TagRecord{}, err
+	}
+	record, err := s.store.Put(tag, d, 0)
+	if err != nil {
+		return tagmodels.TagRecord{}, handler.Errorf("storage: %s", err)
+	}
+	s.duplicatePut(tag, record)
+	return record, nil
+}
+
+// putTagRecord applies a record replicated from another cluster, and
+// duplicates it to neighbors. Returns false if the record is stale, or was
+// merged into a newer record.
+func (s *Server) putTagRecord(
+	tag string, record tagmodels.TagRecord, deps core.DigestList) (bool, error) {
+
+	if err := s.checkDependencies(tag, deps); err != nil {
+		return false, err
+	}
+	merged, err := s.mergeReferrersRecord(tag, record)
+	if err != nil {
+		return false, err
+	}
+	if merged {
+		// The merged record has been put and replicated in place of record.
+		return false, nil
+	}
+	applied, err := s.store.PutRecord(tag, record, 0)
+	if err != nil {
+		return false, handler.Errorf("storage: %s", err)
+	}
+	if !applied {
+		s.stats.Counter("stale_tag_puts").Inc(1)
+		return false, nil
+	}
+	s.duplicatePut(tag, record)
+	return true, nil
+}
+
+func (s *Server) checkDependencies(tag string, deps core.DigestList) error {
 	for _, dep := range deps {
 		if _, err := s.localOriginClient.Stat(tag, dep); err == blobclient.ErrBlobNotFound {
 			return handler.Errorf("cannot upload tag, missing dependency %s", dep)
@@ -380,11 +518,10 @@ func (s *Server) putTag(tag string, d core.Digest, deps core.DigestList) error {
 			return handler.Errorf("check blob: %s", err)
 		}
 	}
+	return nil
+}
 
-	if err := s.store.Put(tag, d, 0); err != nil {
-		return handler.Errorf("storage: %s", err)
-	}
-
+func (s *Server) duplicatePut(tag string, record tagmodels.TagRecord) {
 	neighbors := s.neighbors.Resolve()
 
 	var delay time.Duration
@@ -392,7 +529,7 @@ func (s *Server) putTag(tag string, d core.Digest, deps core.DigestList) error {
 	for addr := range neighbors {
 		delay += s.config.DuplicatePutStagger
 		client := s.provider.Provide(addr)
-		if err := client.DuplicatePut(tag, d, delay); err != nil {
+		if err := client.DuplicatePut(tag, record, delay); err != nil {
 			log.Errorf("Error duplicating put task to %s: %s", addr, err)
 		} else {
 			successes++
@@ -401,17 +538,19 @@ func (s *Server) putTag(tag string, d core.Digest, deps core.DigestList) error {
 	if len(neighbors) != 0 && successes == 0 {
 		s.stats.Counter("duplicate_put_failures").Inc(1)
 	}
-	return nil
 }
 
-func (s *Server) replicateTag(tag string, d core.Digest, deps core.DigestList) error {
+func (s *Server) replicateTag(
+	tag string, record tagmodels.TagRecord, deps core.DigestList) error {
+
 	destinations := s.remotes.Match(tag)
 	if len(destinations) == 0 {
 		return nil
 	}
 
 	for _, dest := range destinations {
-		task := tagreplication.NewTask(tag, d, deps, dest, 0)
+		task := tagreplication.NewTask(tag, record.Digest, deps, dest, 0)
+		task.Version = record.Version
 		if err := s.tagReplicationManager.Add(task); err != nil {
 			return handler.Errorf("add replicate task: %s", err)
 		}
@@ -424,7 +563,7 @@ func (s *Server) replicateTag(tag string, d core.Digest, deps core.DigestList) e
 	for addr := range neighbors { // Loops in random order.
 		delay += s.config.DuplicateReplicateStagger
 		client := s.provider.Provide(addr)
-		if err := client.DuplicateReplicate(tag, d, deps, delay); err != nil {
+		if err := client.DuplicateReplicate(tag, record, deps, delay); err != nil {
 			log.Errorf("Error duplicating replicate task to %s: %s", addr, err)
 		} else {
 			successes++
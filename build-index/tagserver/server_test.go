@@ -23,20 +23,25 @@ import (
 	"time"
 
 	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/build-index/tagmodels"
 	"github.com/uber/kraken/build-index/tagstore"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/tagreplication"
 	"github.com/uber/kraken/mocks/build-index/tagclient"
 	"github.com/uber/kraken/mocks/build-index/tagstore"
 	"github.com/uber/kraken/mocks/build-index/tagtype"
 	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/mocks/lib/persistedretry"
+	"github.com/uber/kraken/mocks/lib/persistedretry/notification"
 	"github.com/uber/kraken/mocks/origin/blobclient"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/mockutil"
 	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -63,6 +68,8 @@ type serverMocks struct {
 	originClient          *mockblobclient.MockClusterClient
 	store                 *mocktagstore.MockStore
 	neighbors             hostlist.List
+	notifier              *mocknotification.MockNotifier
+	verifier              contenttrust.Verifier
 }
 
 func newServerMocks(t *testing.T) (*serverMocks, func()) {
@@ -105,6 +112,8 @@ func newServerMocks(t *testing.T) (*serverMocks, func()) {
 		depResolver:           depResolver,
 		store:                 store,
 		neighbors:             hostlist.Fixture(_testNeighbor),
+		notifier:              mocknotification.NewMockNotifier(ctrl),
+		verifier:              contenttrust.NoopVerifier{},
 	}, cleanup.Run
 }
 
@@ -124,7 +133,9 @@ func (m *serverMocks) handler() http.Handler {
 		m.remotes,
 		m.tagReplicationManager,
 		m.provider,
-		m.depResolver).Handler()
+		m.depResolver,
+		m.notifier,
+		m.verifier).Handler()
 }
 
 func newClusterClient(addr string) tagclient.Client {
@@ -164,16 +175,82 @@ func TestPut(t *testing.T) {
 	digest := core.DigestFixture()
 	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
 
+	record := tagmodels.TagRecord{Digest: digest, Version: 1}
+
 	mocks.depResolver.EXPECT().Resolve(tag, digest).Return(core.DigestList{digest}, nil)
 	mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil)
-	mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(nil)
+	mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(record, nil)
 	mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient)
 	neighborClient.EXPECT().DuplicatePut(
-		tag, digest, mocks.config.DuplicateReplicateStagger).Return(nil)
+		tag, record, mocks.config.DuplicateReplicateStagger).Return(nil)
+	mocks.notifier.EXPECT().Notify(
+		notification.NewTagEvent(notification.ActionPush, tag, digest))
 
 	require.NoError(client.Put(tag, digest))
 }
 
+func TestPutRecordAndReplicate(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newClusterClient(addr)
+
+	tag := core.TagFixture()
+	digest := core.DigestFixture()
+	deps := core.DigestList{digest}
+	record := tagmodels.TagRecord{Digest: digest, Version: 5}
+	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
+	task := tagreplication.NewTask(tag, digest, deps, _testRemote, 0)
+	task.Version = record.Version
+
+	gomock.InOrder(
+		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(deps, nil),
+		mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil),
+		mocks.store.EXPECT().PutRecord(tag, record, time.Duration(0)).Return(true, nil),
+		mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient),
+		neighborClient.EXPECT().DuplicatePut(
+			tag, record, mocks.config.DuplicateReplicateStagger).Return(nil),
+		mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil),
+		mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient),
+		neighborClient.EXPECT().DuplicateReplicate(
+			tag, record, deps, mocks.config.DuplicateReplicateStagger).Return(nil),
+		mocks.notifier.EXPECT().Notify(
+			notification.NewTagEvent(notification.ActionPush, tag, digest)),
+	)
+
+	require.NoError(client.PutRecordAndReplicate(tag, record))
+}
+
+func TestPutRecordAndReplicateStaleRecordIsNoop(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newClusterClient(addr)
+
+	tag := core.TagFixture()
+	digest := core.DigestFixture()
+	record := tagmodels.TagRecord{Digest: digest, Version: 5}
+
+	gomock.InOrder(
+		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(core.DigestList{digest}, nil),
+		mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil),
+		mocks.store.EXPECT().PutRecord(tag, record, time.Duration(0)).Return(false, nil),
+	)
+
+	// No duplication, replication, nor notification for stale records.
+	require.NoError(client.PutRecordAndReplicate(tag, record))
+}
+
 func TestPutInvalidParam(t *testing.T) {
 	tag := core.TagFixture()
 	digest := core.DigestFixture()
@@ -195,6 +272,10 @@ func TestPutInvalidParam(t *testing.T) {
 			"invalid replicate param",
 			fmt.Sprintf("tags/%s/digest/%s?replicate=bar", url.PathEscape(tag), digest),
 			http.StatusInternalServerError,
+		}, {
+			"invalid version param",
+			fmt.Sprintf("tags/%s/digest/%s?version=bar", url.PathEscape(tag), digest),
+			http.StatusBadRequest,
 		},
 	}
 	for _, test := range tests {
@@ -229,9 +310,31 @@ func TestDuplicatePut(t *testing.T) {
 	digest := core.DigestFixture()
 	delay := 5 * time.Minute
 
-	mocks.store.EXPECT().Put(tag, digest, delay).Return(nil)
+	record := tagmodels.TagRecord{Digest: digest, Version: 5}
 
-	require.NoError(client.DuplicatePut(tag, digest, delay))
+	mocks.store.EXPECT().PutRecord(tag, record, delay).Return(true, nil)
+
+	require.NoError(client.DuplicatePut(tag, record, delay))
+}
+
+func TestDuplicatePutUnversioned(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := tagclient.NewSingleClient(addr, nil)
+
+	tag := core.TagFixture()
+	digest := core.DigestFixture()
+	delay := 5 * time.Minute
+
+	mocks.store.EXPECT().Put(tag, digest, delay).Return(tagmodels.TagRecord{Digest: digest}, nil)
+
+	require.NoError(client.DuplicatePut(tag, tagmodels.TagRecord{Digest: digest}, delay))
 }
 
 func TestDuplicatePutInvalidParam(t *testing.T) {
@@ -284,13 +387,34 @@ func TestGet(t *testing.T) {
 	tag := core.TagFixture()
 	digest := core.DigestFixture()
 
-	mocks.store.EXPECT().Get(tag).Return(digest, nil)
+	mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: digest}, nil)
 
 	result, err := client.Get(tag)
 	require.NoError(err)
 	require.Equal(digest, result)
 }
 
+func TestGetRecord(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newClusterClient(addr)
+
+	tag := core.TagFixture()
+	record := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 5}
+
+	mocks.store.EXPECT().GetRecord(tag).Return(record, nil)
+
+	result, err := client.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(record, result)
+}
+
 func TestGetTagNotFound(t *testing.T) {
 	require := require.New(t)
 
@@ -304,12 +428,103 @@ func TestGetTagNotFound(t *testing.T) {
 
 	tag := core.TagFixture()
 
-	mocks.store.EXPECT().Get(tag).Return(core.Digest{}, tagstore.ErrTagNotFound)
+	mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{}, tagstore.ErrTagNotFound)
 
 	_, err := client.Get(tag)
 	require.Equal(tagclient.ErrTagNotFound, err)
 }
 
+func TestGetContentTrust(t *testing.T) {
+	key := contenttrust.NewKeyFixture()
+
+	config, c := contenttrust.ConfigFixture(key)
+	defer c()
+
+	verifier, err := contenttrust.New(config)
+	require.NoError(t, err)
+
+	repo := "some/repo"
+	tag := repo + ":some-tag"
+	digest := core.DigestFixture()
+
+	t.Run("signed", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		mocks.verifier = verifier
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		sig := key.Sign(repo, digest)
+
+		mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: digest}, nil)
+		mocks.store.EXPECT().Get(sig.Tag).Return(sig.ManifestDigest, nil)
+		mocks.originClient.EXPECT().DownloadBlob(
+			repo, sig.ManifestDigest, mockutil.MatchWriter(sig.Manifest)).Return(nil)
+		mocks.originClient.EXPECT().DownloadBlob(
+			repo, sig.PayloadDigest, mockutil.MatchWriter(sig.Payload)).Return(nil)
+
+		client := newClusterClient(addr)
+
+		result, err := client.Get(tag)
+		require.NoError(err)
+		require.Equal(digest, result)
+
+		// Verification is cached, such that the signature is not downloaded again.
+		mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: digest}, nil)
+
+		result, err = client.Get(tag)
+		require.NoError(err)
+		require.Equal(digest, result)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		mocks.verifier = verifier
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: digest}, nil).Times(2)
+		mocks.store.EXPECT().Get(
+			contenttrust.SignatureTag(repo, digest)).Return(core.Digest{}, tagstore.ErrTagNotFound)
+
+		for i := 0; i < 2; i++ {
+			_, err := httputil.Get(fmt.Sprintf("http://%s/tags/%s", addr, url.PathEscape(tag)))
+			require.True(httputil.IsForbidden(err))
+			require.Contains(err.Error(), "not signed")
+		}
+	})
+
+	t.Run("signature tags are not verified", func(t *testing.T) {
+		require := require.New(t)
+
+		mocks, cleanup := newServerMocks(t)
+		defer cleanup()
+
+		mocks.verifier = verifier
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		sigTag := contenttrust.SignatureTag(repo, digest)
+		sigDigest := core.DigestFixture()
+
+		mocks.store.EXPECT().GetRecord(sigTag).Return(tagmodels.TagRecord{Digest: sigDigest}, nil)
+
+		result, err := newClusterClient(addr).Get(sigTag)
+		require.NoError(err)
+		require.Equal(sigDigest, result)
+	})
+}
+
 func TestHas(t *testing.T) {
 	require := require.New(t)
 
@@ -470,20 +685,24 @@ func TestPutAndReplicate(t *testing.T) {
 	digest := core.DigestFixture()
 	deps := core.DigestList{digest}
 	neighborClient := mocktagclient.NewMockClient(mocks.ctrl)
+	record := tagmodels.TagRecord{Digest: digest, Version: 1}
 	task := tagreplication.NewTask(tag, digest, deps, _testRemote, 0)
+	task.Version = record.Version
 	replicaClient := mocks.client()
 
 	gomock.InOrder(
 		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(core.DigestList{digest}, nil),
 		mocks.originClient.EXPECT().Stat(tag, digest).Return(core.NewBlobInfo(256), nil),
-		mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(nil),
+		mocks.store.EXPECT().Put(tag, digest, time.Duration(0)).Return(record, nil),
 		mocks.provider.EXPECT().Provide(_testNeighbor).Return(neighborClient),
 		neighborClient.EXPECT().DuplicatePut(
-			tag, digest, mocks.config.DuplicateReplicateStagger).Return(nil),
+			tag, record, mocks.config.DuplicateReplicateStagger).Return(nil),
 		mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil),
 		mocks.provider.EXPECT().Provide(_testNeighbor).Return(replicaClient),
 		replicaClient.EXPECT().DuplicateReplicate(
-			tag, digest, deps, mocks.config.DuplicateReplicateStagger).Return(nil),
+			tag, record, deps, mocks.config.DuplicateReplicateStagger).Return(nil),
+		mocks.notifier.EXPECT().Notify(
+			notification.NewTagEvent(notification.ActionPush, tag, digest)),
 	)
 
 	require.NoError(client.PutAndReplicate(tag, digest))
@@ -503,16 +722,18 @@ func TestReplicate(t *testing.T) {
 	tag := core.TagFixture()
 	digest := core.DigestFixture()
 	deps := core.DigestList{digest}
+	record := tagmodels.TagRecord{Digest: digest, Version: 5}
 	task := tagreplication.NewTask(tag, digest, deps, _testRemote, 0)
+	task.Version = record.Version
 	replicaClient := mocks.client()
 
 	gomock.InOrder(
-		mocks.store.EXPECT().Get(tag).Return(digest, nil),
+		mocks.store.EXPECT().GetRecord(tag).Return(record, nil),
 		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(deps, nil),
 		mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil),
 		mocks.provider.EXPECT().Provide(_testNeighbor).Return(replicaClient),
 		replicaClient.EXPECT().DuplicateReplicate(
-			tag, digest, deps, mocks.config.DuplicateReplicateStagger).Return(nil),
+			tag, record, deps, mocks.config.DuplicateReplicateStagger).Return(nil),
 	)
 
 	require.NoError(client.Replicate(tag))
@@ -532,7 +753,7 @@ func TestReplicateNotFound(t *testing.T) {
 	tag := core.TagFixture()
 
 	gomock.InOrder(
-		mocks.store.EXPECT().Get(tag).Return(core.Digest{}, tagstore.ErrTagNotFound),
+		mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{}, tagstore.ErrTagNotFound),
 	)
 
 	err := client.Replicate(tag)
@@ -555,11 +776,13 @@ func TestDuplicateReplicate(t *testing.T) {
 	digest := core.DigestFixture()
 	dependencies := core.DigestListFixture(3)
 	delay := 5 * time.Minute
+	record := tagmodels.TagRecord{Digest: digest, Version: 5}
 	task := tagreplication.NewTask(tag, digest, dependencies, _testRemote, delay)
+	task.Version = record.Version
 
 	mocks.tagReplicationManager.EXPECT().Add(tagreplication.MatchTask(task)).Return(nil)
 
-	require.NoError(client.DuplicateReplicate(tag, digest, dependencies, delay))
+	require.NoError(client.DuplicateReplicate(tag, record, dependencies, delay))
 }
 
 func TestDuplicateReplicateInvalidParam(t *testing.T) {
@@ -619,7 +842,7 @@ func TestNoopReplicate(t *testing.T) {
 	deps := core.DigestList{digest}
 
 	gomock.InOrder(
-		mocks.store.EXPECT().Get(tag).Return(digest, nil),
+		mocks.store.EXPECT().GetRecord(tag).Return(tagmodels.TagRecord{Digest: digest}, nil),
 		mocks.depResolver.EXPECT().Resolve(tag, digest).Return(deps, nil),
 	)
 
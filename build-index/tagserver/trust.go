@@ -0,0 +1,49 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagserver
+
+import (
+	"bytes"
+
+	"github.com/uber/kraken/build-index/tagstore"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
+	"github.com/uber/kraken/origin/blobclient"
+)
+
+// trustFetcher fetches signature artifacts from the local tag store and
+// origin cluster.
+type trustFetcher struct {
+	store  tagstore.Store
+	origin blobclient.ClusterClient
+}
+
+func (f *trustFetcher) GetTag(tag string) (core.Digest, error) {
+	d, err := f.store.Get(tag)
+	if err == tagstore.ErrTagNotFound {
+		return core.Digest{}, contenttrust.ErrNotFound
+	}
+	return d, err
+}
+
+func (f *trustFetcher) GetBlob(namespace string, d core.Digest) ([]byte, error) {
+	var b bytes.Buffer
+	if err := f.origin.DownloadBlob(namespace, d, &b); err != nil {
+		if err == blobclient.ErrBlobNotFound {
+			return nil, contenttrust.ErrNotFound
+		}
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+type verificationKey struct {
+	tag    string
+	digest core.Digest
+	keySet string
+}
+
+type verificationResult struct {
+	err     error // Nil if trusted, else an *contenttrust.UntrustedError.
+	expires time.Time
+}
+
+// verificationCache caches the results of signature verification, which
+// otherwise downloads signature artifacts on every tag lookup. Untrusted
+// results expire sooner, such that newly signed images are trusted quickly.
+type verificationCache struct {
+	clk          clock.Clock
+	ttl          time.Duration
+	untrustedTTL time.Duration
+	maxSize      int
+
+	mu      sync.Mutex
+	results map[verificationKey]verificationResult
+}
+
+func newVerificationCache(clk clock.Clock, config Config) *verificationCache {
+	return &verificationCache{
+		clk:          clk,
+		ttl:          config.VerificationCacheTTL,
+		untrustedTTL: config.UntrustedVerificationCacheTTL,
+		maxSize:      config.VerificationCacheSize,
+		results:      make(map[verificationKey]verificationResult),
+	}
+}
+
+// get returns the cached result of k, if any.
+func (c *verificationCache) get(k verificationKey) (verificationResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.results[k]
+	if !ok {
+		return verificationResult{}, false
+	}
+	if !c.clk.Now().Before(r.expires) {
+		delete(c.results, k)
+		return verificationResult{}, false
+	}
+	return r, true
+}
+
+// put caches the result of k. err must be nil or an untrusted error.
+func (c *verificationCache) put(k verificationKey, err error) {
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.untrustedTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clk.Now()
+	if len(c.results) >= c.maxSize {
+		for k, r := range c.results {
+			if !now.Before(r.expires) {
+				delete(c.results, k)
+			}
+		}
+		if len(c.results) >= c.maxSize {
+			return
+		}
+	}
+	c.results[k] = verificationResult{err, now.Add(ttl)}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tagserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerificationCacheExpiresResults(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	c := newVerificationCache(clk, Config{}.applyDefaults())
+
+	trusted := verificationKey{"repo:a", core.DigestFixture(), "keys"}
+	untrusted := verificationKey{"repo:b", core.DigestFixture(), "keys"}
+
+	c.put(trusted, nil)
+	c.put(untrusted, &contenttrust.UntrustedError{})
+
+	r, ok := c.get(trusted)
+	require.True(ok)
+	require.NoError(r.err)
+
+	r, ok = c.get(untrusted)
+	require.True(ok)
+	require.Error(r.err)
+
+	clk.Add(time.Minute)
+
+	_, ok = c.get(untrusted)
+	require.False(ok)
+	_, ok = c.get(trusted)
+	require.True(ok)
+
+	clk.Add(10 * time.Minute)
+
+	_, ok = c.get(trusted)
+	require.False(ok)
+}
+
+func TestVerificationCacheKeySetChangeMisses(t *testing.T) {
+	require := require.New(t)
+
+	c := newVerificationCache(clock.NewMock(), Config{}.applyDefaults())
+
+	k := verificationKey{"repo:a", core.DigestFixture(), "old"}
+	c.put(k, nil)
+
+	k.keySet = "new"
+	_, ok := c.get(k)
+	require.False(ok)
+}
+
+func TestVerificationCacheMaxSize(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	c := newVerificationCache(clk, Config{VerificationCacheSize: 1}.applyDefaults())
+
+	k1 := verificationKey{"repo:a", core.DigestFixture(), "keys"}
+	k2 := verificationKey{"repo:b", core.DigestFixture(), "keys"}
+
+	c.put(k1, nil)
+	c.put(k2, nil)
+
+	_, ok := c.get(k2)
+	require.False(ok)
+
+	// Expired results make room for new ones.
+	clk.Add(time.Hour)
+	c.put(k2, nil)
+
+	_, ok = c.get(k2)
+	require.True(ok)
+}
@@ -19,8 +19,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/uber/kraken/build-index/tagmodels"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/backend/backenderrors"
@@ -28,6 +30,7 @@ import (
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
 
 	"github.com/uber-go/tally"
 )
@@ -42,12 +45,22 @@ type FileStore interface {
 	CreateCacheFile(name string, r io.Reader) error
 	SetCacheFileMetadata(name string, md metadata.Metadata) (bool, error)
 	GetCacheFileReader(name string) (store.FileReader, error)
+	GetCacheFileMetadata(name string, md metadata.Metadata) error
+	OverwriteCacheFile(name string, r io.Reader) error
 }
 
 // Store defines tag storage operations.
 type Store interface {
-	Put(tag string, d core.Digest, writeBackDelay time.Duration) error
+	// Put points tag at d, bumping the version of the tag. Returns the
+	// resulting record.
+	Put(tag string, d core.Digest, writeBackDelay time.Duration) (tagmodels.TagRecord, error)
+
+	// PutRecord applies r if it is newer than the current record of the tag.
+	// Returns false if r is stale.
+	PutRecord(tag string, r tagmodels.TagRecord, writeBackDelay time.Duration) (bool, error)
+
 	Get(tag string) (core.Digest, error)
+	GetRecord(tag string) (tagmodels.TagRecord, error)
 }
 
 // tagStore encapsulates two-level tag storage:
@@ -55,9 +68,13 @@ type Store interface {
 // 2. Remote storage: durable tag storage.
 type tagStore struct {
 	config           Config
+	stats            tally.Scope
 	fs               FileStore
 	backends         *backend.Manager
 	writeBackManager persistedretry.Manager
+
+	// Serializes mutations of tags on disk.
+	mu sync.Mutex
 }
 
 // New creates a new Store.
@@ -74,21 +91,114 @@ func New(
 
 	return &tagStore{
 		config:           config,
+		stats:            stats,
 		fs:               fs,
 		backends:         backends,
 		writeBackManager: writeBackManager,
 	}
 }
 
-func (s *tagStore) Put(tag string, d core.Digest, writeBackDelay time.Duration) error {
-	if err := s.writeTagToDisk(tag, d); err != nil {
-		return fmt.Errorf("write tag to disk: %s", err)
+func (s *tagStore) Put(
+	tag string, d core.Digest, writeBackDelay time.Duration) (tagmodels.TagRecord, error) {
+
+	s.mu.Lock()
+	cur, err := s.readRecordFromDisk(tag)
+	if err != nil && err != ErrTagNotFound {
+		s.mu.Unlock()
+		return tagmodels.TagRecord{}, fmt.Errorf("read tag from disk: %s", err)
 	}
-	if _, err := s.fs.SetCacheFileMetadata(tag, metadata.NewPersist(true)); err != nil {
-		return fmt.Errorf("set persist metadata: %s", err)
+	if err == nil && cur.Digest == d {
+		// Re-putting the same digest is not a mutation.
+		s.mu.Unlock()
+		return cur, nil
+	}
+	r := tagmodels.TagRecord{Digest: d, Version: nextVersion(cur.Version)}
+	err = s.writeRecordToDisk(tag, r, err == nil)
+	s.mu.Unlock()
+	if err != nil {
+		return tagmodels.TagRecord{}, err
+	}
+	if cur.Version != 0 {
+		s.stats.Counter("mutations").Inc(1)
+	}
+	if err := s.writeBack(tag, writeBackDelay); err != nil {
+		return tagmodels.TagRecord{}, err
+	}
+	return r, nil
+}
+
+func (s *tagStore) PutRecord(
+	tag string, r tagmodels.TagRecord, writeBackDelay time.Duration) (bool, error) {
+
+	s.mu.Lock()
+	cur, err := s.readRecordFromDisk(tag)
+	if err != nil && err != ErrTagNotFound {
+		s.mu.Unlock()
+		return false, fmt.Errorf("read tag from disk: %s", err)
+	}
+	exists := err == nil
+	if exists && !r.Newer(cur) {
+		s.mu.Unlock()
+		if r != cur {
+			s.stats.Counter("stale_writes").Inc(1)
+			log.With("tag", tag, "current", cur, "record", r).Info("Ignoring stale tag write")
+		}
+		return r == cur, nil
+	}
+	err = s.writeRecordToDisk(tag, r, exists)
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		s.stats.Counter("mutations").Inc(1)
+	}
+	if err := s.writeBack(tag, writeBackDelay); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *tagStore) Get(tag string) (core.Digest, error) {
+	r, err := s.GetRecord(tag)
+	if err != nil {
+		return core.Digest{}, err
+	}
+	return r.Digest, nil
+}
+
+// GetRecord returns the current record of tag. Tags which are only present in
+// the backend resolve to the version written back with them, or version 0 if
+// they were written back before versioning.
+func (s *tagStore) GetRecord(tag string) (r tagmodels.TagRecord, err error) {
+	for _, resolve := range []func(tag string) (tagmodels.TagRecord, error){
+		s.resolveFromDisk,
+		s.resolveFromBackend,
+	} {
+		r, err = resolve(tag)
+		if err == ErrTagNotFound {
+			continue
+		}
+		break
 	}
+	return r, err
+}
 
+// nextVersion returns a version which supersedes cur. Versions are based on
+// wall-clock time such that independent mutations of a tag across replicas
+// are ordered by time.
+func nextVersion(cur int64) int64 {
+	v := time.Now().UnixNano()
+	if v <= cur {
+		v = cur + 1
+	}
+	return v
+}
+
+func (s *tagStore) writeBack(tag string, writeBackDelay time.Duration) error {
 	task := writeback.NewTask(tag, tag, writeBackDelay)
+	// Tags are mutable, so the backend copy must always be replaced.
+	task.Overwrite = true
 	if s.config.WriteThrough {
 		if err := s.writeBackManager.SyncExec(task); err != nil {
 			return fmt.Errorf("sync exec write-back task: %s", err)
@@ -101,63 +211,70 @@ func (s *tagStore) Put(tag string, d core.Digest, writeBackDelay time.Duration)
 	return nil
 }
 
-func (s *tagStore) Get(tag string) (d core.Digest, err error) {
-	for _, resolve := range []func(tag string) (core.Digest, error){
-		s.resolveFromDisk,
-		s.resolveFromBackend,
-	} {
-		d, err = resolve(tag)
-		if err == ErrTagNotFound {
-			continue
+// writeRecordToDisk must be called with s.mu held. If overwrite is set, the
+// existing tag file is replaced.
+func (s *tagStore) writeRecordToDisk(tag string, r tagmodels.TagRecord, overwrite bool) error {
+	buf := bytes.NewReader(r.Serialize())
+	if overwrite {
+		// The content is replaced atomically, preserving persistence, such
+		// that the tag never falls back to the backend copy.
+		if err := s.fs.OverwriteCacheFile(tag, buf); err != nil {
+			return fmt.Errorf("overwrite tag on disk: %s", err)
 		}
-		break
+		return nil
 	}
-	return d, err
-}
-
-func (s *tagStore) writeTagToDisk(tag string, d core.Digest) error {
-	buf := bytes.NewBufferString(d.String())
 	if err := s.fs.CreateCacheFile(tag, buf); err != nil && !os.IsExist(err) {
-		return err
+		return fmt.Errorf("write tag to disk: %s", err)
+	}
+	if _, err := s.fs.SetCacheFileMetadata(tag, metadata.NewPersist(true)); err != nil {
+		return fmt.Errorf("set persist metadata: %s", err)
 	}
 	return nil
 }
 
-func (s *tagStore) resolveFromDisk(tag string) (core.Digest, error) {
+func (s *tagStore) resolveFromDisk(tag string) (tagmodels.TagRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readRecordFromDisk(tag)
+}
+
+// readRecordFromDisk must be called with s.mu held.
+func (s *tagStore) readRecordFromDisk(tag string) (tagmodels.TagRecord, error) {
 	f, err := s.fs.GetCacheFileReader(tag)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return core.Digest{}, ErrTagNotFound
+			return tagmodels.TagRecord{}, ErrTagNotFound
 		}
-		return core.Digest{}, fmt.Errorf("fs: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("fs: %s", err)
 	}
 	defer f.Close()
 	var b bytes.Buffer
 	if _, err := io.Copy(&b, f); err != nil {
-		return core.Digest{}, fmt.Errorf("copy from fs: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("copy from fs: %s", err)
 	}
-	d, err := core.ParseSHA256Digest(b.String())
+	r, err := tagmodels.DeserializeTagRecord(b.Bytes())
 	if err != nil {
-		return core.Digest{}, fmt.Errorf("parse fs digest: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("parse fs record: %s", err)
 	}
-	return d, nil
+	return r, nil
 }
 
-func (s *tagStore) resolveFromBackend(tag string) (core.Digest, error) {
+func (s *tagStore) resolveFromBackend(tag string) (tagmodels.TagRecord, error) {
 	backendClient, err := s.backends.GetClient(tag)
 	if err != nil {
-		return core.Digest{}, fmt.Errorf("backend manager: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("backend manager: %s", err)
 	}
 	var b bytes.Buffer
 	if err := backendClient.Download(tag, tag, &b); err != nil {
 		if err == backenderrors.ErrBlobNotFound {
-			return core.Digest{}, ErrTagNotFound
+			return tagmodels.TagRecord{}, ErrTagNotFound
 		}
-		return core.Digest{}, fmt.Errorf("backend client: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("backend client: %s", err)
 	}
-	d, err := core.ParseSHA256Digest(b.String())
+	r, err := tagmodels.DeserializeTagRecord(b.Bytes())
 	if err != nil {
-		return core.Digest{}, fmt.Errorf("parse backend digest: %s", err)
+		return tagmodels.TagRecord{}, fmt.Errorf("parse backend record: %s", err)
 	}
-	return d, nil
+	return r, nil
 }
@@ -16,15 +16,18 @@ package tagstore_test
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"sync"
 	"testing"
 
+	"github.com/uber/kraken/build-index/tagmodels"
 	. "github.com/uber/kraken/build-index/tagstore"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/backend/backenderrors"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/mocks/lib/persistedretry"
 	"github.com/uber/kraken/utils/mockutil"
@@ -68,6 +71,12 @@ func (m *storeMocks) new(config Config) Store {
 	return New(config, tally.NoopScope, m.ss, m.backends, m.writeBackManager)
 }
 
+func newWriteBackTask(tag string) *writeback.Task {
+	task := writeback.NewTask(tag, tag, 0)
+	task.Overwrite = true
+	return task
+}
+
 func checkConcurrentGets(t *testing.T, store Store, tag string, expected core.Digest) {
 	t.Helper()
 
@@ -93,9 +102,10 @@ func TestPutAndGetFromDisk(t *testing.T) {
 	digest := core.DigestFixture()
 
 	mocks.writeBackManager.EXPECT().Add(
-		writeback.MatchTask(writeback.NewTask(tag, tag, 0))).Return(nil)
+		writeback.MatchTask(newWriteBackTask(tag))).Return(nil)
 
-	require.NoError(store.Put(tag, digest, 0))
+	_, err := store.Put(tag, digest, 0)
+	require.NoError(err)
 
 	result, err := store.Get(tag)
 	require.NoError(err)
@@ -114,15 +124,153 @@ func TestPutAndGetFromDiskWriteThrough(t *testing.T) {
 	digest := core.DigestFixture()
 
 	mocks.writeBackManager.EXPECT().SyncExec(
-		writeback.MatchTask(writeback.NewTask(tag, tag, 0))).Return(nil)
+		writeback.MatchTask(newWriteBackTask(tag))).Return(nil)
 
-	require.NoError(store.Put(tag, digest, 0))
+	_, err := store.Put(tag, digest, 0)
+	require.NoError(err)
 
 	result, err := store.Get(tag)
 	require.NoError(err)
 	require.Equal(digest, result)
 }
 
+func TestPutMutatesTag(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStoreMocks(t)
+	defer cleanup()
+
+	store := mocks.new(Config{})
+
+	tag := core.TagFixture()
+	d1 := core.DigestFixture()
+	d2 := core.DigestFixture()
+
+	mocks.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(newWriteBackTask(tag))).Return(nil).Times(2)
+
+	r1, err := store.Put(tag, d1, 0)
+	require.NoError(err)
+
+	// Re-putting the same digest does not bump the version.
+	result, err := store.Put(tag, d1, 0)
+	require.NoError(err)
+	require.Equal(r1, result)
+
+	r2, err := store.Put(tag, d2, 0)
+	require.NoError(err)
+	require.Equal(d2, r2.Digest)
+	require.True(r2.Version > r1.Version)
+
+	record, err := store.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(r2, record)
+
+	// The tag stays persisted across mutations, such that it is never
+	// evicted in favor of a stale backend copy.
+	var p metadata.Persist
+	require.NoError(mocks.ss.GetCacheFileMetadata(tag, &p))
+	require.True(p.Value)
+}
+
+func TestPutRecordLastWriterWins(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStoreMocks(t)
+	defer cleanup()
+
+	store := mocks.new(Config{})
+
+	tag := core.TagFixture()
+	older := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 1}
+	newer := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+
+	mocks.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(newWriteBackTask(tag))).Return(nil).Times(3)
+
+	applied, err := store.PutRecord(tag, older, 0)
+	require.NoError(err)
+	require.True(applied)
+
+	applied, err = store.PutRecord(tag, newer, 0)
+	require.NoError(err)
+	require.True(applied)
+
+	// Stale records are ignored.
+	applied, err = store.PutRecord(tag, older, 0)
+	require.NoError(err)
+	require.False(applied)
+
+	record, err := store.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(newer, record)
+
+	// Local mutations supersede replicated records.
+	mutation, err := store.Put(tag, core.DigestFixture(), 0)
+	require.NoError(err)
+	require.True(mutation.Newer(newer))
+}
+
+func TestGetRecordFromBackendKeepsVersion(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStoreMocks(t)
+	defer cleanup()
+
+	store := mocks.new(Config{})
+
+	tag := core.TagFixture()
+
+	mocks.writeBackManager.EXPECT().Add(
+		writeback.MatchTask(newWriteBackTask(tag))).Return(nil)
+
+	r, err := store.Put(tag, core.DigestFixture(), 0)
+	require.NoError(err)
+
+	// The tag file, which is written back as is, carries the version.
+	f, err := mocks.ss.GetCacheFileReader(tag)
+	require.NoError(err)
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	require.NoError(err)
+
+	// Once the tag is written back and evicted from disk, the backend copy
+	// resolves to the same record.
+	require.NoError(mocks.ss.DeleteCacheFileMetadata(tag, &metadata.Persist{}))
+	require.NoError(mocks.ss.DeleteCacheFile(tag))
+	mocks.backendClient.EXPECT().Download(tag, tag, gomock.Any()).DoAndReturn(
+		func(namespace, name string, dst io.Writer) error {
+			_, err := dst.Write(content)
+			return err
+		})
+
+	record, err := store.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(r, record)
+}
+
+func TestGetRecordFromBackendIsUnversioned(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStoreMocks(t)
+	defer cleanup()
+
+	store := mocks.new(Config{})
+
+	tag := core.TagFixture()
+	digest := core.DigestFixture()
+
+	mocks.backendClient.EXPECT().Download(tag, tag, gomock.Any()).DoAndReturn(
+		func(namespace, name string, dst io.Writer) error {
+			_, err := io.WriteString(dst, digest.String())
+			return err
+		})
+
+	record, err := store.GetRecord(tag)
+	require.NoError(err)
+	require.Equal(tagmodels.TagRecord{Digest: digest}, record)
+}
+
 func TestGetFromBackendNotFound(t *testing.T) {
 	require := require.New(t)
 
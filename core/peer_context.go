@@ -13,7 +13,12 @@
 // limitations under the License.
 package core
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
 
 // PeerContext defines the context a peer runs within, namely the fields which
 // are used to identify each peer.
@@ -26,6 +31,15 @@ type PeerContext struct {
 	IP   string `json:"ip"`
 	Port int    `json:"port"`
 
+	// SecondaryIP is the address of a dual-stack peer in the IP family other
+	// than IP's, announced alongside IP so that peers which only support one
+	// family can still reach it. Empty for single-stack peers.
+	SecondaryIP string `json:"secondary_ip,omitempty"`
+
+	// Addresses are the addresses of a multi-homed peer on named networks, on
+	// which peers sharing the network reach it instead of IP.
+	Addresses []PeerAddress `json:"addresses,omitempty"`
+
 	// PeerID the peer will identify itself as.
 	PeerID PeerID `json:"peer_id"`
 
@@ -62,3 +76,38 @@ func NewPeerContext(
 		Origin:  origin,
 	}, nil
 }
+
+// SetSecondaryIP sets the secondary address of a dual-stack peer, which must
+// be in a different IP family than the primary address.
+func (pctx *PeerContext) SetSecondaryIP(ip string) error {
+	primary := net.ParseIP(pctx.IP)
+	if primary == nil {
+		return fmt.Errorf("primary ip %q is not an ip address", pctx.IP)
+	}
+	secondary := net.ParseIP(ip)
+	if secondary == nil {
+		return fmt.Errorf("secondary ip %q is not an ip address", ip)
+	}
+	if isIPv4(primary) == isIPv4(secondary) {
+		return fmt.Errorf("primary ip %s and secondary ip %s are in the same family", pctx.IP, ip)
+	}
+	pctx.SecondaryIP = ip
+	return nil
+}
+
+// AddNetworkAddress adds the address of the peer on a named network.
+func (pctx *PeerContext) AddNetworkAddress(network, ip string) error {
+	if network == "" || strings.ContainsAny(network, ",=") {
+		return fmt.Errorf("invalid network name %q", network)
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("ip %q of network %s is not an ip address", ip, network)
+	}
+	for _, a := range pctx.Addresses {
+		if a.Network == network {
+			return fmt.Errorf("duplicate address for network %s", network)
+		}
+	}
+	pctx.Addresses = append(pctx.Addresses, PeerAddress{Network: network, IP: ip})
+	return nil
+}
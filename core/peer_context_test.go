@@ -65,3 +65,53 @@ func TestNewOriginPeerContextErrors(t *testing.T) {
 		require.Error(err)
 	})
 }
+
+func TestPeerContextSetSecondaryIP(t *testing.T) {
+	tests := []struct {
+		desc        string
+		ip          string
+		secondaryIP string
+		ok          bool
+	}{
+		{"ipv4 and ipv6", "10.0.0.1", "2001:db8::1", true},
+		{"ipv6 and ipv4", "2001:db8::1", "10.0.0.1", true},
+		{"same family", "10.0.0.1", "10.0.0.2", false},
+		{"invalid secondary", "10.0.0.1", "localhost", false},
+		{"invalid primary", "localhost", "2001:db8::1", false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			pctx := PeerContextFixture()
+			pctx.IP = test.ip
+			err := pctx.SetSecondaryIP(test.secondaryIP)
+			if test.ok {
+				require.NoError(err)
+				require.Equal(test.secondaryIP, pctx.SecondaryIP)
+				require.Equal(test.secondaryIP, PeerInfoFromContext(pctx, false).SecondaryIP)
+			} else {
+				require.Error(err)
+			}
+		})
+	}
+}
+
+func TestPeerContextAddNetworkAddress(t *testing.T) {
+	require := require.New(t)
+
+	pctx := PeerContextFixture()
+	require.NoError(pctx.AddNetworkAddress("storage", "192.168.0.1"))
+	require.NoError(pctx.AddNetworkAddress("backbone", "fd00::1"))
+
+	require.Equal([]PeerAddress{
+		{"storage", "192.168.0.1"},
+		{"backbone", "fd00::1"},
+	}, PeerInfoFromContext(pctx, false).Addresses)
+
+	require.Error(pctx.AddNetworkAddress("storage", "192.168.0.2"))
+	require.Error(pctx.AddNetworkAddress("", "192.168.0.2"))
+	require.Error(pctx.AddNetworkAddress("a=b", "192.168.0.2"))
+	require.Error(pctx.AddNetworkAddress("a,b", "192.168.0.2"))
+	require.Error(pctx.AddNetworkAddress("other", "localhost"))
+}
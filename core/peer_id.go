@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
+	"strconv"
 )
 
 // PeerIDFactory defines the method used to generate a peer id.
@@ -38,7 +40,7 @@ func (f PeerIDFactory) GeneratePeerID(ip string, port int) (PeerID, error) {
 	case RandomPeerIDFactory:
 		return RandomPeerID()
 	case AddrHashPeerIDFactory:
-		return HashedPeerID(fmt.Sprintf("%s:%d", ip, port))
+		return HashedPeerID(net.JoinHostPort(ip, strconv.Itoa(port)))
 	default:
 		err := fmt.Errorf("invalid peer id factory: %q", string(f))
 		return PeerID{}, err
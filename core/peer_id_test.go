@@ -86,3 +86,13 @@ func TestPeerIDCompare(t *testing.T) {
 		require.True(peer2.LessThan(peer1))
 	}
 }
+
+func TestAddrHashPeerIDFactoryIPv6(t *testing.T) {
+	require := require.New(t)
+
+	id, err := AddrHashPeerIDFactory.GeneratePeerID("2001:db8::1", 8000)
+	require.NoError(err)
+	expected, err := HashedPeerID("[2001:db8::1]:8000")
+	require.NoError(err)
+	require.Equal(expected, id)
+}
@@ -13,7 +13,11 @@
 // limitations under the License.
 package core
 
-import "sort"
+import (
+	"net"
+	"sort"
+	"strconv"
+)
 
 // PeerInfo defines peer metadata scoped to a torrent.
 type PeerInfo struct {
@@ -22,6 +26,21 @@ type PeerInfo struct {
 	Port     int    `json:"port"`
 	Origin   bool   `json:"origin"`
 	Complete bool   `json:"complete"`
+
+	// SecondaryIP is the address of a dual-stack peer in the IP family other
+	// than IP's. Empty for single-stack peers.
+	SecondaryIP string `json:"secondary_ip,omitempty"`
+
+	// Addresses are the addresses of a multi-homed peer on named networks.
+	Addresses []PeerAddress `json:"addresses,omitempty"`
+}
+
+// PeerAddress is the address of a peer on a named network, such as a dedicated
+// storage network. Peers which share a network reach each other through their
+// addresses on it.
+type PeerAddress struct {
+	Network string `json:"network"`
+	IP      string `json:"ip"`
 }
 
 // NewPeerInfo creates a new PeerInfo.
@@ -43,7 +62,100 @@ func NewPeerInfo(
 
 // PeerInfoFromContext derives PeerInfo from a PeerContext.
 func PeerInfoFromContext(pctx PeerContext, complete bool) *PeerInfo {
-	return NewPeerInfo(pctx.PeerID, pctx.IP, pctx.Port, pctx.Origin, complete)
+	p := NewPeerInfo(pctx.PeerID, pctx.IP, pctx.Port, pctx.Origin, complete)
+	p.SecondaryIP = pctx.SecondaryIP
+	p.Addresses = pctx.Addresses
+	return p
+}
+
+// Addr returns the "ip:port" address of p, with IPv6 addresses bracketed.
+func (p *PeerInfo) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+}
+
+// HasIP returns true if ip is any of the addresses of p.
+func (p *PeerInfo) HasIP(ip string) bool {
+	if ip == p.IP || ip == p.SecondaryIP {
+		return true
+	}
+	for _, a := range p.Addresses {
+		if ip == a.IP {
+			return true
+		}
+	}
+	return false
+}
+
+// ReachableFrom returns a copy of p with the single IP which is reachable from
+// a peer with the addresses of other. If the peers share a named network,
+// p's address on that network is used. Otherwise, an address in an IP family
+// which both peers support is used, preferring the primary IP of p. Returns
+// false if the peers share neither a network nor an IP family.
+//
+// Addresses which are not IP literals, such as host names, are assumed to be
+// reachable.
+func (p *PeerInfo) ReachableFrom(other *PeerInfo) (*PeerInfo, bool) {
+	for _, a := range p.Addresses {
+		if other.onNetwork(a.Network) {
+			return p.withIP(a.IP), true
+		}
+	}
+	v4, v6, ok := other.families()
+	if !ok {
+		return p.withIP(p.IP), true
+	}
+	for _, addr := range []string{p.IP, p.SecondaryIP} {
+		if addr == "" {
+			continue
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || (isIPv4(ip) && v4) || (!isIPv4(ip) && v6) {
+			return p.withIP(addr), true
+		}
+	}
+	return nil, false
+}
+
+// withIP returns a copy of p with ip as its only address.
+func (p *PeerInfo) withIP(ip string) *PeerInfo {
+	c := *p
+	c.IP = ip
+	c.SecondaryIP = ""
+	c.Addresses = nil
+	return &c
+}
+
+func (p *PeerInfo) onNetwork(network string) bool {
+	for _, a := range p.Addresses {
+		if a.Network == network {
+			return true
+		}
+	}
+	return false
+}
+
+// families returns which IP families p has addresses in. Returns false if
+// the families cannot be determined.
+func (p *PeerInfo) families() (v4 bool, v6 bool, ok bool) {
+	for _, addr := range []string{p.IP, p.SecondaryIP} {
+		if addr == "" {
+			continue
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false, false, false
+		}
+		if isIPv4(ip) {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return v4, v6, v4 || v6
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
 }
 
 // PeerInfos groups PeerInfo structs for sorting.
@@ -30,3 +30,145 @@ func TestSortedByPeerID(t *testing.T) {
 	require.True(sorted[0].PeerID.LessThan(sorted[1].PeerID))
 	require.True(sorted[1].PeerID.LessThan(sorted[2].PeerID))
 }
+
+func TestPeerInfoAddr(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"10.0.0.1", "10.0.0.1:8000"},
+		{"2001:db8::1", "[2001:db8::1]:8000"},
+		{"localhost", "localhost:8000"},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			p := NewPeerInfo(PeerIDFixture(), test.ip, 8000, false, false)
+			require.Equal(t, test.expected, p.Addr())
+		})
+	}
+}
+
+func TestPeerInfoReachableFrom(t *testing.T) {
+	peer := func(ip, secondaryIP string) *PeerInfo {
+		p := NewPeerInfo(PeerIDFixture(), ip, 8000, false, false)
+		p.SecondaryIP = secondaryIP
+		return p
+	}
+
+	tests := []struct {
+		desc     string
+		peer     *PeerInfo
+		other    *PeerInfo
+		expected string
+		ok       bool
+	}{
+		{"ipv4 to ipv4", peer("10.0.0.1", ""), peer("10.0.0.2", ""), "10.0.0.1", true},
+		{"ipv6 to ipv6", peer("2001:db8::1", ""), peer("2001:db8::2", ""), "2001:db8::1", true},
+		{"ipv6 to ipv4", peer("2001:db8::1", ""), peer("10.0.0.2", ""), "", false},
+		{"ipv4 to ipv6", peer("10.0.0.1", ""), peer("2001:db8::2", ""), "", false},
+		{
+			"dual stack to ipv4",
+			peer("2001:db8::1", "10.0.0.1"), peer("10.0.0.2", ""), "10.0.0.1", true,
+		},
+		{
+			"dual stack to ipv6",
+			peer("10.0.0.1", "2001:db8::1"), peer("2001:db8::2", ""), "2001:db8::1", true,
+		},
+		{
+			"dual stack prefers primary",
+			peer("2001:db8::1", "10.0.0.1"), peer("10.0.0.2", "2001:db8::2"), "2001:db8::1", true,
+		},
+		{
+			"ipv6 to dual stack",
+			peer("2001:db8::1", ""), peer("10.0.0.2", "2001:db8::2"), "2001:db8::1", true,
+		},
+		{"host name", peer("origin1", ""), peer("10.0.0.2", ""), "origin1", true},
+		{"unknown families", peer("2001:db8::1", ""), peer("agent1", ""), "2001:db8::1", true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			result, ok := test.peer.ReachableFrom(test.other)
+			require.Equal(test.ok, ok)
+			if ok {
+				require.Equal(test.expected, result.IP)
+				require.Equal(test.peer.PeerID, result.PeerID)
+				require.Equal(test.peer.Port, result.Port)
+			}
+		})
+	}
+}
+
+func TestPeerInfoReachableFromSharedNetwork(t *testing.T) {
+	peer := func(ip string, addrs ...PeerAddress) *PeerInfo {
+		p := NewPeerInfo(PeerIDFixture(), ip, 8000, false, false)
+		p.Addresses = addrs
+		return p
+	}
+	storage := func(ip string) PeerAddress { return PeerAddress{"storage", ip} }
+	backbone := func(ip string) PeerAddress { return PeerAddress{"backbone", ip} }
+
+	tests := []struct {
+		desc     string
+		peer     *PeerInfo
+		other    *PeerInfo
+		expected string
+		ok       bool
+	}{
+		{
+			"shared network",
+			peer("10.0.0.1", storage("192.168.0.1")),
+			peer("10.0.0.2", storage("192.168.0.2")),
+			"192.168.0.1", true,
+		},
+		{
+			"no shared network",
+			peer("10.0.0.1", storage("192.168.0.1")),
+			peer("10.0.0.2", backbone("172.16.0.2")),
+			"10.0.0.1", true,
+		},
+		{
+			"other not multi-homed",
+			peer("10.0.0.1", storage("192.168.0.1")),
+			peer("10.0.0.2"),
+			"10.0.0.1", true,
+		},
+		{
+			"shared network across families",
+			peer("2001:db8::1", backbone("fd00::1"), storage("192.168.0.1")),
+			peer("10.0.0.2", storage("192.168.0.2")),
+			"192.168.0.1", true,
+		},
+		{
+			"shared network without shared family",
+			peer("2001:db8::1", storage("fd00::1")),
+			peer("10.0.0.2", storage("fd00::2")),
+			"fd00::1", true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			result, ok := test.peer.ReachableFrom(test.other)
+			require.Equal(test.ok, ok)
+			require.Equal(test.expected, result.IP)
+			require.Empty(result.SecondaryIP)
+			require.Empty(result.Addresses)
+		})
+	}
+}
+
+func TestPeerInfoHasIP(t *testing.T) {
+	require := require.New(t)
+
+	p := NewPeerInfo(PeerIDFixture(), "10.0.0.1", 8000, false, false)
+	p.SecondaryIP = "2001:db8::1"
+	p.Addresses = []PeerAddress{{"storage", "192.168.0.1"}}
+
+	require.True(p.HasIP("10.0.0.1"))
+	require.True(p.HasIP("2001:db8::1"))
+	require.True(p.HasIP("192.168.0.1"))
+	require.False(p.HasIP("10.0.0.2"))
+}
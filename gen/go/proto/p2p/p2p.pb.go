@@ -122,6 +122,10 @@ type BitfieldMessage struct {
 	// remoteBitfieldBytes contains the binary sets of pieces downloaded of
 	// all peers that the sender is currently connected to.
 	RemoteBitfieldBytes map[string][]byte `protobuf:"bytes,7,rep,name=remoteBitfieldBytes" json:"remoteBitfieldBytes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// compression lists the piece payload compression algorithms the sender
+	// supports. Payloads are only compressed with an algorithm both peers
+	// support.
+	Compression []string `protobuf:"bytes,8,rep,name=compression" json:"compression,omitempty"`
 }
 
 func (m *BitfieldMessage) Reset()                    { *m = BitfieldMessage{} }
@@ -157,6 +161,11 @@ type PiecePayloadMessage struct {
 	Offset int32  `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
 	Length int32  `protobuf:"varint,4,opt,name=length" json:"length,omitempty"`
 	Digest string `protobuf:"bytes,5,opt,name=digest" json:"digest,omitempty"`
+	// If set, the payload is compressed with the given algorithm and is
+	// compressedLength bytes long on the wire. length is always the size of
+	// the uncompressed piece.
+	Compression      string `protobuf:"bytes,6,opt,name=compression" json:"compression,omitempty"`
+	CompressedLength int32  `protobuf:"varint,7,opt,name=compressedLength" json:"compressedLength,omitempty"`
 }
 
 func (m *PiecePayloadMessage) Reset()                    { *m = PiecePayloadMessage{} }
@@ -288,46 +297,48 @@ func init() {
 func init() { proto.RegisterFile("proto/p2p/p2p.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 647 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xac, 0x54, 0x4f, 0x6f, 0xd3, 0x4e,
-	0x10, 0x6d, 0x12, 0x3b, 0x7f, 0x26, 0x69, 0xeb, 0x6c, 0xa3, 0xdf, 0xcf, 0x14, 0x0e, 0x95, 0x45,
-	0x45, 0x85, 0xa0, 0xad, 0xcc, 0x05, 0x10, 0x12, 0x4a, 0x1c, 0x57, 0x44, 0x4a, 0x9b, 0xb0, 0xa4,
-	0x07, 0xc4, 0xa1, 0x72, 0x9d, 0x49, 0x6b, 0x91, 0x7a, 0x8d, 0xed, 0x56, 0xcd, 0xd7, 0xe0, 0x43,
-	0x21, 0xf1, 0xad, 0xd0, 0x4e, 0xec, 0xc4, 0x6e, 0x02, 0xe2, 0xc0, 0x21, 0x92, 0xdf, 0xf3, 0x7b,
-	0xb3, 0xb3, 0x33, 0x2f, 0x86, 0x9d, 0x20, 0x14, 0xb1, 0x38, 0x0a, 0xcc, 0x40, 0xfe, 0x0e, 0x09,
-	0xb1, 0x52, 0x60, 0x06, 0xc6, 0x8f, 0x22, 0x6c, 0x77, 0xbc, 0x78, 0xe2, 0xe1, 0x74, 0x7c, 0x8a,
-	0x51, 0xe4, 0x5c, 0x21, 0xdb, 0x85, 0xaa, 0xe7, 0x4f, 0xc4, 0x07, 0x27, 0xba, 0xd6, 0x8b, 0x7b,
-	0x85, 0x83, 0x1a, 0x5f, 0x60, 0xc6, 0x40, 0xf1, 0x9d, 0x1b, 0xd4, 0x4b, 0xc4, 0xd3, 0x33, 0xfb,
-	0x0f, 0xca, 0x01, 0x62, 0xd8, 0xeb, 0xea, 0x0a, 0xb1, 0x09, 0x62, 0x4f, 0x61, 0xf3, 0x32, 0x29,
-	0xdd, 0x99, 0xc5, 0x18, 0xe9, 0xea, 0x5e, 0xe1, 0xa0, 0xc1, 0xf3, 0x24, 0x7b, 0x02, 0x35, 0x59,
-	0x25, 0x0a, 0x1c, 0x17, 0xf5, 0x32, 0x15, 0x58, 0x12, 0xec, 0x02, 0x76, 0x42, 0xbc, 0x11, 0x31,
-	0x76, 0x72, 0x95, 0x2a, 0x7b, 0xa5, 0x83, 0xba, 0xf9, 0xf2, 0x50, 0xde, 0xe6, 0x41, 0xfb, 0x87,
-	0x7c, 0x55, 0x6f, 0xfb, 0x71, 0x38, 0xe3, 0xeb, 0x2a, 0xed, 0x9e, 0x80, 0xfe, 0x3b, 0x03, 0xd3,
-	0xa0, 0xf4, 0x15, 0x67, 0x7a, 0x81, 0x9a, 0x92, 0x8f, 0xac, 0x05, 0xea, 0x9d, 0x33, 0xbd, 0x45,
-	0x9a, 0x4b, 0x83, 0xcf, 0xc1, 0xdb, 0xe2, 0xeb, 0x82, 0xf1, 0x05, 0x76, 0x86, 0x1e, 0xba, 0xc8,
-	0xf1, 0xdb, 0x2d, 0x46, 0x71, 0x3a, 0xcb, 0x16, 0xa8, 0x9e, 0x3f, 0xc6, 0x7b, 0x32, 0xa8, 0x7c,
-	0x0e, 0xe4, 0xc4, 0xc4, 0x64, 0x12, 0x61, 0x4c, 0x73, 0x54, 0x79, 0x82, 0x24, 0x3f, 0x45, 0xff,
-	0x2a, 0xbe, 0xa6, 0x49, 0xaa, 0x3c, 0x41, 0x46, 0x94, 0x14, 0x1f, 0x3a, 0xb3, 0xa9, 0x70, 0xc6,
-	0xff, 0xb4, 0xb8, 0xe4, 0xc7, 0xde, 0x15, 0x46, 0x31, 0xed, 0xa7, 0xc6, 0x13, 0x64, 0xbc, 0x80,
-	0x56, 0xdb, 0xf7, 0xc5, 0xad, 0xef, 0x22, 0x1d, 0xfe, 0xc7, 0x53, 0x8d, 0xe7, 0xc0, 0x2c, 0xc7,
-	0x77, 0x71, 0xfa, 0x17, 0xda, 0xef, 0x05, 0x68, 0xd8, 0x61, 0x28, 0xc2, 0x8c, 0x0c, 0x25, 0x4e,
-	0xe2, 0x36, 0x07, 0x4b, 0x73, 0x29, 0x7b, 0xbd, 0x23, 0x50, 0x5c, 0x31, 0x46, 0xba, 0xc4, 0x96,
-	0xf9, 0x98, 0x22, 0x90, 0x2d, 0x36, 0x07, 0x96, 0x18, 0x23, 0x27, 0xa1, 0xb1, 0x0f, 0xb5, 0x05,
-	0xc5, 0x74, 0x68, 0x0d, 0x7b, 0xb6, 0x65, 0x5f, 0x70, 0xfb, 0xe3, 0xb9, 0xfd, 0x69, 0x74, 0x71,
-	0xd2, 0xee, 0xf5, 0xed, 0xae, 0xb6, 0x61, 0x34, 0x61, 0xdb, 0x12, 0x37, 0xc1, 0x14, 0xe3, 0xb4,
-	0x7b, 0xe3, 0xa7, 0x02, 0x95, 0xb4, 0x45, 0x1d, 0x2a, 0x77, 0x18, 0x46, 0x9e, 0xf0, 0x93, 0x3c,
-	0xa4, 0x90, 0xed, 0x83, 0x12, 0xcf, 0x82, 0x79, 0x24, 0xb6, 0xcc, 0x26, 0x35, 0x94, 0xf6, 0x32,
-	0x9a, 0x05, 0xc8, 0xe9, 0x35, 0x3b, 0x86, 0x6a, 0x1a, 0x7c, 0xba, 0x50, 0xdd, 0x6c, 0xad, 0x8b,
-	0x2f, 0x5f, 0xa8, 0xd8, 0x3b, 0x68, 0x04, 0x99, 0x48, 0xd1, 0x8d, 0xeb, 0xa6, 0x4e, 0xae, 0x35,
-	0x59, 0xe3, 0x39, 0xf5, 0xc2, 0x9d, 0x64, 0x86, 0x96, 0x9b, 0x73, 0xe7, 0xc3, 0xc4, 0x73, 0x6a,
-	0xf6, 0x1e, 0x36, 0x9d, 0xec, 0xf2, 0xe9, 0x9f, 0x59, 0x37, 0x1f, 0x91, 0x7d, 0x5d, 0x2c, 0x78,
-	0x5e, 0xcf, 0xde, 0x40, 0xdd, 0x5d, 0xe6, 0x41, 0xaf, 0x90, 0xfd, 0x7f, 0xb2, 0xaf, 0xe6, 0x84,
-	0x67, 0xb5, 0xec, 0x59, 0x9a, 0x86, 0x2a, 0x99, 0x9a, 0x2b, 0x2b, 0x4e, 0x03, 0x72, 0x0c, 0x55,
-	0x37, 0x59, 0x99, 0x5e, 0xcb, 0x8c, 0xf4, 0xc1, 0x1e, 0xf9, 0x42, 0x65, 0xdc, 0x83, 0x22, 0x57,
-	0xc2, 0x1a, 0x50, 0xed, 0xf4, 0x46, 0x27, 0x3d, 0xbb, 0xdf, 0xd5, 0x36, 0x58, 0x13, 0x36, 0x73,
-	0xa1, 0xd0, 0x0a, 0x4b, 0x6a, 0xd8, 0xfe, 0xdc, 0x1f, 0xb4, 0xbb, 0x5a, 0x51, 0x52, 0xed, 0xb3,
-	0xb3, 0xc1, 0xb9, 0x24, 0xe5, 0x2b, 0xad, 0xc4, 0x34, 0x68, 0x58, 0xed, 0x33, 0xcb, 0xee, 0x27,
-	0x8c, 0xc2, 0x6a, 0xa0, 0xda, 0x9c, 0x0f, 0xb8, 0xa6, 0xca, 0x33, 0xac, 0xc1, 0xe9, 0xb0, 0x6f,
-	0x8f, 0x6c, 0xad, 0x7c, 0x59, 0xa6, 0x8f, 0xee, 0xab, 0x5f, 0x01, 0x00, 0x00, 0xff, 0xff, 0xfd,
-	0x11, 0xd8, 0xe6, 0x8b, 0x05, 0x00, 0x00,
+	// 684 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0xcd, 0x6e, 0x9b, 0x6a,
+	0x10, 0x8d, 0x7f, 0xf0, 0xcf, 0xe0, 0x24, 0xf8, 0x8b, 0x75, 0x2f, 0x37, 0xf7, 0x2e, 0x2c, 0x74,
+	0xa3, 0x5a, 0x51, 0x9b, 0x44, 0x74, 0xd3, 0x56, 0x95, 0x2a, 0x1b, 0x13, 0xd5, 0x92, 0x13, 0xbb,
+	0x5f, 0x9d, 0x45, 0xd5, 0x45, 0x44, 0x60, 0x9c, 0xa0, 0xda, 0x40, 0x81, 0x44, 0xf1, 0x6b, 0xf4,
+	0x8d, 0xba, 0xec, 0x9b, 0xf4, 0x31, 0x2a, 0x06, 0xb0, 0xc1, 0x76, 0xab, 0x2e, 0xba, 0x88, 0xc4,
+	0x39, 0xcc, 0x9c, 0xcc, 0x9c, 0x39, 0x18, 0x0e, 0x3c, 0xdf, 0x0d, 0xdd, 0x53, 0x4f, 0xf5, 0xa2,
+	0xbf, 0x13, 0x42, 0xac, 0xe4, 0xa9, 0x9e, 0xf2, 0xbd, 0x08, 0xfb, 0x3d, 0x3b, 0x9c, 0xda, 0x38,
+	0xb3, 0x2e, 0x30, 0x08, 0x8c, 0x5b, 0x64, 0x87, 0x50, 0xb3, 0x9d, 0xa9, 0xfb, 0xd6, 0x08, 0xee,
+	0xe4, 0x62, 0xbb, 0xd0, 0xa9, 0xf3, 0x25, 0x66, 0x0c, 0xca, 0x8e, 0x31, 0x47, 0xb9, 0x44, 0x3c,
+	0x3d, 0xb3, 0xbf, 0xa0, 0xe2, 0x21, 0xfa, 0x83, 0xbe, 0x5c, 0x26, 0x36, 0x41, 0xec, 0x7f, 0xd8,
+	0xbd, 0x49, 0xa4, 0x7b, 0x8b, 0x10, 0x03, 0x59, 0x68, 0x17, 0x3a, 0x0d, 0x9e, 0x27, 0xd9, 0x7f,
+	0x50, 0x8f, 0x54, 0x02, 0xcf, 0x30, 0x51, 0xae, 0x90, 0xc0, 0x8a, 0x60, 0xd7, 0x70, 0xe0, 0xe3,
+	0xdc, 0x0d, 0xb1, 0x97, 0x53, 0xaa, 0xb6, 0x4b, 0x1d, 0x51, 0x7d, 0x76, 0x12, 0x6d, 0xb3, 0x36,
+	0xfe, 0x09, 0xdf, 0xac, 0xd7, 0x9d, 0xd0, 0x5f, 0xf0, 0x6d, 0x4a, 0xac, 0x0d, 0xa2, 0xe9, 0xce,
+	0x3d, 0x1f, 0x83, 0xc0, 0x76, 0x1d, 0xb9, 0xd6, 0x2e, 0x75, 0xea, 0x3c, 0x4b, 0x1d, 0x9e, 0x83,
+	0xfc, 0x33, 0x49, 0x26, 0x41, 0xe9, 0x13, 0x2e, 0xe4, 0x02, 0x8d, 0x1d, 0x3d, 0xb2, 0x16, 0x08,
+	0x0f, 0xc6, 0xec, 0x1e, 0xc9, 0xb9, 0x06, 0x8f, 0xc1, 0xab, 0xe2, 0x8b, 0x82, 0xf2, 0x11, 0x0e,
+	0xc6, 0x36, 0x9a, 0xc8, 0xf1, 0xf3, 0x3d, 0x06, 0x61, 0xea, 0x76, 0x0b, 0x04, 0xdb, 0xb1, 0xf0,
+	0x91, 0x1a, 0x04, 0x1e, 0x83, 0xc8, 0x53, 0x77, 0x3a, 0x0d, 0x30, 0x24, 0xa7, 0x05, 0x9e, 0xa0,
+	0x88, 0x9f, 0xa1, 0x73, 0x1b, 0xde, 0x91, 0xd7, 0x02, 0x4f, 0x90, 0xf2, 0xb5, 0x90, 0xa8, 0x8f,
+	0x8d, 0xc5, 0xcc, 0x35, 0xac, 0x3f, 0xaa, 0x1e, 0xf1, 0x96, 0x7d, 0x8b, 0x41, 0x48, 0x27, 0xac,
+	0xf3, 0x04, 0xad, 0x9b, 0x17, 0x5f, 0x2f, 0x4b, 0xb1, 0x63, 0x90, 0x52, 0x88, 0xd6, 0x30, 0xd6,
+	0xae, 0x92, 0xf6, 0x06, 0xaf, 0x3c, 0x85, 0x56, 0xd7, 0x71, 0xdc, 0x7b, 0xc7, 0x44, 0x5a, 0xe5,
+	0x97, 0x3b, 0x28, 0xc7, 0xc0, 0x34, 0xc3, 0x31, 0x71, 0xf6, 0x1b, 0xb5, 0x5f, 0x0a, 0xd0, 0xd0,
+	0x7d, 0xdf, 0xf5, 0x33, 0x65, 0x18, 0xe1, 0x24, 0xdf, 0x31, 0x58, 0x35, 0x97, 0xb2, 0x66, 0x9d,
+	0x42, 0xd9, 0x74, 0x2d, 0x24, 0x4b, 0xf6, 0xd4, 0x7f, 0x29, 0x73, 0x59, 0xb1, 0x18, 0x68, 0xae,
+	0x85, 0x9c, 0x0a, 0x95, 0x23, 0xa8, 0x2f, 0x29, 0x26, 0x43, 0x6b, 0x3c, 0xd0, 0x35, 0xfd, 0x9a,
+	0xeb, 0xef, 0xae, 0xf4, 0xf7, 0x93, 0xeb, 0xf3, 0xee, 0x60, 0xa8, 0xf7, 0xa5, 0x1d, 0xa5, 0x09,
+	0xfb, 0x9a, 0x3b, 0xf7, 0x66, 0x18, 0xa6, 0xd3, 0x2b, 0xdf, 0xca, 0x50, 0x4d, 0x47, 0x94, 0xa1,
+	0xfa, 0x80, 0x3e, 0xf9, 0x1a, 0xc7, 0x2b, 0x85, 0xec, 0x08, 0xca, 0xe1, 0xc2, 0x8b, 0x13, 0xb6,
+	0xa7, 0x36, 0x69, 0xa0, 0x74, 0x96, 0xc9, 0xc2, 0x43, 0x4e, 0xaf, 0xd9, 0x19, 0xd4, 0xd2, 0x2f,
+	0x8d, 0x16, 0x12, 0xd5, 0xd6, 0xb6, 0xef, 0x85, 0x2f, 0xab, 0xd8, 0x6b, 0x68, 0x78, 0x99, 0x84,
+	0xd2, 0xc6, 0xa2, 0x2a, 0x53, 0xd7, 0x96, 0xe8, 0xf2, 0x5c, 0xf5, 0xb2, 0x3b, 0x49, 0xa0, 0x2c,
+	0xac, 0x77, 0xe7, 0xa3, 0xc9, 0x73, 0xd5, 0xec, 0x0d, 0xec, 0x1a, 0xd9, 0xe3, 0x53, 0x98, 0x44,
+	0xf5, 0x1f, 0x6a, 0xdf, 0x16, 0x0b, 0x9e, 0xaf, 0x67, 0x2f, 0x41, 0x34, 0x57, 0x79, 0xa0, 0x90,
+	0x89, 0xea, 0xdf, 0xd4, 0xbe, 0x99, 0x13, 0x9e, 0xad, 0x65, 0x4f, 0xd2, 0x34, 0xd4, 0xa8, 0xa9,
+	0xb9, 0x71, 0xe2, 0x34, 0x20, 0x67, 0x50, 0x33, 0x93, 0x93, 0xc9, 0xf5, 0x8c, 0xa5, 0x6b, 0x77,
+	0xe4, 0xcb, 0x2a, 0xe5, 0x11, 0xca, 0xd1, 0x49, 0x58, 0x03, 0x6a, 0xbd, 0xc1, 0xe4, 0x7c, 0xa0,
+	0x0f, 0xfb, 0xd2, 0x0e, 0x6b, 0xc2, 0x6e, 0x2e, 0x14, 0x52, 0x61, 0x45, 0x8d, 0xbb, 0x1f, 0x86,
+	0xa3, 0x6e, 0x5f, 0x2a, 0x46, 0x54, 0xf7, 0xf2, 0x72, 0x74, 0x15, 0x91, 0xd1, 0x2b, 0xa9, 0xc4,
+	0x24, 0x68, 0x68, 0xdd, 0x4b, 0x4d, 0x1f, 0x26, 0x4c, 0x99, 0xd5, 0x41, 0xd0, 0x39, 0x1f, 0x71,
+	0x49, 0x88, 0xfe, 0x87, 0x36, 0xba, 0x18, 0x0f, 0xf5, 0x89, 0x2e, 0x55, 0x6e, 0x2a, 0xf4, 0x2b,
+	0xff, 0xfc, 0xc7, 0x00, 0x93, 0xe5, 0x3c, 0x77, 0xfc, 0x05, 0x00, 0x00,
 }
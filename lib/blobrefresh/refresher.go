@@ -23,6 +23,7 @@ import (
 	"github.com/uber/kraken/lib/backend/backenderrors"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/utils/dedup"
 	"github.com/uber/kraken/utils/log"
 
@@ -134,9 +135,32 @@ func (r *Refresher) Refresh(namespace string, d core.Digest, hooks ...PostHook)
 	}
 }
 
+// HandleCorruptBlob re-downloads a blob which was removed from the local cache
+// due to corruption. Implements store.CorruptBlobHandler.
+func (r *Refresher) HandleCorruptBlob(d core.Digest, namespace string) {
+	logger := log.With("namespace", namespace, "name", d.Hex())
+	if namespace == "" {
+		logger.Warn("Cannot refresh corrupt blob: namespace unknown")
+		r.stats.Counter("corrupt_refresh_skipped").Inc(1)
+		return
+	}
+	if err := r.Refresh(namespace, d); err != nil && err != ErrPending {
+		logger.Errorf("Error refreshing corrupt blob: %s", err)
+		r.stats.Counter("corrupt_refresh_failures").Inc(1)
+		return
+	}
+	logger.Info("Refreshing corrupt blob from backend")
+}
+
 func (r *Refresher) download(client backend.Client, namespace string, d core.Digest) error {
 	name := d.Hex()
-	return r.cas.WriteCacheFile(name, func(w store.FileReadWriter) error {
+	if err := r.cas.WriteCacheFile(name, func(w store.FileReadWriter) error {
 		return client.Download(namespace, name, w)
-	})
+	}); err != nil {
+		return err
+	}
+	if _, err := r.cas.SetCacheFileMetadata(name, metadata.NewNamespace(namespace)); err != nil {
+		return fmt.Errorf("set namespace metadata: %s", err)
+	}
+	return nil
 }
@@ -145,3 +145,40 @@ func TestRefreshSizeLimitWithValidSize(t *testing.T) {
 		return !os.IsNotExist(err)
 	}))
 }
+
+func TestHandleCorruptBlobRefreshesFromBackend(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRefresherMocks(t)
+	defer cleanup()
+
+	refresher := mocks.new()
+
+	namespace := core.TagFixture()
+	client := mocks.newClient(namespace)
+
+	blob := core.SizedBlobFixture(100, uint64(_testPieceLength))
+
+	client.EXPECT().Stat(namespace, blob.Digest.Hex()).Return(core.NewBlobInfo(int64(len(blob.Content))), nil)
+	client.EXPECT().Download(namespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content)).Return(nil)
+
+	refresher.HandleCorruptBlob(blob.Digest, namespace)
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		var ns metadata.Namespace
+		if err := mocks.cas.GetCacheFileMetadata(blob.Digest.Hex(), &ns); err != nil {
+			return false
+		}
+		return ns.Value == namespace
+	}))
+}
+
+func TestHandleCorruptBlobSkipsUnknownNamespace(t *testing.T) {
+	mocks, cleanup := newRefresherMocks(t)
+	defer cleanup()
+
+	refresher := mocks.new()
+
+	// No backend calls are expected.
+	refresher.HandleCorruptBlob(core.DigestFixture(), "")
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package containerd
+
+import "time"
+
+// Config defines containerd integration configuration.
+type Config struct {
+
+	// Enabled preloads images into containerd instead of the docker daemon.
+	Enabled bool `yaml:"enabled"`
+
+	// Address is the containerd API socket, which also serves the CRI image
+	// service.
+	Address string `yaml:"address"`
+
+	// Registry is the registry host images are pulled from when preloading.
+	// Defaults to the agent registry. Set this to a registry mirrored by the
+	// agent (see Mirrors) such that preloaded images are named as they are
+	// referenced by pods.
+	Registry string `yaml:"registry"`
+
+	// Timeout limits the duration of a single preload.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// HostsDir is the containerd registry config_path, typically
+	// /etc/containerd/certs.d. If set, a hosts.toml pointing each of Mirrors
+	// at the agent registry is written on startup.
+	HostsDir string `yaml:"hosts_dir"`
+
+	// Mirrors lists the registry hosts whose pulls are served by the agent.
+	Mirrors []string `yaml:"mirrors"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.Address == "" {
+		c.Address = "unix:///run/containerd/containerd.sock"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 15 * time.Minute
+	}
+	return c
+}
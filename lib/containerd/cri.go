@@ -0,0 +1,198 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package containerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// CRI image service methods, newest first. containerd 1.5+ serves v1, older
+// releases only serve v1alpha2.
+var _pullImageMethods = []string{
+	"/runtime.v1.ImageService/PullImage",
+	"/runtime.v1alpha2.ImageService/PullImage",
+}
+
+// Client pulls images into containerd through the CRI image service, such
+// that images are stored and unpacked exactly as if the kubelet pulled them.
+// Client implements dockerdaemon.DockerClient.
+type Client struct {
+	config   Config
+	registry string
+	conn     *grpc.ClientConn
+}
+
+// NewClient creates a new Client which pulls images from the registry of
+// config, defaulting to registry.
+func NewClient(config Config, registry string) (*Client, error) {
+	config = config.applyDefaults()
+
+	if config.Registry != "" {
+		registry = config.Registry
+	}
+
+	dialer, target, err := parseAddress(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("parse containerd address %q: %s", config.Address, err)
+	}
+	// Connections are established lazily, such that agents start even if
+	// containerd is not yet running.
+	conn, err := grpc.Dial(
+		target,
+		grpc.WithInsecure(),
+		grpc.WithDialer(dialer),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		return nil, fmt.Errorf("grpc dial: %s", err)
+	}
+	return &Client{config, registry, conn}, nil
+}
+
+// parseAddress parses a "unix://" or "tcp://" address into a dialer.
+func parseAddress(addr string) (func(string, time.Duration) (net.Conn, error), string, error) {
+	parts := strings.SplitN(addr, "://", 2)
+	if len(parts) != 2 {
+		return nil, "", errors.New("expected <protocol>://<address>")
+	}
+	protocol, target := parts[0], parts[1]
+	if protocol != "unix" && protocol != "tcp" {
+		return nil, "", fmt.Errorf("protocol %s not supported", protocol)
+	}
+	dialer := func(_ string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout(protocol, target, timeout)
+	}
+	return dialer, target, nil
+}
+
+// Close closes the connection to containerd.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// PullImage pulls repo:tag from the configured registry into containerd.
+func (c *Client) PullImage(ctx context.Context, repo, tag string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	image := fmt.Sprintf("%s/%s:%s", c.registry, repo, tag)
+	req := encodePullImageRequest(image)
+	var err error
+	for _, method := range _pullImageMethods {
+		var resp []byte
+		err = c.conn.Invoke(ctx, method, req, &resp)
+		if status.Code(err) == codes.Unimplemented {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cri pull %s: %s", image, err)
+		}
+		if _, err := decodePullImageResponse(resp); err != nil {
+			return fmt.Errorf("cri pull %s: %s", image, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("cri image service not available: %s", err)
+}
+
+// The CRI messages used by Client, encoded by hand to avoid depending on the
+// generated CRI API:
+//
+//   message ImageSpec { string image = 1; }
+//   message PullImageRequest { ImageSpec image = 1; }
+//   message PullImageResponse { string image_ref = 1; }
+
+func encodePullImageRequest(image string) []byte {
+	var spec []byte
+	spec = protowire.AppendTag(spec, 1, protowire.BytesType)
+	spec = protowire.AppendString(spec, image)
+
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, spec)
+	return req
+}
+
+// decodePullImageResponse returns the image ref of a PullImageResponse.
+func decodePullImageResponse(b []byte) (string, error) {
+	ref, err := decodeBytesField(b, 1)
+	if err != nil {
+		return "", fmt.Errorf("image ref: %s", err)
+	}
+	return string(ref), nil
+}
+
+// decodeBytesField returns the last value of the length-delimited field num
+// in b, skipping unknown fields. Returns empty bytes if the field is unset.
+func decodeBytesField(b []byte, num protowire.Number) ([]byte, error) {
+	var result []byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				return nil, protowire.ParseError(l)
+			}
+			result = v
+			b = b[l:]
+			continue
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+	}
+	return result, nil
+}
+
+// rawCodec passes pre-encoded protobuf messages through grpc unchanged.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec: unexpected message type %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec: unexpected message type %T", v)
+	}
+	*b = append([]byte(nil), data...)
+	return nil
+}
+
+// Name is the content subtype of the codec. Messages are protobuf encoded.
+func (rawCodec) Name() string { return "proto" }
+
+// String implements the deprecated grpc.Codec interface, which is required to
+// register rawCodec on servers.
+func (rawCodec) String() string { return "proto" }
@@ -0,0 +1,191 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeCRIServer serves the CRI PullImage method of a single API version.
+type fakeCRIServer struct {
+	sync.Mutex
+	method string
+	err    error
+	pulls  []string
+}
+
+func (s *fakeCRIServer) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	if method != s.method {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	image, err := decodePullImageRequest(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.Lock()
+	s.pulls = append(s.pulls, image)
+	s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return stream.SendMsg(encodePullImageResponse("sha256:abc"))
+}
+
+func (s *fakeCRIServer) getPulls() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.pulls...)
+}
+
+// decodePullImageRequest returns the image of a PullImageRequest.
+func decodePullImageRequest(b []byte) (string, error) {
+	spec, err := decodeBytesField(b, 1)
+	if err != nil {
+		return "", fmt.Errorf("image spec: %s", err)
+	}
+	image, err := decodeBytesField(spec, 1)
+	if err != nil {
+		return "", fmt.Errorf("image: %s", err)
+	}
+	return string(image), nil
+}
+
+func encodePullImageResponse(imageRef string) []byte {
+	var resp []byte
+	resp = protowire.AppendTag(resp, 1, protowire.BytesType)
+	resp = protowire.AppendString(resp, imageRef)
+	return resp
+}
+
+// startFakeCRIServer starts s on a unix socket and returns its address.
+func startFakeCRIServer(t *testing.T, s *fakeCRIServer) (string, func()) {
+	dir, err := ioutil.TempDir("", "containerd")
+	require.NoError(t, err)
+	sock := filepath.Join(dir, "containerd.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+
+	server := grpc.NewServer(
+		grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(s.handle))
+	go server.Serve(l)
+
+	return "unix://" + sock, func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClientPullImage(t *testing.T) {
+	tests := []struct {
+		desc   string
+		method string
+	}{
+		{"v1", "/runtime.v1.ImageService/PullImage"},
+		{"v1alpha2", "/runtime.v1alpha2.ImageService/PullImage"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			s := &fakeCRIServer{method: test.method}
+			addr, stop := startFakeCRIServer(t, s)
+			defer stop()
+
+			client, err := NewClient(Config{Address: addr}, "127.0.0.1:16000")
+			require.NoError(err)
+			defer client.Close()
+
+			require.NoError(client.PullImage(context.Background(), "team/app", "v1"))
+			require.Equal([]string{"127.0.0.1:16000/team/app:v1"}, s.getPulls())
+		})
+	}
+}
+
+func TestClientPullImageFromConfiguredRegistry(t *testing.T) {
+	require := require.New(t)
+
+	s := &fakeCRIServer{method: _pullImageMethods[0]}
+	addr, stop := startFakeCRIServer(t, s)
+	defer stop()
+
+	config := Config{Address: addr, Registry: "kraken.example.com"}
+	client, err := NewClient(config, "127.0.0.1:16000")
+	require.NoError(err)
+	defer client.Close()
+
+	require.NoError(client.PullImage(context.Background(), "team/app", "v1"))
+	require.Equal([]string{"kraken.example.com/team/app:v1"}, s.getPulls())
+}
+
+func TestClientPullImageError(t *testing.T) {
+	require := require.New(t)
+
+	s := &fakeCRIServer{
+		method: _pullImageMethods[0],
+		err:    status.Error(codes.NotFound, "manifest unknown"),
+	}
+	addr, stop := startFakeCRIServer(t, s)
+	defer stop()
+
+	client, err := NewClient(Config{Address: addr}, "127.0.0.1:16000")
+	require.NoError(err)
+	defer client.Close()
+
+	err = client.PullImage(context.Background(), "team/app", "v1")
+	require.Error(err)
+	require.Contains(err.Error(), "manifest unknown")
+}
+
+func TestClientPullImageUnavailable(t *testing.T) {
+	require := require.New(t)
+
+	s := &fakeCRIServer{method: "/runtime.v2.ImageService/PullImage"}
+	addr, stop := startFakeCRIServer(t, s)
+	defer stop()
+
+	client, err := NewClient(Config{Address: addr}, "127.0.0.1:16000")
+	require.NoError(err)
+	defer client.Close()
+
+	err = client.PullImage(context.Background(), "team/app", "v1")
+	require.Error(err)
+	require.Contains(err.Error(), "cri image service not available")
+}
+
+func TestNewClientInvalidAddress(t *testing.T) {
+	for _, addr := range []string{"/run/containerd/containerd.sock", "npipe://containerd"} {
+		t.Run(addr, func(t *testing.T) {
+			_, err := NewClient(Config{Address: addr}, "127.0.0.1:16000")
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package containerd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// HostsTOML returns the containerd hosts.toml which serves pulls from
+// registry through mirror, falling back to registry itself if the mirror is
+// unavailable.
+func HostsTOML(registry, mirror string) []byte {
+	server := "https://" + registry
+	if registry == "docker.io" {
+		server = "https://registry-1.docker.io"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "server = %q\n\n", server)
+	fmt.Fprintf(&b, "[host.%q]\n", "http://"+mirror)
+	fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+	return b.Bytes()
+}
+
+// WriteHostsFiles writes a hosts.toml for each mirror in config which points
+// the registry at the agent registry. Does nothing if no hosts dir is
+// configured.
+func WriteHostsFiles(config Config, agentRegistry string) error {
+	if config.HostsDir == "" {
+		return nil
+	}
+	for _, registry := range config.Mirrors {
+		dir := filepath.Join(config.HostsDir, registry)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir: %s", err)
+		}
+		// Write to a temp file and rename, such that containerd never reads a
+		// partially written file.
+		tmp, err := ioutil.TempFile(dir, ".hosts.toml")
+		if err != nil {
+			return fmt.Errorf("create temp file: %s", err)
+		}
+		_, err = tmp.Write(HostsTOML(registry, agentRegistry))
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(dir, "hosts.toml"))
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("write hosts.toml of %s: %s", registry, err)
+		}
+	}
+	return nil
+}
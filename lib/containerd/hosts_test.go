@@ -0,0 +1,81 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package containerd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostsTOML(t *testing.T) {
+	tests := []struct {
+		registry string
+		expected string
+	}{
+		{
+			"kraken.example.com",
+			`server = "https://kraken.example.com"
+
+[host."http://127.0.0.1:16000"]
+  capabilities = ["pull", "resolve"]
+`,
+		}, {
+			"docker.io",
+			`server = "https://registry-1.docker.io"
+
+[host."http://127.0.0.1:16000"]
+  capabilities = ["pull", "resolve"]
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.registry, func(t *testing.T) {
+			require.Equal(t, test.expected, string(HostsTOML(test.registry, "127.0.0.1:16000")))
+		})
+	}
+}
+
+func TestWriteHostsFiles(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "certs.d")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := Config{
+		HostsDir: dir,
+		Mirrors:  []string{"kraken.example.com", "docker.io"},
+	}
+	require.NoError(WriteHostsFiles(config, "127.0.0.1:16000"))
+
+	// Rewriting existing files succeeds.
+	require.NoError(WriteHostsFiles(config, "127.0.0.1:16000"))
+
+	for _, registry := range config.Mirrors {
+		b, err := ioutil.ReadFile(filepath.Join(dir, registry, "hosts.toml"))
+		require.NoError(err)
+		require.Equal(HostsTOML(registry, "127.0.0.1:16000"), b)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "docker.io"))
+	require.NoError(err)
+	require.Len(entries, 1)
+}
+
+func TestWriteHostsFilesNoHostsDir(t *testing.T) {
+	require.NoError(t, WriteHostsFiles(Config{Mirrors: []string{"docker.io"}}, "127.0.0.1:16000"))
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package contenttrust
+
+// Config defines content trust policy.
+type Config struct {
+	// Enabled requires images to carry a valid signature before their tags
+	// resolve.
+	Enabled bool `yaml:"enabled"`
+
+	// PublicKeys are paths to PEM encoded public keys which images may be
+	// signed with. ECDSA, Ed25519 and RSA keys are supported.
+	PublicKeys []string `yaml:"public_keys"`
+
+	// Repositories are regular expressions of repositories which require
+	// signatures. All repositories require signatures if empty.
+	Repositories []string `yaml:"repositories"`
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package contenttrust
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+// KeyFixture is a locally generated signing key for testing purposes.
+type KeyFixture struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewKeyFixture generates a new KeyFixture.
+func NewKeyFixture() *KeyFixture {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return &KeyFixture{key}
+}
+
+// PublicKeyPEM returns the PEM encoded public key of k.
+func (k *KeyFixture) PublicKeyPEM() []byte {
+	b, err := x509.MarshalPKIXPublicKey(&k.key.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b})
+}
+
+// SignatureFixture holds the signature artifacts of a manifest.
+type SignatureFixture struct {
+	Tag            string
+	ManifestDigest core.Digest
+	Manifest       []byte
+	PayloadDigest  core.Digest
+	Payload        []byte
+}
+
+// Sign signs manifest d of repo with k.
+func (k *KeyFixture) Sign(repo string, d core.Digest) *SignatureFixture {
+	payload, err := json.Marshal(Payload{
+		Critical: Critical{
+			Identity: Identity{DockerReference: repo},
+			Image:    Image{DockerManifestDigest: d.String()},
+			Type:     PayloadType,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	h := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, k.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	payloadDigest, err := core.NewDigester().FromBytes(payload)
+	if err != nil {
+		panic(err)
+	}
+	m, err := json.Marshal(schema2.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		},
+		Config: distribution.Descriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    digest.Digest(core.DigestFixture().String()),
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: PayloadMediaType,
+			Size:      int64(len(payload)),
+			Digest:    digest.Digest(payloadDigest.String()),
+			Annotations: map[string]string{
+				SignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+			},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	manifestDigest, err := core.NewDigester().FromBytes(m)
+	if err != nil {
+		panic(err)
+	}
+	return &SignatureFixture{
+		Tag:            SignatureTag(repo, d),
+		ManifestDigest: manifestDigest,
+		Manifest:       m,
+		PayloadDigest:  payloadDigest,
+		Payload:        payload,
+	}
+}
+
+// ConfigFixture returns a Config which trusts keys, and a function to clean
+// up the public key files it writes.
+func ConfigFixture(keys ...*KeyFixture) (Config, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	dir, err := ioutil.TempDir("", "contenttrust-")
+	if err != nil {
+		panic(err)
+	}
+	cleanup.Add(func() { os.RemoveAll(dir) })
+
+	config := Config{Enabled: true}
+	for i, k := range keys {
+		p := filepath.Join(dir, fmt.Sprintf("key%d.pem", i))
+		if err := ioutil.WriteFile(p, k.PublicKeyPEM(), 0644); err != nil {
+			panic(err)
+		}
+		config.PublicKeys = append(config.PublicKeys, p)
+	}
+	return config, cleanup.Run
+}
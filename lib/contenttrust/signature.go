@@ -0,0 +1,91 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package contenttrust
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uber/kraken/core"
+)
+
+// Signature artifacts follow cosign conventions: the signature of manifest
+// <repo>@sha256:<hex> is stored under tag <repo>:sha256-<hex>.sig, which
+// resolves to a manifest whose layers are signed payloads. The signature of
+// each payload is stored base64 encoded in the layer annotations.
+const (
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	PayloadMediaType    = "application/vnd.dev.cosign.simplesigning.v1+json"
+	PayloadType         = "cosign container image signature"
+
+	_signatureTagSuffix = ".sig"
+)
+
+// Payload is the content signed by a signature.
+type Payload struct {
+	Critical Critical          `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// Critical holds the signed claims of a Payload.
+type Critical struct {
+	Identity Identity `json:"identity"`
+	Image    Image    `json:"image"`
+	Type     string   `json:"type"`
+}
+
+// Identity identifies the repository an image was signed for.
+type Identity struct {
+	DockerReference string `json:"docker-reference"`
+}
+
+// Image identifies the signed manifest.
+type Image struct {
+	DockerManifestDigest string `json:"docker-manifest-digest"`
+}
+
+// SignatureTag returns the tag under which signatures of manifest d in repo
+// are stored.
+func SignatureTag(repo string, d core.Digest) string {
+	return fmt.Sprintf("%s:%s-%s%s", repo, d.Algo(), d.Hex(), _signatureTagSuffix)
+}
+
+// IsSignatureTag returns true if tag (either "repo:tag" or "tag") is of the
+// exact form "<algo>-<hex>.sig" under which signatures of a manifest digest are
+// stored.
+func IsSignatureTag(tag string) bool {
+	if i := strings.LastIndex(tag, ":"); i != -1 {
+		tag = tag[i+1:]
+	}
+	if !strings.HasSuffix(tag, _signatureTagSuffix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(tag, _signatureTagSuffix), "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	_, err := core.ParseSHA256Digest(parts[0] + ":" + parts[1])
+	return err == nil
+}
+
+// referenceRepo strips the registry host from a docker reference, if present,
+// such that it can be compared with repository names.
+func referenceRepo(ref string) string {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 &&
+		(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[1]
+	}
+	return ref
+}
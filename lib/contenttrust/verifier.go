@@ -0,0 +1,286 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package contenttrust
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/dockerutil"
+
+	"github.com/docker/distribution/manifest/schema2"
+)
+
+// ErrNotFound is returned by Fetcher when a tag or blob does not exist.
+var ErrNotFound = errors.New("not found")
+
+// UntrustedError occurs when an image has no valid signature from any of the
+// configured keys.
+type UntrustedError struct {
+	Repo   string
+	Digest core.Digest
+	Reason string
+}
+
+func (e *UntrustedError) Error() string {
+	return fmt.Sprintf("image %s@%s is untrusted: %s", e.Repo, e.Digest, e.Reason)
+}
+
+// Fetcher fetches signature artifacts.
+type Fetcher interface {
+	GetTag(tag string) (core.Digest, error)
+	GetBlob(namespace string, d core.Digest) ([]byte, error)
+}
+
+// Verifier verifies image signatures.
+type Verifier interface {
+	// Verify returns an *UntrustedError if manifest d in repo is not signed by
+	// a trusted key. Signature artifacts are fetched using f.
+	Verify(f Fetcher, repo string, d core.Digest) error
+
+	// KeySet identifies the trusted keys and the repositories which require
+	// signatures, such that cached results are never reused across policies.
+	KeySet() string
+}
+
+// NoopVerifier trusts all images.
+type NoopVerifier struct{}
+
+// Verify always returns nil.
+func (v NoopVerifier) Verify(f Fetcher, repo string, d core.Digest) error {
+	return nil
+}
+
+// KeySet returns an empty key set.
+func (v NoopVerifier) KeySet() string {
+	return ""
+}
+
+type verifier struct {
+	keys         []crypto.PublicKey
+	repositories []*regexp.Regexp
+	keySet       string
+}
+
+// New creates a new Verifier. Returns a NoopVerifier if content trust is
+// disabled.
+func New(config Config) (Verifier, error) {
+	if !config.Enabled {
+		return NoopVerifier{}, nil
+	}
+	if len(config.PublicKeys) == 0 {
+		return nil, errors.New("no public keys configured")
+	}
+	var keys []crypto.PublicKey
+	keySet := sha256.New()
+	for _, p := range config.PublicKeys {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %s", err)
+		}
+		key, err := ParsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key %s: %s", p, err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("marshal public key %s: %s", p, err)
+		}
+		keySet.Write(der)
+		keys = append(keys, key)
+	}
+	var repositories []*regexp.Regexp
+	for _, s := range config.Repositories {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository regexp %q: %s", s, err)
+		}
+		fmt.Fprintf(keySet, "%d:%s", len(s), s)
+		repositories = append(repositories, re)
+	}
+	return &verifier{keys, repositories, hex.EncodeToString(keySet.Sum(nil))}, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func (v *verifier) KeySet() string {
+	return v.keySet
+}
+
+func (v *verifier) Verify(f Fetcher, repo string, d core.Digest) error {
+	if !v.requiresSignature(repo) {
+		return nil
+	}
+	untrusted := func(reason string) error {
+		return &UntrustedError{repo, d, reason}
+	}
+
+	sigManifestDigest, err := f.GetTag(SignatureTag(repo, d))
+	if err != nil {
+		if err == ErrNotFound {
+			return untrusted("image is not signed")
+		}
+		return fmt.Errorf("get signature tag: %s", err)
+	}
+	b, err := f.GetBlob(repo, sigManifestDigest)
+	if err != nil {
+		if err == ErrNotFound {
+			return untrusted("signature manifest not found")
+		}
+		return fmt.Errorf("get signature manifest: %s", err)
+	}
+	var manifest schema2.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return untrusted(fmt.Sprintf("invalid signature manifest: %s", err))
+	}
+	for _, layer := range manifest.Layers {
+		sig, ok := layer.Annotations[SignatureAnnotation]
+		if !ok {
+			continue
+		}
+		payloadDigest, err := core.ParseSHA256Digest(string(layer.Digest))
+		if err != nil {
+			continue
+		}
+		payload, err := f.GetBlob(repo, payloadDigest)
+		if err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			return fmt.Errorf("get signature payload: %s", err)
+		}
+		if v.verifyPayload(repo, d, payloadDigest, payload, sig) {
+			return nil
+		}
+	}
+	return untrusted("no valid signature from a trusted key")
+}
+
+// VerifyTag verifies manifest d which tag of repo resolves to using v.
+// Signature tags are exempt, since they must resolve in order to verify
+// signatures, as are referrers tags which resolve to the referrers indexes
+// generated by the build-index. Anything else pushed under such tags is
+// verified like any other tag.
+func VerifyTag(v Verifier, f Fetcher, tag, repo string, d core.Digest) error {
+	if IsSignatureTag(tag) {
+		return nil
+	}
+	if subject, ok := dockerutil.ParseReferrersTag(tag); ok {
+		index, err := f.GetBlob(repo, d)
+		if err != nil && err != ErrNotFound {
+			return fmt.Errorf("get referrers index: %s", err)
+		}
+		if err == nil {
+			generated, err := dockerutil.IsGeneratedReferrersIndex(
+				subject, index, func(m core.Digest) ([]byte, error) {
+					return f.GetBlob(repo, m)
+				})
+			if err != nil {
+				return fmt.Errorf("check referrers index: %s", err)
+			}
+			if generated {
+				return nil
+			}
+		}
+	}
+	return v.Verify(f, repo, d)
+}
+
+func (v *verifier) requiresSignature(repo string) bool {
+	if len(v.repositories) == 0 {
+		return true
+	}
+	for _, re := range v.repositories {
+		if re.MatchString(repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPayload returns true if payload claims manifest d of repo and sig is a
+// valid signature of payload by any trusted key.
+func (v *verifier) verifyPayload(
+	repo string, d core.Digest, payloadDigest core.Digest, payload []byte, sig string) bool {
+
+	actual, err := core.NewDigester().FromBytes(payload)
+	if err != nil || actual != payloadDigest {
+		return false
+	}
+	var p Payload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return false
+	}
+	if p.Critical.Type != PayloadType || p.Critical.Image.DockerManifestDigest != d.String() {
+		return false
+	}
+	// Signatures are only valid for the repository they were created for, such
+	// that they cannot be copied to the same manifest in another repository.
+	if referenceRepo(p.Critical.Identity.DockerReference) != repo {
+		return false
+	}
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	for _, key := range v.keys {
+		if verifySignature(key, payload, rawSig) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	h := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, h[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+	default:
+		return false
+	}
+}
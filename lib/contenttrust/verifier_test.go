@@ -0,0 +1,255 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package contenttrust
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockFetcher struct {
+	tags  map[string]core.Digest
+	blobs map[core.Digest][]byte
+	err   error
+}
+
+func newMockFetcher() *mockFetcher {
+	return &mockFetcher{
+		tags:  make(map[string]core.Digest),
+		blobs: make(map[core.Digest][]byte),
+	}
+}
+
+func (f *mockFetcher) add(sig *SignatureFixture) {
+	f.tags[sig.Tag] = sig.ManifestDigest
+	f.blobs[sig.ManifestDigest] = sig.Manifest
+	f.blobs[sig.PayloadDigest] = sig.Payload
+}
+
+func (f *mockFetcher) GetTag(tag string) (core.Digest, error) {
+	if f.err != nil {
+		return core.Digest{}, f.err
+	}
+	d, ok := f.tags[tag]
+	if !ok {
+		return core.Digest{}, ErrNotFound
+	}
+	return d, nil
+}
+
+func (f *mockFetcher) GetBlob(namespace string, d core.Digest) ([]byte, error) {
+	b, ok := f.blobs[d]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func newVerifier(t *testing.T, keys ...*KeyFixture) Verifier {
+	config, cleanup := ConfigFixture(keys...)
+	defer cleanup()
+
+	v, err := New(config)
+	require.NoError(t, err)
+	return v
+}
+
+func requireUntrusted(t *testing.T, err error) {
+	t.Helper()
+
+	_, ok := err.(*UntrustedError)
+	require.True(t, ok, "expected *UntrustedError, got %v", err)
+}
+
+func TestVerifySignedImage(t *testing.T) {
+	key := NewKeyFixture()
+	v := newVerifier(t, NewKeyFixture(), key)
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	f := newMockFetcher()
+	f.add(key.Sign(repo, d))
+
+	require.NoError(t, v.Verify(f, repo, d))
+}
+
+func TestVerifyUnsignedImage(t *testing.T) {
+	v := newVerifier(t, NewKeyFixture())
+
+	err := v.Verify(newMockFetcher(), "some/repo", core.DigestFixture())
+	requireUntrusted(t, err)
+	require.Contains(t, err.Error(), "not signed")
+}
+
+func TestVerifyImageSignedByUntrustedKey(t *testing.T) {
+	v := newVerifier(t, NewKeyFixture())
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	f := newMockFetcher()
+	f.add(NewKeyFixture().Sign(repo, d))
+
+	requireUntrusted(t, v.Verify(f, repo, d))
+}
+
+func TestVerifySignatureOfDifferentImage(t *testing.T) {
+	key := NewKeyFixture()
+	v := newVerifier(t, key)
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	// Copy the signature of another image under the signature tag of d.
+	sig := key.Sign(repo, core.DigestFixture())
+	sig.Tag = SignatureTag(repo, d)
+
+	f := newMockFetcher()
+	f.add(sig)
+
+	requireUntrusted(t, v.Verify(f, repo, d))
+}
+
+func TestVerifySignatureOfDifferentRepo(t *testing.T) {
+	key := NewKeyFixture()
+	v := newVerifier(t, key)
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	// Copy the signature of the same manifest in another repo.
+	sig := key.Sign("other/repo", d)
+	sig.Tag = SignatureTag(repo, d)
+
+	f := newMockFetcher()
+	f.add(sig)
+
+	requireUntrusted(t, v.Verify(f, repo, d))
+}
+
+func TestVerifySignatureWithRegistryHost(t *testing.T) {
+	key := NewKeyFixture()
+	v := newVerifier(t, key)
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	sig := key.Sign("registry.example.com:5000/"+repo, d)
+	sig.Tag = SignatureTag(repo, d)
+
+	f := newMockFetcher()
+	f.add(sig)
+
+	require.NoError(t, v.Verify(f, repo, d))
+}
+
+func TestIsSignatureTag(t *testing.T) {
+	d := core.DigestFixture()
+
+	tests := []struct {
+		tag      string
+		expected bool
+	}{
+		{SignatureTag("some/repo", d), true},
+		{SignatureTag("localhost:5000/some/repo", d), true},
+		{"sha256-" + d.Hex() + ".sig", true},
+		{"some/repo:foo.sig", false},
+		{"some/repo:sha256-foo.sig", false},
+		{"some/repo:md5-" + d.Hex() + ".sig", false},
+		{"some/repo:sha256-" + d.Hex(), false},
+		{"some/repo:latest", false},
+	}
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			require.Equal(t, test.expected, IsSignatureTag(test.tag))
+		})
+	}
+}
+
+func TestVerifyTamperedPayload(t *testing.T) {
+	key := NewKeyFixture()
+	v := newVerifier(t, key)
+
+	repo := "some/repo"
+	d := core.DigestFixture()
+
+	sig := key.Sign(repo, d)
+
+	f := newMockFetcher()
+	f.add(sig)
+	f.blobs[sig.PayloadDigest] = append(sig.Payload, ' ')
+
+	requireUntrusted(t, v.Verify(f, repo, d))
+}
+
+func TestVerifyOnlyConfiguredRepositories(t *testing.T) {
+	config, cleanup := ConfigFixture(NewKeyFixture())
+	defer cleanup()
+
+	config.Repositories = []string{"^prod/.*"}
+
+	v, err := New(config)
+	require.NoError(t, err)
+
+	d := core.DigestFixture()
+
+	require.NoError(t, v.Verify(newMockFetcher(), "dev/repo", d))
+	requireUntrusted(t, v.Verify(newMockFetcher(), "prod/repo", d))
+}
+
+func TestVerifyFetchError(t *testing.T) {
+	v := newVerifier(t, NewKeyFixture())
+
+	f := newMockFetcher()
+	f.err = errors.New("some error")
+
+	err := v.Verify(f, "some/repo", core.DigestFixture())
+	require.Error(t, err)
+	_, ok := err.(*UntrustedError)
+	require.False(t, ok)
+}
+
+func TestNewDisabled(t *testing.T) {
+	v, err := New(Config{})
+	require.NoError(t, err)
+	require.Equal(t, NoopVerifier{}, v)
+}
+
+func TestNewRequiresPublicKeys(t *testing.T) {
+	_, err := New(Config{Enabled: true})
+	require.Error(t, err)
+}
+
+func TestKeySet(t *testing.T) {
+	require := require.New(t)
+
+	k1 := NewKeyFixture()
+	k2 := NewKeyFixture()
+
+	require.Equal(newVerifier(t, k1).KeySet(), newVerifier(t, k1).KeySet())
+	require.NotEqual(newVerifier(t, k1).KeySet(), newVerifier(t, k2).KeySet())
+	require.NotEqual(newVerifier(t, k1).KeySet(), newVerifier(t, k1, k2).KeySet())
+
+	config, cleanup := ConfigFixture(k1)
+	defer cleanup()
+	config.Repositories = []string{"^some/repo$"}
+	v, err := New(config)
+	require.NoError(err)
+	require.NotEqual(newVerifier(t, k1).KeySet(), v.KeySet())
+}
@@ -14,6 +14,7 @@
 package dockerregistry
 
 import (
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
 	"github.com/uber/kraken/lib/store"
 	"github.com/docker/distribution/configuration"
@@ -29,7 +30,8 @@ const (
 
 // Config defines registry configuration.
 type Config struct {
-	Docker configuration.Configuration `yaml:"docker"`
+	Docker       configuration.Configuration `yaml:"docker"`
+	ContentTrust contenttrust.Config         `yaml:"content_trust"`
 }
 
 // ReadWriteParameters builds parameters for a read-write driver.
@@ -14,6 +14,7 @@
 package dockerregistry
 
 import (
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
 	"github.com/uber/kraken/lib/store"
 
@@ -24,6 +25,10 @@ import (
 func StorageDriverFixture() (*KrakenStorageDriver, func()) {
 	cas, cleanup := store.CAStoreFixture()
 	sd := NewReadWriteStorageDriver(
-		Config{}, cas, transfer.NewTestTransferer(cas), tally.NoopScope)
+		Config{},
+		cas,
+		transfer.NewTestTransferer(cas),
+		contenttrust.NoopVerifier{},
+		tally.NoopScope)
 	return sd, cleanup
 }
@@ -14,13 +14,18 @@
 package dockerregistry
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/log"
 )
 
@@ -34,10 +39,13 @@ const (
 
 type manifests struct {
 	transferer transfer.ImageTransferer
+	verifier   contenttrust.Verifier
 }
 
-func newManifests(transferer transfer.ImageTransferer) *manifests {
-	return &manifests{transferer}
+func newManifests(
+	transferer transfer.ImageTransferer, verifier contenttrust.Verifier) *manifests {
+
+	return &manifests{transferer, verifier}
 }
 
 // getDigest downloads and returns manifest digest.
@@ -59,10 +67,16 @@ func (t *manifests) getDigest(path string, subtype PathSubType) ([]byte, error)
 		if err != nil {
 			return nil, fmt.Errorf("get manifest tag: %s", err)
 		}
-		digest, err = t.transferer.GetTag(fmt.Sprintf("%s:%s", repo, tag))
+		fullTag := fmt.Sprintf("%s:%s", repo, tag)
+		digest, err = t.transferer.GetTag(fullTag)
 		if err != nil {
 			return nil, fmt.Errorf("transferer get tag: %w", err)
 		}
+		err = contenttrust.VerifyTag(
+			t.verifier, &transfererFetcher{t.transferer}, fullTag, repo, digest)
+		if err != nil {
+			return nil, fmt.Errorf("content trust: %w", err)
+		}
 	case _revisions:
 		var err error
 		digest, err = GetManifestDigest(path)
@@ -104,11 +118,45 @@ func (t *manifests) putContent(path string, subtype PathSubType) error {
 			return fmt.Errorf("post tag: %w", err)
 		}
 		return nil
+	case _revisions:
+		repo, err := GetRepo(path)
+		if err != nil {
+			return fmt.Errorf("get repo: %s", err)
+		}
+		digest, err := GetManifestDigest(path)
+		if err != nil {
+			return fmt.Errorf("get manifest digest: %s", err)
+		}
+		return t.putReferrer(repo, digest)
 	}
 	// Intentional no-op.
 	return nil
 }
 
+// putReferrer adds the manifest to the referrers index of its subject, if the
+// manifest has one. The manifest blob is always written before its revision
+// link, so it can be read back here.
+func (t *manifests) putReferrer(repo string, digest core.Digest) error {
+	blob, err := t.transferer.Download(repo, digest)
+	if err != nil {
+		return fmt.Errorf("transferer download: %w", err)
+	}
+	defer blob.Close()
+	b, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return fmt.Errorf("read manifest: %s", err)
+	}
+	if _, _, ok, err := dockerutil.ParseReferrer(digest, b); err != nil {
+		return fmt.Errorf("parse manifest: %s", err)
+	} else if !ok {
+		return nil
+	}
+	if err := t.transferer.PutReferrer(repo, digest); err != nil {
+		return fmt.Errorf("put referrer: %w", err)
+	}
+	return nil
+}
+
 func (t *manifests) stat(path string) (storagedriver.FileInfo, error) {
 	repo, err := GetRepo(path)
 	if err != nil {
@@ -148,3 +196,28 @@ func (t *manifests) list(path string) ([]string, error) {
 	}
 	return tags, nil
 }
+
+// transfererFetcher fetches signature artifacts using an ImageTransferer.
+type transfererFetcher struct {
+	transferer transfer.ImageTransferer
+}
+
+func (f *transfererFetcher) GetTag(tag string) (core.Digest, error) {
+	d, err := f.transferer.GetTag(tag)
+	if errors.Is(err, transfer.ErrTagNotFound) {
+		return core.Digest{}, contenttrust.ErrNotFound
+	}
+	return d, err
+}
+
+func (f *transfererFetcher) GetBlob(namespace string, d core.Digest) ([]byte, error) {
+	blob, err := f.transferer.Download(namespace, d)
+	if err != nil {
+		if errors.Is(err, transfer.ErrBlobNotFound) || errors.Is(err, os.ErrNotExist) {
+			return nil, contenttrust.ErrNotFound
+		}
+		return nil, err
+	}
+	defer blob.Close()
+	return ioutil.ReadAll(blob)
+}
@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/utils/log"
@@ -98,13 +99,18 @@ func (factory *krakenStorageDriverFactory) Create(
 	transferer := getParam(params, "transferer").(transfer.ImageTransferer)
 	metrics := getParam(params, "metrics").(tally.Scope)
 
+	verifier, err := contenttrust.New(config.ContentTrust)
+	if err != nil {
+		return nil, fmt.Errorf("content trust: %s", err)
+	}
+
 	switch constructor {
 	case _rw:
 		castore := getParam(params, "castore").(*store.CAStore)
-		return NewReadWriteStorageDriver(config, castore, transferer, metrics), nil
+		return NewReadWriteStorageDriver(config, castore, transferer, verifier, metrics), nil
 	case _ro:
 		blobstore := getParam(params, "blobstore").(BlobStore)
-		return NewReadOnlyStorageDriver(config, blobstore, transferer, metrics), nil
+		return NewReadOnlyStorageDriver(config, blobstore, transferer, verifier, metrics), nil
 	default:
 		return nil, fmt.Errorf("unknown constructor %s", constructor)
 	}
@@ -125,6 +131,7 @@ func NewReadWriteStorageDriver(
 	config Config,
 	cas *store.CAStore,
 	transferer transfer.ImageTransferer,
+	verifier contenttrust.Verifier,
 	metrics tally.Scope) *KrakenStorageDriver {
 
 	return &KrakenStorageDriver{
@@ -132,7 +139,7 @@ func NewReadWriteStorageDriver(
 		transferer: transferer,
 		blobs:      newBlobs(cas, transferer),
 		uploads:    newCASUploads(cas, transferer),
-		manifests:  newManifests(transferer),
+		manifests:  newManifests(transferer, verifier),
 		metrics:    metrics,
 	}
 }
@@ -142,6 +149,7 @@ func NewReadOnlyStorageDriver(
 	config Config,
 	bs BlobStore,
 	transferer transfer.ImageTransferer,
+	verifier contenttrust.Verifier,
 	metrics tally.Scope) *KrakenStorageDriver {
 
 	return &KrakenStorageDriver{
@@ -149,7 +157,7 @@ func NewReadOnlyStorageDriver(
 		transferer: transferer,
 		blobs:      newBlobs(bs, transferer),
 		uploads:    disabledUploads{},
-		manifests:  newManifests(transferer),
+		manifests:  newManifests(transferer, verifier),
 		metrics:    metrics,
 	}
 }
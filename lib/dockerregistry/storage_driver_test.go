@@ -15,6 +15,7 @@ package dockerregistry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -24,8 +25,14 @@ import (
 
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/uuid"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/mocks/lib/dockerregistry/transfer"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/randutil"
 )
 
@@ -73,6 +80,43 @@ func TestStorageDriverGetContent(t *testing.T) {
 	}
 }
 
+func TestStorageDriverGetContentContentTrust(t *testing.T) {
+	require := require.New(t)
+
+	td, cleanup := newTestDriver()
+	defer cleanup()
+
+	_, testImage := td.setup()
+
+	key := contenttrust.NewKeyFixture()
+	config, c := contenttrust.ConfigFixture(key)
+	defer c()
+	verifier, err := contenttrust.New(config)
+	require.NoError(err)
+
+	sd := NewReadWriteStorageDriver(Config{}, td.cas, td.transferer, verifier, tally.NoopScope)
+
+	path := genManifestTagCurrentLinkPath(testImage.repo, testImage.tag, testImage.manifest)
+
+	// Image is not signed yet.
+	_, err = sd.GetContent(contextFixture(), path)
+	var untrusted *contenttrust.UntrustedError
+	require.True(errors.As(err, &untrusted))
+
+	d, err := core.NewSHA256DigestFromHex(testImage.manifest)
+	require.NoError(err)
+	sig := key.Sign(testImage.repo, d)
+	require.NoError(td.transferer.Upload(
+		testImage.repo, sig.ManifestDigest, store.NewBufferFileReader(sig.Manifest)))
+	require.NoError(td.transferer.Upload(
+		testImage.repo, sig.PayloadDigest, store.NewBufferFileReader(sig.Payload)))
+	require.NoError(td.transferer.PutTag(sig.Tag, sig.ManifestDigest))
+
+	data, err := sd.GetContent(contextFixture(), path)
+	require.NoError(err)
+	require.Equal([]byte(d.String()), data)
+}
+
 func TestStorageDriverReader(t *testing.T) {
 	td, cleanup := newTestDriver()
 	defer cleanup()
@@ -140,6 +184,40 @@ func TestStorageDriverPutContent(t *testing.T) {
 	// TODO (@evelynl): check content written
 }
 
+func TestStorageDriverPutContentReferrer(t *testing.T) {
+	require := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cas, cleanup := store.CAStoreFixture()
+	defer cleanup()
+
+	transferer := mocktransfer.NewMockImageTransferer(ctrl)
+
+	sd := NewReadWriteStorageDriver(
+		Config{}, cas, transferer, contenttrust.NoopVerifier{}, tally.NoopScope)
+
+	repo := "some/repo"
+
+	imageDigest, image := dockerutil.ManifestFixture(
+		core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(imageDigest, "application/spdx+json")
+
+	gomock.InOrder(
+		transferer.EXPECT().Download(repo, imageDigest).Return(
+			store.NewBufferFileReader(image), nil),
+		transferer.EXPECT().Download(repo, sbomDigest).Return(
+			store.NewBufferFileReader(sbom), nil),
+		transferer.EXPECT().PutReferrer(repo, sbomDigest).Return(nil),
+	)
+
+	require.NoError(sd.PutContent(
+		contextFixture(), genManifestRevisionLinkPath(repo, imageDigest.Hex()), nil))
+	require.NoError(sd.PutContent(
+		contextFixture(), genManifestRevisionLinkPath(repo, sbomDigest.Hex()), nil))
+}
+
 func TestStorageDriverWriter(t *testing.T) {
 	td, cleanup := newTestDriver()
 	defer cleanup()
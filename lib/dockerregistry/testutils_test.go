@@ -20,6 +20,7 @@ import (
 	"path"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/contenttrust"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/utils/dockerutil"
@@ -57,7 +58,8 @@ func newTestDriver() (*testDriver, func()) {
 }
 
 func (d *testDriver) setup() (*KrakenStorageDriver, testImageUploadBundle) {
-	sd := NewReadWriteStorageDriver(Config{}, d.cas, d.transferer, tally.NoopScope)
+	sd := NewReadWriteStorageDriver(
+		Config{}, d.cas, d.transferer, contenttrust.NoopVerifier{}, tally.NoopScope)
 
 	// Create upload
 	uploadUUID := uuid.Generate().String()
@@ -112,3 +112,8 @@ func (t *ReadOnlyTransferer) PutTag(tag string, d core.Digest) error {
 func (t *ReadOnlyTransferer) ListTags(prefix string) ([]string, error) {
 	return nil, errors.New("not supported")
 }
+
+// PutReferrer is not supported.
+func (t *ReadOnlyTransferer) PutReferrer(namespace string, d core.Digest) error {
+	return errors.New("not supported")
+}
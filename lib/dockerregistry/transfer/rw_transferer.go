@@ -145,6 +145,15 @@ func (t *ReadWriteTransferer) PutTag(tag string, d core.Digest) error {
 	return nil
 }
 
+// PutReferrer adds manifest d to the referrers index of its subject.
+func (t *ReadWriteTransferer) PutReferrer(namespace string, d core.Digest) error {
+	if err := t.tags.PutReferrer(namespace, d); err != nil {
+		t.stats.Counter("put_referrer_error").Inc(1)
+		return fmt.Errorf("put referrer: %s", err)
+	}
+	return nil
+}
+
 // ListTags lists all tags with prefix.
 func (t *ReadWriteTransferer) ListTags(prefix string) ([]string, error) {
 	return t.tags.List(prefix)
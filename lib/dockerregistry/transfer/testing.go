@@ -95,3 +95,9 @@ func (t *testTransferer) ListTags(prefix string) ([]string, error) {
 	}
 	return tags, nil
 }
+
+// PutReferrer is a no-op, since referrers indexes are maintained by the
+// build-index.
+func (t *testTransferer) PutReferrer(namespace string, d core.Digest) error {
+	return nil
+}
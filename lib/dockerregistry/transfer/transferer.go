@@ -27,4 +27,6 @@ type ImageTransferer interface {
 	GetTag(tag string) (core.Digest, error)
 	PutTag(tag string, d core.Digest) error
 	ListTags(prefix string) ([]string, error)
+
+	PutReferrer(namespace string, d core.Digest) error
 }
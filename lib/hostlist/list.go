@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -139,8 +140,14 @@ func getLocalNames() (stringset.Set, error) {
 			return nil, fmt.Errorf("addrs of %v: %s", i, err)
 		}
 		for _, addr := range addrs {
-			ip := net.ParseIP(addr.String()).To4()
-			if ip == nil {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+			if ip == nil || ip.IsLoopback() {
 				continue
 			}
 			result.Add(ip.String())
@@ -160,15 +167,16 @@ func getLocalNames() (stringset.Set, error) {
 func attachPortIfMissing(names stringset.Set, port int) (stringset.Set, error) {
 	result := make(stringset.Set)
 	for name := range names {
-		parts := strings.Split(name, ":")
-		switch len(parts) {
-		case 1:
+		if _, _, err := net.SplitHostPort(name); err == nil {
+			// No-op, name is already in "host:port" format.
+		} else if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+			// Name is an ip address, possibly IPv6 -- attach port.
+			name = net.JoinHostPort(strings.Trim(name, "[]"), strconv.Itoa(port))
+		} else if !strings.Contains(name, ":") {
 			// Name is in 'host' format -- attach port.
-			name = fmt.Sprintf("%s:%d", parts[0], port)
-		case 2:
-			// No-op, name is already in "ip:port" format.
-		default:
-			return nil, fmt.Errorf("invalid name format: %s, expected 'host' or 'ip:port'", name)
+			name = net.JoinHostPort(name, strconv.Itoa(port))
+		} else {
+			return nil, fmt.Errorf("invalid name format: %s, expected 'host' or 'host:port'", name)
 		}
 		result.Add(name)
 	}
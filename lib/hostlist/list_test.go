@@ -24,7 +24,7 @@ import (
 func TestListResolve(t *testing.T) {
 	require := require.New(t)
 
-	addrs := []string{"a:80", "b:80", "c:80"}
+	addrs := []string{"a:80", "b:80", "c:80", "[2001:db8::1]:80"}
 
 	l, err := New(Config{Static: addrs})
 	require.NoError(err)
@@ -38,6 +38,14 @@ func TestAttachPortIfMissing(t *testing.T) {
 	require.Equal(t, stringset.New("x:7", "y:5", "z:7"), addrs)
 }
 
+func TestAttachPortIfMissingIPv6(t *testing.T) {
+	addrs, err := attachPortIfMissing(
+		stringset.New("2001:db8::1", "[2001:db8::2]", "[2001:db8::3]:5", "10.0.0.1"), 7)
+	require.NoError(t, err)
+	require.Equal(t, stringset.New(
+		"[2001:db8::1]:7", "[2001:db8::2]:7", "[2001:db8::3]:5", "10.0.0.1:7"), addrs)
+}
+
 func TestAttachPortIfMissingError(t *testing.T) {
 	_, err := attachPortIfMissing(stringset.New("a:b:c"), 7)
 	require.Error(t, err)
@@ -0,0 +1,78 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+)
+
+// Config defines webhook notification configuration.
+type Config struct {
+	Endpoints []EndpointConfig      `yaml:"endpoints"`
+	Retry     persistedretry.Config `yaml:"retry"`
+
+	// Source identifies the emitting host in event payloads. Defaults to the
+	// hostname.
+	Source string `yaml:"source"`
+}
+
+// EndpointConfig defines a single webhook receiver.
+type EndpointConfig struct {
+	// Name uniquely identifies the endpoint. Defaults to URL.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+
+	// Secret is used to sign payloads with HMAC-SHA256. Payloads are not
+	// signed if empty.
+	Secret string `yaml:"secret"`
+
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Actions restricts which events are sent to the endpoint. All events are
+	// sent if empty.
+	Actions []string `yaml:"actions"`
+}
+
+func (c Config) applyDefaults() Config {
+	endpoints := make([]EndpointConfig, len(c.Endpoints))
+	for i, e := range c.Endpoints {
+		endpoints[i] = e.applyDefaults()
+	}
+	c.Endpoints = endpoints
+	return c
+}
+
+func (c EndpointConfig) applyDefaults() EndpointConfig {
+	if c.Name == "" {
+		c.Name = c.URL
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	return c
+}
+
+func (c EndpointConfig) accepts(action string) bool {
+	if len(c.Actions) == 0 {
+		return true
+	}
+	for _, a := range c.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"strings"
+	"time"
+
+	"github.com/uber/kraken/core"
+)
+
+// MediaType is the content type of notification payloads, identical to the
+// one used by Docker registry notifications.
+const MediaType = "application/vnd.docker.distribution.events.v1+json"
+
+// Event actions.
+const (
+	// ActionPush is emitted when a tag is created or a blob upload is
+	// committed.
+	ActionPush = "push"
+
+	// ActionWriteBack is emitted when a blob is written back to remote
+	// storage.
+	ActionWriteBack = "writeback"
+
+	// ActionReplicationFailed is emitted the first time a tag fails to
+	// replicate to a remote build-index.
+	ActionReplicationFailed = "replication_failed"
+)
+
+// Envelope is the request body POSTed to webhook endpoints.
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+// Event describes a single action. Its format follows Docker registry
+// notifications, such that existing registry event consumers can parse it.
+type Event struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    *Target   `json:"target"`
+	Source    Source    `json:"source"`
+
+	// Destination is the remote build-index of a failed replication.
+	Destination string `json:"destination,omitempty"`
+}
+
+// Target holds information about the target of an event.
+type Target struct {
+	MediaType  string `json:"mediaType,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// Source identifies the host which emitted an event.
+type Source struct {
+	Addr string `json:"addr"`
+}
+
+// NewTagEvent creates an event targeting tag, which resolves to d.
+func NewTagEvent(action, tag string, d core.Digest) *Event {
+	repo := tag
+	var name string
+	if i := strings.LastIndex(tag, ":"); i != -1 {
+		repo, name = tag[:i], tag[i+1:]
+	}
+	return &Event{
+		Action: action,
+		Target: &Target{
+			Digest:     d.String(),
+			Repository: repo,
+			Tag:        name,
+		},
+	}
+}
+
+// NewBlobEvent creates an event targeting blob d in namespace.
+func NewBlobEvent(action, namespace string, d core.Digest) *Event {
+	return &Event{
+		Action: action,
+		Target: &Target{
+			MediaType:  "application/octet-stream",
+			Digest:     d.String(),
+			Repository: namespace,
+		},
+	}
+}
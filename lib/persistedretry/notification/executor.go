@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/uber-go/tally"
+)
+
+// Headers set on webhook requests.
+const (
+	SignatureHeader = "X-Kraken-Signature"
+	EventIDHeader   = "X-Kraken-Event-Id"
+)
+
+// Executor executes notification tasks.
+type Executor struct {
+	stats     tally.Scope
+	endpoints map[string]EndpointConfig
+}
+
+// NewExecutor creates a new Executor.
+func NewExecutor(config Config, stats tally.Scope) *Executor {
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "notificationexecutor",
+	})
+
+	endpoints := make(map[string]EndpointConfig)
+	for _, e := range config.Endpoints {
+		endpoints[e.Name] = e
+	}
+	return &Executor{stats, endpoints}
+}
+
+// Name returns the executor name.
+func (e *Executor) Name() string {
+	return "notification"
+}
+
+// Exec POSTs the payload of r to its endpoint.
+func (e *Executor) Exec(r persistedretry.Task) error {
+	t := r.(*Task)
+
+	endpoint, ok := e.endpoints[t.Endpoint]
+	if !ok {
+		log.With(
+			"endpoint", t.Endpoint,
+			"event_id", t.EventID).Info("Dropping notification for unconfigured endpoint")
+		return nil
+	}
+
+	headers := map[string]string{
+		"Content-Type": MediaType,
+		EventIDHeader:  t.EventID,
+	}
+	if endpoint.Secret != "" {
+		headers[SignatureHeader] = Sign(endpoint.Secret, t.Payload)
+	}
+
+	start := time.Now()
+	_, err := httputil.Post(
+		endpoint.URL,
+		httputil.SendBody(bytes.NewReader(t.Payload)),
+		httputil.SendHeaders(headers),
+		httputil.SendTimeout(endpoint.Timeout),
+		httputil.SendAcceptedCodes(
+			http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent))
+	if err != nil {
+		return fmt.Errorf("post: %s", err)
+	}
+	e.stats.Timer("post").Record(time.Since(start))
+	e.stats.Timer("lifetime").Record(time.Since(t.CreatedAt))
+
+	return nil
+}
+
+// Sign returns the signature of payload using secret, in the format sent in
+// SignatureHeader.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether signature is a valid signature of payload using
+// secret. Webhook receivers may use Verify to authenticate requests.
+func Verify(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, payload)), []byte(signature))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/pressly/chi"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func startReceiver(status int) (addr string, requests chan request, stop func()) {
+	requests = make(chan request, 1)
+	r := chi.NewRouter()
+	r.Post("/events", func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		requests <- request{r.Header, b}
+		w.WriteHeader(status)
+	})
+	addr, stop = testutil.StartServer(r)
+	return addr, requests, stop
+}
+
+func TestExecutorSignsPayload(t *testing.T) {
+	require := require.New(t)
+
+	addr, requests, stop := startReceiver(http.StatusOK)
+	defer stop()
+
+	secret := "some-secret"
+	executor := NewExecutor(Config{
+		Endpoints: []EndpointConfig{{
+			Name:   "receiver",
+			URL:    "http://" + addr + "/events",
+			Secret: secret,
+		}},
+	}, tally.NoopScope)
+
+	task := NewTask("receiver", "some-event", []byte(`{"events":[]}`))
+
+	require.NoError(executor.Exec(task))
+
+	req := <-requests
+	require.Equal(task.Payload, req.body)
+	require.Equal(MediaType, req.header.Get("Content-Type"))
+	require.Equal(task.EventID, req.header.Get(EventIDHeader))
+	require.True(Verify(secret, req.body, req.header.Get(SignatureHeader)))
+	require.False(Verify("wrong-secret", req.body, req.header.Get(SignatureHeader)))
+}
+
+func TestExecutorDoesNotSignWithoutSecret(t *testing.T) {
+	require := require.New(t)
+
+	addr, requests, stop := startReceiver(http.StatusNoContent)
+	defer stop()
+
+	url := "http://" + addr + "/events"
+	executor := NewExecutor(Config{
+		Endpoints: []EndpointConfig{{URL: url}},
+	}, tally.NoopScope)
+
+	// Name defaults to URL.
+	require.NoError(executor.Exec(NewTask(url, "some-event", []byte(`{"events":[]}`))))
+
+	req := <-requests
+	require.Empty(req.header.Get(SignatureHeader))
+}
+
+func TestExecutorErrorsOnEndpointFailure(t *testing.T) {
+	require := require.New(t)
+
+	addr, requests, stop := startReceiver(http.StatusInternalServerError)
+	defer stop()
+
+	executor := NewExecutor(Config{
+		Endpoints: []EndpointConfig{{Name: "receiver", URL: "http://" + addr + "/events"}},
+	}, tally.NoopScope)
+
+	require.Error(executor.Exec(NewTask("receiver", "some-event", []byte(`{"events":[]}`))))
+	<-requests
+}
+
+func TestExecutorDropsTasksForUnconfiguredEndpoints(t *testing.T) {
+	require := require.New(t)
+
+	executor := NewExecutor(Config{}, tally.NoopScope)
+
+	require.NoError(executor.Exec(TaskFixture()))
+}
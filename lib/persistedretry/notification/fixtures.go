@@ -0,0 +1,28 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"fmt"
+
+	"github.com/uber/kraken/utils/randutil"
+)
+
+// TaskFixture returns a randomly generated Task for testing purposes.
+func TaskFixture() *Task {
+	return NewTask(
+		fmt.Sprintf("endpoint-%s", randutil.Hex(8)),
+		randutil.Hex(16),
+		[]byte(fmt.Sprintf(`{"events":[{"id":"%s"}]}`, randutil.Hex(8))))
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/docker/distribution/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/uber-go/tally"
+)
+
+// Notifier emits events to webhook endpoints.
+type Notifier interface {
+	// Notify asynchronously delivers e to all endpoints subscribed to its
+	// action. Delivery failures never surface to the caller.
+	Notify(e *Event)
+}
+
+// New creates a Notifier which persists pending notifications in db. Returns a
+// no-op Notifier if no endpoints are configured.
+func New(config Config, stats tally.Scope, db *sqlx.DB) (Notifier, error) {
+	if len(config.Endpoints) == 0 {
+		log.Info("No notification endpoints configured, notifications disabled")
+		return NoopNotifier{}, nil
+	}
+	m, err := persistedretry.NewManager(
+		config.Retry, stats, NewStore(db), NewExecutor(config, stats))
+	if err != nil {
+		return nil, fmt.Errorf("new manager: %s", err)
+	}
+	return NewNotifier(config, stats, m), nil
+}
+
+// NoopNotifier drops all events.
+type NoopNotifier struct{}
+
+// Notify is a no-op.
+func (n NoopNotifier) Notify(e *Event) {}
+
+type notifier struct {
+	config  Config
+	stats   tally.Scope
+	manager persistedretry.Manager
+}
+
+// NewNotifier creates a Notifier which adds notification tasks to manager.
+func NewNotifier(
+	config Config, stats tally.Scope, manager persistedretry.Manager) Notifier {
+
+	config = config.applyDefaults()
+
+	if config.Source == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Errorf("Error getting hostname for notification source: %s", err)
+		}
+		config.Source = hostname
+	}
+
+	stats = stats.Tagged(map[string]string{
+		"module": "notifier",
+	})
+
+	return &notifier{config, stats, manager}
+}
+
+func (n *notifier) Notify(e *Event) {
+	event := *e
+	event.ID = uuid.Generate().String()
+	event.Timestamp = time.Now().UTC()
+	event.Source = Source{Addr: n.config.Source}
+
+	payload, err := json.Marshal(Envelope{Events: []Event{event}})
+	if err != nil {
+		n.stats.Counter("marshal_errors").Inc(1)
+		log.With("action", event.Action).Errorf("Error marshalling notification: %s", err)
+		return
+	}
+	for _, endpoint := range n.config.Endpoints {
+		if !endpoint.accepts(event.Action) {
+			continue
+		}
+		if err := n.manager.Add(NewTask(endpoint.Name, event.ID, payload)); err != nil {
+			n.stats.Counter("add_errors").Inc(1)
+			log.With(
+				"endpoint", endpoint.Name,
+				"action", event.Action).Errorf("Error adding notification task: %s", err)
+			continue
+		}
+		n.stats.Tagged(map[string]string{"action": event.Action}).Counter("notifications").Inc(1)
+	}
+}
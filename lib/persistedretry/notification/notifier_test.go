@@ -0,0 +1,92 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/mocks/lib/persistedretry"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestNotifierAddsTaskPerSubscribedEndpoint(t *testing.T) {
+	require := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	manager := mockpersistedretry.NewMockManager(ctrl)
+
+	n := NewNotifier(Config{
+		Source: "some-host",
+		Endpoints: []EndpointConfig{
+			{Name: "all", URL: "http://all"},
+			{Name: "push", URL: "http://push", Actions: []string{ActionPush}},
+			{Name: "writeback", URL: "http://writeback", Actions: []string{ActionWriteBack}},
+		},
+	}, tally.NoopScope, manager)
+
+	var tasks []*Task
+	manager.EXPECT().Add(gomock.Any()).Times(2).DoAndReturn(func(t persistedretry.Task) error {
+		tasks = append(tasks, t.(*Task))
+		return nil
+	})
+
+	tag := "some/repo:some-tag"
+	d := core.DigestFixture()
+
+	n.Notify(NewTagEvent(ActionPush, tag, d))
+
+	require.Len(tasks, 2)
+	require.Equal("all", tasks[0].Endpoint)
+	require.Equal("push", tasks[1].Endpoint)
+	require.Equal(tasks[0].EventID, tasks[1].EventID)
+	require.Equal(tasks[0].Payload, tasks[1].Payload)
+
+	var envelope Envelope
+	require.NoError(json.Unmarshal(tasks[0].Payload, &envelope))
+	require.Len(envelope.Events, 1)
+
+	event := envelope.Events[0]
+	require.Equal(tasks[0].EventID, event.ID)
+	require.Equal(ActionPush, event.Action)
+	require.Equal("some-host", event.Source.Addr)
+	require.False(event.Timestamp.IsZero())
+	require.Equal(&Target{
+		Digest:     d.String(),
+		Repository: "some/repo",
+		Tag:        "some-tag",
+	}, event.Target)
+}
+
+func TestNotifierIgnoresAddErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	manager := mockpersistedretry.NewMockManager(ctrl)
+
+	n := NewNotifier(Config{
+		Endpoints: []EndpointConfig{{Name: "some-endpoint", URL: "http://some-endpoint"}},
+	}, tally.NoopScope, manager)
+
+	manager.EXPECT().Add(gomock.Any()).Return(persistedretry.ErrManagerClosed)
+
+	n.Notify(NewBlobEvent(ActionWriteBack, "some-namespace", core.DigestFixture()))
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/localdb"
+
+	"github.com/stretchr/testify/require"
+)
+
+func checkTasks(t *testing.T, expected []*Task, result []persistedretry.Task) {
+	t.Helper()
+
+	require.Equal(t, len(expected), len(result))
+
+	for i := 0; i < len(expected); i++ {
+		expectedCopy := *expected[i]
+		resultCopy := *(result[i].(*Task))
+
+		require.InDelta(t, expectedCopy.CreatedAt.Unix(), resultCopy.CreatedAt.Unix(), 1)
+		expectedCopy.CreatedAt = time.Time{}
+		resultCopy.CreatedAt = time.Time{}
+
+		require.InDelta(t, expectedCopy.LastAttempt.Unix(), resultCopy.LastAttempt.Unix(), 1)
+		expectedCopy.LastAttempt = time.Time{}
+		resultCopy.LastAttempt = time.Time{}
+
+		require.Equal(t, expectedCopy, resultCopy)
+	}
+}
+
+func checkPending(t *testing.T, store *Store, expected ...*Task) {
+	t.Helper()
+
+	result, err := store.GetPending()
+	require.NoError(t, err)
+	checkTasks(t, expected, result)
+}
+
+func checkFailed(t *testing.T, store *Store, expected ...*Task) {
+	t.Helper()
+
+	result, err := store.GetFailed()
+	require.NoError(t, err)
+	checkTasks(t, expected, result)
+}
+
+func TestAddTwiceReturnsErrTaskExists(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	require.Equal(persistedretry.ErrTaskExists, store.AddPending(task))
+	require.Equal(persistedretry.ErrTaskExists, store.AddFailed(task))
+}
+
+func TestSameEventDifferentEndpoints(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task1 := TaskFixture()
+	task2 := NewTask("other-endpoint", task1.EventID, task1.Payload)
+
+	require.NoError(store.AddPending(task1))
+	require.NoError(store.AddPending(task2))
+
+	checkPending(t, store, task1, task2)
+}
+
+func TestStateTransitions(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+
+	require.NoError(store.AddPending(task))
+	checkPending(t, store, task)
+	checkFailed(t, store)
+
+	require.NoError(store.MarkFailed(task))
+	checkPending(t, store)
+	checkFailed(t, store, task)
+
+	require.NoError(store.MarkPending(task))
+	checkPending(t, store, task)
+	checkFailed(t, store)
+
+	require.NoError(store.Remove(task))
+	checkPending(t, store)
+	checkFailed(t, store)
+}
+
+func TestMarkTaskNotFound(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+
+	require.Equal(persistedretry.ErrTaskNotFound, store.MarkPending(task))
+	require.Equal(persistedretry.ErrTaskNotFound, store.MarkFailed(task))
+}
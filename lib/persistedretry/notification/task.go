@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package notification
+
+import (
+	"fmt"
+	"time"
+)
+
+// Task contains a serialized event to deliver to a webhook endpoint.
+type Task struct {
+	Endpoint    string    `db:"endpoint"`
+	EventID     string    `db:"event_id"`
+	Payload     []byte    `db:"payload"`
+	CreatedAt   time.Time `db:"created_at"`
+	LastAttempt time.Time `db:"last_attempt"`
+	Failures    int       `db:"failures"`
+}
+
+// NewTask creates a new Task.
+func NewTask(endpoint, eventID string, payload []byte) *Task {
+	return &Task{
+		Endpoint:  endpoint,
+		EventID:   eventID,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (t *Task) String() string {
+	return fmt.Sprintf("notification.Task(endpoint=%s, event_id=%s)", t.Endpoint, t.EventID)
+}
+
+// GetLastAttempt returns when t was last attempted.
+func (t *Task) GetLastAttempt() time.Time {
+	return t.LastAttempt
+}
+
+// GetFailures returns the number of times t has failed.
+func (t *Task) GetFailures() int {
+	return t.Failures
+}
+
+// Ready always returns true.
+func (t *Task) Ready() bool {
+	return true
+}
+
+// Tags returns the endpoint of the task.
+func (t *Task) Tags() map[string]string {
+	return map[string]string{
+		"endpoint": t.Endpoint,
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/origin/blobclient"
 
 	"github.com/uber-go/tally"
@@ -29,19 +30,21 @@ type Executor struct {
 	stats             tally.Scope
 	originCluster     blobclient.ClusterClient
 	tagClientProvider tagclient.Provider
+	notifier          notification.Notifier
 }
 
 // NewExecutor creates a new Executor.
 func NewExecutor(
 	stats tally.Scope,
 	originCluster blobclient.ClusterClient,
-	tagClientProvider tagclient.Provider) *Executor {
+	tagClientProvider tagclient.Provider,
+	notifier notification.Notifier) *Executor {
 
 	stats = stats.Tagged(map[string]string{
 		"module": "tagreplicationexecutor",
 	})
 
-	return &Executor{stats, originCluster, tagClientProvider}
+	return &Executor{stats, originCluster, tagClientProvider, notifier}
 }
 
 // Name returns the executor name.
@@ -53,13 +56,33 @@ func (e *Executor) Name() string {
 // cluster, then replicates the tag to the remote build-index.
 func (e *Executor) Exec(r persistedretry.Task) error {
 	t := r.(*Task)
+	if err := e.replicate(t); err != nil {
+		if t.Failures == 0 {
+			// Only notify on the first failure, since the task will be
+			// retried until it succeeds.
+			event := notification.NewTagEvent(
+				notification.ActionReplicationFailed, t.Tag, t.Digest)
+			event.Destination = t.Destination
+			e.notifier.Notify(event)
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *Executor) replicate(t *Task) error {
 	start := time.Now()
 	remoteTagClient := e.tagClientProvider.Provide(t.Destination)
 
-	if ok, err := remoteTagClient.Has(t.Tag); err == nil && ok {
-		// Remote index already has the tag, therefore dependencies have already
-		// been replicated, and the remote has also replicated the tag. No-op.
-		return nil
+	if remote, err := remoteTagClient.GetRecord(t.Tag); err == nil {
+		// Tasks without a version predate tag mutation, in which case any
+		// remote record is considered current.
+		if t.Version == 0 || !t.Record().Newer(remote) {
+			// Remote index already has the tag, therefore dependencies have
+			// already been replicated, and the remote has also replicated the
+			// tag. No-op.
+			return nil
+		}
 	}
 
 	remoteOrigin, err := remoteTagClient.Origin()
@@ -74,8 +97,14 @@ func (e *Executor) Exec(r persistedretry.Task) error {
 
 	// Put tag and triggers replication on the remote client.
 	// Replication will call Exec n^2 times but some will return early
-	// if remote has the tag already.
-	if err := remoteTagClient.PutAndReplicate(t.Tag, t.Digest); err != nil {
+	// if remote has the tag already. Versioned puts are ignored by the remote
+	// if it has since received a newer record.
+	if t.Version == 0 {
+		err = remoteTagClient.PutAndReplicate(t.Tag, t.Digest)
+	} else {
+		err = remoteTagClient.PutRecordAndReplicate(t.Tag, t.Record())
+	}
+	if err != nil {
 		return fmt.Errorf("put and replicate tag: %s", err)
 	}
 
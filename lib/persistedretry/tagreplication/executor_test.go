@@ -14,9 +14,15 @@
 package tagreplication
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/build-index/tagmodels"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/mocks/build-index/tagclient"
+	"github.com/uber/kraken/mocks/lib/persistedretry/notification"
 	"github.com/uber/kraken/mocks/origin/blobclient"
 
 	"github.com/golang/mock/gomock"
@@ -32,6 +38,7 @@ type executorMocks struct {
 	ctrl              *gomock.Controller
 	originCluster     *mockblobclient.MockClusterClient
 	tagClientProvider *mocktagclient.MockProvider
+	notifier          *mocknotification.MockNotifier
 }
 
 func newExecutorMocks(t *testing.T) (*executorMocks, func()) {
@@ -40,11 +47,12 @@ func newExecutorMocks(t *testing.T) (*executorMocks, func()) {
 		ctrl:              ctrl,
 		originCluster:     mockblobclient.NewMockClusterClient(ctrl),
 		tagClientProvider: mocktagclient.NewMockProvider(ctrl),
+		notifier:          mocknotification.NewMockNotifier(ctrl),
 	}, ctrl.Finish
 }
 
 func (m *executorMocks) new() *Executor {
-	return NewExecutor(tally.NoopScope, m.originCluster, m.tagClientProvider)
+	return NewExecutor(tally.NoopScope, m.originCluster, m.tagClientProvider, m.notifier)
 }
 
 func (m *executorMocks) newTagClient() *mocktagclient.MockClient {
@@ -63,7 +71,8 @@ func TestExecutor(t *testing.T) {
 
 	gomock.InOrder(
 		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
-		tagClient.EXPECT().Has(task.Tag).Return(false, nil),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(
+			tagmodels.TagRecord{}, tagclient.ErrTagNotFound),
 		tagClient.EXPECT().Origin().Return(_testRemoteOrigin, nil),
 		mocks.originCluster.EXPECT().ReplicateToRemote(
 			task.Tag, task.Dependencies[0], _testRemoteOrigin).Return(nil),
@@ -89,8 +98,91 @@ func TestExecutorNoopsWhenTagAlreadyReplicated(t *testing.T) {
 
 	gomock.InOrder(
 		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
-		tagClient.EXPECT().Has(task.Tag).Return(true, nil),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(task.Record(), nil),
 	)
 
 	require.NoError(executor.Exec(task))
 }
+
+func TestExecutorReplicatesNewerVersion(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	executor := mocks.new()
+	tagClient := mocks.newTagClient()
+	task := TaskFixture()
+	task.Version = 2
+
+	remote := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 1}
+
+	gomock.InOrder(
+		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(remote, nil),
+		tagClient.EXPECT().Origin().Return(_testRemoteOrigin, nil),
+		mocks.originCluster.EXPECT().ReplicateToRemote(
+			task.Tag, gomock.Any(), _testRemoteOrigin).Return(nil).Times(3),
+		tagClient.EXPECT().PutRecordAndReplicate(task.Tag, task.Record()).Return(nil),
+	)
+
+	require.NoError(executor.Exec(task))
+}
+
+func TestExecutorNoopsWhenRemoteVersionIsNewer(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	executor := mocks.new()
+	tagClient := mocks.newTagClient()
+	task := TaskFixture()
+	task.Version = 1
+
+	remote := tagmodels.TagRecord{Digest: core.DigestFixture(), Version: 2}
+
+	gomock.InOrder(
+		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(remote, nil),
+	)
+
+	require.NoError(executor.Exec(task))
+}
+
+func TestExecutorNotifiesOnFirstReplicationFailure(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	executor := mocks.new()
+	tagClient := mocks.newTagClient()
+	task := TaskFixture()
+
+	expected := notification.NewTagEvent(
+		notification.ActionReplicationFailed, task.Tag, task.Digest)
+	expected.Destination = task.Destination
+
+	gomock.InOrder(
+		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(
+			tagmodels.TagRecord{}, tagclient.ErrTagNotFound),
+		tagClient.EXPECT().Origin().Return("", errors.New("some error")),
+		mocks.notifier.EXPECT().Notify(expected),
+	)
+
+	require.Error(executor.Exec(task))
+
+	// Subsequent failures should not notify.
+	task.Failures++
+
+	gomock.InOrder(
+		mocks.tagClientProvider.EXPECT().Provide(task.Destination).Return(tagClient),
+		tagClient.EXPECT().GetRecord(task.Tag).Return(
+			tagmodels.TagRecord{}, tagclient.ErrTagNotFound),
+		tagClient.EXPECT().Origin().Return("", errors.New("some error")),
+	)
+
+	require.Error(executor.Exec(task))
+}
@@ -19,7 +19,6 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/mattn/go-sqlite3"
 
 	"github.com/uber/kraken/lib/persistedretry"
 )
@@ -63,7 +62,7 @@ func (s *Store) MarkPending(r persistedretry.Task) error {
 	res, err := s.db.NamedExec(`
 		UPDATE replicate_tag_task
 		SET status = "pending"
-		WHERE tag=:tag AND destination=:destination
+		WHERE tag=:tag AND destination=:destination AND version=:version
 	`, r.(*Task))
 	if err != nil {
 		return err
@@ -84,7 +83,7 @@ func (s *Store) MarkFailed(r persistedretry.Task) error {
 		SET last_attempt = CURRENT_TIMESTAMP,
 			failures = failures + 1,
 			status = "failed"
-		WHERE tag=:tag AND destination=:destination
+		WHERE tag=:tag AND destination=:destination AND version=:version
 	`, t)
 	if err != nil {
 		return err
@@ -99,9 +98,12 @@ func (s *Store) MarkFailed(r persistedretry.Task) error {
 	return nil
 }
 
-// Remove removes r.
+// Remove removes r. No-op if r has been superseded by a newer version.
 func (s *Store) Remove(r persistedretry.Task) error {
-	return s.delete(r)
+	_, err := s.db.NamedExec(`
+		DELETE FROM replicate_tag_task
+		WHERE tag=:tag AND destination=:destination AND version=:version`, r.(*Task))
+	return err
 }
 
 // Find is not supported.
@@ -109,6 +111,9 @@ func (s *Store) Find(query interface{}) ([]persistedretry.Task, error) {
 	return nil, errors.New("not supported")
 }
 
+// addWithStatus inserts r, replacing any existing task of the same tag and
+// destination with an older version. Returns ErrTaskExists if an existing task
+// is not older than r.
 func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 	query := fmt.Sprintf(`
 		INSERT INTO replicate_tag_task (
@@ -119,6 +124,7 @@ func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 			last_attempt,
 			failures,
 			delay,
+			version,
 			status
 		) VALUES (
 			:tag,
@@ -128,22 +134,37 @@ func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 			:last_attempt,
 			:failures,
 			:delay,
+			:version,
 			%q
 		)
+		ON CONFLICT(tag, destination) DO UPDATE SET
+			digest = excluded.digest,
+			dependencies = excluded.dependencies,
+			created_at = CURRENT_TIMESTAMP,
+			last_attempt = excluded.last_attempt,
+			failures = excluded.failures,
+			delay = excluded.delay,
+			version = excluded.version,
+			status = excluded.status
+		WHERE excluded.version > replicate_tag_task.version
 	`, status)
-	_, err := s.db.NamedExec(query, r.(*Task))
-	if se, ok := err.(sqlite3.Error); ok {
-		if se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
-			return persistedretry.ErrTaskExists
-		}
+	res, err := s.db.NamedExec(query, r.(*Task))
+	if err != nil {
+		return err
 	}
-	return err
+	if n, err := res.RowsAffected(); err != nil {
+		panic("driver does not support RowsAffected")
+	} else if n == 0 {
+		return persistedretry.ErrTaskExists
+	}
+	return nil
 }
 
 func (s *Store) selectStatus(status string) ([]persistedretry.Task, error) {
 	var tasks []*Task
 	err := s.db.Select(&tasks, `
-		SELECT tag, digest, dependencies, destination, created_at, last_attempt, failures, delay
+		SELECT tag, digest, dependencies, destination, created_at, last_attempt, failures, delay,
+			version
 		FROM replicate_tag_task
 		WHERE status=?`, status)
 	if err != nil {
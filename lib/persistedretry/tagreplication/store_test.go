@@ -22,6 +22,7 @@ import (
 	"github.com/jmoiron/sqlx"
 
 	"github.com/stretchr/testify/require"
+	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/persistedretry"
 	. "github.com/uber/kraken/lib/persistedretry/tagreplication"
 	"github.com/uber/kraken/localdb"
@@ -179,6 +180,37 @@ func TestAddPendingTwiceReturnsErrTaskExists(t *testing.T) {
 	require.Equal(persistedretry.ErrTaskExists, store.AddPending(task))
 }
 
+func TestAddPendingNewerVersionReplacesTask(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStoreMocks(t)
+	defer cleanup()
+
+	store := mocks.new()
+
+	task := TaskFixture()
+	task.Version = 1
+
+	require.NoError(store.AddFailed(task))
+
+	newer := *task
+	newer.Digest = core.DigestFixture()
+	newer.Version = 2
+
+	require.NoError(store.AddPending(&newer))
+	checkPending(t, store, &newer)
+	checkFailed(t, store)
+
+	// Older versions are rejected.
+	require.Equal(persistedretry.ErrTaskExists, store.AddPending(task))
+	checkPending(t, store, &newer)
+
+	// Removing the superseded task must not remove the newer one.
+	require.NoError(store.Remove(task))
+	checkPending(t, store, &newer)
+	require.Equal(persistedretry.ErrTaskNotFound, store.MarkFailed(task))
+}
+
 func TestAddFailed(t *testing.T) {
 	require := require.New(t)
 
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/uber/kraken/build-index/tagmodels"
 	"github.com/uber/kraken/core"
 )
 
@@ -31,6 +32,10 @@ type Task struct {
 	LastAttempt  time.Time       `db:"last_attempt"`
 	Failures     int             `db:"failures"`
 	Delay        time.Duration   `db:"delay"`
+
+	// Version is the version of the tag record being replicated. Tasks for
+	// newer versions replace pending tasks of the same tag and destination.
+	Version int64 `db:"version"`
 }
 
 // NewTask creates a new Task.
@@ -51,8 +56,14 @@ func NewTask(
 	}
 }
 
+// Record returns the tag record which t replicates.
+func (t *Task) Record() tagmodels.TagRecord {
+	return tagmodels.TagRecord{Digest: t.Digest, Version: t.Version}
+}
+
 func (t *Task) String() string {
-	return fmt.Sprintf("tagreplication.Task(tag=%s, dest=%s)", t.Tag, t.Destination)
+	return fmt.Sprintf(
+		"tagreplication.Task(tag=%s, dest=%s, version=%d)", t.Tag, t.Destination, t.Version)
 }
 
 // GetLastAttempt returns when t was last attempted.
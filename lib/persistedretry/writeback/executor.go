@@ -14,13 +14,16 @@
 package writeback
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/uber-go/tally"
+	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/utils/log"
@@ -30,6 +33,7 @@ import (
 type FileStore interface {
 	DeleteCacheFileMetadata(name string, md metadata.Metadata) error
 	GetCacheFileReader(name string) (store.FileReader, error)
+	GetCacheFileStat(name string) (os.FileInfo, error)
 }
 
 // Executor executes write back tasks.
@@ -37,19 +41,21 @@ type Executor struct {
 	stats    tally.Scope
 	fs       FileStore
 	backends *backend.Manager
+	notifier notification.Notifier
 }
 
 // NewExecutor creates a new Executor.
 func NewExecutor(
 	stats tally.Scope,
 	fs FileStore,
-	backends *backend.Manager) *Executor {
+	backends *backend.Manager,
+	notifier notification.Notifier) *Executor {
 
 	stats = stats.Tagged(map[string]string{
 		"module": "writebackexecutor",
 	})
 
-	return &Executor{stats, fs, backends}
+	return &Executor{stats, fs, backends, notifier}
 }
 
 // Name returns the executor name.
@@ -58,20 +64,34 @@ func (e *Executor) Name() string {
 }
 
 // Exec uploads the cache file corresponding to r's digest to the remote backend
-// that matches r's namespace.
+// that matches r's namespace. If the file was replaced during the upload, e.g.
+// because a tag was mutated, Exec fails such that the task is retried with the
+// new content, since further write-backs of the file were deduplicated into r.
 func (e *Executor) Exec(r persistedretry.Task) error {
 	t := r.(*Task)
-	if err := e.upload(t); err != nil {
+	uploaded, err := e.upload(t)
+	if err != nil {
 		return err
 	}
-	err := e.fs.DeleteCacheFileMetadata(t.Name, &metadata.Persist{})
+	if uploaded != nil {
+		cur, err := e.fs.GetCacheFileStat(t.Name)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("stat file: %s", err)
+		}
+		if err == nil && !os.SameFile(uploaded, cur) {
+			e.stats.Counter("replaced_during_upload").Inc(1)
+			return errors.New("file replaced during upload")
+		}
+	}
+	err = e.fs.DeleteCacheFileMetadata(t.Name, &metadata.Persist{})
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("delete persist metadata: %s", err)
 	}
 	return nil
 }
 
-func (e *Executor) upload(t *Task) error {
+// upload returns the stat of the uploaded file, or nil if nothing was uploaded.
+func (e *Executor) upload(t *Task) (os.FileInfo, error) {
 	start := time.Now()
 
 	client, err := e.backends.GetClient(t.Namespace)
@@ -80,35 +100,49 @@ func (e *Executor) upload(t *Task) error {
 			log.With(
 				"namespace", t.Namespace,
 				"name", t.Name).Info("Dropping writeback for unconfigured namespace")
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("get client: %s", err)
+		return nil, fmt.Errorf("get client: %s", err)
 	}
 
-	if _, err := client.Stat(t.Namespace, t.Name); err == nil {
-		// File already uploaded, no-op.
-		return nil
+	if !t.Overwrite {
+		if _, err := client.Stat(t.Namespace, t.Name); err == nil {
+			// File already uploaded, no-op.
+			return nil, nil
+		}
 	}
 
-	f, err := e.fs.GetCacheFileReader(t.Name)
+	// Stat before opening the file, such that a replacement of the file after
+	// the stat is always detected, even if the upload reads the new content.
+	info, err := e.fs.GetCacheFileStat(t.Name)
+	var f store.FileReader
+	if err == nil {
+		f, err = e.fs.GetCacheFileReader(t.Name)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Nothing we can do about this but make noise and drop the task.
 			e.stats.Counter("missing_files").Inc(1)
 			log.With("name", t.Name).Error("Invariant violation: writeback cache file missing")
-			return nil
+			return nil, nil
 		}
-		return fmt.Errorf("get file: %s", err)
+		return nil, fmt.Errorf("get file: %s", err)
 	}
 	defer f.Close()
 
 	if err := client.Upload(t.Namespace, t.Name, f); err != nil {
-		return fmt.Errorf("upload: %s", err)
+		return nil, fmt.Errorf("upload: %s", err)
 	}
 
 	// We don't want to time noops nor errors.
 	e.stats.Timer("upload").Record(time.Since(start))
 	e.stats.Timer("lifetime").Record(time.Since(t.CreatedAt))
 
-	return nil
+	// Build-index also writes back tags, which are not blobs and are notified
+	// on creation instead.
+	if d, err := core.NewSHA256DigestFromHex(t.Name); err == nil {
+		e.notifier.Notify(notification.NewBlobEvent(notification.ActionWriteBack, t.Namespace, d))
+	}
+
+	return info, nil
 }
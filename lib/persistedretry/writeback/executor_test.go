@@ -16,14 +16,18 @@ package writeback
 import (
 	"bytes"
 	"errors"
+	"io"
+	"os"
 	"testing"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/mocks/lib/backend"
+	"github.com/uber/kraken/mocks/lib/persistedretry/notification"
 	"github.com/uber/kraken/utils/mockutil"
 	"github.com/uber/kraken/utils/testutil"
 
@@ -36,6 +40,7 @@ type executorMocks struct {
 	ctrl     *gomock.Controller
 	cas      *store.CAStore
 	backends *backend.Manager
+	notifier *mocknotification.MockNotifier
 }
 
 func newExecutorMocks(t *testing.T) (*executorMocks, func()) {
@@ -52,11 +57,12 @@ func newExecutorMocks(t *testing.T) (*executorMocks, func()) {
 		ctrl:     ctrl,
 		cas:      cas,
 		backends: backend.ManagerFixture(),
+		notifier: mocknotification.NewMockNotifier(ctrl),
 	}, cleanup.Run
 }
 
 func (m *executorMocks) new() *Executor {
-	return NewExecutor(tally.NoopScope, m.cas, m.backends)
+	return NewExecutor(tally.NoopScope, m.cas, m.backends, m.notifier)
 }
 
 func (m *executorMocks) client(namespace string) *mockbackend.MockClient {
@@ -89,6 +95,8 @@ func TestExec(t *testing.T) {
 	client := mocks.client(task.Namespace)
 	client.EXPECT().Stat(task.Namespace, blob.Digest.Hex()).Return(nil, backenderrors.ErrBlobNotFound)
 	client.EXPECT().Upload(task.Namespace, blob.Digest.Hex(), mockutil.MatchReader(blob.Content)).Return(nil)
+	mocks.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionWriteBack, task.Namespace, blob.Digest))
 
 	executor := mocks.new()
 
@@ -98,6 +106,56 @@ func TestExec(t *testing.T) {
 	require.NoError(mocks.cas.DeleteCacheFile(blob.Digest.Hex()))
 }
 
+func TestExecDoesNotNotifyForTags(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	ss, c := store.SimpleStoreFixture()
+	defer c()
+
+	tag := core.TagFixture()
+	content := core.DigestFixture().String()
+
+	require.NoError(ss.CreateCacheFile(tag, bytes.NewReader([]byte(content))))
+
+	task := NewTask(tag, tag, 0)
+
+	client := mocks.client(task.Namespace)
+	client.EXPECT().Stat(task.Namespace, tag).Return(nil, backenderrors.ErrBlobNotFound)
+	client.EXPECT().Upload(task.Namespace, tag, mockutil.MatchReader([]byte(content))).Return(nil)
+
+	executor := NewExecutor(tally.NoopScope, ss, mocks.backends, mocks.notifier)
+
+	require.NoError(executor.Exec(task))
+}
+
+func TestExecOverwritesAlreadyUploadedFile(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	ss, c := store.SimpleStoreFixture()
+	defer c()
+
+	tag := core.TagFixture()
+	content := core.DigestFixture().String()
+
+	require.NoError(ss.CreateCacheFile(tag, bytes.NewReader([]byte(content))))
+
+	task := NewTask(tag, tag, 0)
+	task.Overwrite = true
+
+	client := mocks.client(task.Namespace)
+	client.EXPECT().Upload(task.Namespace, tag, mockutil.MatchReader([]byte(content))).Return(nil)
+
+	executor := NewExecutor(tally.NoopScope, ss, mocks.backends, mocks.notifier)
+
+	require.NoError(executor.Exec(task))
+}
+
 func TestExecNoopWhenFileAlreadyUploaded(t *testing.T) {
 	require := require.New(t)
 
@@ -188,3 +246,47 @@ func TestExecUploadFailure(t *testing.T) {
 	// metadata is still present.
 	require.Error(mocks.cas.DeleteCacheFile(blob.Digest.Hex()))
 }
+
+func TestExecFailsWhenFileReplacedDuringUpload(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newExecutorMocks(t)
+	defer cleanup()
+
+	ss, c := store.SimpleStoreFixture()
+	defer c()
+
+	tag := core.TagFixture()
+	content := []byte(core.DigestFixture().String())
+	newContent := []byte(core.DigestFixture().String())
+
+	require.NoError(ss.CreateCacheFile(tag, bytes.NewReader(content)))
+	_, err := ss.SetCacheFileMetadata(tag, metadata.NewPersist(true))
+	require.NoError(err)
+
+	task := NewTask(tag, tag, 0)
+	task.Overwrite = true
+
+	client := mocks.client(task.Namespace)
+	gomock.InOrder(
+		client.EXPECT().Upload(task.Namespace, tag, mockutil.MatchReader(content)).DoAndReturn(
+			func(namespace, name string, src io.Reader) error {
+				// Tag is mutated while the old content is uploaded.
+				return ss.OverwriteCacheFile(tag, bytes.NewReader(newContent))
+			}),
+		client.EXPECT().Upload(task.Namespace, tag, mockutil.MatchReader(newContent)).Return(nil),
+	)
+
+	executor := NewExecutor(tally.NoopScope, ss, mocks.backends, mocks.notifier)
+
+	require.Error(executor.Exec(task))
+
+	// The new content must still be written back.
+	var p metadata.Persist
+	require.NoError(ss.GetCacheFileMetadata(tag, &p))
+	require.True(p.Value)
+
+	require.NoError(executor.Exec(task))
+
+	require.True(os.IsNotExist(ss.GetCacheFileMetadata(tag, &p)))
+}
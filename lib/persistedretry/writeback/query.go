@@ -22,3 +22,11 @@ type NameQuery struct {
 func NewNameQuery(name string) *NameQuery {
 	return &NameQuery{name}
 }
+
+// AllQuery queries all writeback tasks, pending or failed.
+type AllQuery struct{}
+
+// NewAllQuery returns a new AllQuery.
+func NewAllQuery() *AllQuery {
+	return &AllQuery{}
+}
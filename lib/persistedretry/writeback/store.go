@@ -111,10 +111,15 @@ func (s *Store) Find(query interface{}) ([]persistedretry.Task, error) {
 	switch q := query.(type) {
 	case *NameQuery:
 		err = s.db.Select(&tasks, `
-			SELECT namespace, name, created_at, last_attempt, failures, delay
+			SELECT namespace, name, created_at, last_attempt, failures, delay, overwrite
 			FROM writeback_task
 			WHERE name=?
 		`, q.name)
+	case *AllQuery:
+		err = s.db.Select(&tasks, `
+			SELECT namespace, name, created_at, last_attempt, failures, delay, overwrite
+			FROM writeback_task
+		`)
 	default:
 		return nil, errors.New("unknown query type")
 	}
@@ -132,6 +137,7 @@ func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 			last_attempt,
 			failures,
 			delay,
+			overwrite,
 			status
 		) VALUES (
 			:namespace,
@@ -139,6 +145,7 @@ func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 			:last_attempt,
 			:failures,
 			:delay,
+			:overwrite,
 			%q
 		)
 	`, status)
@@ -154,7 +161,7 @@ func (s *Store) addWithStatus(r persistedretry.Task, status string) error {
 func (s *Store) selectStatus(status string) ([]persistedretry.Task, error) {
 	var tasks []*Task
 	err := s.db.Select(&tasks, `
-		SELECT namespace, name, created_at, last_attempt, failures, delay
+		SELECT namespace, name, created_at, last_attempt, failures, delay, overwrite
 		FROM writeback_task
 		WHERE status=?
 	`, status)
@@ -103,6 +103,22 @@ func TestAddPending(t *testing.T) {
 	checkPending(t, store, task)
 }
 
+func TestAddPendingPersistsOverwrite(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task := TaskFixture()
+	task.Overwrite = true
+
+	require.NoError(store.AddPending(task))
+
+	checkPending(t, store, task)
+}
+
 func TestAddPendingTwiceReturnsErrTaskExists(t *testing.T) {
 	require := require.New(t)
 
@@ -260,3 +276,22 @@ func TestFindEmpty(t *testing.T) {
 	require.NoError(err)
 	require.Empty(result)
 }
+
+func TestFindAll(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup := localdb.Fixture()
+	defer cleanup()
+
+	store := NewStore(db)
+
+	task1 := TaskFixture()
+	task2 := TaskFixture()
+
+	require.NoError(store.AddPending(task1))
+	require.NoError(store.AddFailed(task2))
+
+	result, err := store.Find(NewAllQuery())
+	require.NoError(err)
+	checkTasks(t, []*Task{task1, task2}, result)
+}
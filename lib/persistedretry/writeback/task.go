@@ -29,6 +29,10 @@ type Task struct {
 	Failures    int           `db:"failures"`
 	Delay       time.Duration `db:"delay"`
 
+	// Overwrite uploads the file even if it already exists in remote storage,
+	// which is required for mutable files such as tags.
+	Overwrite bool `db:"overwrite"`
+
 	// Deprecated. Use name instead.
 	Digest core.Digest `db:"digest"`
 }
@@ -17,11 +17,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/utils/stringset"
@@ -75,7 +77,7 @@ type FileEntry interface {
 	Create(targetState FileState, len int64) error
 	Reload() error
 	MoveFrom(targetState FileState, sourcePath string) error
-	Move(targetState FileState) error
+	Move(targetState FileState, stagedPath string) error
 	LinkTo(targetPath string) error
 	Delete() error
 
@@ -363,8 +365,10 @@ func (entry *localFileEntry) MoveFrom(targetState FileState, sourcePath string)
 
 // Move moves file to target dir under the same name, moves all metadata that's `movable`, and
 // updates state in memory.
+// If stagedPath is not empty, it must hold a copy of the data created by stageMove, which is
+// renamed into place instead of the data.
 // If for any reason the target path already exists, it will be overwritten.
-func (entry *localFileEntry) Move(targetState FileState) error {
+func (entry *localFileEntry) Move(targetState FileState, stagedPath string) error {
 	sourcePath := entry.GetPath()
 	targetPath := filepath.Join(targetState.GetDirectory(), entry.relativeDataPath)
 	if err := os.MkdirAll(filepath.Dir(targetPath), DefaultDirPermission); err != nil {
@@ -396,8 +400,16 @@ func (entry *localFileEntry) Move(targetState FileState) error {
 		return err
 	}
 
-	// Move data. This could be a slow operation if source and target are not on the same FS.
-	if err := os.Rename(sourcePath, targetPath); err != nil {
+	// Move data. This is a slow copy if source and target are not on the same FS and the data
+	// was not staged.
+	if stagedPath != "" {
+		if err := os.Rename(stagedPath, targetPath); err != nil {
+			return err
+		}
+		if err := os.Remove(sourcePath); err != nil {
+			return err
+		}
+	} else if err := renameOrCopy(sourcePath, targetPath); err != nil {
 		return err
 	}
 
@@ -620,3 +632,96 @@ func compareAndWriteFile(filePath string, b []byte) (bool, error) {
 	}
 	return true, nil
 }
+
+// renameOrCopy renames sourcePath to targetPath, falling back to copying the
+// data if the paths are on different file systems.
+func renameOrCopy(sourcePath, targetPath string) error {
+	err := os.Rename(sourcePath, targetPath)
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+
+	// Copy into a temporary file first, such that a partial copy is never
+	// mistaken for a complete file.
+	tmp := targetPath + ".tmp"
+	if err := copyFile(sourcePath, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copy across file systems: %s", err)
+	}
+	if err := os.Rename(tmp, targetPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(sourcePath)
+}
+
+// stageMove copies sourcePath next to targetPath if they are on different file
+// systems, such that the slow copy can run without holding the lock of the
+// file. Returns the path of the copy, or "" if sourcePath can be renamed
+// directly, and the stat of sourcePath before the copy.
+func stageMove(sourcePath, targetPath string) (string, os.FileInfo, error) {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", nil, err
+	}
+	// The target directory may not exist yet, so the closest existing
+	// ancestor decides the file system. Symlinked ancestors are followed,
+	// e.g. into volumes.
+	dir := filepath.Dir(targetPath)
+	dirInfo, err := os.Stat(dir)
+	for os.IsNotExist(err) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+		dirInfo, err = os.Stat(dir)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if sameFileSystem(info, dirInfo) {
+		return "", info, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), DefaultDirPermission); err != nil {
+		return "", nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(targetPath), filepath.Base(targetPath)+".tmp")
+	if err != nil {
+		return "", nil, err
+	}
+	tmp.Close()
+	if err := copyFile(sourcePath, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, fmt.Errorf("copy across file systems: %s", err)
+	}
+	return tmp.Name(), info, nil
+}
+
+// sameFileSystem returns true if a and b are stored on the same device.
+func sameFileSystem(a, b os.FileInfo) bool {
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	return sa.Dev == sb.Dev
+}
+
+func copyFile(sourcePath, targetPath string) error {
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0775)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
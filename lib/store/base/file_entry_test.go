@@ -27,6 +27,7 @@ import (
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/utils/randutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -361,7 +362,7 @@ func testMove(require *require.Assertions, bundle *fileEntryTestBundle) {
 	require.Equal(mm.content, mmresult.content)
 
 	// Move file, removes non-movable metadata.
-	err = fe.Move(s3)
+	err = fe.Move(s3, "")
 	require.NoError(err)
 	_, err = os.Stat(fp)
 	require.Error(err)
@@ -596,3 +597,121 @@ func testRangeMetadata(require *require.Assertions, bundle *fileEntryTestBundle)
 
 	require.ElementsMatch(ms, result)
 }
+
+// otherFileSystemDir returns a temp dir on a different file system than dir,
+// or skips the test if there is none.
+func otherFileSystemDir(t *testing.T, dir string) string {
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []string{"/dev/shm", "/run", "/var/tmp"} {
+		other, err := ioutil.TempDir(root, "kraken-test")
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { os.RemoveAll(other) })
+		otherInfo, err := os.Stat(other)
+		if err == nil && !sameFileSystem(info, otherInfo) {
+			return other
+		}
+	}
+	t.Skip("no second file system available")
+	return ""
+}
+
+func TestRenameOrCopy(t *testing.T) {
+	targetDir := t.TempDir()
+
+	sourceDirs := map[string]func(t *testing.T) string{
+		"same file system": func(t *testing.T) string {
+			return t.TempDir()
+		},
+		"different file system": func(t *testing.T) string {
+			return otherFileSystemDir(t, targetDir)
+		},
+	}
+	for desc, sourceDir := range sourceDirs {
+		t.Run(desc, func(t *testing.T) {
+			require := require.New(t)
+
+			content := randutil.Text(64)
+			source := filepath.Join(sourceDir(t), "data")
+			target := filepath.Join(targetDir, "data")
+			require.NoError(ioutil.WriteFile(source, content, 0775))
+
+			require.NoError(renameOrCopy(source, target))
+
+			_, err := os.Stat(source)
+			require.True(os.IsNotExist(err))
+			b, err := ioutil.ReadFile(target)
+			require.NoError(err)
+			require.Equal(content, b)
+		})
+	}
+}
+
+func TestStageMove(t *testing.T) {
+	require := require.New(t)
+
+	targetDir := t.TempDir()
+	target := filepath.Join(targetDir, "a", "b", "data")
+	content := randutil.Text(64)
+
+	// Files on the same file system are renamed directly.
+	source := filepath.Join(t.TempDir(), "data")
+	require.NoError(ioutil.WriteFile(source, content, 0775))
+	staged, info, err := stageMove(source, target)
+	require.NoError(err)
+	require.Empty(staged)
+	require.Equal(int64(len(content)), info.Size())
+
+	// Files on other file systems are copied next to the target.
+	source = filepath.Join(otherFileSystemDir(t, targetDir), "data")
+	require.NoError(ioutil.WriteFile(source, content, 0775))
+	staged, _, err = stageMove(source, target)
+	require.NoError(err)
+	require.Equal(filepath.Dir(target), filepath.Dir(staged))
+	b, err := ioutil.ReadFile(staged)
+	require.NoError(err)
+	require.Equal(content, b)
+	_, err = os.Stat(source)
+	require.NoError(err)
+}
+
+func TestMoveFileAcrossFileSystems(t *testing.T) {
+	require := require.New(t)
+
+	s1 := NewFileState(t.TempDir())
+	s2 := NewFileState(otherFileSystemDir(t, s1.GetDirectory()))
+
+	store := NewLocalFileStore(clock.New())
+	op := store.NewFileOp().AcceptState(s1).AcceptState(s2)
+
+	name := "test_file"
+	require.NoError(op.CreateFile(name, s1, 5))
+	mm := getMockMetadataMovable()
+	mm.content = []byte("foo")
+	_, err := op.SetFileMetadata(name, mm)
+	require.NoError(err)
+
+	require.NoError(op.MoveFile(name, s2))
+
+	p, err := op.GetFilePath(name)
+	require.NoError(err)
+	require.Equal(filepath.Join(s2.GetDirectory(), name, DefaultDataFileName), p)
+	info, err := os.Stat(p)
+	require.NoError(err)
+	require.Equal(int64(5), info.Size())
+
+	result := getMockMetadataMovable()
+	require.NoError(op.GetFileMetadata(name, result))
+	require.Equal(mm.content, result.content)
+
+	// Only the data and metadata were left behind in the target dir.
+	files, err := ioutil.ReadDir(filepath.Dir(p))
+	require.NoError(err)
+	for _, f := range files {
+		require.False(strings.Contains(f.Name(), ".tmp"), f.Name())
+	}
+}
@@ -195,7 +195,7 @@ func TestFileMapLoadForWrite(t *testing.T) {
 				if fe.GetState() == s2 {
 					atomic.AddUint32(&stateErrorCount, 1)
 				} else {
-					err = fe.Move(s2)
+					err = fe.Move(s2, "")
 					if err == nil {
 						atomic.AddUint32(&successCount, 1)
 					} else {
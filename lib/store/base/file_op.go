@@ -16,6 +16,7 @@ package base
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/uber/kraken/lib/store/metadata"
@@ -40,6 +41,7 @@ type FileOp interface {
 
 	CreateFile(name string, createState FileState, len int64) error
 	MoveFileFrom(name string, createState FileState, sourcePath string) error
+	ReplaceFileFrom(name string, sourcePath string) error
 	MoveFile(name string, goalState FileState) error
 	LinkFileTo(name string, targetPath string) error
 	DeleteFile(name string) error
@@ -280,13 +282,46 @@ func (op *localFileOp) MoveFileFrom(name string, targetState FileState, sourcePa
 	return op.createFileHelper(name, targetState, sourcePath, -1)
 }
 
+// ReplaceFileFrom atomically replaces the content of an existing file with an
+// unmanaged file, preserving all metadata.
+// If file doesn't exist, returns os.ErrNotExist.
+func (op *localFileOp) ReplaceFileFrom(name string, sourcePath string) (err error) {
+	if loadErr := op.lockHelper(name, _lockLevelWrite, func(name string, entry FileEntry) {
+		err = os.Rename(sourcePath, entry.GetPath())
+	}); loadErr != nil {
+		return loadErr
+	}
+	return err
+}
+
 // MoveFile moves a file to a different directory and updates its state
 // accordingly, and moves all metadata that's `movable`.
+// Moves across file systems copy the data before locking the file for write,
+// such that readers are only blocked for the final rename.
 func (op *localFileOp) MoveFile(name string, targetState FileState) (err error) {
 	if _, err = op.reloadFileEntryHelper(name); err != nil {
 		return err
 	}
 
+	// States are verified once the file is locked for write below.
+	var sourcePath, targetPath string
+	if !op.s.fileMap.LoadForRead(name, func(name string, entry FileEntry) {
+		sourcePath = entry.GetPath()
+		targetPath = filepath.Join(
+			targetState.GetDirectory(), op.s.fileEntryFactory.GetRelativePath(name))
+	}) {
+		return os.ErrNotExist
+	}
+	stagedPath, staged, err := stageMove(sourcePath, targetPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if stagedPath != "" && err != nil {
+			os.Remove(stagedPath)
+		}
+	}()
+
 	// Verify that the file is not in target state, and is currently in one of
 	// the acceptable states.
 	loaded := op.s.fileMap.LoadForWrite(name, func(name string, entry FileEntry) {
@@ -297,8 +332,20 @@ func (op *localFileOp) MoveFile(name string, targetState FileState) (err error)
 		}
 		for state := range op.states {
 			if currState == state {
-				// File is in one of the acceptable states. Perform move.
-				err = entry.Move(targetState)
+				// File is in one of the acceptable states. Perform move, unless
+				// the file was moved or replaced since it was staged.
+				if stagedPath != "" {
+					info, serr := os.Stat(entry.GetPath())
+					if serr != nil {
+						err = serr
+						return
+					}
+					if entry.GetPath() != sourcePath || !os.SameFile(info, staged) {
+						err = fmt.Errorf("file %s changed while staging move", name)
+						return
+					}
+				}
+				err = entry.Move(targetState, stagedPath)
 				return
 			}
 		}
@@ -30,6 +30,7 @@ type CADownloadStore struct {
 	downloadState base.FileState
 	cacheState    base.FileState
 	cleanup       *cleanupManager
+	scrubber      *scrubber
 }
 
 // NewCADownloadStore creates a new CADownloadStore.
@@ -52,26 +53,48 @@ func NewCADownloadStore(config CADownloadStoreConfig, stats tally.Scope) (*CADow
 	if err != nil {
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob(
+	if err := cleanup.addJob(
 		"download",
 		config.DownloadCleanup,
-		backend.NewFileOp().AcceptState(downloadState))
-	cleanup.addJob(
+		backend.NewFileOp().AcceptState(downloadState)); err != nil {
+		return nil, err
+	}
+	if err := cleanup.addJob(
 		"cache",
 		config.CacheCleanup,
+		backend.NewFileOp().AcceptState(cacheState)); err != nil {
+		cleanup.stop()
+		return nil, err
+	}
+
+	scrubber := newScrubber(
+		config.Scrubber.applyDefaults(config.CacheDir),
+		clock.New(),
+		stats,
 		backend.NewFileOp().AcceptState(cacheState))
+	if config.Scrubber.Enabled {
+		scrubber.start()
+	}
 
 	return &CADownloadStore{
 		backend:       backend,
 		downloadState: downloadState,
 		cacheState:    cacheState,
 		cleanup:       cleanup,
+		scrubber:      scrubber,
 	}, nil
 }
 
 // Close terminates all goroutines started by s.
 func (s *CADownloadStore) Close() {
 	s.cleanup.stop()
+	s.scrubber.stop()
+}
+
+// OnCorruptBlob sets the handler notified when the scrubber removes a corrupt
+// cache file.
+func (s *CADownloadStore) OnCorruptBlob(h CorruptBlobHandler) {
+	s.scrubber.setHandler(h)
 }
 
 // CreateDownloadFile creates an empty download file initialized with length.
@@ -170,6 +193,11 @@ func (a *CADownloadStoreScope) DeleteFile(name string) error {
 	return a.op.DeleteFile(name)
 }
 
+// ListNames returns the names of all files within the scope.
+func (a *CADownloadStoreScope) ListNames() ([]string, error) {
+	return a.op.ListNames()
+}
+
 // GetMetadata returns the metadata content of md for name.
 func (a *CADownloadStoreScope) GetMetadata(name string, md metadata.Metadata) error {
 	return a.op.GetFileMetadata(name, md)
@@ -15,17 +15,13 @@ package store
 
 import (
 	"fmt"
-	"hash"
 	"io"
 	"os"
-	"path"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/docker/distribution/uuid"
-	"github.com/spaolacci/murmur3"
 	"github.com/uber-go/tally"
 	"github.com/uber/kraken/core"
-	"github.com/uber/kraken/lib/hrw"
 	"github.com/uber/kraken/lib/store/base"
 )
 
@@ -35,7 +31,10 @@ type CAStore struct {
 
 	*uploadStore
 	*cacheStore
-	cleanup *cleanupManager
+	cleanup  *cleanupManager
+	scrubber *scrubber
+	volumes  *volumeManager
+	tiers    *tierManager // Nil unless a cold tier is configured.
 }
 
 // NewCAStore creates a new CAStore.
@@ -57,7 +56,26 @@ func NewCAStore(config CAStoreConfig, stats tally.Scope) (*CAStore, error) {
 		return nil, fmt.Errorf("new cache store: %s", err)
 	}
 
-	if err := initCASVolumes(config.CacheDir, config.Volumes); err != nil {
+	var tiers *tierManager
+	if config.ColdCacheDir != "" {
+		if err := cacheStore.addColdTier(config.ColdCacheDir); err != nil {
+			return nil, fmt.Errorf("add cold tier: %s", err)
+		}
+		tiers, err = newTierManager(
+			config.Tiering, clock.New(), stats, cacheBackend, cacheStore.state, *cacheStore.cold)
+		if err != nil {
+			return nil, fmt.Errorf("new tier manager: %s", err)
+		}
+	}
+
+	volumes := newVolumeManager(
+		config.VolumeHealth,
+		clock.New(),
+		stats,
+		config.CacheDir,
+		config.Volumes,
+		cacheStore.newFileOp())
+	if err := volumes.init(); err != nil {
 		return nil, fmt.Errorf("init cas volumes: %s", err)
 	}
 
@@ -65,15 +83,57 @@ func NewCAStore(config CAStoreConfig, stats tally.Scope) (*CAStore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp())
-	cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp())
+	if err := cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp()); err != nil {
+		return nil, err
+	}
+	if err := cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp()); err != nil {
+		cleanup.stop()
+		return nil, err
+	}
+
+	scrubber := newScrubber(
+		config.Scrubber.applyDefaults(config.CacheDir), clock.New(), stats, cacheStore.newFileOp())
+	if config.Scrubber.Enabled {
+		scrubber.start()
+	}
+	volumes.start()
+	if tiers != nil {
+		tiers.start()
+	}
 
-	return &CAStore{config, uploadStore, cacheStore, cleanup}, nil
+	return &CAStore{config, uploadStore, cacheStore, cleanup, scrubber, volumes, tiers}, nil
 }
 
 // Close terminates any goroutines started by s.
 func (s *CAStore) Close() {
 	s.cleanup.stop()
+	s.scrubber.stop()
+	s.volumes.stop()
+	if s.tiers != nil {
+		s.tiers.stop()
+	}
+}
+
+// GetCacheFileReader returns a reader for the cache file name, which may be
+// in either tier.
+func (s *CAStore) GetCacheFileReader(name string) (FileReader, error) {
+	r, err := s.cacheStore.GetCacheFileReader(name)
+	if err == nil && s.tiers != nil {
+		s.tiers.recordAccess(name)
+	}
+	return r, err
+}
+
+// OnCorruptBlob sets the handler notified when the scrubber removes a corrupt
+// cache file.
+func (s *CAStore) OnCorruptBlob(h CorruptBlobHandler) {
+	s.scrubber.setHandler(h)
+}
+
+// OnCorruptPersistedBlob sets the fetcher used by the scrubber to replace
+// corrupt cache files which have not been written back yet.
+func (s *CAStore) OnCorruptPersistedBlob(f CorruptBlobFetcher) {
+	s.scrubber.setFetcher(f)
 }
 
 // MoveUploadFileToCache commits uploadName as cacheName. Clients are expected
@@ -151,39 +211,3 @@ func (s *CAStore) verify(r io.Reader, name string) error {
 	}
 	return nil
 }
-
-func initCASVolumes(dir string, volumes []Volume) error {
-	if len(volumes) == 0 {
-		return nil
-	}
-
-	rendezvousHash := hrw.NewRendezvousHash(
-		func() hash.Hash { return murmur3.New64() },
-		hrw.UInt64ToFloat64)
-
-	for _, v := range volumes {
-		if _, err := os.Stat(v.Location); err != nil {
-			return fmt.Errorf("verify volume: %s", err)
-		}
-		rendezvousHash.AddNode(v.Location, v.Weight)
-	}
-
-	// Create 256 symlinks under dir.
-	for subdirIndex := 0; subdirIndex < 256; subdirIndex++ {
-		subdirName := fmt.Sprintf("%02X", subdirIndex)
-		nodes := rendezvousHash.GetOrderedNodes(subdirName, 1)
-		if len(nodes) != 1 {
-			return fmt.Errorf("calculate volume for subdir: %s", subdirName)
-		}
-		sourcePath := path.Join(nodes[0].Label, path.Base(dir), subdirName)
-		if err := os.MkdirAll(sourcePath, 0775); err != nil {
-			return fmt.Errorf("volume source path: %s", err)
-		}
-		targetPath := path.Join(dir, subdirName)
-		if err := createOrUpdateSymlink(sourcePath, targetPath); err != nil {
-			return fmt.Errorf("symlink to volume: %s", err)
-		}
-	}
-
-	return nil
-}
@@ -26,6 +26,10 @@ import (
 type cacheStore struct {
 	state   base.FileState
 	backend base.FileStore
+
+	// cold is an optional second tier which files can be moved to. Files are
+	// always added to state, but read from either tier.
+	cold *base.FileState
 }
 
 func newCacheStore(dir string, backend base.FileStore) (*cacheStore, error) {
@@ -33,7 +37,17 @@ func newCacheStore(dir string, backend base.FileStore) (*cacheStore, error) {
 		return nil, fmt.Errorf("mkdir: %s", err)
 	}
 	state := base.NewFileState(dir)
-	return &cacheStore{state, backend}, nil
+	return &cacheStore{state: state, backend: backend}, nil
+}
+
+// addColdTier enables a second tier of cache files stored under dir.
+func (s *cacheStore) addColdTier(dir string) error {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return fmt.Errorf("mkdir: %s", err)
+	}
+	cold := base.NewFileState(dir)
+	s.cold = &cold
+	return nil
 }
 
 func (s *cacheStore) GetCacheFileReader(name string) (FileReader, error) {
@@ -69,5 +83,9 @@ func (s *cacheStore) ListCacheFiles() ([]string, error) {
 }
 
 func (s *cacheStore) newFileOp() base.FileOp {
-	return s.backend.NewFileOp().AcceptState(s.state)
+	op := s.backend.NewFileOp().AcceptState(s.state)
+	if s.cold != nil {
+		op = op.AcceptState(*s.cold)
+	}
+	return op
 }
@@ -14,9 +14,13 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/uber/kraken/lib/store/base"
@@ -24,6 +28,7 @@ import (
 	"github.com/uber/kraken/utils/log"
 
 	"github.com/andres-erbsen/clock"
+	"github.com/c2h5oh/datasize"
 	"github.com/uber-go/tally"
 )
 
@@ -33,6 +38,19 @@ type CleanupConfig struct {
 	Interval time.Duration `yaml:"interval"` // How often cleanup runs.
 	TTI      time.Duration `yaml:"tti"`      // Time to idle based on last access time.
 	TTL      time.Duration `yaml:"ttl"`      // Time to live regardless of access. If 0, disables TTL.
+
+	// HighWatermark enables capacity-based eviction: once the files stored on
+	// a volume exceed HighWatermark bytes, the least recently accessed files
+	// on that volume are deleted until usage drops to LowWatermark. Files
+	// pending write-back are never evicted. If 0, disables eviction.
+	HighWatermark datasize.ByteSize `yaml:"high_watermark"`
+	LowWatermark  datasize.ByteSize `yaml:"low_watermark"` // Defaults to 90% of HighWatermark.
+
+	// EvictionInterval is how often volume usage is checked against
+	// HighWatermark. Usually much shorter than Interval, since bursts can fill
+	// disks well before files become idle. Files are only listed if statfs
+	// shows a volume used beyond HighWatermark, or once per Interval.
+	EvictionInterval time.Duration `yaml:"eviction_interval"`
 }
 
 func (c CleanupConfig) applyDefaults() CleanupConfig {
@@ -42,9 +60,22 @@ func (c CleanupConfig) applyDefaults() CleanupConfig {
 	if c.TTI == 0 {
 		c.TTI = 6 * time.Hour
 	}
+	if c.HighWatermark > 0 && c.LowWatermark == 0 {
+		c.LowWatermark = c.HighWatermark * 9 / 10
+	}
+	if c.EvictionInterval == 0 {
+		c.EvictionInterval = time.Minute
+	}
 	return c
 }
 
+func (c CleanupConfig) validate() error {
+	if c.LowWatermark > c.HighWatermark {
+		return errors.New("low_watermark must not exceed high_watermark")
+	}
+	return nil
+}
+
 type cleanupManager struct {
 	clk      clock.Clock
 	stats    tally.Scope
@@ -71,19 +102,35 @@ func newCleanupManager(clk clock.Clock, stats tally.Scope) (*cleanupManager, err
 // addJob starts a background cleanup task which removes idle files from op based
 // on the settings in config. op must set the desired states to clean before addJob
 // is called.
-func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp) {
+func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp) error {
 	config = config.applyDefaults()
 	if config.Disabled {
 		log.Warnf("Cleanup disabled for %s", op)
-		return
+		return nil
 	}
 	if config.TTL == 0 {
 		log.Warnf("TTL disabled for %s", op)
 	}
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("invalid %s cleanup config: %s", tag, err)
+	}
 
 	ticker := m.clk.Ticker(config.Interval)
 
-	usageGauge := m.stats.Tagged(map[string]string{"job": tag}).Gauge("disk_usage")
+	// Receiving from a nil channel blocks forever, i.e. eviction never runs.
+	var evictTicker *clock.Ticker
+	var evictc <-chan time.Time
+	if config.HighWatermark > 0 {
+		evictTicker = m.clk.Ticker(config.EvictionInterval)
+		evictc = evictTicker.C
+	}
+
+	stats := m.stats.Tagged(map[string]string{"job": tag})
+	usageGauge := stats.Gauge("disk_usage")
+
+	// Volumes seen by the last full eviction pass, mapped to a path on each.
+	var volumes map[uint64]string
+	var lastEvict time.Time
 
 	go func() {
 		for {
@@ -95,12 +142,31 @@ func (m *cleanupManager) addJob(tag string, config CleanupConfig, op base.FileOp
 					log.Errorf("Error scanning %s: %s", op, err)
 				}
 				usageGauge.Update(float64(usage))
+			case <-evictc:
+				high := config.HighWatermark.Bytes()
+				if volumes != nil &&
+					m.clk.Now().Sub(lastEvict) < config.Interval &&
+					!exceedsWatermark(volumes, high) {
+					stats.Counter("eviction_skipped").Inc(1)
+					break
+				}
+				v, err := m.evict(op, high, config.LowWatermark.Bytes(), stats)
+				if err != nil {
+					log.Errorf("Error evicting from %s: %s", op, err)
+					break
+				}
+				volumes = v
+				lastEvict = m.clk.Now()
 			case <-m.stopc:
 				ticker.Stop()
+				if evictTicker != nil {
+					evictTicker.Stop()
+				}
 				return
 			}
 		}
 	}()
+	return nil
 }
 
 func (m *cleanupManager) stop() {
@@ -153,3 +219,111 @@ func (m *cleanupManager) readyForDeletion(
 	}
 	return m.clk.Now().Sub(lat.Time) > tti, nil
 }
+
+type evictionCandidate struct {
+	name           string
+	size           int64
+	lastAccessTime time.Time
+}
+
+// evict deletes the least recently accessed files of each volume whose usage
+// exceeds high bytes, until its usage drops to low bytes. Files are grouped
+// into volumes by the device they are stored on. Persisted files count toward
+// usage but are never evicted. Returns a directory on each volume, keyed by
+// device.
+func (m *cleanupManager) evict(
+	op base.FileOp, high, low uint64, stats tally.Scope) (map[uint64]string, error) {
+
+	names, err := op.ListNames()
+	if err != nil {
+		return nil, fmt.Errorf("list names: %s", err)
+	}
+	volumes := make(map[uint64]string)
+	usage := make(map[uint64]uint64)
+	candidates := make(map[uint64][]evictionCandidate)
+	for _, name := range names {
+		info, err := op.GetFileStat(name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error getting file stat: %s", err)
+			}
+			continue
+		}
+		dev := deviceOf(info)
+		usage[dev] += uint64(info.Size())
+		if _, ok := volumes[dev]; !ok {
+			if p, err := op.GetFilePath(name); err == nil {
+				volumes[dev] = filepath.Dir(p)
+			}
+		}
+
+		var persist metadata.Persist
+		if err := op.GetFileMetadata(name, &persist); err == nil && persist.Value {
+			continue
+		}
+		c := evictionCandidate{name, info.Size(), info.ModTime()}
+		var lat metadata.LastAccessTime
+		if err := op.GetFileMetadata(name, &lat); err == nil {
+			c.lastAccessTime = lat.Time
+		}
+		candidates[dev] = append(candidates[dev], c)
+	}
+
+	for dev, u := range usage {
+		if u <= high {
+			continue
+		}
+		stats.Counter("high_watermark_exceeded").Inc(1)
+
+		cs := candidates[dev]
+		sort.Slice(cs, func(i, j int) bool {
+			return cs[i].lastAccessTime.Before(cs[j].lastAccessTime)
+		})
+		for _, c := range cs {
+			if u <= low {
+				break
+			}
+			if err := op.DeleteFile(c.name); err != nil {
+				if err != base.ErrFilePersisted && !os.IsNotExist(err) {
+					log.With("name", c.name).Errorf("Error evicting file: %s", err)
+				}
+				continue
+			}
+			u -= uint64(c.size)
+			stats.Counter("evictions").Inc(1)
+			stats.Counter("evicted_bytes").Inc(c.size)
+		}
+		if u > low {
+			stats.Counter("eviction_shortfall").Inc(1)
+			log.Warnf(
+				"Cannot evict %s below low watermark: %s in use, rest is pending write-back",
+				op, datasize.ByteSize(u).HR())
+		}
+	}
+	return volumes, nil
+}
+
+// exceedsWatermark returns true unless statfs shows every volume using at
+// most high bytes in total. Since the files of a store are a subset of the
+// data on their volumes, files need not be listed while volumes are below
+// the watermark.
+func exceedsWatermark(volumes map[uint64]string, high uint64) bool {
+	for _, dir := range volumes {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return true
+		}
+		if (st.Blocks-st.Bfree)*uint64(st.Bsize) > high {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceOf returns the id of the device info's file is stored on.
+func deviceOf(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev)
+	}
+	return 0
+}
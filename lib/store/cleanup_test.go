@@ -4,7 +4,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//     http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -15,7 +15,9 @@ package store
 
 import (
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/andres-erbsen/clock"
+	"github.com/c2h5oh/datasize"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
 )
@@ -62,7 +65,7 @@ func TestCleanupManagerAddJob(t *testing.T) {
 		Interval: time.Second,
 		TTI:      time.Second,
 	}
-	m.addJob("test_cleanup", config, op)
+	require.NoError(m.addJob("test_cleanup", config, op))
 
 	name := "test_file"
 
@@ -228,3 +231,171 @@ func TestCleanupManageDiskUsage(t *testing.T) {
 	require.NoError(err)
 	require.Equal(int64(500), usage)
 }
+
+func TestCleanupManagerEvictLeastRecentlyAccessedFiles(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	var names []string
+	for i := 0; i < 10; i++ {
+		name := core.DigestFixture().Hex()
+		require.NoError(op.CreateFile(name, state, 10))
+		names = append(names, name)
+		clk.Add(time.Minute)
+	}
+
+	// Below the high watermark, nothing is evicted.
+	_, err = m.evict(op, 100, 30, tally.NoopScope)
+	require.NoError(err)
+	for _, name := range names {
+		_, err := op.GetFileStat(name)
+		require.NoError(err)
+	}
+
+	_, err = m.evict(op, 50, 30, tally.NoopScope)
+	require.NoError(err)
+	for _, name := range names[:7] {
+		_, err := op.GetFileStat(name)
+		require.True(os.IsNotExist(err))
+	}
+	for _, name := range names[7:] {
+		_, err := op.GetFileStat(name)
+		require.NoError(err)
+	}
+}
+
+func TestCleanupManagerEvictSkipsPersistedFiles(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	var names []string
+	for i := 0; i < 10; i++ {
+		name := core.DigestFixture().Hex()
+		require.NoError(op.CreateFile(name, state, 10))
+		names = append(names, name)
+		clk.Add(time.Minute)
+	}
+
+	// The least recently accessed files are pending write-back.
+	persisted := names[:5]
+	for _, name := range persisted {
+		_, err := op.SetFileMetadata(name, metadata.NewPersist(true))
+		require.NoError(err)
+	}
+
+	_, err = m.evict(op, 50, 30, tally.NoopScope)
+	require.NoError(err)
+	for _, name := range persisted {
+		_, err := op.GetFileStat(name)
+		require.NoError(err)
+	}
+	for _, name := range names[5:] {
+		_, err := op.GetFileStat(name)
+		require.True(os.IsNotExist(err))
+	}
+}
+
+func TestCleanupManagerAddJobEvictsAboveHighWatermark(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.New()
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	config := CleanupConfig{
+		Interval:         time.Hour,
+		HighWatermark:    50,
+		EvictionInterval: 100 * time.Millisecond,
+	}
+	require.NoError(m.addJob("test_eviction", config, op))
+
+	for i := 0; i < 10; i++ {
+		require.NoError(op.CreateFile(core.DigestFixture().Hex(), state, 10))
+	}
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		usage, err := m.scan(op, time.Hour, 0)
+		return err == nil && usage <= 45
+	}))
+}
+
+func TestCleanupManagerAddJobRejectsInvalidWatermarks(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.New()
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	_, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	config := CleanupConfig{
+		HighWatermark: datasize.GB,
+		LowWatermark:  2 * datasize.GB,
+	}
+	require.Error(m.addJob("test_eviction", config, op))
+}
+
+func TestCleanupManagerEvictReturnsVolumes(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.New()
+
+	m, err := newCleanupManager(clk, tally.NoopScope)
+	require.NoError(err)
+	defer m.stop()
+
+	state, op, cleanup := fileOpFixture(clk)
+	defer cleanup()
+
+	name := core.DigestFixture().Hex()
+	require.NoError(op.CreateFile(name, state, 10))
+	p, err := op.GetFilePath(name)
+	require.NoError(err)
+
+	volumes, err := m.evict(op, 100, 30, tally.NoopScope)
+	require.NoError(err)
+	require.Len(volumes, 1)
+	for _, dir := range volumes {
+		require.Equal(filepath.Dir(p), dir)
+	}
+
+	require.True(exceedsWatermark(volumes, 0))
+	require.False(exceedsWatermark(volumes, math.MaxUint64))
+	require.True(exceedsWatermark(map[uint64]string{0: "/nonexistent"}, math.MaxUint64))
+}
+
+func TestCleanupConfigLowWatermarkDefault(t *testing.T) {
+	require := require.New(t)
+
+	config := CleanupConfig{HighWatermark: 100 * datasize.GB}.applyDefaults()
+	require.Equal(90*datasize.GB, config.LowWatermark)
+
+	config = CleanupConfig{HighWatermark: datasize.GB, LowWatermark: 2 * datasize.GB}
+	require.Error(config.applyDefaults().validate())
+}
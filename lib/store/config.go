@@ -23,12 +23,19 @@ type Volume struct {
 
 // CAStoreConfig defines CAStore configuration.
 type CAStoreConfig struct {
-	UploadDir     string        `yaml:"upload_dir"`
-	CacheDir      string        `yaml:"cache_dir"`
-	Volumes       []Volume      `yaml:"volumes"`
-	Capacity      int           `yaml:"capacity"`
-	UploadCleanup CleanupConfig `yaml:"upload_cleanup"`
-	CacheCleanup  CleanupConfig `yaml:"cache_cleanup"`
+	UploadDir     string             `yaml:"upload_dir"`
+	CacheDir      string             `yaml:"cache_dir"`
+	Volumes       []Volume           `yaml:"volumes"`
+	VolumeHealth  VolumeHealthConfig `yaml:"volume_health"`
+	Capacity      int                `yaml:"capacity"`
+	UploadCleanup CleanupConfig      `yaml:"upload_cleanup"`
+	CacheCleanup  CleanupConfig      `yaml:"cache_cleanup"`
+	Scrubber      ScrubberConfig     `yaml:"scrubber"`
+
+	// ColdCacheDir enables a second, slower cache tier. New files are cached
+	// in CacheDir and moved to ColdCacheDir once they are rarely accessed.
+	ColdCacheDir string        `yaml:"cold_cache_dir"`
+	Tiering      TieringConfig `yaml:"tiering"`
 
 	SkipHashVerification bool `yaml:"skip_hash_verification"`
 }
@@ -51,8 +58,9 @@ type SimpleStoreConfig struct {
 // CADownloadStoreConfig defines CADownloadStore configuration.
 // TODO(evelynl94): rename
 type CADownloadStoreConfig struct {
-	DownloadDir     string        `yaml:"download_dir"`
-	CacheDir        string        `yaml:"cache_dir"`
-	DownloadCleanup CleanupConfig `yaml:"download_cleanup"`
-	CacheCleanup    CleanupConfig `yaml:"cache_cleanup"`
+	DownloadDir     string         `yaml:"download_dir"`
+	CacheDir        string         `yaml:"cache_dir"`
+	DownloadCleanup CleanupConfig  `yaml:"download_cleanup"`
+	CacheCleanup    CleanupConfig  `yaml:"cache_cleanup"`
+	Scrubber        ScrubberConfig `yaml:"scrubber"`
 }
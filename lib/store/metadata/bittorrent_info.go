@@ -0,0 +1,60 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metadata
+
+import "regexp"
+
+const _bitTorrentInfoSuffix = "_btinfo"
+
+func init() {
+	Register(regexp.MustCompile(_bitTorrentInfoSuffix), &bitTorrentInfoFactory{})
+}
+
+type bitTorrentInfoFactory struct{}
+
+func (f bitTorrentInfoFactory) Create(suffix string) Metadata {
+	return &BitTorrentInfo{}
+}
+
+// BitTorrentInfo stores the bencoded info dictionary of the BitTorrent v1
+// torrent generated for a blob, such that piece hashes are only computed once.
+type BitTorrentInfo struct {
+	Info []byte
+}
+
+// NewBitTorrentInfo creates a new BitTorrentInfo.
+func NewBitTorrentInfo(info []byte) *BitTorrentInfo {
+	return &BitTorrentInfo{info}
+}
+
+// GetSuffix returns a static suffix.
+func (m *BitTorrentInfo) GetSuffix() string {
+	return _bitTorrentInfoSuffix
+}
+
+// Movable is true.
+func (m *BitTorrentInfo) Movable() bool {
+	return true
+}
+
+// Serialize converts m to bytes.
+func (m *BitTorrentInfo) Serialize() ([]byte, error) {
+	return m.Info, nil
+}
+
+// Deserialize loads b into m.
+func (m *BitTorrentInfo) Deserialize(b []byte) error {
+	m.Info = b
+	return nil
+}
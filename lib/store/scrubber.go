@@ -0,0 +1,403 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"golang.org/x/time/rate"
+)
+
+// ScrubberConfig defines configuration for periodically re-hashing cache files
+// to detect on-disk corruption.
+type ScrubberConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`      // Pause between passes.
+	BytesPerSec uint64        `yaml:"bytes_per_sec"` // Max disk read rate.
+
+	// QuarantineDir is where corrupt files are kept for inspection. Defaults
+	// to a "quarantine" directory next to the cache directory.
+	QuarantineDir string `yaml:"quarantine_dir"`
+
+	// Quarantined files are removed once older than QuarantineTTL, and oldest
+	// first while QuarantineDir exceeds QuarantineMaxBytes.
+	QuarantineTTL      time.Duration `yaml:"quarantine_ttl"`
+	QuarantineMaxBytes uint64        `yaml:"quarantine_max_bytes"`
+}
+
+func (c ScrubberConfig) applyDefaults(cacheDir string) ScrubberConfig {
+	if c.Interval == 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.BytesPerSec == 0 {
+		c.BytesPerSec = 20 * memsize.MB
+	}
+	if c.QuarantineDir == "" {
+		c.QuarantineDir = filepath.Join(filepath.Dir(filepath.Clean(cacheDir)), "quarantine")
+	}
+	if c.QuarantineTTL == 0 {
+		c.QuarantineTTL = 7 * 24 * time.Hour
+	}
+	if c.QuarantineMaxBytes == 0 {
+		c.QuarantineMaxBytes = 10 * memsize.GB
+	}
+	return c
+}
+
+// CorruptBlobHandler is notified after a corrupt blob has been removed from
+// the cache. namespace is empty if the blob's origin namespace is unknown.
+type CorruptBlobHandler func(d core.Digest, namespace string)
+
+// CorruptBlobFetcher writes a replacement for a corrupt blob to dst. It is
+// used for blobs still awaiting write-back, which cannot be re-downloaded from
+// the storage backend.
+type CorruptBlobFetcher func(d core.Digest, namespace string, dst io.Writer) error
+
+// scrubber periodically verifies that cache files still hash to their names.
+// Corrupt files are moved aside into the quarantine directory and deleted
+// from the store.
+type scrubber struct {
+	config  ScrubberConfig
+	clk     clock.Clock
+	stats   tally.Scope
+	op      base.FileOp
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	onCorrupt CorruptBlobHandler
+	fetcher   CorruptBlobFetcher
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+}
+
+func newScrubber(
+	config ScrubberConfig,
+	clk clock.Clock,
+	stats tally.Scope,
+	op base.FileOp) *scrubber {
+
+	return &scrubber{
+		config: config,
+		clk:    clk,
+		stats: stats.Tagged(map[string]string{
+			"module": "storescrubber",
+		}),
+		op:      op,
+		limiter: rate.NewLimiter(rate.Limit(config.BytesPerSec), int(config.BytesPerSec)),
+		stopc:   make(chan struct{}),
+	}
+}
+
+// setHandler sets the handler notified of corrupt blobs.
+func (s *scrubber) setHandler(h CorruptBlobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCorrupt = h
+}
+
+func (s *scrubber) handler() CorruptBlobHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.onCorrupt
+}
+
+// setFetcher sets the fetcher used to replace corrupt persisted blobs.
+func (s *scrubber) setFetcher(f CorruptBlobFetcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetcher = f
+}
+
+func (s *scrubber) getFetcher() CorruptBlobFetcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetcher
+}
+
+// start runs scrub passes in the background until stop is called.
+func (s *scrubber) start() {
+	go func() {
+		for {
+			select {
+			case <-s.clk.After(s.config.Interval):
+				log.Debugf("Scrubbing %s", s.op)
+				if err := s.scrub(); err != nil {
+					log.Errorf("Error scrubbing %s: %s", s.op, err)
+				}
+			case <-s.stopc:
+				return
+			}
+		}
+	}()
+}
+
+func (s *scrubber) stop() {
+	s.stopOnce.Do(func() { close(s.stopc) })
+}
+
+// scrub re-hashes every file in op once.
+func (s *scrubber) scrub() error {
+	names, err := s.op.ListNames()
+	if err != nil {
+		return fmt.Errorf("list names: %s", err)
+	}
+	var corrupt int
+	for _, name := range names {
+		select {
+		case <-s.stopc:
+			return nil
+		default:
+		}
+		ok, err := s.verify(name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error scrubbing file: %s", err)
+			}
+			continue
+		}
+		if !ok {
+			corrupt++
+		}
+	}
+	s.stats.Gauge("corrupt_last_pass").Update(float64(corrupt))
+	return nil
+}
+
+// verify hashes name and quarantines it on mismatch. Returns false if name
+// was corrupt.
+func (s *scrubber) verify(name string) (bool, error) {
+	expected, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		// Not a content-addressable file, nothing to verify against.
+		return true, nil
+	}
+	f, err := s.open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := &throttledReader{r: f, limiter: s.limiter}
+	computed, err := core.NewDigester().FromReader(r)
+	if err != nil {
+		return false, fmt.Errorf("hash: %s", err)
+	}
+	s.stats.Counter("scrubbed").Inc(1)
+	s.stats.Counter("scrubbed_bytes").Inc(r.n)
+
+	if computed == expected {
+		return true, nil
+	}
+	s.stats.Counter("corrupt").Inc(1)
+	log.With("name", name, "computed", computed.Hex()).Error("Corrupt cache file detected")
+
+	if err := s.quarantine(expected); err != nil {
+		s.stats.Counter("quarantine_failures").Inc(1)
+		return false, fmt.Errorf("quarantine: %s", err)
+	}
+	return false, nil
+}
+
+// quarantine preserves a copy of the corrupt file for d outside of the store,
+// removes it from the store and notifies the handler. Files still awaiting
+// write-back are replaced in place by the fetcher instead, since the storage
+// backend has no copy to recover them from.
+func (s *scrubber) quarantine(d core.Digest) error {
+	name := d.Hex()
+
+	var ns metadata.Namespace
+	if err := s.op.GetFileMetadata(name, &ns); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("get namespace metadata: %s", err)
+	}
+	var pm metadata.Persist
+	if err := s.op.GetFileMetadata(name, &pm); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("get persist metadata: %s", err)
+	}
+
+	if err := os.MkdirAll(s.config.QuarantineDir, 0775); err != nil {
+		return fmt.Errorf("mkdir: %s", err)
+	}
+	target := filepath.Join(s.config.QuarantineDir, name)
+	if err := os.RemoveAll(target); err != nil {
+		return fmt.Errorf("remove previous quarantined file: %s", err)
+	}
+	if err := s.op.LinkFileTo(name, target); err != nil {
+		// Links fail across devices, e.g. when the cache is spread over volumes.
+		if err := s.copyTo(name, target); err != nil {
+			return fmt.Errorf("copy to quarantine: %s", err)
+		}
+	}
+	// Age is measured from the time of quarantine, not of the original write.
+	now := s.clk.Now()
+	if err := os.Chtimes(target, now, now); err != nil {
+		return fmt.Errorf("touch quarantined file: %s", err)
+	}
+	s.stats.Counter("quarantined").Inc(1)
+	if err := s.pruneQuarantine(); err != nil {
+		log.Errorf("Error pruning quarantine: %s", err)
+	}
+
+	if pm.Value {
+		return s.replace(d, ns.Value)
+	}
+
+	if err := s.op.DeleteFile(name); err != nil {
+		return fmt.Errorf("delete file: %s", err)
+	}
+	if h := s.handler(); h != nil {
+		h(d, ns.Value)
+	}
+	return nil
+}
+
+// replace overwrites the corrupt file for d with a verified copy from the
+// fetcher, keeping its metadata such that the pending write-back uploads the
+// replacement. If no replacement is available, the file is left in place and
+// retried on the next pass.
+func (s *scrubber) replace(d core.Digest, namespace string) error {
+	logger := log.With("name", d.Hex(), "namespace", namespace)
+
+	f := s.getFetcher()
+	if f == nil {
+		s.stats.Counter("replace_deferred").Inc(1)
+		logger.Warn("Cannot replace corrupt persisted file: no fetcher")
+		return nil
+	}
+	tmp, err := ioutil.TempFile(s.config.QuarantineDir, d.Hex()+".replacement.")
+	if err != nil {
+		return fmt.Errorf("create temp file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := f(d, namespace, tmp); err != nil {
+		s.stats.Counter("replace_deferred").Inc(1)
+		logger.Errorf("Error fetching replacement for corrupt persisted file: %s", err)
+		return nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek temp file: %s", err)
+	}
+	computed, err := core.NewDigester().FromReader(tmp)
+	if err != nil {
+		return fmt.Errorf("hash replacement: %s", err)
+	}
+	if computed != d {
+		s.stats.Counter("replace_deferred").Inc(1)
+		logger.With("computed", computed.Hex()).Error("Replacement for corrupt file is corrupt")
+		return nil
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %s", err)
+	}
+	if err := s.op.ReplaceFileFrom(d.Hex(), tmp.Name()); err != nil {
+		return fmt.Errorf("replace file: %s", err)
+	}
+	s.stats.Counter("replaced").Inc(1)
+	logger.Info("Replaced corrupt persisted file")
+	return nil
+}
+
+// pruneQuarantine removes quarantined files older than the configured ttl,
+// then the oldest files until the quarantine fits in the configured size.
+func (s *scrubber) pruneQuarantine() error {
+	infos, err := ioutil.ReadDir(s.config.QuarantineDir)
+	if err != nil {
+		return fmt.Errorf("read dir: %s", err)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	var total uint64
+	for _, info := range infos {
+		total += uint64(info.Size())
+	}
+	now := s.clk.Now()
+	for _, info := range infos {
+		if total <= s.config.QuarantineMaxBytes &&
+			now.Sub(info.ModTime()) <= s.config.QuarantineTTL {
+			// Files are sorted oldest first, so the rest are kept as well.
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(s.config.QuarantineDir, info.Name())); err != nil {
+			return fmt.Errorf("remove %s: %s", info.Name(), err)
+		}
+		total -= uint64(info.Size())
+		s.stats.Counter("quarantine_pruned").Inc(1)
+	}
+	return nil
+}
+
+func (s *scrubber) copyTo(name, target string) error {
+	r, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// open opens name by path, such that scrubbing does not refresh the last
+// access time and keep idle files from being cleaned up.
+func (s *scrubber) open(name string) (*os.File, error) {
+	p, err := s.op.GetFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// throttledReader limits the rate at which the underlying reader is consumed.
+type throttledReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+	n       int64
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		if werr := r.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func scrubbedCAStoreFixture() (*CAStore, string, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	config, c := CAStoreConfigFixture()
+	cleanup.Add(c)
+
+	quarantine := tempdir(&cleanup, "quarantine")
+	config.Scrubber.QuarantineDir = quarantine
+
+	s, err := NewCAStore(config, tally.NoopScope)
+	if err != nil {
+		panic(err)
+	}
+	cleanup.Add(s.Close)
+
+	return s, quarantine, cleanup.Run
+}
+
+// corrupt flips the first byte of the cache file for d.
+func corrupt(t *testing.T, path string) {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, b, 0775))
+}
+
+func TestScrubberKeepsValidFiles(t *testing.T) {
+	require := require.New(t)
+
+	cas, quarantine, cleanup := scrubbedCAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	var called bool
+	cas.OnCorruptBlob(func(core.Digest, string) { called = true })
+
+	require.NoError(cas.scrubber.scrub())
+
+	_, err := cas.GetCacheFileStat(blob.Digest.Hex())
+	require.NoError(err)
+	_, err = os.Stat(filepath.Join(quarantine, blob.Digest.Hex()))
+	require.True(os.IsNotExist(err))
+	require.False(called)
+}
+
+func TestScrubberQuarantinesCorruptFiles(t *testing.T) {
+	require := require.New(t)
+
+	cas, quarantine, cleanup := scrubbedCAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	name := blob.Digest.Hex()
+	require.NoError(cas.CreateCacheFile(name, bytes.NewReader(blob.Content)))
+	_, err := cas.SetCacheFileMetadata(name, metadata.NewNamespace("some-namespace"))
+	require.NoError(err)
+
+	p, err := cas.cacheStore.newFileOp().GetFilePath(name)
+	require.NoError(err)
+	corrupt(t, p)
+
+	var corrupted []core.Digest
+	var namespaces []string
+	cas.OnCorruptBlob(func(d core.Digest, namespace string) {
+		corrupted = append(corrupted, d)
+		namespaces = append(namespaces, namespace)
+	})
+
+	require.NoError(cas.scrubber.scrub())
+
+	_, err = cas.GetCacheFileStat(name)
+	require.True(os.IsNotExist(err))
+
+	b, err := ioutil.ReadFile(filepath.Join(quarantine, name))
+	require.NoError(err)
+	require.Len(b, len(blob.Content))
+	require.NotEqual(blob.Content, b)
+
+	require.Equal([]core.Digest{blob.Digest}, corrupted)
+	require.Equal([]string{"some-namespace"}, namespaces)
+}
+
+func TestScrubberReplacesCorruptPersistedFiles(t *testing.T) {
+	require := require.New(t)
+
+	cas, quarantine, cleanup := scrubbedCAStoreFixture()
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	name := blob.Digest.Hex()
+	require.NoError(cas.CreateCacheFile(name, bytes.NewReader(blob.Content)))
+	_, err := cas.SetCacheFileMetadata(name, metadata.NewPersist(true))
+	require.NoError(err)
+	_, err = cas.SetCacheFileMetadata(name, metadata.NewNamespace("some-namespace"))
+	require.NoError(err)
+
+	p, err := cas.cacheStore.newFileOp().GetFilePath(name)
+	require.NoError(err)
+	corrupt(t, p)
+
+	var called bool
+	cas.OnCorruptBlob(func(core.Digest, string) { called = true })
+	cas.OnCorruptPersistedBlob(func(d core.Digest, namespace string, dst io.Writer) error {
+		require.Equal(blob.Digest, d)
+		require.Equal("some-namespace", namespace)
+		_, err := dst.Write(blob.Content)
+		return err
+	})
+
+	require.NoError(cas.scrubber.scrub())
+
+	b, err := ioutil.ReadFile(p)
+	require.NoError(err)
+	require.Equal(blob.Content, b)
+
+	var pm metadata.Persist
+	require.NoError(cas.GetCacheFileMetadata(name, &pm))
+	require.True(pm.Value)
+	require.False(called)
+
+	_, err = os.Stat(filepath.Join(quarantine, name))
+	require.NoError(err)
+}
+
+func TestScrubberKeepsCorruptPersistedFilesWithoutReplacement(t *testing.T) {
+	tests := []struct {
+		desc  string
+		fetch CorruptBlobFetcher
+	}{
+		{"no fetcher", nil},
+		{"fetch error", func(core.Digest, string, io.Writer) error {
+			return errors.New("some error")
+		}},
+		{"corrupt replacement", func(_ core.Digest, _ string, dst io.Writer) error {
+			_, err := dst.Write([]byte("corrupt"))
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			cas, _, cleanup := scrubbedCAStoreFixture()
+			defer cleanup()
+
+			blob := core.NewBlobFixture()
+			name := blob.Digest.Hex()
+			require.NoError(cas.CreateCacheFile(name, bytes.NewReader(blob.Content)))
+			_, err := cas.SetCacheFileMetadata(name, metadata.NewPersist(true))
+			require.NoError(err)
+
+			p, err := cas.cacheStore.newFileOp().GetFilePath(name)
+			require.NoError(err)
+			corrupt(t, p)
+
+			cas.OnCorruptPersistedBlob(test.fetch)
+
+			require.NoError(cas.scrubber.scrub())
+
+			// The file awaits write-back and must not be lost before a
+			// replacement is found.
+			_, err = cas.GetCacheFileStat(name)
+			require.NoError(err)
+			var pm metadata.Persist
+			require.NoError(cas.GetCacheFileMetadata(name, &pm))
+			require.True(pm.Value)
+		})
+	}
+}
+
+func TestScrubberPrunesQuarantine(t *testing.T) {
+	require := require.New(t)
+
+	cas, quarantine, cleanup := scrubbedCAStoreFixture()
+	defer cleanup()
+
+	blobs := []*core.BlobFixture{
+		core.SizedBlobFixture(100, 10),
+		core.SizedBlobFixture(100, 10),
+	}
+	cas.scrubber.config.QuarantineMaxBytes = 150
+
+	// Stale files are pruned regardless of size.
+	stale := filepath.Join(quarantine, "stale")
+	require.NoError(ioutil.WriteFile(stale, []byte("stale"), 0775))
+	old := time.Now().Add(-2 * cas.scrubber.config.QuarantineTTL)
+	require.NoError(os.Chtimes(stale, old, old))
+
+	for i, blob := range blobs {
+		name := blob.Digest.Hex()
+		require.NoError(cas.CreateCacheFile(name, bytes.NewReader(blob.Content)))
+		p, err := cas.cacheStore.newFileOp().GetFilePath(name)
+		require.NoError(err)
+		corrupt(t, p)
+
+		require.NoError(cas.scrubber.scrub())
+
+		// Ensure the second file is strictly newer than the first.
+		if i == 0 {
+			past := time.Now().Add(-time.Minute)
+			require.NoError(os.Chtimes(filepath.Join(quarantine, name), past, past))
+		}
+	}
+
+	infos, err := ioutil.ReadDir(quarantine)
+	require.NoError(err)
+	require.Len(infos, 1)
+	require.Equal(blobs[1].Digest.Hex(), infos[0].Name())
+}
+
+func TestScrubberCADownloadStore(t *testing.T) {
+	require := require.New(t)
+
+	cads, cleanup := CADownloadStoreFixture()
+	defer cleanup()
+
+	var c testutil.Cleanup
+	defer c.Run()
+	quarantine := tempdir(&c, "quarantine")
+	cads.scrubber.config.QuarantineDir = quarantine
+
+	good := core.NewBlobFixture()
+	bad := core.NewBlobFixture()
+	for _, blob := range []*core.BlobFixture{good, bad} {
+		require.NoError(RunDownload(cads, blob.Digest, blob.Content))
+	}
+
+	p, err := cads.backend.NewFileOp().AcceptState(cads.cacheState).GetFilePath(bad.Digest.Hex())
+	require.NoError(err)
+	corrupt(t, p)
+
+	require.NoError(cads.scrubber.scrub())
+
+	_, err = cads.Cache().GetFileStat(good.Digest.Hex())
+	require.NoError(err)
+	_, err = cads.Cache().GetFileStat(bad.Digest.Hex())
+	require.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(quarantine, bad.Digest.Hex()))
+	require.NoError(err)
+}
@@ -52,8 +52,13 @@ func NewSimpleStore(config SimpleStoreConfig, stats tally.Scope) (*SimpleStore,
 	if err != nil {
 		return nil, fmt.Errorf("new cleanup manager: %s", err)
 	}
-	cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp())
-	cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp())
+	if err := cleanup.addJob("upload", config.UploadCleanup, uploadStore.newFileOp()); err != nil {
+		return nil, err
+	}
+	if err := cleanup.addJob("cache", config.CacheCleanup, cacheStore.newFileOp()); err != nil {
+		cleanup.stop()
+		return nil, err
+	}
 
 	return &SimpleStore{uploadStore, cacheStore, cleanup}, nil
 }
@@ -96,3 +101,37 @@ func (s *SimpleStore) CreateCacheFile(name string, r io.Reader) error {
 	}
 	return nil
 }
+
+// OverwriteCacheFile atomically replaces the content of cache file name with r,
+// preserving its metadata. Readers observe either the old or the new content.
+// Initializes the cache file if it does not exist.
+func (s *SimpleStore) OverwriteCacheFile(name string, r io.Reader) error {
+	tmp := fmt.Sprintf("%s.%s", name, uuid.Generate().String())
+	if err := s.CreateUploadFile(tmp, 0); err != nil {
+		return fmt.Errorf("create upload file: %s", err)
+	}
+	defer s.DeleteUploadFile(tmp)
+
+	w, err := s.GetUploadFileReadWriter(tmp)
+	if err != nil {
+		return fmt.Errorf("get upload writer: %s", err)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("copy: %s", err)
+	}
+
+	uploadPath, err := s.uploadStore.newFileOp().GetFilePath(tmp)
+	if err != nil {
+		return fmt.Errorf("get upload path: %s", err)
+	}
+	err = s.cacheStore.newFileOp().ReplaceFileFrom(name, uploadPath)
+	if os.IsNotExist(err) {
+		err = s.MoveUploadFileToCache(tmp, name)
+	}
+	if err != nil {
+		return fmt.Errorf("replace cache file: %s", err)
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/metadata"
 
 	"github.com/stretchr/testify/require"
 )
@@ -40,3 +41,38 @@ func TestSimpleStoreCreateCacheFile(t *testing.T) {
 	require.NoError(err)
 	require.Equal(d, string(result))
 }
+
+func TestSimpleStoreOverwriteCacheFile(t *testing.T) {
+	require := require.New(t)
+
+	s, cleanup := SimpleStoreFixture()
+	defer cleanup()
+
+	tag := core.TagFixture()
+	d1 := core.DigestFixture().String()
+	d2 := core.DigestFixture().String()
+
+	readCacheFile := func() string {
+		f, err := s.GetCacheFileReader(tag)
+		require.NoError(err)
+		defer f.Close()
+		result, err := ioutil.ReadAll(f)
+		require.NoError(err)
+		return string(result)
+	}
+
+	// Missing files are initialized.
+	require.NoError(s.OverwriteCacheFile(tag, bytes.NewBufferString(d1)))
+	require.Equal(d1, readCacheFile())
+
+	_, err := s.SetCacheFileMetadata(tag, metadata.NewPersist(true))
+	require.NoError(err)
+
+	require.NoError(s.OverwriteCacheFile(tag, bytes.NewBufferString(d2)))
+	require.Equal(d2, readCacheFile())
+
+	// Metadata is preserved.
+	var p metadata.Persist
+	require.NoError(s.GetCacheFileMetadata(tag, &p))
+	require.True(p.Value)
+}
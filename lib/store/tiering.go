@@ -0,0 +1,230 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/c2h5oh/datasize"
+	"github.com/uber-go/tally"
+)
+
+// TieringConfig defines how cache files move between the hot and cold tiers
+// of a CAStore.
+type TieringConfig struct {
+	Interval time.Duration `yaml:"interval"` // How often files are promoted / demoted.
+
+	// HotCapacity is the number of bytes kept in the hot tier. Once exceeded,
+	// the least frequently accessed files are demoted to the cold tier.
+	HotCapacity datasize.ByteSize `yaml:"hot_capacity"`
+
+	// Access frequency is tracked as a score which halves every interval and
+	// increments for every interval a file was read in. Cold files which are
+	// read again and reach PromoteThreshold are promoted to the hot tier. The
+	// default of 1.5 requires reads in two consecutive intervals.
+	PromoteThreshold float64 `yaml:"promote_threshold"`
+}
+
+func (c TieringConfig) applyDefaults() TieringConfig {
+	if c.Interval == 0 {
+		c.Interval = 5 * time.Minute
+	}
+	if c.PromoteThreshold == 0 {
+		c.PromoteThreshold = 1.5
+	}
+	return c
+}
+
+// tierManager periodically moves cache files between the hot and cold tier
+// based on how frequently they are accessed.
+type tierManager struct {
+	config  TieringConfig
+	clk     clock.Clock
+	stats   tally.Scope
+	backend base.FileStore
+	hot     base.FileState
+	cold    base.FileState
+
+	mu       sync.Mutex
+	accessed map[string]bool // Files read since the last pass.
+
+	// Only accessed by rebalance.
+	scores map[string]float64
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+}
+
+func newTierManager(
+	config TieringConfig,
+	clk clock.Clock,
+	stats tally.Scope,
+	backend base.FileStore,
+	hot base.FileState,
+	cold base.FileState) (*tierManager, error) {
+
+	config = config.applyDefaults()
+	if config.HotCapacity == 0 {
+		return nil, errors.New("tiering.hot_capacity must be set when cold tier is configured")
+	}
+	stats = stats.Tagged(map[string]string{
+		"module": "storetiering",
+	})
+	return &tierManager{
+		config:   config,
+		clk:      clk,
+		stats:    stats,
+		backend:  backend,
+		hot:      hot,
+		cold:     cold,
+		accessed: make(map[string]bool),
+		scores:   make(map[string]float64),
+		stopc:    make(chan struct{}),
+	}, nil
+}
+
+// recordAccess marks name as read during the current interval.
+func (m *tierManager) recordAccess(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accessed[name] = true
+}
+
+// start runs rebalance passes in the background until stop is called.
+func (m *tierManager) start() {
+	ticker := m.clk.Ticker(m.config.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.rebalance(); err != nil {
+					log.Errorf("Error rebalancing cache tiers: %s", err)
+				}
+			case <-m.stopc:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (m *tierManager) stop() {
+	m.stopOnce.Do(func() { close(m.stopc) })
+}
+
+type tierCandidate struct {
+	name           string
+	size           int64
+	score          float64
+	lastAccessTime time.Time
+}
+
+// rebalance promotes frequently read cold files, then demotes the least
+// frequently read hot files until the hot tier fits within its capacity.
+func (m *tierManager) rebalance() error {
+	m.mu.Lock()
+	accessed := m.accessed
+	m.accessed = make(map[string]bool)
+	m.mu.Unlock()
+
+	hotOp := m.backend.NewFileOp().AcceptState(m.hot)
+	coldOp := m.backend.NewFileOp().AcceptState(m.cold)
+
+	hotNames, err := hotOp.ListNames()
+	if err != nil {
+		return fmt.Errorf("list hot tier: %s", err)
+	}
+	coldNames, err := coldOp.ListNames()
+	if err != nil {
+		return fmt.Errorf("list cold tier: %s", err)
+	}
+
+	// Scores of deleted files are dropped by only carrying over present ones.
+	scores := make(map[string]float64, len(hotNames)+len(coldNames))
+	for _, name := range append(hotNames, coldNames...) {
+		s := m.scores[name] / 2
+		if accessed[name] {
+			s++
+		}
+		scores[name] = s
+	}
+	m.scores = scores
+
+	for _, name := range coldNames {
+		if !accessed[name] || scores[name] < m.config.PromoteThreshold {
+			continue
+		}
+		if err := coldOp.MoveFile(name, m.hot); err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error promoting file: %s", err)
+			}
+			continue
+		}
+		m.stats.Counter("promotions").Inc(1)
+		hotNames = append(hotNames, name)
+	}
+
+	var usage uint64
+	var candidates []tierCandidate
+	for _, name := range hotNames {
+		info, err := hotOp.GetFileStat(name)
+		if err != nil {
+			continue
+		}
+		usage += uint64(info.Size())
+		c := tierCandidate{name, info.Size(), scores[name], info.ModTime()}
+		var lat metadata.LastAccessTime
+		if err := hotOp.GetFileMetadata(name, &lat); err == nil {
+			c.lastAccessTime = lat.Time
+		}
+		candidates = append(candidates, c)
+	}
+
+	capacity := m.config.HotCapacity.Bytes()
+	if usage > capacity {
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].score != candidates[j].score {
+				return candidates[i].score < candidates[j].score
+			}
+			return candidates[i].lastAccessTime.Before(candidates[j].lastAccessTime)
+		})
+		for _, c := range candidates {
+			if usage <= capacity {
+				break
+			}
+			if err := hotOp.MoveFile(c.name, m.cold); err != nil {
+				if !os.IsNotExist(err) {
+					log.With("name", c.name).Errorf("Error demoting file: %s", err)
+				}
+				continue
+			}
+			usage -= uint64(c.size)
+			m.stats.Counter("demotions").Inc(1)
+			m.stats.Counter("demoted_bytes").Inc(c.size)
+		}
+	}
+	m.stats.Gauge("hot_usage_bytes").Update(float64(usage))
+	return nil
+}
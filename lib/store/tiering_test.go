@@ -0,0 +1,135 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func tieredCAStoreFixture(tiering TieringConfig) (*CAStore, CAStoreConfig, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	config, c := CAStoreConfigFixture()
+	cleanup.Add(c)
+
+	config.ColdCacheDir = tempdir(&cleanup, "cold")
+	config.Tiering = tiering
+
+	s, err := NewCAStore(config, tally.NoopScope)
+	if err != nil {
+		panic(err)
+	}
+	cleanup.Add(s.Close)
+
+	return s, config, cleanup.Run
+}
+
+func isCold(t *testing.T, cas *CAStore, config CAStoreConfig, name string) bool {
+	p, err := cas.cacheStore.newFileOp().GetFilePath(name)
+	require.NoError(t, err)
+	return strings.HasPrefix(p, config.ColdCacheDir)
+}
+
+func readCacheFile(t *testing.T, cas *CAStore, name string) []byte {
+	f, err := cas.GetCacheFileReader(name)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return b
+}
+
+func TestTieringDemotesLeastFrequentlyAccessedFiles(t *testing.T) {
+	require := require.New(t)
+
+	cas, config, cleanup := tieredCAStoreFixture(TieringConfig{HotCapacity: 600})
+	defer cleanup()
+
+	var blobs []*core.BlobFixture
+	for i := 0; i < 4; i++ {
+		blob := core.NewBlobFixture() // 256 bytes.
+		require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+		require.False(isCold(t, cas, config, blob.Digest.Hex()))
+		blobs = append(blobs, blob)
+	}
+
+	for _, blob := range blobs[2:] {
+		readCacheFile(t, cas, blob.Digest.Hex())
+	}
+
+	require.NoError(cas.tiers.rebalance())
+
+	for _, blob := range blobs[:2] {
+		require.True(isCold(t, cas, config, blob.Digest.Hex()))
+	}
+	for _, blob := range blobs[2:] {
+		require.False(isCold(t, cas, config, blob.Digest.Hex()))
+	}
+
+	// Files are readable from either tier.
+	for _, blob := range blobs {
+		require.Equal(blob.Content, readCacheFile(t, cas, blob.Digest.Hex()))
+	}
+}
+
+func TestTieringPromotesFrequentlyAccessedColdFiles(t *testing.T) {
+	require := require.New(t)
+
+	cas, config, cleanup := tieredCAStoreFixture(TieringConfig{HotCapacity: 1})
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	name := blob.Digest.Hex()
+	require.NoError(cas.CreateCacheFile(name, bytes.NewReader(blob.Content)))
+
+	require.NoError(cas.tiers.rebalance())
+	require.True(isCold(t, cas, config, name))
+
+	cas.tiers.config.HotCapacity = 1 << 20
+
+	// A single read is not enough for promotion.
+	readCacheFile(t, cas, name)
+	require.NoError(cas.tiers.rebalance())
+	require.True(isCold(t, cas, config, name))
+
+	readCacheFile(t, cas, name)
+	require.NoError(cas.tiers.rebalance())
+	require.False(isCold(t, cas, config, name))
+
+	require.Equal(blob.Content, readCacheFile(t, cas, name))
+}
+
+func TestTieringRequiresHotCapacity(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := CAStoreConfigFixture()
+	defer cleanup()
+
+	var c testutil.Cleanup
+	defer c.Run()
+	config.ColdCacheDir = tempdir(&c, "cold")
+
+	_, err := NewCAStore(config, tally.NoopScope)
+	require.Error(err)
+}
@@ -0,0 +1,427 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/uber/kraken/lib/hrw"
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/c2h5oh/datasize"
+	"github.com/spaolacci/murmur3"
+	"github.com/uber-go/tally"
+)
+
+const (
+	_volumeProbeFile = ".kraken_probe"
+
+	// _shardPinFile marks a shard source which is not on the preferred volume
+	// of the shard.
+	_shardPinFile = ".kraken_pinned"
+)
+
+// VolumeHealthConfig defines how CAStore monitors its volumes at runtime.
+type VolumeHealthConfig struct {
+	Disabled bool          `yaml:"disabled"`
+	Interval time.Duration `yaml:"interval"` // How often volumes are checked.
+
+	// MinFree is the free space below which a volume stops receiving shards
+	// moved off of failed volumes, unless no other healthy volume is left.
+	MinFree datasize.ByteSize `yaml:"min_free"`
+}
+
+func (c VolumeHealthConfig) applyDefaults() VolumeHealthConfig {
+	if c.Interval == 0 {
+		c.Interval = time.Minute
+	}
+	return c
+}
+
+// volumeManager places the shard directories of the cache onto volumes via
+// symlinks. Shards are assigned by weighted rendezvous hashing at startup, and
+// moved to other volumes when their volume fails a health check. Shards which
+// are not on their preferred volume are pinned, such that they keep their data
+// across restarts.
+type volumeManager struct {
+	config  VolumeHealthConfig
+	clk     clock.Clock
+	stats   tally.Scope
+	dir     string
+	volumes []Volume
+	hash    *hrw.RendezvousHash
+	op      base.FileOp
+
+	// probe checks whether a volume is readable and writable. Stubbed in tests.
+	probe func(location string) error
+
+	mu        sync.Mutex
+	unhealthy map[string]bool
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+}
+
+func newVolumeManager(
+	config VolumeHealthConfig,
+	clk clock.Clock,
+	stats tally.Scope,
+	dir string,
+	volumes []Volume,
+	op base.FileOp) *volumeManager {
+
+	h := hrw.NewRendezvousHash(
+		func() hash.Hash { return murmur3.New64() },
+		hrw.UInt64ToFloat64)
+	for _, v := range volumes {
+		h.AddNode(v.Location, v.Weight)
+	}
+	return &volumeManager{
+		config:    config.applyDefaults(),
+		clk:       clk,
+		stats:     stats,
+		dir:       dir,
+		volumes:   volumes,
+		hash:      h,
+		op:        op,
+		probe:     probeVolume,
+		unhealthy: make(map[string]bool),
+		stopc:     make(chan struct{}),
+	}
+}
+
+// init creates the shard symlinks under dir, skipping volumes which are
+// unhealthy at startup. Pinned shards stay on their volume while it is healthy.
+func (m *volumeManager) init() error {
+	if len(m.volumes) == 0 {
+		return nil
+	}
+	for _, v := range m.volumes {
+		if _, err := os.Stat(v.Location); err != nil {
+			return fmt.Errorf("verify volume: %s", err)
+		}
+	}
+	m.check()
+	if m.numHealthy() == 0 {
+		return errors.New("no healthy volumes")
+	}
+
+	// Create 256 symlinks under dir.
+	for subdirIndex := 0; subdirIndex < 256; subdirIndex++ {
+		subdirName := fmt.Sprintf("%02X", subdirIndex)
+		targetPath := path.Join(m.dir, subdirName)
+		source := m.pinnedSource(targetPath)
+		if source == "" {
+			var err error
+			source, err = m.place(subdirName, false)
+			if err != nil {
+				return fmt.Errorf("calculate volume for subdir %s: %s", subdirName, err)
+			}
+		}
+		if err := os.MkdirAll(source, 0775); err != nil {
+			return fmt.Errorf("volume source path: %s", err)
+		}
+		if err := m.pin(subdirName, source); err != nil {
+			return fmt.Errorf("pin subdir %s: %s", subdirName, err)
+		}
+		if err := createOrUpdateSymlink(source, targetPath); err != nil {
+			return fmt.Errorf("symlink to volume: %s", err)
+		}
+	}
+	return nil
+}
+
+// pinnedSource returns the current source of the shard symlinked at link if
+// it is pinned to a healthy volume. Otherwise, returns "".
+func (m *volumeManager) pinnedSource(link string) string {
+	source, err := os.Readlink(link)
+	if err != nil {
+		return ""
+	}
+	v := m.volumeOf(source)
+	if v == "" || !m.isHealthy(v) {
+		return ""
+	}
+	if _, err := os.Stat(path.Join(source, _shardPinFile)); err != nil {
+		return ""
+	}
+	return source
+}
+
+// pin marks source as the placement of subdir if it is not on the preferred
+// volume of subdir, such that the shard is not moved back by a restart.
+func (m *volumeManager) pin(subdir, source string) error {
+	preferred := m.hash.GetOrderedNodes(subdir, 1)[0].Label
+	if source == m.sourceOn(preferred, subdir) {
+		return nil
+	}
+	return ioutil.WriteFile(path.Join(source, _shardPinFile), nil, 0644)
+}
+
+// sourceOn returns the source path of subdir on the volume at location.
+func (m *volumeManager) sourceOn(location, subdir string) string {
+	return path.Join(location, path.Base(m.dir), subdir)
+}
+
+// start periodically checks volume health until stop is called.
+func (m *volumeManager) start() {
+	if len(m.volumes) == 0 || m.config.Disabled {
+		return
+	}
+	ticker := m.clk.Ticker(m.config.Interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if m.check() {
+					m.rehome()
+				}
+			case <-m.stopc:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (m *volumeManager) stop() {
+	m.stopOnce.Do(func() { close(m.stopc) })
+}
+
+// check probes every volume and reports its usage. Returns true if any volume
+// became unhealthy since the last check. Volumes which recover are eligible
+// for new shards again, but shards are not moved back to them.
+func (m *volumeManager) check() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed bool
+	for _, v := range m.volumes {
+		stats := m.stats.Tagged(map[string]string{"volume": v.Location})
+		logger := log.With("volume", v.Location)
+
+		err := m.probe(v.Location)
+		if err != nil {
+			if !m.unhealthy[v.Location] {
+				logger.Errorf("Volume is unhealthy: %s", err)
+				stats.Counter("volume_failures").Inc(1)
+				m.unhealthy[v.Location] = true
+				failed = true
+			}
+			stats.Gauge("volume_healthy").Update(0)
+			continue
+		}
+		if m.unhealthy[v.Location] {
+			logger.Info("Volume is healthy again")
+			delete(m.unhealthy, v.Location)
+		}
+		stats.Gauge("volume_healthy").Update(1)
+
+		total, free, err := volumeSpace(v.Location)
+		if err != nil {
+			logger.Errorf("Error getting volume space: %s", err)
+			continue
+		}
+		stats.Gauge("volume_used_bytes").Update(float64(total - free))
+		stats.Gauge("volume_free_bytes").Update(float64(free))
+	}
+	return failed
+}
+
+func (m *volumeManager) numHealthy() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.volumes) - len(m.unhealthy)
+}
+
+func (m *volumeManager) isHealthy(location string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !m.unhealthy[location]
+}
+
+// place returns the source path of subdir on the highest ranked healthy
+// volume. If checkFree is set, volumes below the configured free space are
+// skipped unless no other healthy volume is left.
+func (m *volumeManager) place(subdir string, checkFree bool) (string, error) {
+	var fallback string
+	for _, node := range m.hash.GetOrderedNodes(subdir, len(m.volumes)) {
+		if !m.isHealthy(node.Label) {
+			continue
+		}
+		source := m.sourceOn(node.Label, subdir)
+		if !checkFree || m.config.MinFree == 0 {
+			return source, nil
+		}
+		if _, free, err := volumeSpace(node.Label); err == nil && free >= m.config.MinFree.Bytes() {
+			return source, nil
+		}
+		if fallback == "" {
+			fallback = source
+		}
+	}
+	if fallback == "" {
+		return "", errors.New("no healthy volumes")
+	}
+	return fallback, nil
+}
+
+// rehome moves every shard on an unhealthy volume onto a healthy one. Files
+// in moved shards are dropped from the store, since the failed volume cannot
+// be trusted to serve them.
+func (m *volumeManager) rehome() {
+	for subdirIndex := 0; subdirIndex < 256; subdirIndex++ {
+		subdirName := fmt.Sprintf("%02X", subdirIndex)
+		if err := m.rehomeShard(subdirName); err != nil {
+			log.With("shard", subdirName).Errorf("Error rehoming shard: %s", err)
+		}
+	}
+}
+
+func (m *volumeManager) rehomeShard(subdir string) error {
+	link := path.Join(m.dir, subdir)
+	oldSource, err := os.Readlink(link)
+	if err != nil {
+		return fmt.Errorf("read symlink: %s", err)
+	}
+	if m.isHealthy(m.volumeOf(oldSource)) {
+		return nil
+	}
+	newSource, err := m.place(subdir, true)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(newSource, 0775); err != nil {
+		return fmt.Errorf("volume source path: %s", err)
+	}
+
+	// Collect names before switching the symlink. A volume with IO errors may
+	// not be listable, in which case its files are only dropped from the
+	// store once they are found to be missing.
+	names, err := listShardNames(oldSource)
+	if err != nil {
+		log.With("shard", subdir).Warnf("Error listing files on failed volume: %s", err)
+	}
+
+	if err := m.pin(subdir, newSource); err != nil {
+		return fmt.Errorf("pin shard: %s", err)
+	}
+	// Replace the symlink atomically, such that the shard is always present.
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(newSource, tmp); err != nil {
+		return fmt.Errorf("symlink to volume: %s", err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		return fmt.Errorf("replace symlink: %s", err)
+	}
+	var dropped int64
+	for _, name := range names {
+		if err := m.op.DeleteFile(name); err != nil {
+			if !os.IsNotExist(err) {
+				log.With("name", name).Errorf("Error dropping file from failed volume: %s", err)
+			}
+			continue
+		}
+		dropped++
+	}
+	m.stats.Counter("rehomed_shards").Inc(1)
+	m.stats.Counter("rehomed_dropped_files").Inc(dropped)
+	log.With("shard", subdir, "from", oldSource, "to", newSource).Info("Rehomed shard")
+	return nil
+}
+
+// volumeOf returns the location of the volume source belongs to.
+func (m *volumeManager) volumeOf(source string) string {
+	for _, v := range m.volumes {
+		if strings.HasPrefix(source, path.Clean(v.Location)+"/") {
+			return v.Location
+		}
+	}
+	return ""
+}
+
+// listShardNames returns the names of files under a shard directory, which
+// contains one more level of shard directories.
+func listShardNames(dir string) ([]string, error) {
+	subdirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+		infos, err := ioutil.ReadDir(path.Join(dir, subdir.Name()))
+		if err != nil {
+			return names, err
+		}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// probeVolume writes, syncs and reads back a small file on the volume, which
+// fails on read-only file systems and disks returning IO errors.
+func probeVolume(location string) error {
+	p := path.Join(location, _volumeProbeFile)
+	content := []byte(time.Now().String())
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0664)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(p)
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		return fmt.Errorf("write: %s", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync: %s", err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("read: %s", err)
+	}
+	if string(b) != string(content) {
+		return errors.New("read back mismatched content")
+	}
+	return nil
+}
+
+// volumeSpace returns the total and available bytes of the file system
+// location is on.
+func volumeSpace(location string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(location, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store/base"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type volumeManagerFixture struct {
+	manager *volumeManager
+	cache   *cacheStore
+	volumes []string
+}
+
+func newVolumeManagerFixture(t *testing.T) (*volumeManagerFixture, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	dir := tempdir(&cleanup, "cache")
+	cache, err := newCacheStore(dir, base.NewCASFileStore(clock.New()))
+	require.NoError(t, err)
+
+	var locations []string
+	var volumes []Volume
+	for i := 0; i < 3; i++ {
+		loc := tempdir(&cleanup, "volume")
+		locations = append(locations, loc)
+		volumes = append(volumes, Volume{Location: loc, Weight: 100})
+	}
+
+	m := newVolumeManager(
+		VolumeHealthConfig{}, clock.New(), tally.NoopScope, dir, volumes, cache.newFileOp())
+	cleanup.Add(m.stop)
+
+	return &volumeManagerFixture{m, cache, locations}, cleanup.Run
+}
+
+// failProbe makes health checks of the given volumes fail.
+func (f *volumeManagerFixture) failProbe(locations ...string) {
+	f.manager.probe = func(location string) error {
+		for _, l := range locations {
+			if l == location {
+				return errors.New("some IO error")
+			}
+		}
+		return nil
+	}
+}
+
+// shardsOn returns the shards symlinked onto location.
+func (f *volumeManagerFixture) shardsOn(t *testing.T, location string) []string {
+	links, err := ioutil.ReadDir(f.manager.dir)
+	require.NoError(t, err)
+	var shards []string
+	for _, link := range links {
+		source, err := os.Readlink(path.Join(f.manager.dir, link.Name()))
+		require.NoError(t, err)
+		if strings.HasPrefix(source, location) {
+			shards = append(shards, link.Name())
+		}
+	}
+	return shards
+}
+
+func TestVolumeManagerSkipsUnhealthyVolumesAtStartup(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newVolumeManagerFixture(t)
+	defer cleanup()
+
+	f.failProbe(f.volumes[0])
+	require.NoError(f.manager.init())
+
+	require.Empty(f.shardsOn(t, f.volumes[0]))
+	require.Equal(256, len(f.shardsOn(t, f.volumes[1]))+len(f.shardsOn(t, f.volumes[2])))
+}
+
+func TestVolumeManagerInitErrorsWithoutHealthyVolumes(t *testing.T) {
+	f, cleanup := newVolumeManagerFixture(t)
+	defer cleanup()
+
+	f.failProbe(f.volumes...)
+	require.Error(t, f.manager.init())
+}
+
+func TestVolumeManagerRehomesShardsOfFailedVolume(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newVolumeManagerFixture(t)
+	defer cleanup()
+
+	require.NoError(f.manager.init())
+
+	// Only shards named with decimal digits hold files, since file names are
+	// lowercase hex.
+	var shard string
+	for _, s := range f.shardsOn(t, f.volumes[0]) {
+		if strings.Trim(s, "0123456789") == "" {
+			shard = s
+			break
+		}
+	}
+	require.NotEmpty(shard)
+
+	name := shard + core.DigestFixture().Hex()[2:]
+	op := f.cache.newFileOp()
+	require.NoError(op.CreateFile(name, f.cache.state, 5))
+
+	f.failProbe(f.volumes[0])
+	require.True(f.manager.check())
+	// Already known failures do not trigger rehoming again.
+	require.False(f.manager.check())
+	f.manager.rehome()
+
+	require.Empty(f.shardsOn(t, f.volumes[0]))
+	require.Equal(256, len(f.shardsOn(t, f.volumes[1]))+len(f.shardsOn(t, f.volumes[2])))
+
+	// The file on the failed volume is dropped and can be cached again.
+	_, err := op.GetFileStat(name)
+	require.True(os.IsNotExist(err))
+	require.NoError(op.CreateFile(name, f.cache.state, 5))
+}
+
+func TestVolumeManagerKeepsRehomedShardsAcrossRestarts(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newVolumeManagerFixture(t)
+	defer cleanup()
+
+	stats := tally.NewTestScope("", nil)
+	f.manager.stats = stats
+
+	require.NoError(f.manager.init())
+
+	shards := f.shardsOn(t, f.volumes[0])
+	require.NotEmpty(shards)
+
+	var shard string
+	for _, s := range shards {
+		if strings.Trim(s, "0123456789") == "" {
+			shard = s
+			break
+		}
+	}
+	require.NotEmpty(shard)
+	name := shard + core.DigestFixture().Hex()[2:]
+	op := f.cache.newFileOp()
+	require.NoError(op.CreateFile(name, f.cache.state, 5))
+
+	// Files which are unknown to the store are not counted as dropped.
+	unknown := shard + core.DigestFixture().Hex()[2:]
+	require.NoError(os.MkdirAll(path.Join(f.manager.dir, shard, unknown[2:4], unknown), 0775))
+
+	f.failProbe(f.volumes[0])
+	require.True(f.manager.check())
+	f.manager.rehome()
+	require.Empty(f.shardsOn(t, f.volumes[0]))
+
+	var dropped int64 = -1
+	for _, c := range stats.Snapshot().Counters() {
+		if c.Name() == "rehomed_dropped_files" {
+			dropped = c.Value()
+		}
+	}
+	require.Equal(int64(1), dropped)
+
+	// After a restart with the failed volume recovered, the rehomed shards
+	// stay on the volumes holding their data.
+	f.failProbe()
+	m := newVolumeManager(
+		VolumeHealthConfig{}, clock.New(), tally.NoopScope,
+		f.manager.dir, f.manager.volumes, f.cache.newFileOp())
+	defer m.stop()
+	require.NoError(m.init())
+	require.Empty(f.shardsOn(t, f.volumes[0]))
+}
+
+func TestVolumeManagerPlaceFallsBackToFullVolumes(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newVolumeManagerFixture(t)
+	defer cleanup()
+
+	f.manager.config.MinFree = 1 << 60
+
+	source, err := f.manager.place("00", true)
+	require.NoError(err)
+	require.NotEmpty(f.manager.volumeOf(source))
+}
+
+func TestProbeVolume(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("/tmp", "volume")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(probeVolume(dir))
+	_, err = os.Stat(path.Join(dir, _volumeProbeFile))
+	require.True(os.IsNotExist(err))
+
+	require.Error(probeVolume(path.Join(dir, "missing")))
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/log"
+
+	"go.uber.org/atomic"
+	"golang.org/x/net/websocket"
+)
+
+// CollectPath is the websocket endpoint on which collectors receive events.
+const CollectPath = "/collect"
+
+// collectorStream streams events to a collector over a websocket. Events are
+// buffered and sent asynchronously, such that a slow or unreachable collector
+// never blocks the producer.
+type collectorStream struct {
+	config  CollectorConfig
+	events  chan *Event
+	dropped atomic.Int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newCollectorStream(config CollectorConfig) *collectorStream {
+	config = config.applyDefaults()
+	s := &collectorStream{
+		config: config,
+		events: make(chan *Event, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Write buffers e to be sent to the collector.
+func (s *collectorStream) Write(e *Event) {
+	select {
+	case s.events <- e:
+	default:
+		s.dropped.Inc()
+	}
+}
+
+// Close stops streaming to the collector.
+func (s *collectorStream) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func (s *collectorStream) run() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := websocket.Dial(
+			fmt.Sprintf("ws://%s%s", s.config.Addr, CollectPath), "", "http://localhost/")
+		if err != nil {
+			log.With("collector", s.config.Addr).Warnf(
+				"Error connecting to network event collector: %s", err)
+			select {
+			case <-time.After(s.config.RetryInterval):
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		if n := s.dropped.Swap(0); n > 0 {
+			log.With("collector", s.config.Addr).Warnf(
+				"Dropped %d network events while collector was unavailable", n)
+		}
+		err = s.stream(conn)
+		conn.Close()
+		if err == nil {
+			return
+		}
+		log.With("collector", s.config.Addr).Warnf(
+			"Error streaming network events to collector: %s", err)
+	}
+}
+
+// stream sends events on conn until s is closed, in which case nil is
+// returned, or an error occurs.
+func (s *collectorStream) stream(conn *websocket.Conn) error {
+	for {
+		select {
+		case e := <-s.events:
+			if err := websocket.JSON.Send(conn, e); err != nil {
+				s.dropped.Inc()
+				return err
+			}
+		case <-s.done:
+			return nil
+		}
+	}
+}
@@ -13,8 +13,120 @@
 // limitations under the License.
 package networkevent
 
-// Config defines network event configuration.
+import (
+	"time"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// Config defines network event configuration. Each configured sink receives
+// all produced events.
 type Config struct {
 	LogPath string `yaml:"log_path"`
 	Enabled bool   `yaml:"enabled"`
+
+	// Rotation rotates the log file at LogPath once it grows too large.
+	Rotation RotationConfig `yaml:"rotation"`
+
+	// RingBuffer keeps the most recent events in memory, such that they may
+	// be queried after the fact.
+	RingBuffer RingBufferConfig `yaml:"ring_buffer"`
+
+	// HTTP ships batches of events to an HTTP endpoint.
+	HTTP HTTPConfig `yaml:"http"`
+
+	// Collector streams events to a live visualization collector, independent
+	// of whether events are logged.
+	Collector CollectorConfig `yaml:"collector"`
+}
+
+// CollectorConfig defines streaming of events to a collector.
+type CollectorConfig struct {
+	// Addr is the host:port of the collector. Streaming is disabled if empty.
+	Addr string `yaml:"addr"`
+
+	// BufferSize is the number of events buffered while the collector is slow
+	// or unreachable. Events are dropped while the buffer is full.
+	BufferSize int `yaml:"buffer_size"`
+
+	// RetryInterval is the delay between attempts to connect to the collector.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+func (c CollectorConfig) applyDefaults() CollectorConfig {
+	if c.BufferSize == 0 {
+		c.BufferSize = 10000
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 5 * time.Second
+	}
+	return c
+}
+
+// RotationConfig defines rotation of the event log file.
+type RotationConfig struct {
+	// MaxSize is the size at which the log file is rotated. Rotation is
+	// disabled if zero.
+	MaxSize datasize.ByteSize `yaml:"max_size"`
+
+	// MaxBackups is the number of rotated log files kept, named LogPath.1
+	// (the most recent) through LogPath.MaxBackups.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+func (c RotationConfig) applyDefaults() RotationConfig {
+	if c.MaxBackups == 0 {
+		c.MaxBackups = 3
+	}
+	return c
+}
+
+// RingBufferConfig defines the in-memory buffer of recent events.
+type RingBufferConfig struct {
+	// Size is the number of most recent events kept.
+	Size int `yaml:"size"`
+}
+
+func (c RingBufferConfig) applyDefaults() RingBufferConfig {
+	if c.Size == 0 {
+		c.Size = 10000
+	}
+	return c
+}
+
+// HTTPConfig defines shipping of events to an HTTP endpoint.
+type HTTPConfig struct {
+	// URL is the endpoint batches of events are POSTed to, as newline
+	// delimited json. Shipping is disabled if empty.
+	URL string `yaml:"url"`
+
+	// BatchSize is the maximum number of events sent per request.
+	BatchSize int `yaml:"batch_size"`
+
+	// FlushInterval is the maximum delay before buffered events are sent.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+
+	// BufferSize is the number of events buffered while the endpoint is slow.
+	// Events are dropped while the buffer is full.
+	BufferSize int `yaml:"buffer_size"`
+
+	// Timeout is the timeout of each request. Batches which fail to send are
+	// dropped.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c HTTPConfig) applyDefaults() HTTPConfig {
+	if c.BatchSize == 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.BufferSize == 0 {
+		c.BufferSize = 10000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c
 }
@@ -36,6 +36,10 @@ const (
 	ReceivePiece     Name = "receive_piece"
 	TorrentComplete  Name = "torrent_complete"
 	TorrentCancelled Name = "torrent_cancelled"
+
+	PieceRequestTimeout Name = "piece_request_timeout"
+	BlacklistExpired    Name = "blacklist_expired"
+	AnnounceFailed      Name = "announce_failed"
 )
 
 // Event consolidates all possible event fields.
@@ -51,6 +55,7 @@ type Event struct {
 	Bitfield     []bool `json:"bitfield,omitempty"`
 	DurationMS   int64  `json:"duration_ms,omitempty"`
 	ConnCapacity int    `json:"conn_capacity,omitempty"`
+	Error        string `json:"error,omitempty"`
 }
 
 func baseEvent(name Name, h core.InfoHash, self core.PeerID) *Event {
@@ -131,3 +136,27 @@ func TorrentCompleteEvent(h core.InfoHash, self core.PeerID) *Event {
 func TorrentCancelledEvent(h core.InfoHash, self core.PeerID) *Event {
 	return baseEvent(TorrentCancelled, h, self)
 }
+
+// PieceRequestTimeoutEvent returns an event for a piece request to a peer which
+// timed out before the piece was received.
+func PieceRequestTimeoutEvent(h core.InfoHash, self core.PeerID, peer core.PeerID, piece int) *Event {
+	e := baseEvent(PieceRequestTimeout, h, self)
+	e.Peer = peer.String()
+	e.Piece = piece
+	return e
+}
+
+// BlacklistExpiredEvent returns an event for a blacklisted connection whose
+// blacklist duration has passed.
+func BlacklistExpiredEvent(h core.InfoHash, self core.PeerID, peer core.PeerID) *Event {
+	e := baseEvent(BlacklistExpired, h, self)
+	e.Peer = peer.String()
+	return e
+}
+
+// AnnounceFailedEvent returns an event for a failed announce to the tracker.
+func AnnounceFailedEvent(h core.InfoHash, self core.PeerID, err error) *Event {
+	e := baseEvent(AnnounceFailed, h, self)
+	e.Error = err.Error()
+	return e
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/uber/kraken/utils/log"
+)
+
+// fileSink appends events to a log file as newline delimited json, rotating
+// the file once it grows beyond the configured size.
+type fileSink struct {
+	path     string
+	rotation RotationConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+func newFileSink(path string, rotation RotationConfig) (*fileSink, error) {
+	if path == "" {
+		return nil, errors.New("no log path supplied")
+	}
+	s := &fileSink{path: path, rotation: rotation.applyDefaults()}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	var flag int
+	if _, err := os.Stat(s.path); err != nil {
+		if os.IsNotExist(err) {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		} else {
+			return fmt.Errorf("stat: %s", err)
+		}
+	} else {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(s.path, flag, 0775)
+	if err != nil {
+		return fmt.Errorf("open %d: %s", flag, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat: %s", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shifts the current log file and its backups by one, discarding the
+// oldest backup, and opens a new log file.
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+	s.file = nil
+	for i := s.rotation.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(s.backupPath(i), s.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rename backup: %s", err)
+		}
+	}
+	if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+		return fmt.Errorf("rename: %s", err)
+	}
+	return s.open()
+}
+
+func (s *fileSink) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// Write appends e to the log file.
+func (s *fileSink) Write(e *Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error serializing network event to json: %s", err)
+		return
+	}
+	line := append(b, byte('\n'))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	if s.file == nil {
+		// A previous rotation failed. Retry such that events are not lost
+		// until restart.
+		if err := s.open(); err != nil {
+			log.Errorf("Error reopening network event log: %s", err)
+			return
+		}
+	}
+	maxSize := int64(s.rotation.MaxSize)
+	if maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > maxSize {
+		if err := s.rotate(); err != nil {
+			log.Errorf("Error rotating network event log: %s", err)
+			if s.file == nil {
+				return
+			}
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		log.Errorf("Error writing network event: %s", err)
+	}
+}
+
+// Close closes the log file.
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/require"
+)
+
+func readEventLog(t *testing.T, path string) []*Event {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []*Event
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := new(Event)
+		require.NoError(t, json.Unmarshal(s.Bytes(), e))
+		events = append(events, e)
+	}
+	require.NoError(t, s.Err())
+	return events
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	var events []*Event
+	for i := 1; i <= 7; i++ {
+		events = append(events, ReceivePieceEvent(h, peer1, peer2, i))
+	}
+	b, err := json.Marshal(events[0])
+	require.NoError(err)
+	lineSize := len(b) + 1
+
+	// Two events fit per file.
+	path := filepath.Join(dir, "netevents")
+	s, err := newFileSink(path, RotationConfig{
+		MaxSize:    datasize.ByteSize(2*lineSize + 1),
+		MaxBackups: 2,
+	})
+	require.NoError(err)
+	for _, e := range events {
+		s.Write(e)
+	}
+	require.NoError(s.Close())
+
+	checkPieces := func(path string, pieces ...int) {
+		t.Helper()
+		var result []int
+		for _, e := range readEventLog(t, path) {
+			result = append(result, e.Piece)
+		}
+		require.Equal(pieces, result)
+	}
+	checkPieces(path, 7)
+	checkPieces(path+".1", 5, 6)
+	checkPieces(path+".2", 3, 4)
+
+	// The oldest backup is discarded.
+	_, err = os.Stat(path + ".3")
+	require.True(os.IsNotExist(err))
+}
+
+func TestFileSinkRotationAccountsForExistingFile(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	path := filepath.Join(dir, "netevents")
+	require.NoError(ioutil.WriteFile(path, make([]byte, 1024), 0775))
+
+	s, err := newFileSink(path, RotationConfig{MaxSize: datasize.KB})
+	require.NoError(err)
+	s.Write(TorrentCompleteEvent(h, peer))
+	require.NoError(s.Close())
+
+	require.Len(readEventLog(t, path), 1)
+	info, err := os.Stat(path + ".1")
+	require.NoError(err)
+	require.Equal(int64(1024), info.Size())
+}
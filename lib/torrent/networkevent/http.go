@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"go.uber.org/atomic"
+)
+
+// httpSink ships batches of events to an HTTP endpoint. Events are buffered and
+// sent asynchronously, such that a slow endpoint never blocks the producer.
+type httpSink struct {
+	config  HTTPConfig
+	events  chan *Event
+	dropped atomic.Int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newHTTPSink(config HTTPConfig) *httpSink {
+	config = config.applyDefaults()
+	s := &httpSink{
+		config: config,
+		events: make(chan *Event, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Write buffers e to be sent in the next batch.
+func (s *httpSink) Write(e *Event) {
+	select {
+	case s.events <- e:
+	default:
+		s.dropped.Inc()
+	}
+}
+
+// Close sends all buffered events and stops the sink.
+func (s *httpSink) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+func (s *httpSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Event
+	for {
+		select {
+		case e := <-s.events:
+			batch = append(batch, e)
+			if len(batch) < s.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.done:
+			for {
+				select {
+				case e := <-s.events:
+					batch = append(batch, e)
+					if len(batch) == s.config.BatchSize {
+						s.send(batch)
+						batch = nil
+					}
+				default:
+					s.send(batch)
+					return
+				}
+			}
+		}
+		s.send(batch)
+		batch = nil
+	}
+}
+
+// send posts batch as newline delimited json.
+func (s *httpSink) send(batch []*Event) {
+	if n := s.dropped.Swap(0); n > 0 {
+		log.With("url", s.config.URL).Warnf("Dropped %d network events", n)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range batch {
+		if err := enc.Encode(e); err != nil {
+			log.Errorf("Error serializing network event to json: %s", err)
+			return
+		}
+	}
+	resp, err := httputil.Post(
+		s.config.URL,
+		httputil.SendBody(&b),
+		httputil.SendTimeout(s.config.Timeout),
+		httputil.SendHeaders(map[string]string{"Content-Type": "application/x-ndjson"}),
+		httputil.SendAcceptedCodes(http.StatusOK, http.StatusAccepted, http.StatusNoContent))
+	if err != nil {
+		log.With("url", s.config.URL).Warnf(
+			"Error sending %d network events: %s", len(batch), err)
+		return
+	}
+	resp.Body.Close()
+}
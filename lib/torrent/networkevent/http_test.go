@@ -0,0 +1,115 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder records batches of events POSTed by an httpSink.
+type batchRecorder struct {
+	sync.Mutex
+	batches [][]*Event
+}
+
+func (r *batchRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var batch []*Event
+	s := bufio.NewScanner(req.Body)
+	for s.Scan() {
+		e := new(Event)
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, e)
+	}
+	r.Lock()
+	r.batches = append(r.batches, batch)
+	r.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *batchRecorder) getBatches() [][]*Event {
+	r.Lock()
+	defer r.Unlock()
+	return append([][]*Event(nil), r.batches...)
+}
+
+func TestHTTPSinkSendsFullBatches(t *testing.T) {
+	require := require.New(t)
+
+	var recorder batchRecorder
+	addr, stop := testutil.StartServer(&recorder)
+	defer stop()
+
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	s := newHTTPSink(HTTPConfig{
+		URL:           "http://" + addr,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	for i := 0; i < 5; i++ {
+		s.Write(ReceivePieceEvent(h, peer1, peer2, i))
+	}
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return len(recorder.getBatches()) == 2
+	}))
+
+	// Remaining events are flushed on close.
+	require.NoError(s.Close())
+
+	batches := recorder.getBatches()
+	require.Len(batches, 3)
+	require.Equal([]int{0, 1}, pieces(batches[0]))
+	require.Equal([]int{2, 3}, pieces(batches[1]))
+	require.Equal([]int{4}, pieces(batches[2]))
+}
+
+func TestHTTPSinkFlushesPartialBatches(t *testing.T) {
+	require := require.New(t)
+
+	var recorder batchRecorder
+	addr, stop := testutil.StartServer(&recorder)
+	defer stop()
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	s := newHTTPSink(HTTPConfig{
+		URL:           "http://" + addr,
+		FlushInterval: 100 * time.Millisecond,
+	})
+	defer s.Close()
+
+	s.Write(TorrentCompleteEvent(h, peer))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return len(recorder.getBatches()) == 1
+	}))
+	require.Equal(TorrentComplete, recorder.getBatches()[0][0].Name)
+}
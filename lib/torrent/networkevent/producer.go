@@ -14,11 +14,9 @@
 package networkevent
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"os"
 
+	"github.com/uber/kraken/utils/errutil"
 	"github.com/uber/kraken/utils/log"
 )
 
@@ -28,59 +26,54 @@ type Producer interface {
 	Close() error
 }
 
+// Sink receives produced events. Sinks must not block the producer.
+type Sink interface {
+	Write(e *Event)
+	Close() error
+}
+
 type producer struct {
-	file *os.File
+	sinks []Sink
 }
 
-// NewProducer creates a new Producer.
-func NewProducer(config Config) (Producer, error) {
-	var f *os.File
+// NewProducer creates a new Producer which writes events to the sinks enabled
+// in config, plus any extra sinks.
+func NewProducer(config Config, extra ...Sink) (Producer, error) {
+	var sinks []Sink
 	if config.Enabled {
-		if config.LogPath == "" {
-			return nil, errors.New("no log path supplied")
-		}
-		var flag int
-		if _, err := os.Stat(config.LogPath); err != nil {
-			if os.IsNotExist(err) {
-				flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
-			} else {
-				return nil, fmt.Errorf("stat: %s", err)
-			}
-		} else {
-			flag = os.O_WRONLY | os.O_APPEND
-		}
-		var err error
-		f, err = os.OpenFile(config.LogPath, flag, 0775)
+		f, err := newFileSink(config.LogPath, config.Rotation)
 		if err != nil {
-			return nil, fmt.Errorf("open %d: %s", flag, err)
+			return nil, fmt.Errorf("file sink: %s", err)
 		}
-	} else {
-		log.Warn("Kafka network events disabled")
+		sinks = append(sinks, f)
+	}
+	if config.HTTP.URL != "" {
+		sinks = append(sinks, newHTTPSink(config.HTTP))
 	}
-	return &producer{f}, nil
+	if config.Collector.Addr != "" {
+		sinks = append(sinks, newCollectorStream(config.Collector))
+	}
+	sinks = append(sinks, extra...)
+	if len(sinks) == 0 {
+		log.Warn("Network events disabled")
+	}
+	return &producer{sinks}, nil
 }
 
 // Produce emits a network event.
 func (p *producer) Produce(e *Event) {
-	if p.file == nil {
-		return
-	}
-	b, err := json.Marshal(e)
-	if err != nil {
-		log.Errorf("Error serializing network event to json: %s", err)
-		return
-	}
-	line := append(b, byte('\n'))
-	if _, err := p.file.Write(line); err != nil {
-		log.Errorf("Error writing network event: %s", err)
-		return
+	for _, s := range p.sinks {
+		s.Write(e)
 	}
 }
 
 // Close closes the producer.
 func (p *producer) Close() error {
-	if p.file == nil {
-		return nil
+	var errs []error
+	for _, s := range p.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return p.file.Close()
+	return errutil.Join(errs)
 }
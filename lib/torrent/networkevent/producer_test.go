@@ -16,14 +16,19 @@ package networkevent
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 )
 
 func TestProducerCreatesAndReusesFile(t *testing.T) {
@@ -93,3 +98,90 @@ func TestDisabledProducerNoops(t *testing.T) {
 
 	p.Produce(ReceivePieceEvent(h, peer1, peer2, 1))
 }
+
+func TestProducerStreamsToCollector(t *testing.T) {
+	require := require.New(t)
+
+	received := make(chan *Event, 10)
+	mux := http.NewServeMux()
+	mux.Handle(CollectPath, websocket.Handler(func(conn *websocket.Conn) {
+		for {
+			e := new(Event)
+			if err := websocket.JSON.Receive(conn, e); err != nil {
+				return
+			}
+			received <- e
+		}
+	}))
+	addr, stop := testutil.StartServer(mux)
+	defer stop()
+
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	p, err := NewProducer(Config{Collector: CollectorConfig{Addr: addr}})
+	require.NoError(err)
+	defer p.Close()
+
+	events := []*Event{
+		AddActiveConnEvent(h, peer1, peer2),
+		ReceivePieceEvent(h, peer1, peer2, 1),
+		TorrentCompleteEvent(h, peer1),
+	}
+	for _, e := range events {
+		p.Produce(e)
+	}
+
+	var results []*Event
+	for range events {
+		select {
+		case e := <-received:
+			results = append(results, e)
+		case <-time.After(5 * time.Second):
+			require.FailNow("timed out waiting for collector")
+		}
+	}
+	require.Equal(StripTimestamps(events), StripTimestamps(results))
+}
+
+func TestCollectorStreamDropsEventsWhenBufferFull(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	// Nothing listens on the collector address.
+	s := newCollectorStream(CollectorConfig{
+		Addr:          "localhost:0",
+		BufferSize:    1,
+		RetryInterval: time.Hour,
+	})
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		s.Write(TorrentCompleteEvent(h, peer))
+	}
+	require.Equal(int64(2), s.dropped.Load())
+}
+
+func TestProducerWritesToExtraSinks(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	b := NewRingBuffer(RingBufferConfig{})
+	p, err := NewProducer(Config{}, b)
+	require.NoError(err)
+	defer p.Close()
+
+	e := AnnounceFailedEvent(h, peer1, errors.New("some error"))
+	p.Produce(e)
+	p.Produce(BlacklistExpiredEvent(h, peer1, peer2))
+
+	result := b.Query(Query{Names: []Name{AnnounceFailed}})
+	require.Equal([]*Event{e}, result)
+	require.Equal("some error", result[0].Error)
+}
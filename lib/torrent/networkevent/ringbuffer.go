@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"sync"
+	"time"
+)
+
+// Query filters the events returned by RingBuffer.
+type Query struct {
+	// Torrent restricts events to a torrent info hash, if set.
+	Torrent string
+
+	// Names restricts events to the given names, if set.
+	Names []Name
+
+	// Since restricts events to those which occurred after Since, if set.
+	Since time.Time
+
+	// Limit restricts events to the Limit most recent matches, if positive.
+	Limit int
+}
+
+func (q Query) match(e *Event) bool {
+	if q.Torrent != "" && e.Torrent != q.Torrent {
+		return false
+	}
+	if !q.Since.IsZero() && !e.Time.After(q.Since) {
+		return false
+	}
+	if len(q.Names) == 0 {
+		return true
+	}
+	for _, name := range q.Names {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RingBuffer is a Sink which keeps the most recent events in memory, such that
+// they may be queried after the fact.
+type RingBuffer struct {
+	mu     sync.Mutex
+	events []*Event
+	next   int
+	full   bool
+}
+
+// NewRingBuffer creates a new RingBuffer.
+func NewRingBuffer(config RingBufferConfig) *RingBuffer {
+	config = config.applyDefaults()
+	return &RingBuffer{events: make([]*Event, config.Size)}
+}
+
+// Write adds e to the buffer, evicting the oldest event if full.
+func (b *RingBuffer) Write(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events[b.next] = e
+	b.next = (b.next + 1) % len(b.events)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Close noops.
+func (b *RingBuffer) Close() error { return nil }
+
+// Query returns the buffered events which match q, oldest first.
+func (b *RingBuffer) Query(q Query) []*Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var result []*Event
+	// Iterate from the newest event, such that the limit is applied to the most
+	// recent matches.
+	for i := 0; i < b.len(); i++ {
+		e := b.events[(b.next-1-i+len(b.events))%len(b.events)]
+		if !q.match(e) {
+			continue
+		}
+		result = append(result, e)
+		if len(result) == q.Limit {
+			break
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+func (b *RingBuffer) len() int {
+	if b.full {
+		return len(b.events)
+	}
+	return b.next
+}
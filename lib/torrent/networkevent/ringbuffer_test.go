@@ -0,0 +1,97 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func pieces(events []*Event) []int {
+	var result []int
+	for _, e := range events {
+		result = append(result, e.Piece)
+	}
+	return result
+}
+
+func TestRingBufferEvictsOldestEvents(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	b := NewRingBuffer(RingBufferConfig{Size: 3})
+	require.Empty(b.Query(Query{}))
+
+	for i := 1; i <= 2; i++ {
+		b.Write(ReceivePieceEvent(h, peer1, peer2, i))
+	}
+	require.Equal([]int{1, 2}, pieces(b.Query(Query{})))
+
+	for i := 3; i <= 5; i++ {
+		b.Write(ReceivePieceEvent(h, peer1, peer2, i))
+	}
+	require.Equal([]int{3, 4, 5}, pieces(b.Query(Query{})))
+}
+
+func TestRingBufferQuery(t *testing.T) {
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	peer1 := core.PeerIDFixture()
+	peer2 := core.PeerIDFixture()
+
+	start := time.Now()
+	events := []*Event{
+		RequestPieceEvent(h1, peer1, peer2, 1),
+		PieceRequestTimeoutEvent(h1, peer1, peer2, 1),
+		RequestPieceEvent(h2, peer1, peer2, 2),
+		RequestPieceEvent(h1, peer1, peer2, 3),
+		ReceivePieceEvent(h1, peer1, peer2, 3),
+	}
+	for i, e := range events {
+		e.Time = start.Add(time.Duration(i) * time.Second)
+	}
+
+	tests := []struct {
+		desc     string
+		query    Query
+		expected []int
+	}{
+		{"all", Query{}, []int{1, 1, 2, 3, 3}},
+		{"torrent", Query{Torrent: h1.String()}, []int{1, 1, 3, 3}},
+		{"names", Query{Names: []Name{PieceRequestTimeout, ReceivePiece}}, []int{1, 3}},
+		{"since", Query{Since: start.Add(2 * time.Second)}, []int{3, 3}},
+		{"limit keeps most recent", Query{Limit: 2}, []int{3, 3}},
+		{
+			"combined",
+			Query{Torrent: h1.String(), Names: []Name{RequestPiece}, Limit: 1},
+			[]int{3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			b := NewRingBuffer(RingBufferConfig{})
+			for _, e := range events {
+				b.Write(e)
+			}
+			require.Equal(t, test.expected, pieces(b.Query(test.query)))
+		})
+	}
+}
@@ -85,11 +85,15 @@ func Default(
 }
 
 // Announce announces through the underlying client and returns the resulting
-// peer handout. Updates the announce interval if it has changed.
+// peer handout, excluding peers in exclude. Updates the announce interval if it
+// has changed.
 func (a *Announcer) Announce(
-	d core.Digest, h core.InfoHash, complete bool) ([]*core.PeerInfo, error) {
+	d core.Digest,
+	h core.InfoHash,
+	complete bool,
+	exclude []core.PeerID) ([]*core.PeerInfo, error) {
 
-	peers, interval, err := a.client.Announce(d, h, complete, announceclient.V1)
+	peers, interval, err := a.client.Announce(d, h, complete, announceclient.V1, exclude)
 	if err != nil {
 		return nil, err
 	}
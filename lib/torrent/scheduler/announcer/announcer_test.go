@@ -97,9 +97,10 @@ func TestAnnouncerAnnounceUpdatesInterval(t *testing.T) {
 	interval := 10 * time.Second
 	peers := []*core.PeerInfo{core.PeerInfoFixture()}
 
-	mocks.client.EXPECT().Announce(d, hash, false, announceclient.V1).Return(peers, interval, nil)
+	mocks.client.EXPECT().Announce(
+		d, hash, false, announceclient.V1, nil).Return(peers, interval, nil)
 
-	result, err := announcer.Announce(d, hash, false)
+	result, err := announcer.Announce(d, hash, false, nil)
 	require.NoError(err)
 	require.Equal(peers, result)
 
@@ -129,8 +130,9 @@ func TestAnnouncerAnnounceErr(t *testing.T) {
 	hash := core.InfoHashFixture()
 	err := errors.New("some error")
 
-	mocks.client.EXPECT().Announce(d, hash, false, announceclient.V1).Return(nil, time.Duration(0), err)
+	mocks.client.EXPECT().Announce(
+		d, hash, false, announceclient.V1, nil).Return(nil, time.Duration(0), err)
 
-	_, aErr := announcer.Announce(d, hash, false)
+	_, aErr := announcer.Announce(d, hash, false, nil)
 	require.Equal(err, aErr)
 }
@@ -20,6 +20,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
 	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/memsize"
 )
 
 // Config is the Scheduler configuration.
@@ -54,6 +55,15 @@ type Config struct {
 
 	ProbeTimeout time.Duration `yaml:"probe_timeout"`
 
+	// UseObservedIP announces the address which the tracker observes announces
+	// coming from instead of the configured peer ip. Enable for agents behind
+	// a NAT.
+	UseObservedIP bool `yaml:"use_observed_ip"`
+
+	Restore RestoreConfig `yaml:"restore"`
+
+	Rebalance RebalanceConfig `yaml:"rebalance"`
+
 	ConnState connstate.Config `yaml:"connstate"`
 
 	Conn conn.Config `yaml:"conn"`
@@ -86,5 +96,86 @@ func (c Config) applyDefaults() Config {
 	if c.ProbeTimeout == 0 {
 		c.ProbeTimeout = 3 * time.Second
 	}
+	c.Restore = c.Restore.applyDefaults()
+	c.Rebalance = c.Rebalance.applyDefaults()
+	return c
+}
+
+// RestoreConfig defines how torrents persisted on disk by a previous run are
+// restored when the Scheduler starts.
+type RestoreConfig struct {
+
+	// Disable disables restoring torrents on startup.
+	Disable bool `yaml:"disable"`
+
+	// MaxTorrents is the max number of torrents restored on startup. In-progress
+	// torrents are restored first, followed by completed torrents in order of
+	// most recent access.
+	MaxTorrents int `yaml:"max_torrents"`
+
+	// MaxIdleTime is the max duration since a completed torrent was last
+	// accessed for it to be restored as a seeder.
+	MaxIdleTime time.Duration `yaml:"max_idle_time"`
+}
+
+func (c RestoreConfig) applyDefaults() RestoreConfig {
+	if c.MaxTorrents == 0 {
+		c.MaxTorrents = 100
+	}
+	if c.MaxIdleTime == 0 {
+		c.MaxIdleTime = 24 * time.Hour
+	}
+	return c
+}
+
+// RebalanceConfig defines periodic rebalancing of the swarms of large torrents.
+// Late joiners to large torrents tend to form unbalanced topologies, where
+// early peers are saturated with each other. Rebalancing drops and
+// re-establishes conns such that each swarm converges toward a random regular
+// graph of degree TargetDegree.
+type RebalanceConfig struct {
+
+	// Enable enables rebalancing.
+	Enable bool `yaml:"enable"`
+
+	// MinTorrentSize is the min size of torrents whose swarms are rebalanced.
+	MinTorrentSize uint64 `yaml:"min_torrent_size"`
+
+	// TargetDegree is the number of conns each torrent converges toward. Should
+	// be lower than the max open conns per torrent, leaving capacity for
+	// incoming conns from late joiners.
+	TargetDegree int `yaml:"target_degree"`
+
+	// Interval is the interval in which swarms are rebalanced.
+	Interval time.Duration `yaml:"interval"`
+
+	// MinConnAge is the min age of conns which may be dropped, giving new conns
+	// time to become productive.
+	MinConnAge time.Duration `yaml:"min_conn_age"`
+}
+
+// drops returns the number of conns to drop from a torrent with open conns, of
+// which droppable are old enough to be dropped.
+func (c RebalanceConfig) drops(open, droppable int) int {
+	n := open - c.TargetDegree
+	if n > droppable {
+		n = droppable
+	}
+	return n
+}
+
+func (c RebalanceConfig) applyDefaults() RebalanceConfig {
+	if c.MinTorrentSize == 0 {
+		c.MinTorrentSize = 10 * memsize.GB
+	}
+	if c.TargetDegree == 0 {
+		c.TargetDegree = 5
+	}
+	if c.Interval == 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.MinConnAge == 0 {
+		c.MinConnAge = time.Minute
+	}
 	return c
 }
@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"fmt"
+
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// _zstd is the only supported piece payload compression algorithm.
+const _zstd = "zstd"
+
+// Upper bound on memory used to decompress a single piece, which protects
+// against malicious payloads which decompress to huge sizes.
+const _maxDecompressedPieceSize = 512 * memsize.MB
+
+// CompressionConfig defines piece payload compression.
+type CompressionConfig struct {
+	// Enabled compresses piece payloads sent to peers which support it.
+	// Compressed payloads are always accepted from peers, regardless of Enabled.
+	Enabled bool `yaml:"enabled"`
+
+	// Level is the zstd compression level, from 1 (fastest) to 22 (smallest).
+	Level int `yaml:"level"`
+
+	// MaxRatio is the highest compressed to uncompressed size ratio at which
+	// a piece is sent compressed. Pieces which compress worse are sent as is,
+	// to save the receiver from decompressing them.
+	MaxRatio float64 `yaml:"max_ratio"`
+}
+
+func (c CompressionConfig) applyDefaults() CompressionConfig {
+	if c.Level == 0 {
+		c.Level = 1
+	}
+	if c.MaxRatio == 0 {
+		c.MaxRatio = 0.9
+	}
+	return c
+}
+
+// pieceCodec compresses and decompresses piece payloads. Safe for concurrent
+// use by multiple Conns.
+type pieceCodec struct {
+	config  CompressionConfig
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newPieceCodec(config CompressionConfig) (*pieceCodec, error) {
+	encoder, err := zstd.NewWriter(
+		nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(config.Level)))
+	if err != nil {
+		return nil, fmt.Errorf("zstd encoder: %s", err)
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(_maxDecompressedPieceSize))
+	if err != nil {
+		return nil, fmt.Errorf("zstd decoder: %s", err)
+	}
+	return &pieceCodec{config, encoder, decoder}, nil
+}
+
+// negotiate returns the algorithm to compress payloads sent to a peer which
+// supports the given algorithms, or empty string if payloads should not be
+// compressed.
+func (c *pieceCodec) negotiate(remote []string) string {
+	if !c.config.Enabled {
+		return ""
+	}
+	for _, algo := range remote {
+		if algo == _zstd {
+			return _zstd
+		}
+	}
+	return ""
+}
+
+// compress returns the compressed piece, and whether it compressed well enough
+// to be worth sending compressed.
+func (c *pieceCodec) compress(piece []byte) ([]byte, bool) {
+	b := c.encoder.EncodeAll(piece, make([]byte, 0, len(piece)))
+	return b, float64(len(b)) <= c.config.MaxRatio*float64(len(piece))
+}
+
+// decompress decompresses a payload compressed with algo into a piece of the
+// given length.
+func (c *pieceCodec) decompress(algo string, payload []byte, length int32) ([]byte, error) {
+	if algo != _zstd {
+		return nil, fmt.Errorf("unsupported compression %q", algo)
+	}
+	piece, err := c.decoder.DecodeAll(payload, make([]byte, 0, length))
+	if err != nil {
+		return nil, err
+	}
+	if len(piece) != int(length) {
+		return nil, fmt.Errorf("decompressed %d bytes, expected %d", len(piece), length)
+	}
+	return piece, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/bitsetutil"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compressionConfigFixture() Config {
+	config := ConfigFixture()
+	config.Compression.Enabled = true
+	return config
+}
+
+func TestPieceCodecNegotiate(t *testing.T) {
+	tests := []struct {
+		desc     string
+		enabled  bool
+		remote   []string
+		expected string
+	}{
+		{"enabled and supported", true, []string{"gzip", _zstd}, _zstd},
+		{"enabled and unsupported", true, []string{"gzip"}, ""},
+		{"enabled and old peer", true, nil, ""},
+		{"disabled", false, []string{_zstd}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			config := CompressionConfig{Enabled: test.enabled}.applyDefaults()
+			codec, err := newPieceCodec(config)
+			require.NoError(err)
+
+			require.Equal(test.expected, codec.negotiate(test.remote))
+		})
+	}
+}
+
+func TestPieceCodecRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	codec, err := newPieceCodec(CompressionConfig{Enabled: true}.applyDefaults())
+	require.NoError(err)
+
+	piece := bytes.Repeat([]byte("kraken"), 1000)
+
+	compressed, ok := codec.compress(piece)
+	require.True(ok)
+	require.True(len(compressed) < len(piece))
+
+	result, err := codec.decompress(_zstd, compressed, int32(len(piece)))
+	require.NoError(err)
+	require.Equal(piece, result)
+}
+
+func TestPieceCodecSkipsIncompressiblePieces(t *testing.T) {
+	require := require.New(t)
+
+	codec, err := newPieceCodec(CompressionConfig{Enabled: true}.applyDefaults())
+	require.NoError(err)
+
+	_, ok := codec.compress(randutil.Blob(4096))
+	require.False(ok)
+}
+
+func TestPieceCodecDecompressErrors(t *testing.T) {
+	require := require.New(t)
+
+	codec, err := newPieceCodec(CompressionConfig{Enabled: true}.applyDefaults())
+	require.NoError(err)
+
+	piece := bytes.Repeat([]byte("kraken"), 1000)
+	compressed, _ := codec.compress(piece)
+
+	_, err = codec.decompress("gzip", compressed, int32(len(piece)))
+	require.Error(err)
+
+	_, err = codec.decompress(_zstd, compressed, int32(len(piece)+1))
+	require.Error(err)
+
+	_, err = codec.decompress(_zstd, []byte("garbage"), int32(len(piece)))
+	require.Error(err)
+}
+
+func sendAndReceivePiece(t *testing.T, config Config, piece []byte) *p2p.PiecePayloadMessage {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(1, uint64(len(piece)))
+	local, remote, cleanup := PipeFixture(config, info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(piece))))
+
+	select {
+	case msg := <-remote.Receiver():
+		require.Equal(p2p.Message_PIECE_PAYLOAD, msg.Message.Type)
+		result, err := ioutil.ReadAll(msg.Payload)
+		require.NoError(err)
+		require.Equal(piece, result)
+		return msg.Message.PiecePayload
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for piece")
+	}
+	return nil
+}
+
+func TestConnSendsCompressedPiece(t *testing.T) {
+	require := require.New(t)
+
+	piece := bytes.Repeat([]byte("kraken"), 1000)
+
+	pm := sendAndReceivePiece(t, compressionConfigFixture(), piece)
+	require.Equal(_zstd, pm.Compression)
+	require.True(int(pm.CompressedLength) < len(piece))
+	require.Equal(int32(len(piece)), pm.Length)
+}
+
+func TestConnSendsIncompressiblePieceUncompressed(t *testing.T) {
+	require := require.New(t)
+
+	pm := sendAndReceivePiece(t, compressionConfigFixture(), randutil.Blob(4096))
+	require.Empty(pm.Compression)
+}
+
+func TestConnCompressionDisabled(t *testing.T) {
+	require := require.New(t)
+
+	pm := sendAndReceivePiece(t, ConfigFixture(), bytes.Repeat([]byte("kraken"), 1000))
+	require.Empty(pm.Compression)
+}
+
+func TestConnRejectsInvalidCompressedLength(t *testing.T) {
+	require := require.New(t)
+
+	c, cleanup := Fixture()
+	defer cleanup()
+
+	_, err := c.readPiecePayload(nil, &p2p.PiecePayloadMessage{
+		Length:           10,
+		Compression:      _zstd,
+		CompressedLength: 11,
+	})
+	require.Error(err)
+}
+
+func TestHandshakerNegotiatesCompression(t *testing.T) {
+	require := require.New(t)
+
+	h := HandshakerFixture(compressionConfigFixture())
+
+	hs := &handshake{
+		peerID:      core.PeerIDFixture(),
+		digest:      core.DigestFixture(),
+		infoHash:    core.InfoHashFixture(),
+		bitfield:    bitsetutil.FromBools(true),
+		compression: []string{_zstd},
+	}
+	msg, err := hs.toP2PMessage()
+	require.NoError(err)
+	result, err := handshakeFromP2PMessage(msg)
+	require.NoError(err)
+
+	require.Equal(_zstd, h.codec.negotiate(result.compression))
+}
@@ -38,6 +38,17 @@ type Config struct {
 	ReceiverBufferSize int `yaml:"receiver_buffer_size"`
 
 	Bandwidth bandwidth.Config `yaml:"bandwidth"`
+
+	// Compression configures compression of piece payloads.
+	Compression CompressionConfig `yaml:"compression"`
+
+	// Transport is the transport used to dial peers, either "tcp" or "quic".
+	// Peers always accept TCP connections, and dialing over QUIC falls back to
+	// TCP for peers which do not accept QUIC, so both transports can be used
+	// in the same cluster.
+	Transport string `yaml:"transport"`
+
+	QUIC QUICConfig `yaml:"quic"`
 }
 
 func (c Config) applyDefaults() Config {
@@ -56,5 +67,10 @@ func (c Config) applyDefaults() Config {
 	if c.Bandwidth.IngressBitsPerSec == 0 {
 		c.Bandwidth.IngressBitsPerSec = 300 * 8 * memsize.Mbit
 	}
+	c.Compression = c.Compression.applyDefaults()
+	if c.Transport == "" {
+		c.Transport = TCP
+	}
+	c.QUIC = c.QUIC.applyDefaults()
 	return c
 }
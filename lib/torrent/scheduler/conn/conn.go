@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"sync"
 	"time"
@@ -38,6 +39,13 @@ import (
 // Maximum support protocol message size. Does not include piece payload.
 const maxMessageSize = 32 * memsize.KB
 
+// pieceStreamer is implemented by connections which send each piece payload
+// on its own stream, rather than on the connection itself.
+type pieceStreamer interface {
+	openPieceStream() (io.WriteCloser, error)
+	acceptPieceStream() (io.Reader, error)
+}
+
 // Events defines Conn events.
 type Events interface {
 	ConnClosed(*Conn)
@@ -51,6 +59,11 @@ type Conn struct {
 	createdAt   time.Time
 	localPeerID core.PeerID
 	bandwidth   *bandwidth.Limiter
+	codec       *pieceCodec
+
+	// Algorithm for compressing sent piece payloads. Empty if the remote peer
+	// does not support compression or compression is disabled.
+	compression string
 
 	events Events
 
@@ -59,6 +72,7 @@ type Conn struct {
 	lastPieceSent         time.Time
 
 	nc            net.Conn
+	streams       pieceStreamer // Nil if payloads are sent on nc.
 	config        Config
 	clk           clock.Clock
 	stats         tally.Scope
@@ -73,9 +87,10 @@ type Conn struct {
 	receiver chan *Message
 
 	// The following fields orchestrate the closing of the connection:
-	closed *atomic.Bool
-	done   chan struct{}  // Signals to readLoop / writeLoop to exit.
-	wg     sync.WaitGroup // Waits for readLoop / writeLoop to exit.
+	closed  *atomic.Bool
+	done    chan struct{}  // Signals to readLoop / writeLoop to exit.
+	wg      sync.WaitGroup // Waits for readLoop / writeLoop to exit.
+	readers sync.WaitGroup // Waits for piece stream readers to exit.
 
 	logger *zap.SugaredLogger
 }
@@ -86,6 +101,8 @@ func newConn(
 	clk clock.Clock,
 	networkEvents networkevent.Producer,
 	bandwidth *bandwidth.Limiter,
+	codec *pieceCodec,
+	compression string,
 	events Events,
 	nc net.Conn,
 	localPeerID core.PeerID,
@@ -106,6 +123,8 @@ func newConn(
 		createdAt:      clk.Now(),
 		localPeerID:    localPeerID,
 		bandwidth:      bandwidth,
+		codec:          codec,
+		compression:    compression,
 		events:         events,
 		nc:             nc,
 		config:         config,
@@ -119,6 +138,9 @@ func newConn(
 		done:           make(chan struct{}),
 		logger:         logger,
 	}
+	if s, ok := nc.(pieceStreamer); ok {
+		c.streams = s
+	}
 
 	return c, nil
 }
@@ -128,6 +150,10 @@ func newConn(
 // socket.
 func (c *Conn) Start() {
 	c.startOnce.Do(func() {
+		if c.streams != nil {
+			c.readers.Add(1)
+			go c.acceptPieceStreamsLoop()
+		}
 		c.wg.Add(2)
 		go c.readLoop()
 		go c.writeLoop()
@@ -194,21 +220,44 @@ func (c *Conn) IsClosed() bool {
 	return c.closed.Load()
 }
 
-func (c *Conn) readPayload(length int32) ([]byte, error) {
+func (c *Conn) readPayload(r io.Reader, length int32) ([]byte, error) {
 	if err := c.bandwidth.ReserveIngress(int64(length)); err != nil {
 		c.log().Errorf("Error reserving ingress bandwidth for piece payload: %s", err)
 		return nil, fmt.Errorf("ingress bandwidth: %s", err)
 	}
 	payload := make([]byte, length)
-	if _, err := io.ReadFull(c.nc, payload); err != nil {
+	if _, err := io.ReadFull(r, payload); err != nil {
 		return nil, err
 	}
 	c.countBandwidth("ingress", int64(8*length))
 	return payload, nil
 }
 
-func (c *Conn) readMessage() (*Message, error) {
-	p2pMessage, err := readMessage(c.nc)
+// readPiecePayload reads the payload of pm from r, decompressing it if needed.
+func (c *Conn) readPiecePayload(r io.Reader, pm *p2p.PiecePayloadMessage) ([]byte, error) {
+	if pm.Compression == "" {
+		return c.readPayload(r, pm.Length)
+	}
+	// Compressed payloads are only sent if smaller than the piece.
+	if pm.CompressedLength <= 0 || pm.CompressedLength > pm.Length {
+		return nil, fmt.Errorf(
+			"invalid compressed length %d for piece of length %d", pm.CompressedLength, pm.Length)
+	}
+	payload, err := c.readPayload(r, pm.CompressedLength)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	piece, err := c.codec.decompress(pm.Compression, payload, pm.Length)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %s", err)
+	}
+	c.stats.Timer("piece_decompress_time").Record(time.Since(start))
+	return piece, nil
+}
+
+func (c *Conn) readMessage(r io.Reader) (*Message, error) {
+	p2pMessage, err := readMessage(r)
 	if err != nil {
 		return nil, fmt.Errorf("read message: %s", err)
 	}
@@ -216,7 +265,7 @@ func (c *Conn) readMessage() (*Message, error) {
 	if p2pMessage.Type == p2p.Message_PIECE_PAYLOAD {
 		// For payload messages, we must read the actual payload to the connection
 		// after reading the message.
-		payload, err := c.readPayload(p2pMessage.PiecePayload.Length)
+		payload, err := c.readPiecePayload(r, p2pMessage.PiecePayload)
 		if err != nil {
 			return nil, fmt.Errorf("read payload: %s", err)
 		}
@@ -231,9 +280,11 @@ func (c *Conn) readMessage() (*Message, error) {
 // receiver channel.
 func (c *Conn) readLoop() {
 	defer func() {
+		c.Close()
+		// Piece stream readers may still be sending to the receiver.
+		c.readers.Wait()
 		close(c.receiver)
 		c.wg.Done()
-		c.Close()
 	}()
 
 	for {
@@ -241,7 +292,7 @@ func (c *Conn) readLoop() {
 		case <-c.done:
 			return
 		default:
-			msg, err := c.readMessage()
+			msg, err := c.readMessage(c.nc)
 			if err != nil {
 				c.log().Infof("Error reading message from socket, exiting read loop: %s", err)
 				return
@@ -251,7 +302,45 @@ func (c *Conn) readLoop() {
 	}
 }
 
-func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
+// acceptPieceStreamsLoop reads piece payloads off of streams opened by the
+// remote peer and sends them to the receiver channel.
+func (c *Conn) acceptPieceStreamsLoop() {
+	defer func() {
+		c.readers.Done()
+		c.Close()
+	}()
+
+	for {
+		s, err := c.streams.acceptPieceStream()
+		if err != nil {
+			c.log().Infof("Error accepting piece stream, exiting accept loop: %s", err)
+			return
+		}
+		c.readers.Add(1)
+		go func() {
+			defer c.readers.Done()
+
+			msg, err := c.readMessage(s)
+			if err != nil {
+				c.log().Infof("Error reading message from piece stream, closing conn: %s", err)
+				c.Close()
+				return
+			}
+			if msg.Message.Type != p2p.Message_PIECE_PAYLOAD {
+				c.log().Infof(
+					"Unexpected %s message on piece stream, closing conn", msg.Message.Type)
+				c.Close()
+				return
+			}
+			select {
+			case c.receiver <- msg:
+			case <-c.done:
+			}
+		}()
+	}
+}
+
+func (c *Conn) sendPiecePayload(w io.Writer, pr storage.PieceReader) error {
 	defer pr.Close()
 
 	if err := c.bandwidth.ReserveEgress(int64(pr.Length())); err != nil {
@@ -259,7 +348,7 @@ func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
 		c.log().Errorf("Error reserving egress bandwidth for piece payload: %s", err)
 		return fmt.Errorf("egress bandwidth: %s", err)
 	}
-	n, err := io.Copy(c.nc, pr)
+	n, err := io.Copy(w, pr)
 	if err != nil {
 		return fmt.Errorf("copy to socket: %s", err)
 	}
@@ -267,14 +356,78 @@ func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
 	return nil
 }
 
+// sendCompressedPiecePayload sends a piece payload message, compressing the
+// payload unless the piece does not compress well.
+func (c *Conn) sendCompressedPiecePayload(w io.Writer, msg *Message) error {
+	raw, err := ioutil.ReadAll(msg.Payload)
+	msg.Payload.Close()
+	if err != nil {
+		return fmt.Errorf("read piece: %s", err)
+	}
+
+	start := time.Now()
+	compressed, ok := c.codec.compress(raw)
+	c.stats.Timer("piece_compress_time").Record(time.Since(start))
+
+	// Copy the message, since it is owned by the caller.
+	pm := *msg.Message.PiecePayload
+	payload := raw
+	if ok {
+		pm.Compression = c.compression
+		pm.CompressedLength = int32(len(compressed))
+		payload = compressed
+	} else {
+		c.stats.Counter("piece_compression_skipped").Inc(1)
+	}
+	c.stats.Counter("piece_compression_raw_bytes").Inc(int64(len(raw)))
+	c.stats.Counter("piece_compression_wire_bytes").Inc(int64(len(payload)))
+
+	m := *msg.Message
+	m.PiecePayload = &pm
+	if err := sendMessage(w, &m); err != nil {
+		return fmt.Errorf("send message: %s", err)
+	}
+	if err := c.sendPiecePayload(w, piecereader.NewBuffer(payload)); err != nil {
+		return fmt.Errorf("send piece payload: %s", err)
+	}
+	return nil
+}
+
 func (c *Conn) sendMessage(msg *Message) error {
-	if err := sendMessage(c.nc, msg.Message); err != nil {
+	if msg.Message.Type == p2p.Message_PIECE_PAYLOAD && c.streams != nil {
+		return c.sendPieceOnStream(msg)
+	}
+	return c.sendMessageTo(c.nc, msg)
+}
+
+// sendPieceOnStream sends a piece payload message on a new stream.
+func (c *Conn) sendPieceOnStream(msg *Message) error {
+	s, err := c.streams.openPieceStream()
+	if err != nil {
+		msg.Payload.Close()
+		return fmt.Errorf("open piece stream: %s", err)
+	}
+	if err := c.sendMessageTo(s, msg); err != nil {
+		s.Close()
+		return err
+	}
+	if err := s.Close(); err != nil {
+		return fmt.Errorf("close piece stream: %s", err)
+	}
+	return nil
+}
+
+func (c *Conn) sendMessageTo(w io.Writer, msg *Message) error {
+	if msg.Message.Type == p2p.Message_PIECE_PAYLOAD && c.compression != "" {
+		return c.sendCompressedPiecePayload(w, msg)
+	}
+	if err := sendMessage(w, msg.Message); err != nil {
 		return fmt.Errorf("send message: %s", err)
 	}
 	if msg.Message.Type == p2p.Message_PIECE_PAYLOAD {
 		// For payload messages, we must write the actual payload to the connection
 		// after writing the message.
-		if err := c.sendPiecePayload(msg.Payload); err != nil {
+		if err := c.sendPiecePayload(w, msg.Payload); err != nil {
 			return fmt.Errorf("send piece payload: %s", err)
 		}
 	}
@@ -54,15 +54,21 @@ func PipeFixture(
 
 	var err error
 
+	// Both sides of the pipe support compression.
+	var compression string
+	if config.Compression.Enabled {
+		compression = _zstd
+	}
+
 	local, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc1}, core.PeerIDFixture(), info, false)
+		noopDeadline{nc1}, core.PeerIDFixture(), info, false, compression)
 	if err != nil {
 		panic(err)
 	}
 	local.Start()
 
 	remote, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc2}, core.PeerIDFixture(), info, true)
+		noopDeadline{nc2}, core.PeerIDFixture(), info, true, compression)
 	if err != nil {
 		panic(err)
 	}
@@ -17,6 +17,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/gen/go/proto/p2p"
@@ -70,6 +72,7 @@ type handshake struct {
 	bitfield        *bitset.BitSet
 	remoteBitfields RemoteBitfields
 	namespace       string
+	compression     []string
 }
 
 func (h *handshake) toP2PMessage() (*p2p.Message, error) {
@@ -90,6 +93,7 @@ func (h *handshake) toP2PMessage() (*p2p.Message, error) {
 			BitfieldBytes:       b,
 			RemoteBitfieldBytes: rb,
 			Namespace:           h.namespace,
+			Compression:         h.compression,
 		},
 	}, nil
 }
@@ -130,6 +134,7 @@ func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 		digest:          d,
 		namespace:       bitfieldMsg.Namespace,
 		remoteBitfields: remoteBitfields,
+		compression:     bitfieldMsg.Compression,
 	}, nil
 }
 
@@ -192,6 +197,12 @@ type Handshaker struct {
 	networkEvents networkevent.Producer
 	peerID        core.PeerID
 	events        Events
+	codec         *pieceCodec
+
+	mu sync.Mutex
+	// Addresses of peers which could not be dialed over QUIC, mapped to the
+	// time until which they are dialed over TCP.
+	quicFallbacks map[string]time.Time
 }
 
 // NewHandshaker creates a new Handshaker.
@@ -215,6 +226,11 @@ func NewHandshaker(
 		return nil, fmt.Errorf("bandwidth: %s", err)
 	}
 
+	codec, err := newPieceCodec(config.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("piece codec: %s", err)
+	}
+
 	return &Handshaker{
 		config:        config,
 		stats:         stats,
@@ -223,6 +239,8 @@ func NewHandshaker(
 		networkEvents: networkEvents,
 		peerID:        peerID,
 		events:        events,
+		codec:         codec,
+		quicFallbacks: make(map[string]time.Time),
 	}, nil
 }
 
@@ -248,7 +266,8 @@ func (h *Handshaker) Establish(
 	if err := h.sendHandshake(pc.nc, info, remoteBitfields, ""); err != nil {
 		return nil, fmt.Errorf("send handshake: %s", err)
 	}
-	c, err := h.newConn(pc.nc, pc.handshake.peerID, info, true)
+	c, err := h.newConn(
+		pc.nc, pc.handshake.peerID, info, true, h.codec.negotiate(pc.handshake.compression))
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -265,7 +284,7 @@ func (h *Handshaker) Initialize(
 	remoteBitfields RemoteBitfields,
 	namespace string) (*HandshakeResult, error) {
 
-	nc, err := net.DialTimeout("tcp", addr, h.config.HandshakeTimeout)
+	nc, err := h.dial(addr)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %s", err)
 	}
@@ -277,6 +296,38 @@ func (h *Handshaker) Initialize(
 	return r, nil
 }
 
+// dial opens a connection to addr over the configured transport. Peers which
+// cannot be dialed over QUIC, e.g. because they only accept TCP, are dialed
+// over TCP instead.
+func (h *Handshaker) dial(addr string) (net.Conn, error) {
+	if h.config.Transport == QUIC && !h.inQUICFallback(addr) {
+		nc, err := dialQUIC(h.config, addr)
+		if err == nil {
+			return nc, nil
+		}
+		h.stats.Counter("quic_dial_fallbacks").Inc(1)
+		h.mu.Lock()
+		h.quicFallbacks[addr] = h.clk.Now().Add(h.config.QUIC.FallbackTTL)
+		h.mu.Unlock()
+	}
+	return net.DialTimeout("tcp", addr, h.config.HandshakeTimeout)
+}
+
+func (h *Handshaker) inQUICFallback(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	until, ok := h.quicFallbacks[addr]
+	if !ok {
+		return false
+	}
+	if h.clk.Now().After(until) {
+		delete(h.quicFallbacks, addr)
+		return false
+	}
+	return true
+}
+
 func (h *Handshaker) sendHandshake(
 	nc net.Conn,
 	info *storage.TorrentInfo,
@@ -290,6 +341,8 @@ func (h *Handshaker) sendHandshake(
 		bitfield:        info.Bitfield(),
 		remoteBitfields: remoteBitfields,
 		namespace:       namespace,
+		// Compressed payloads can always be received.
+		compression: []string{_zstd},
 	}
 	msg, err := hs.toP2PMessage()
 	if err != nil {
@@ -327,7 +380,7 @@ func (h *Handshaker) fullHandshake(
 	if hs.peerID != peerID {
 		return nil, errors.New("unexpected peer id")
 	}
-	c, err := h.newConn(nc, peerID, info, false)
+	c, err := h.newConn(nc, peerID, info, false, h.codec.negotiate(hs.compression))
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -338,7 +391,8 @@ func (h *Handshaker) newConn(
 	nc net.Conn,
 	peerID core.PeerID,
 	info *storage.TorrentInfo,
-	openedByRemote bool) (*Conn, error) {
+	openedByRemote bool,
+	compression string) (*Conn, error) {
 
 	return newConn(
 		h.config,
@@ -346,6 +400,8 @@ func (h *Handshaker) newConn(
 		h.clk,
 		h.networkEvents,
 		h.bandwidth,
+		h.codec,
+		compression,
 		h.events,
 		nc,
 		h.peerID,
@@ -96,7 +96,7 @@ func NewCompleteMessage() *Message {
 	}
 }
 
-func sendMessage(nc net.Conn, msg *p2p.Message) error {
+func sendMessage(nc io.Writer, msg *p2p.Message) error {
 	data, err := proto.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("proto marshal: %s", err)
@@ -123,7 +123,7 @@ func sendMessageWithTimeout(nc net.Conn, msg *p2p.Message, timeout time.Duration
 	return sendMessage(nc, msg)
 }
 
-func readMessage(nc net.Conn) (*p2p.Message, error) {
+func readMessage(nc io.Reader) (*p2p.Message, error) {
 	var msglen [4]byte
 	if _, err := io.ReadFull(nc, msglen[:]); err != nil {
 		return nil, fmt.Errorf("read message length: %s", err)
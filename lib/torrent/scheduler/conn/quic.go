@@ -0,0 +1,275 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Transports over which connections to peers can be opened.
+const (
+	TCP  = "tcp"
+	QUIC = "quic"
+)
+
+// ALPN protocol negotiated by QUIC connections between peers.
+const _quicProtocol = "kraken-p2p"
+
+// QUICConfig defines the QUIC transport.
+type QUICConfig struct {
+	// MaxIdleTimeout is the duration after which a QUIC connection with no
+	// network activity is closed.
+	MaxIdleTimeout time.Duration `yaml:"max_idle_timeout"`
+
+	// KeepAlivePeriod is the interval at which keep-alive packets are sent
+	// to prevent idle connections from timing out.
+	KeepAlivePeriod time.Duration `yaml:"keep_alive_period"`
+
+	// MaxPieceStreams is the maximum number of piece payloads a remote peer
+	// may send concurrently, each of which occupies its own stream.
+	MaxPieceStreams int64 `yaml:"max_piece_streams"`
+
+	// FallbackTTL is how long a peer which could not be dialed over QUIC is
+	// dialed over TCP, before QUIC is attempted again.
+	FallbackTTL time.Duration `yaml:"fallback_ttl"`
+}
+
+func (c QUICConfig) applyDefaults() QUICConfig {
+	if c.MaxIdleTimeout == 0 {
+		c.MaxIdleTimeout = 30 * time.Second
+	}
+	if c.KeepAlivePeriod == 0 {
+		c.KeepAlivePeriod = 10 * time.Second
+	}
+	if c.MaxPieceStreams == 0 {
+		c.MaxPieceStreams = 1000
+	}
+	if c.FallbackTTL == 0 {
+		c.FallbackTTL = 10 * time.Minute
+	}
+	return c
+}
+
+func (c Config) quicConfig() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout:  c.HandshakeTimeout,
+		MaxIdleTimeout:        c.QUIC.MaxIdleTimeout,
+		KeepAlivePeriod:       c.QUIC.KeepAlivePeriod,
+		MaxIncomingStreams:    1,
+		MaxIncomingUniStreams: c.QUIC.MaxPieceStreams,
+	}
+}
+
+// Listen listens for connections from peers on addr. A TCP listener is always
+// returned so peers which only support TCP can connect. If config.Transport
+// is QUIC, a QUIC listener on the same port is returned as well.
+func Listen(config Config, addr string) ([]net.Listener, error) {
+	config = config.applyDefaults()
+
+	tl, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.Transport != QUIC {
+		return []net.Listener{tl}, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		tl.Close()
+		return nil, fmt.Errorf("split host port: %s", err)
+	}
+	// Reuse the TCP port, in case addr used a random port.
+	port := tl.Addr().(*net.TCPAddr).Port
+	ql, err := listenQUIC(config, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		tl.Close()
+		return nil, fmt.Errorf("quic: %s", err)
+	}
+	return []net.Listener{tl, ql}, nil
+}
+
+// quicListener adapts a QUIC listener to net.Listener, returning the control
+// stream of each accepted connection.
+type quicListener struct {
+	config Config
+	ln     *quic.Listener
+	conns  chan net.Conn
+	done   chan struct{}
+	err    error // Set before done is closed.
+}
+
+func listenQUIC(config Config, addr string) (*quicListener, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, fmt.Errorf("cert: %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{_quicProtocol},
+	}
+	ln, err := quic.ListenAddr(addr, tlsConfig, config.quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	l := &quicListener{
+		config: config,
+		ln:     ln,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// acceptLoop accepts QUIC connections until the listener is closed. The
+// control stream of each connection is awaited in its own goroutine, such that
+// peers which never open one do not block others.
+func (l *quicListener) acceptLoop() {
+	for {
+		qc, err := l.ln.Accept(context.Background())
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.acceptControlStream(qc)
+	}
+}
+
+func (l *quicListener) acceptControlStream(qc quic.Connection) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.HandshakeTimeout)
+	defer cancel()
+
+	s, err := qc.AcceptStream(ctx)
+	if err != nil {
+		qc.CloseWithError(0, "")
+		return
+	}
+	select {
+	case l.conns <- &quicConn{s, qc}:
+	case <-l.done:
+		qc.CloseWithError(0, "")
+	}
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.conns:
+		return nc, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener. Unlike TCP, this also closes all connections
+// accepted by the listener, since they share its socket.
+func (l *quicListener) Close() error {
+	return l.ln.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func dialQUIC(config Config, addr string) (net.Conn, error) {
+	tlsConfig := &tls.Config{
+		// Peers are authenticated by the p2p handshake, the same as over TCP.
+		// TLS is only required by QUIC to encrypt the connection.
+		InsecureSkipVerify: true,
+		NextProtos:         []string{_quicProtocol},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.HandshakeTimeout)
+	defer cancel()
+
+	qc, err := quic.DialAddr(ctx, addr, tlsConfig, config.quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	s, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		qc.CloseWithError(0, "")
+		return nil, fmt.Errorf("open stream: %s", err)
+	}
+	return &quicConn{s, qc}, nil
+}
+
+// quicConn is a net.Conn over the control stream of a QUIC connection, on
+// which all messages except piece payloads are sent. Piece payloads are sent
+// on their own unidirectional streams, so that loss of a packet only blocks
+// the piece it belongs to.
+type quicConn struct {
+	quic.Stream
+	qc quic.Connection
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.qc.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.qc.RemoteAddr()
+}
+
+// Close closes the entire connection, including all streams.
+func (c *quicConn) Close() error {
+	return c.qc.CloseWithError(0, "")
+}
+
+func (c *quicConn) openPieceStream() (io.WriteCloser, error) {
+	return c.qc.OpenUniStreamSync(context.Background())
+}
+
+func (c *quicConn) acceptPieceStream() (io.Reader, error) {
+	return c.qc.AcceptUniStream(context.Background())
+}
+
+// selfSignedCert generates a certificate for the QUIC listener. Peers do not
+// verify it, so there is no point in configuring a real one.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("serial: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: _quicProtocol},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("create certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+)
+
+func quicConfigFixture() Config {
+	config := ConfigFixture()
+	config.Transport = QUIC
+	config.HandshakeTimeout = time.Second
+	return config
+}
+
+// listenerFor returns the listener of the given network out of listeners.
+func listenerFor(t *testing.T, listeners []net.Listener, network string) net.Listener {
+	for _, l := range listeners {
+		if l.Addr().Network() == network {
+			return l
+		}
+	}
+	require.FailNow(t, "no listener for network", network)
+	return nil
+}
+
+// connectPeers opens a Conn from the dialer to the listener, which listens
+// with listenConfig on the given network.
+func connectPeers(
+	t *testing.T,
+	listenConfig Config,
+	network string,
+	dialer *Handshaker,
+	info *storage.TorrentInfo) (local *Conn, remote *Conn, cleanup func()) {
+
+	require := require.New(t)
+
+	// Listeners are closed in cleanup, since closing a QUIC listener closes
+	// the connections it accepted.
+	listeners, err := Listen(listenConfig, "localhost:0")
+	require.NoError(err)
+	l := listenerFor(t, listeners, network)
+	h := HandshakerFixture(listenConfig)
+
+	errc := make(chan error, 1)
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		pc, err := h.Accept(nc)
+		if err != nil {
+			errc <- err
+			return
+		}
+		remote, err = h.Establish(pc, info, RemoteBitfields{})
+		errc <- err
+	}()
+
+	result, err := dialer.Initialize(h.peerID, l.Addr().String(), info, RemoteBitfields{}, "")
+	require.NoError(err)
+	require.NoError(<-errc)
+
+	local = result.Conn
+	local.Start()
+	remote.Start()
+
+	return local, remote, func() {
+		local.Close()
+		remote.Close()
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		networks []string
+	}{
+		{"tcp", ConfigFixture(), []string{"tcp"}},
+		{"quic", quicConfigFixture(), []string{"tcp", "udp"}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			listeners, err := Listen(test.config, "localhost:0")
+			require.NoError(err)
+
+			var networks []string
+			for _, l := range listeners {
+				networks = append(networks, l.Addr().Network())
+				defer l.Close()
+			}
+			require.Equal(test.networks, networks)
+		})
+	}
+}
+
+func TestQUICListenerNotBlockedBySilentPeer(t *testing.T) {
+	require := require.New(t)
+
+	config := quicConfigFixture()
+	config.HandshakeTimeout = 10 * time.Second
+
+	l, err := listenQUIC(config, "localhost:0")
+	require.NoError(err)
+	defer l.Close()
+
+	// The silent peer connects first, but never opens its control stream.
+	silent, err := quic.DialAddr(context.Background(), l.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{_quicProtocol},
+	}, config.quicConfig())
+	require.NoError(err)
+	defer silent.CloseWithError(0, "")
+
+	nc, err := dialQUIC(config, l.Addr().String())
+	require.NoError(err)
+	defer nc.Close()
+	// Streams are only visible to the listener once data is sent on them.
+	_, err = nc.Write([]byte{0})
+	require.NoError(err)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		ac, err := l.Accept()
+		if err == nil {
+			accepted <- ac
+		}
+	}()
+	select {
+	case ac := <-accepted:
+		defer ac.Close()
+		require.Equal(nc.LocalAddr().(*net.UDPAddr).Port, ac.RemoteAddr().(*net.UDPAddr).Port)
+	case <-time.After(config.HandshakeTimeout / 2):
+		require.FailNow("accept blocked by silent peer")
+	}
+}
+
+func TestQUICConnSendsPiecesOnStreams(t *testing.T) {
+	require := require.New(t)
+
+	config := quicConfigFixture()
+	info := storage.TorrentInfoFixture(8*64, 64)
+	pieces := make([][]byte, 8)
+	for i := range pieces {
+		pieces[i] = randutil.Blob(64)
+	}
+
+	local, remote, cleanup := connectPeers(t, config, "udp", HandshakerFixture(config), info)
+	defer cleanup()
+
+	require.IsType(&quicConn{}, local.nc)
+	require.IsType(&quicConn{}, remote.nc)
+
+	for i, p := range pieces {
+		require.NoError(local.Send(NewPiecePayloadMessage(i, piecereader.NewBuffer(p))))
+	}
+	// Control messages are sent on a separate stream from piece payloads.
+	require.NoError(local.Send(NewCompleteMessage()))
+
+	received := make(map[int][]byte)
+	var complete bool
+	for len(received) < len(pieces) || !complete {
+		select {
+		case msg := <-remote.Receiver():
+			if msg.Payload == nil {
+				complete = true
+				continue
+			}
+			b, err := ioutil.ReadAll(msg.Payload)
+			require.NoError(err)
+			received[int(msg.Message.PiecePayload.Index)] = b
+		case <-time.After(5 * time.Second):
+			require.FailNow("timed out waiting for messages")
+		}
+	}
+	for i, p := range pieces {
+		require.Equal(p, received[i])
+	}
+}
+
+func TestQUICConnClose(t *testing.T) {
+	require := require.New(t)
+
+	config := quicConfigFixture()
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := connectPeers(t, config, "udp", HandshakerFixture(config), info)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local.Close()
+		}()
+	}
+	wg.Wait()
+	require.True(local.IsClosed())
+
+	// Remote notices the connection closing.
+	select {
+	case _, ok := <-remote.Receiver():
+		require.False(ok)
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for remote to close")
+	}
+}
+
+func TestHandshakerFallsBackToTCPForPeersWithoutQUIC(t *testing.T) {
+	require := require.New(t)
+
+	dialer := HandshakerFixture(quicConfigFixture())
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, _, cleanup := connectPeers(t, ConfigFixture(), "tcp", dialer, info)
+	defer cleanup()
+
+	_, ok := local.nc.(*net.TCPConn)
+	require.True(ok)
+	require.Len(dialer.quicFallbacks, 1)
+}
+
+func TestTCPDialerConnectsToQUICPeer(t *testing.T) {
+	require := require.New(t)
+
+	dialer := HandshakerFixture(ConfigFixture())
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := connectPeers(t, quicConfigFixture(), "tcp", dialer, info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewCompleteMessage()))
+	select {
+	case <-remote.Receiver():
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for message")
+	}
+}
@@ -126,6 +126,26 @@ func (s *State) ActiveConns() []*conn.Conn {
 	return active
 }
 
+// ActiveTorrentConns returns a list of all active connections for h.
+func (s *State) ActiveTorrentConns(h core.InfoHash) []*conn.Conn {
+	var active []*conn.Conn
+	for _, e := range s.conns[h] {
+		if e.status == _active {
+			active = append(active, e.conn)
+		}
+	}
+	return active
+}
+
+// Peers returns the ids of all peers with pending or active connections for h.
+func (s *State) Peers(h core.InfoHash) []core.PeerID {
+	var peers []core.PeerID
+	for peerID := range s.conns[h] {
+		peers = append(peers, peerID)
+	}
+	return peers
+}
+
 // Saturated returns true if h is at capacity and all the conns are active.
 func (s *State) Saturated(h core.InfoHash) bool {
 	peers, ok := s.conns[h]
@@ -149,8 +169,11 @@ func (s *State) Blacklist(peerID core.PeerID, h core.InfoHash) error {
 	}
 
 	k := connKey{h, peerID}
-	if e, ok := s.blacklist[k]; ok && e.Blacklisted(s.clk.Now()) {
-		return errors.New("conn is already blacklisted")
+	if e, ok := s.blacklist[k]; ok {
+		if e.Blacklisted(s.clk.Now()) {
+			return errors.New("conn is already blacklisted")
+		}
+		s.expireBlacklistEntry(k)
 	}
 	s.blacklist[k] = &blacklistEntry{s.clk.Now().Add(s.config.BlacklistDuration)}
 
@@ -168,6 +191,21 @@ func (s *State) Blacklisted(peerID core.PeerID, h core.InfoHash) bool {
 	return ok && e.Blacklisted(s.clk.Now())
 }
 
+// ExpireBlacklist removes all blacklist entries whose BlacklistDuration has
+// passed.
+func (s *State) ExpireBlacklist() {
+	for k, e := range s.blacklist {
+		if !e.Blacklisted(s.clk.Now()) {
+			s.expireBlacklistEntry(k)
+		}
+	}
+}
+
+func (s *State) expireBlacklistEntry(k connKey) {
+	delete(s.blacklist, k)
+	s.netevents.Produce(networkevent.BlacklistExpiredEvent(k.hash, s.localPeerID, k.peerID))
+}
+
 // ClearBlacklist un-blacklists all connections for h.
 func (s *State) ClearBlacklist(h core.InfoHash) {
 	for k := range s.blacklist {
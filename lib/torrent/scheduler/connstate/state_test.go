@@ -53,6 +53,42 @@ func TestStateBlacklist(t *testing.T) {
 	require.NoError(s.Blacklist(p, h))
 }
 
+func TestStateExpireBlacklist(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		BlacklistDuration: 30 * time.Second,
+	}
+	clk := clock.NewMock()
+	s := testState(config, clk)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+	h := core.InfoHashFixture()
+
+	require.NoError(s.Blacklist(p1, h))
+	clk.Add(config.BlacklistDuration / 2)
+	require.NoError(s.Blacklist(p2, h))
+	clk.Add(config.BlacklistDuration/2 + 1)
+
+	s.ExpireBlacklist()
+
+	require.Len(s.BlacklistSnapshot(), 1)
+	require.Equal(p2, s.BlacklistSnapshot()[0].PeerID)
+
+	expired := networkevent.Filter(
+		s.netevents.(*networkevent.TestProducer).Events(), networkevent.BlacklistExpired)
+	require.Len(expired, 1)
+	require.Equal(p1.String(), expired[0].Peer)
+
+	// Re-blacklisting an expired entry which was not yet removed also records
+	// its expiry.
+	clk.Add(config.BlacklistDuration)
+	require.NoError(s.Blacklist(p2, h))
+	require.Len(networkevent.Filter(
+		s.netevents.(*networkevent.TestProducer).Events(), networkevent.BlacklistExpired), 2)
+}
+
 func TestStateBlacklistSnapshot(t *testing.T) {
 	require := require.New(t)
 
@@ -251,6 +287,35 @@ func TestStateActiveConns(t *testing.T) {
 	require.Empty(s.ActiveConns())
 }
 
+func TestStateActiveTorrentConnsAndPeers(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{}, clock.New())
+
+	c, cleanup := conn.Fixture()
+	defer cleanup()
+
+	other, cleanup := conn.Fixture()
+	defer cleanup()
+
+	h := c.InfoHash()
+	pending := core.PeerIDFixture()
+
+	require.NoError(s.AddPending(c.PeerID(), h, nil))
+	require.NoError(s.MovePendingToActive(c))
+	require.NoError(s.AddPending(pending, h, nil))
+	require.NoError(s.AddPending(other.PeerID(), other.InfoHash(), nil))
+	require.NoError(s.MovePendingToActive(other))
+
+	require.Equal([]*conn.Conn{c}, s.ActiveTorrentConns(h))
+	require.ElementsMatch([]core.PeerID{c.PeerID(), pending}, s.Peers(h))
+
+	s.DeleteActive(c)
+	s.DeletePending(pending, h)
+	require.Empty(s.ActiveTorrentConns(h))
+	require.Empty(s.Peers(h))
+}
+
 func TestStateSaturated(t *testing.T) {
 	require := require.New(t)
 
@@ -41,12 +41,16 @@ func NewAgentScheduler(
 	trackers hashring.PassiveRing,
 	tls *tls.Config) (ReloadableScheduler, error) {
 
+	var opts []announceclient.Option
+	if config.UseObservedIP {
+		opts = append(opts, announceclient.WithObservedIP())
+	}
 	s, err := newScheduler(
 		config,
 		agentstorage.NewTorrentArchive(stats, cads, metainfoclient.New(trackers, tls)),
 		stats,
 		pctx,
-		announceclient.New(pctx, trackers, tls),
+		announceclient.New(pctx, trackers, tls, opts...),
 		netevents)
 	if err != nil {
 		return nil, fmt.Errorf("new scheduler: %s", err)
@@ -37,6 +37,11 @@ type Config struct {
 	// from a peer.
 	PieceRequestPolicy string `yaml:"piece_request_policy"`
 
+	// SequentialWindow is the number of upcoming pieces which the sequential
+	// piece request policy requests before all others. The sequential policy
+	// is used for torrents read by streaming consumers, such as range requests.
+	SequentialWindow int `yaml:"sequential_window"`
+
 	// PipelineLimit limits the total number of requests can be sent to a peer
 	// at the same time.
 	PipelineLimit int `yaml:"pipeline_limit"`
@@ -53,6 +58,9 @@ func (c Config) applyDefaults() Config {
 	if c.PieceRequestPolicy == "" {
 		c.PieceRequestPolicy = piecerequest.DefaultPolicy
 	}
+	if c.SequentialWindow == 0 {
+		c.SequentialWindow = piecerequest.DefaultSequentialWindow
+	}
 	if c.PieceRequestMinTimeout == 0 {
 		c.PieceRequestMinTimeout = 4 * time.Second
 	}
@@ -75,6 +75,8 @@ type Dispatcher struct {
 	pendingPiecesDoneOnce sync.Once
 	pendingPiecesDone     chan struct{}
 	completeOnce          sync.Once
+	pieceWaitersMu        sync.Mutex
+	pieceWaiters          []*pieceWaiter
 	events                Events
 	logger                *zap.SugaredLogger
 	torrentlog            *torrentlog.Logger
@@ -127,7 +129,8 @@ func newDispatcher(
 
 	pieceRequestTimeout := config.calcPieceRequestTimeout(t.MaxPieceLength())
 	pieceRequestManager, err := piecerequest.NewManager(
-		clk, pieceRequestTimeout, config.PieceRequestPolicy, config.PipelineLimit)
+		clk, pieceRequestTimeout, config.PieceRequestPolicy, config.PipelineLimit,
+		config.SequentialWindow)
 	if err != nil {
 		return nil, fmt.Errorf("piece request manager: %s", err)
 	}
@@ -274,6 +277,26 @@ func (d *Dispatcher) removePeer(p *peer) error {
 	return nil
 }
 
+// SetPieceRequestPolicy overrides the configured piece request policy for this
+// torrent only. An empty policy restores the configured policy.
+func (d *Dispatcher) SetPieceRequestPolicy(policy string) error {
+	if policy == "" {
+		policy = d.config.PieceRequestPolicy
+	}
+	return d.pieceRequestManager.SetPolicy(policy)
+}
+
+// PieceRequestPolicy returns the current piece request policy of the torrent.
+func (d *Dispatcher) PieceRequestPolicy() string {
+	return d.pieceRequestManager.Policy()
+}
+
+// Seek moves the window of pieces requested first by the sequential piece
+// request policy to start at piece i.
+func (d *Dispatcher) Seek(i int) {
+	d.pieceRequestManager.Seek(i)
+}
+
 // TearDown closes all Dispatcher connections.
 func (d *Dispatcher) TearDown() {
 	d.pendingPiecesDoneOnce.Do(func() {
@@ -309,8 +332,69 @@ func (d *Dispatcher) String() string {
 	return fmt.Sprintf("Dispatcher(%s)", d.torrent)
 }
 
+// pieceWaiter is released once all of its pieces are complete.
+type pieceWaiter struct {
+	pieces []int
+	done   chan struct{}
+}
+
+// PrioritizePieces requests pieces ahead of all other pieces, and returns a
+// channel which is closed once they are all complete. The rest of the torrent
+// continues downloading in the background.
+func (d *Dispatcher) PrioritizePieces(pieces []int) <-chan struct{} {
+	w := &pieceWaiter{pieces, make(chan struct{})}
+
+	d.pieceWaitersMu.Lock()
+	d.pieceWaiters = append(d.pieceWaiters, w)
+	d.pieceWaitersMu.Unlock()
+
+	var missing []int
+	for _, i := range pieces {
+		if !d.torrent.HasPiece(i) {
+			missing = append(missing, i)
+		}
+	}
+	d.pieceRequestManager.Prioritize(missing)
+	d.stats.Counter("prioritized_pieces").Inc(int64(len(missing)))
+
+	// Pieces may have completed before the waiter was registered.
+	d.releasePieceWaiters()
+
+	if len(missing) > 0 {
+		d.peers.Range(func(k, v interface{}) bool {
+			go d.maybeRequestMorePieces(v.(*peer))
+			return true
+		})
+	}
+	return w.done
+}
+
+// releasePieceWaiters releases all waiters whose pieces are complete.
+func (d *Dispatcher) releasePieceWaiters() {
+	d.pieceWaitersMu.Lock()
+	defer d.pieceWaitersMu.Unlock()
+
+	var waiting []*pieceWaiter
+	for _, w := range d.pieceWaiters {
+		ready := true
+		for _, i := range w.pieces {
+			if !d.torrent.HasPiece(i) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			close(w.done)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	d.pieceWaiters = waiting
+}
+
 func (d *Dispatcher) complete() {
 	d.completeOnce.Do(func() { go d.events.DispatcherComplete(d) })
+	d.releasePieceWaiters()
 	d.pendingPiecesDoneOnce.Do(func() { close(d.pendingPiecesDone) })
 
 	d.peers.Range(func(k, v interface{}) bool {
@@ -390,6 +474,11 @@ func (d *Dispatcher) maybeSendPieceRequests(p *peer, candidates *bitset.BitSet)
 }
 
 func (d *Dispatcher) resendFailedPieceRequests() {
+	for _, r := range d.pieceRequestManager.MarkExpired() {
+		d.netevents.Produce(networkevent.PieceRequestTimeoutEvent(
+			d.torrent.InfoHash(), d.localPeerID, r.PeerID, r.Piece))
+	}
+
 	failedRequests := d.pieceRequestManager.GetFailedRequests()
 	if len(failedRequests) > 0 {
 		d.log().Infof("Resending %d failed piece requests", len(failedRequests))
@@ -553,6 +642,7 @@ func (d *Dispatcher) handlePiecePayload(
 	}
 
 	d.pieceRequestManager.Clear(i)
+	d.releasePieceWaiters()
 
 	d.maybeRequestMorePieces(p)
 
@@ -229,6 +229,14 @@ func TestDispatcherResendFailedPieceRequests(t *testing.T) {
 	require.Equal(map[int]int{
 		1: 1,
 	}, numRequestsPerPiece(p3.messages))
+
+	// Timeouts of both requests to p1 are recorded.
+	timeouts := networkevent.Filter(
+		d.netevents.(*networkevent.TestProducer).Events(), networkevent.PieceRequestTimeout)
+	require.Len(timeouts, 2)
+	for _, e := range timeouts {
+		require.Equal(p1.id.String(), e.Peer)
+	}
 }
 
 func TestDispatcherSendErrorsMarksPieceRequestsUnsent(t *testing.T) {
@@ -343,6 +351,46 @@ func TestDispatcherHandlePiecePayloadAnnouncesPiece(t *testing.T) {
 	require.Equal([]int{0}, announcedPieces(p2.messages))
 }
 
+func TestDispatcherPrioritizePiecesWaitsForPieces(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(4, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(Config{}, clock.NewMock(), torrent)
+
+	p, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(true, true, true, true), newMockMessages())
+	require.NoError(err)
+
+	done := d.PrioritizePieces([]int{1, 2})
+
+	for _, i := range []int{1, 2} {
+		select {
+		case <-done:
+			require.FailNow("released before pieces were received")
+		default:
+		}
+		msg := conn.NewPiecePayloadMessage(i, piecereader.NewBuffer(blob.Content[i:i+1]))
+		require.NoError(d.dispatch(p, msg))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("not released after pieces were received")
+	}
+
+	// Pieces which are already present are released immediately.
+	select {
+	case <-d.PrioritizePieces([]int{1}):
+	default:
+		require.FailNow("not released for present piece")
+	}
+}
+
 func TestDispatcherHandlePiecePayloadSendsCompleteMessage(t *testing.T) {
 	require := require.New(t)
 
@@ -49,7 +49,8 @@ type Request struct {
 	PeerID core.PeerID
 	Status Status
 
-	sentAt time.Time
+	sentAt   time.Time
+	priority bool
 }
 
 // Manager encapsulates thread-safe piece request bookkeeping. It is not responsible
@@ -61,11 +62,17 @@ type Manager struct {
 	requests       map[int][]*Request
 	requestsByPeer map[core.PeerID]map[int]*Request
 
+	// priority holds pieces which must be requested before all others, e.g.
+	// because a reader is blocked on them.
+	priority map[int]bool
+
 	clock   clock.Clock
 	timeout time.Duration
 
-	policy        pieceSelectionPolicy
-	pipelineLimit int
+	policy           pieceSelectionPolicy
+	policyName       string
+	pipelineLimit    int
+	sequentialWindow int
 }
 
 // NewManager creates a new Manager.
@@ -73,29 +80,93 @@ func NewManager(
 	clk clock.Clock,
 	timeout time.Duration,
 	policy string,
-	pipelineLimit int) (*Manager, error) {
+	pipelineLimit int,
+	sequentialWindow int) (*Manager, error) {
 
 	m := &Manager{
-		requests:       make(map[int][]*Request),
-		requestsByPeer: make(map[core.PeerID]map[int]*Request),
-		clock:          clk,
-		timeout:        timeout,
-		pipelineLimit:  pipelineLimit,
+		requests:         make(map[int][]*Request),
+		requestsByPeer:   make(map[core.PeerID]map[int]*Request),
+		priority:         make(map[int]bool),
+		clock:            clk,
+		timeout:          timeout,
+		pipelineLimit:    pipelineLimit,
+		sequentialWindow: sequentialWindow,
+	}
+	p, err := m.newPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	m.policy = p
+	m.policyName = policy
+	return m, nil
+}
+
+// ValidatePolicy returns an error if policy is not a valid piece selection
+// policy.
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case DefaultPolicy, RarestFirstPolicy, SequentialPolicy:
+		return nil
+	default:
+		return fmt.Errorf("invalid piece selection policy: %s", policy)
 	}
+}
 
+func (m *Manager) newPolicy(policy string) (pieceSelectionPolicy, error) {
 	switch policy {
 	case DefaultPolicy:
-		m.policy = newDefaultPolicy()
+		return newDefaultPolicy(), nil
 	case RarestFirstPolicy:
-		m.policy = newRarestFirstPolicy()
+		return newRarestFirstPolicy(), nil
+	case SequentialPolicy:
+		return newSequentialPolicy(m.sequentialWindow), nil
 	default:
 		return nil, fmt.Errorf("invalid piece selection policy: %s", policy)
 	}
-	return m, nil
+}
+
+// SetPolicy replaces the piece selection policy, e.g. when a torrent starts
+// being consumed by a streaming reader. Pending requests are not affected.
+// Setting the current policy again is a no-op, such that its state (e.g. the
+// sequential position) is preserved.
+func (m *Manager) SetPolicy(policy string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.policyName == policy {
+		return nil
+	}
+	p, err := m.newPolicy(policy)
+	if err != nil {
+		return err
+	}
+	m.policy = p
+	m.policyName = policy
+	return nil
+}
+
+// Policy returns the name of the current piece selection policy.
+func (m *Manager) Policy() string {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.policyName
+}
+
+// Seek moves the window of the sequential policy to start at piece i. No-op
+// for other policies.
+func (m *Manager) Seek(i int) {
+	m.Lock()
+	defer m.Unlock()
+
+	if p, ok := m.policy.(*sequentialPolicy); ok {
+		p.seek(i)
+	}
 }
 
 // ReservePieces selects the next piece(s) to be requested from given peer.
-// It selects peers on a rarity-first basis using numPeersByPiece.
+// Prioritized pieces are selected first, in index order, in addition to up to
+// the pipeline limit of regular pieces selected by the policy.
 // If allowDuplicates is set, may return pieces which have already been
 // reserved under other peers.
 func (m *Manager) ReservePieces(
@@ -107,24 +178,41 @@ func (m *Manager) ReservePieces(
 	m.Lock()
 	defer m.Unlock()
 
-	quota := m.requestQuota(peerID)
-	if quota <= 0 {
-		return nil, nil
+	valid := func(i int) bool { return m.validRequest(peerID, i, allowDuplicates) }
+
+	// Priority pieces are selected first, and are pipelined separately such
+	// that they are never queued behind regular requests.
+	var pieces []int
+	chosen := make(map[int]bool)
+	priorityQuota := m.priorityQuota(peerID)
+	for _, i := range m.priorityPieces() {
+		if len(pieces) >= priorityQuota {
+			break
+		}
+		if candidates.Test(uint(i)) && valid(i) {
+			pieces = append(pieces, i)
+			chosen[i] = true
+		}
 	}
+	numPriority := len(pieces)
 
-	valid := func(i int) bool { return m.validRequest(peerID, i, allowDuplicates) }
-	pieces, err := m.policy.selectPieces(quota, valid, candidates, numPeersByPiece)
-	if err != nil {
-		return nil, err
+	if quota := m.requestQuota(peerID); quota > 0 {
+		rest, err := m.policy.selectPieces(
+			quota, func(i int) bool { return !chosen[i] && valid(i) }, candidates, numPeersByPiece)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, rest...)
 	}
 
 	// Set as pending in requests map.
-	for _, i := range pieces {
+	for j, i := range pieces {
 		r := &Request{
-			Piece:  i,
-			PeerID: peerID,
-			Status: StatusPending,
-			sentAt: m.clock.Now(),
+			Piece:    i,
+			PeerID:   peerID,
+			Status:   StatusPending,
+			sentAt:   m.clock.Now(),
+			priority: j < numPriority,
 		}
 		m.requests[i] = append(m.requests[i], r)
 		if _, ok := m.requestsByPeer[peerID]; !ok {
@@ -136,6 +224,17 @@ func (m *Manager) ReservePieces(
 	return pieces, nil
 }
 
+// Prioritize marks pieces to be requested before all other pieces, until
+// they are cleared.
+func (m *Manager) Prioritize(pieces []int) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, i := range pieces {
+		m.priority[i] = true
+	}
+}
+
 // MarkUnsent marks the piece request for piece i as unsent.
 func (m *Manager) MarkUnsent(peerID core.PeerID, i int) {
 	m.markStatus(peerID, i, StatusUnsent)
@@ -153,6 +252,7 @@ func (m *Manager) Clear(i int) {
 	defer m.Unlock()
 
 	delete(m.requests, i)
+	delete(m.priority, i)
 
 	for peerID, pm := range m.requestsByPeer {
 		delete(pm, i)
@@ -197,6 +297,28 @@ func (m *Manager) ClearPeer(peerID core.PeerID) {
 	}
 }
 
+// MarkExpired marks all pending piece requests which have timed out as expired,
+// and returns a copy of them.
+func (m *Manager) MarkExpired() []Request {
+	m.Lock()
+	defer m.Unlock()
+
+	var expired []Request
+	for _, rs := range m.requests {
+		for _, r := range rs {
+			if r.Status == StatusPending && m.expired(r) {
+				r.Status = StatusExpired
+				expired = append(expired, Request{
+					Piece:  r.Piece,
+					PeerID: r.PeerID,
+					Status: r.Status,
+				})
+			}
+		}
+	}
+	return expired
+}
+
 // GetFailedRequests returns a copy of all failed piece requests.
 func (m *Manager) GetFailedRequests() []Request {
 	m.RLock()
@@ -235,23 +357,40 @@ func (m *Manager) validRequest(peerID core.PeerID, i int, allowDuplicates bool)
 	return true
 }
 
+// requestQuota returns the number of regular requests which may be sent to
+// peerID.
 func (m *Manager) requestQuota(peerID core.PeerID) int {
-	quota := m.pipelineLimit
-	pm, ok := m.requestsByPeer[peerID]
-	if !ok {
-		return quota
+	return m.pipelineLimit - m.numPending(peerID, false)
+}
+
+// priorityQuota returns the number of priority requests which may be sent to
+// peerID.
+func (m *Manager) priorityQuota(peerID core.PeerID) int {
+	if len(m.priority) == 0 {
+		return 0
 	}
+	return m.pipelineLimit - m.numPending(peerID, true)
+}
 
-	for _, r := range pm {
-		if r.Status == StatusPending && !m.expired(r) {
-			quota--
-			if quota == 0 {
-				break
-			}
+func (m *Manager) numPending(peerID core.PeerID, priority bool) int {
+	var n int
+	for _, r := range m.requestsByPeer[peerID] {
+		if r.priority == priority && r.Status == StatusPending && !m.expired(r) {
+			n++
 		}
 	}
+	return n
+}
 
-	return quota
+// priorityPieces returns the priority pieces in ascending order, such that
+// ranges are filled front to back.
+func (m *Manager) priorityPieces() []int {
+	pieces := make([]int, 0, len(m.priority))
+	for i := range m.priority {
+		pieces = append(pieces, i)
+	}
+	sort.Ints(pieces)
+	return pieces
 }
 
 func (m *Manager) expired(r *Request) bool {
@@ -31,7 +31,7 @@ func newManager(
 	policy string,
 	pipelineLimit int) *Manager {
 
-	m, err := NewManager(clk, timeout, policy, pipelineLimit)
+	m, err := NewManager(clk, timeout, policy, pipelineLimit, 0)
 	if err != nil {
 		panic(err)
 	}
@@ -203,6 +203,103 @@ func TestManagerGetFailedRequests(t *testing.T) {
 	require.Contains(failed, Request{Piece: 2, PeerID: p2, Status: StatusExpired})
 }
 
+func TestManagerSequentialPolicy(t *testing.T) {
+	require := require.New(t)
+
+	m, err := NewManager(clock.NewMock(), 5*time.Second, SequentialPolicy, 3, 2)
+	require.NoError(err)
+
+	m.Seek(1)
+
+	p0 := core.PeerIDFixture()
+	p1 := core.PeerIDFixture()
+
+	// Selects the window in order, then the rarest piece outside the window.
+	pieces, err := m.ReservePieces(p0, bitsetutil.FromBools(true, true, true, true, true, true),
+		countsFromInts(5, 5, 5, 5, 0, 5), false)
+	require.NoError(err)
+	require.Equal([]int{1, 2, 4}, pieces)
+
+	m.Clear(1)
+	m.Clear(2)
+
+	// The window moves past received pieces, and includes pieces already
+	// reserved by other peers.
+	pieces, err = m.ReservePieces(p1, bitsetutil.FromBools(true, false, false, true, true, true),
+		countsFromInts(5, 5, 5, 5, 0, 1), false)
+	require.NoError(err)
+	require.Equal([]int{3, 5, 0}, pieces)
+}
+
+func TestManagerSetPolicy(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, RarestFirstPolicy, 1)
+	require.Equal(RarestFirstPolicy, m.Policy())
+
+	require.NoError(m.SetPolicy(SequentialPolicy))
+	require.Equal(SequentialPolicy, m.Policy())
+	m.Seek(2)
+
+	// Setting the same policy preserves the position.
+	require.NoError(m.SetPolicy(SequentialPolicy))
+
+	pieces, err := m.ReservePieces(core.PeerIDFixture(), bitsetutil.FromBools(true, true, true),
+		countsFromInts(0, 1, 2), false)
+	require.NoError(err)
+	require.Equal([]int{2}, pieces)
+
+	require.Error(m.SetPolicy("invalid"))
+	require.Equal(SequentialPolicy, m.Policy())
+}
+
+func TestValidatePolicy(t *testing.T) {
+	require := require.New(t)
+
+	for _, policy := range []string{DefaultPolicy, RarestFirstPolicy, SequentialPolicy} {
+		require.NoError(ValidatePolicy(policy))
+	}
+	require.Error(ValidatePolicy("invalid"))
+}
+
+func TestManagerMarkExpired(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	timeout := 5 * time.Second
+
+	m := newManager(clk, timeout, DefaultPolicy, 2)
+
+	peerID := core.PeerIDFixture()
+
+	pieces, err := m.ReservePieces(peerID, bitsetutil.FromBools(true, false),
+		countsFromInts(0, 0), false)
+	require.NoError(err)
+	require.Equal([]int{0}, pieces)
+
+	clk.Add(timeout / 2)
+
+	pieces, err = m.ReservePieces(peerID, bitsetutil.FromBools(false, true),
+		countsFromInts(0, 0), false)
+	require.NoError(err)
+	require.Equal([]int{1}, pieces)
+
+	require.Empty(m.MarkExpired())
+
+	clk.Add(timeout/2 + 1) // Expires the request of piece 0.
+
+	require.Equal(
+		[]Request{{Piece: 0, PeerID: peerID, Status: StatusExpired}}, m.MarkExpired())
+	require.Equal([]int{1}, m.PendingPieces(peerID))
+
+	// Requests are only reported as expired once.
+	require.Empty(m.MarkExpired())
+
+	// Expired requests are still failed requests.
+	require.Equal(
+		[]Request{{Piece: 0, PeerID: peerID, Status: StatusExpired}}, m.GetFailedRequests())
+}
+
 func TestManagerClear(t *testing.T) {
 	require := require.New(t)
 
@@ -406,3 +503,57 @@ func TestRarestFirstPolicy(t *testing.T) {
 	require.NoError(err)
 	require.Empty(pieces)
 }
+
+func TestManagerReservePriorityPieces(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 2)
+
+	peerID := core.PeerIDFixture()
+	candidates := bitsetutil.FromBools(true, true, true, true, true, true)
+	counts := countsFromInts(0, 0, 0, 0, 0, 0)
+
+	// Fill the regular pipeline.
+	pieces, err := m.ReservePieces(peerID, bitsetutil.FromBools(true, true), counts, false)
+	require.NoError(err)
+	require.Len(pieces, 2)
+
+	m.Prioritize([]int{5, 3, 4})
+
+	// Priority pieces are requested in order despite the full pipeline, up
+	// to their own pipeline limit.
+	pieces, err = m.ReservePieces(peerID, candidates, counts, false)
+	require.NoError(err)
+	require.Equal([]int{3, 4}, pieces)
+
+	pieces, err = m.ReservePieces(peerID, candidates, counts, false)
+	require.NoError(err)
+	require.Empty(pieces)
+
+	// Other peers only receive priority pieces which are not yet requested.
+	pieces, err = m.ReservePieces(core.PeerIDFixture(), candidates, counts, false)
+	require.NoError(err)
+	require.Equal(5, pieces[0])
+	require.Len(pieces, 2)
+
+	// Clearing a piece also clears its priority and frees the pipeline.
+	m.Clear(3)
+	m.Clear(4)
+	pieces, err = m.ReservePieces(peerID, candidates, counts, false)
+	require.NoError(err)
+	require.Empty(pieces)
+}
+
+func TestManagerPriorityPiecesRespectCandidates(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 1)
+
+	m.Prioritize([]int{0})
+
+	// Peer does not have the priority piece.
+	pieces, err := m.ReservePieces(
+		core.PeerIDFixture(), bitsetutil.FromBools(false, true), countsFromInts(0, 0), false)
+	require.NoError(err)
+	require.Equal([]int{1}, pieces)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package piecerequest
+
+import (
+	"github.com/uber/kraken/utils/syncutil"
+
+	"github.com/willf/bitset"
+)
+
+// SequentialPolicy requests a window of pieces following the read position of
+// a streaming consumer first, and all other pieces rarest-first.
+const SequentialPolicy = "sequential"
+
+// DefaultSequentialWindow is the number of upcoming pieces the sequential
+// policy prioritizes if no window is configured.
+const DefaultSequentialWindow = 4
+
+// sequentialPolicy selects pieces for streaming consumers, which read the
+// torrent in order starting from some position. The next window of candidate
+// pieces at or after the position are selected first, with the earliest
+// pieces (i.e. those with the nearest deadline) first. Pieces outside the
+// window are selected rarest-first.
+type sequentialPolicy struct {
+	window   int
+	position int
+	fallback *rarestFirstPolicy
+}
+
+func newSequentialPolicy(window int) *sequentialPolicy {
+	if window <= 0 {
+		window = DefaultSequentialWindow
+	}
+	return &sequentialPolicy{
+		window:   window,
+		fallback: newRarestFirstPolicy(),
+	}
+}
+
+// seek moves the window to start at piece i.
+func (p *sequentialPolicy) seek(i int) {
+	if i < 0 {
+		i = 0
+	}
+	p.position = i
+}
+
+func (p *sequentialPolicy) selectPieces(
+	limit int,
+	valid func(int) bool,
+	candidates *bitset.BitSet,
+	numPeersByPiece syncutil.Counters) ([]int, error) {
+
+	pieces := make([]int, 0, limit)
+	if limit == 0 {
+		return pieces, nil
+	}
+
+	// The window moves forward as pieces are received, since received pieces
+	// are no longer candidates.
+	inWindow := make(map[int]bool)
+	for i, e := candidates.NextSet(uint(p.position)); e; i, e = candidates.NextSet(i + 1) {
+		if len(inWindow) == p.window {
+			break
+		}
+		inWindow[int(i)] = true
+		if len(pieces) < limit && valid(int(i)) {
+			pieces = append(pieces, int(i))
+		}
+	}
+	if len(pieces) == limit {
+		return pieces, nil
+	}
+
+	rest, err := p.fallback.selectPieces(
+		limit-len(pieces),
+		func(i int) bool { return !inWindow[i] && valid(i) },
+		candidates,
+		numPeersByPiece)
+	if err != nil {
+		return nil, err
+	}
+	return append(pieces, rest...), nil
+}
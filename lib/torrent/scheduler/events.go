@@ -14,6 +14,7 @@
 package scheduler
 
 import (
+	"math/rand"
 	"time"
 
 	"github.com/uber/kraken/core"
@@ -247,7 +248,10 @@ func (e announceTickEvent) apply(s *state) {
 			continue
 		}
 		go s.sched.announce(
-			ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
+			ctrl.dispatcher.Digest(),
+			ctrl.dispatcher.InfoHash(),
+			ctrl.dispatcher.Complete(),
+			s.announceExclude(ctrl))
 		break
 	}
 	// Re-enqueue any torrents we pulled off and ignored, else we would never
@@ -280,7 +284,17 @@ func (e announceResultEvent) apply(s *state) {
 		// Torrent is already complete, don't open any new connections.
 		return
 	}
+	// Rebalanced torrents only open conns up to the target degree. Torrents may
+	// already exceed the target degree, e.g. from incoming conns.
+	var capacity int
+	limited := s.rebalancing(ctrl)
+	if limited {
+		capacity = s.sched.config.Rebalance.TargetDegree - len(s.conns.Peers(e.infoHash))
+	}
 	for _, p := range e.peers {
+		if limited && capacity <= 0 {
+			break
+		}
 		if p.PeerID == s.sched.pctx.PeerID {
 			// Tracker may return our own peer.
 			continue
@@ -294,6 +308,7 @@ func (e announceResultEvent) apply(s *state) {
 			}
 			continue
 		}
+		capacity--
 		go s.sched.initializeOutgoingHandshake(
 			p, ctrl.dispatcher.Stat(), ctrl.dispatcher.RemoteBitfields(), ctrl.namespace)
 	}
@@ -308,6 +323,8 @@ type announceErrEvent struct {
 // apply marks the dispatcher as ready to announce again.
 func (e announceErrEvent) apply(s *state) {
 	s.log("hash", e.infoHash).Errorf("Error announcing: %s", e.err)
+	s.sched.netevents.Produce(
+		networkevent.AnnounceFailedEvent(e.infoHash, s.sched.pctx.PeerID, e.err))
 	s.announceQueue.Ready(e.infoHash)
 }
 
@@ -337,7 +354,54 @@ func (e newTorrentEvent) apply(s *state) {
 	ctrl.errors = append(ctrl.errors, e.errc)
 
 	// Immediately announce new torrents.
-	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
+	go s.sched.announce(
+		ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete(), nil)
+}
+
+// newRangeEvent occurs when a range of a torrent was requested for download.
+type newRangeEvent struct {
+	namespace string
+	torrent   storage.Torrent
+	pieces    []int
+	policy    string
+	errc      chan error
+	waitc     chan (<-chan struct{})
+}
+
+// apply begins leeching a torrent if needed, and prioritizes the pieces of the
+// requested range.
+func (e newRangeEvent) apply(s *state) {
+	ctrl, ok := s.torrentControls[e.torrent.InfoHash()]
+	if !ok {
+		var err error
+		ctrl, err = s.addTorrent(e.namespace, e.torrent, true)
+		if err != nil {
+			e.errc <- err
+			return
+		}
+		s.log("torrent", e.torrent).Info("Added new torrent for range request")
+
+		// Immediately announce new torrents.
+		go s.sched.announce(
+			ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete(), nil)
+	}
+	if ctrl.dispatcher.Complete() {
+		e.errc <- nil
+		return
+	}
+	// The policy of the most recent range applies to the whole torrent. With
+	// the sequential policy, the pieces following the range are requested
+	// first, in anticipation of further reads.
+	if err := ctrl.dispatcher.SetPieceRequestPolicy(e.policy); err != nil {
+		s.log("torrent", e.torrent).Errorf("Error setting piece request policy: %s", err)
+	}
+	if len(e.pieces) > 0 {
+		ctrl.dispatcher.Seek(e.pieces[len(e.pieces)-1] + 1)
+	}
+
+	// The range request fails if the torrent fails.
+	ctrl.errors = append(ctrl.errors, e.errc)
+	e.waitc <- ctrl.dispatcher.PrioritizePieces(e.pieces)
 }
 
 // dispatcherCompleteEvent occurs when a dispatcher finishes downloading its torrent.
@@ -359,6 +423,9 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 	for _, errc := range ctrl.errors {
 		errc <- nil
 	}
+	// Range requests may have already returned without receiving from their
+	// error channels, so clear them to avoid blocking on further notifications.
+	ctrl.errors = nil
 	if ctrl.localRequest {
 		// Normalize the download time for all torrent sizes to a per MB value.
 		// Skip torrents that are less than a MB in size because we can't measure
@@ -374,7 +441,36 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 	s.sched.netevents.Produce(networkevent.TorrentCompleteEvent(infoHash, s.sched.pctx.PeerID))
 
 	// Immediately announce completed torrents.
-	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), true)
+	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), true, nil)
+}
+
+// restoreTorrentEvent occurs when a torrent is found on disk after a restart.
+type restoreTorrentEvent struct {
+	namespace string
+	torrent   storage.Torrent
+}
+
+// apply adds a torrent found on disk after a restart, resuming its download
+// if incomplete or seeding it otherwise.
+func (e restoreTorrentEvent) apply(s *state) {
+	if _, ok := s.torrentControls[e.torrent.InfoHash()]; ok {
+		return
+	}
+	ctrl, err := s.addTorrent(e.namespace, e.torrent, false)
+	if err != nil {
+		s.log("torrent", e.torrent).Errorf("Error adding restored torrent: %s", err)
+		return
+	}
+	s.log("torrent", e.torrent).Info("Restored torrent")
+	if ctrl.dispatcher.Complete() {
+		s.sched.stats.Counter("restored_seeders").Inc(1)
+		return
+	}
+	s.sched.stats.Counter("restored_leechers").Inc(1)
+
+	// Immediately announce resumed torrents. Seeders are announced through
+	// the announce queue, to avoid flooding the tracker on startup.
+	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), false, nil)
 }
 
 // peerRemovedEvent occurs when a dispatcher removes a peer with a closed
@@ -386,11 +482,13 @@ type peerRemovedEvent struct {
 
 func (e peerRemovedEvent) apply(s *state) {}
 
-// preemptionTickEvent occurs periodically to preempt unneeded conns and remove
-// idle torrentControls.
+// preemptionTickEvent occurs periodically to preempt unneeded conns, expire
+// blacklisted conns, and remove idle torrentControls.
 type preemptionTickEvent struct{}
 
 func (e preemptionTickEvent) apply(s *state) {
+	s.conns.ExpireBlacklist()
+
 	for _, c := range s.conns.ActiveConns() {
 		ctrl, ok := s.torrentControls[c.InfoHash()]
 		if !ok {
@@ -437,6 +535,44 @@ func (e preemptionTickEvent) apply(s *state) {
 	}
 }
 
+// rebalanceTickEvent occurs periodically to rebalance the swarms of large
+// torrents toward a random regular graph.
+type rebalanceTickEvent struct{}
+
+// apply drops random conns of each rebalanced torrent above the target degree,
+// freeing capacity for peers below it, which re-announce to find new peers.
+// Since peers are dropped and handed out at random, swarms converge toward a
+// random regular graph.
+func (e rebalanceTickEvent) apply(s *state) {
+	config := s.sched.config.Rebalance
+	for h, ctrl := range s.torrentControls {
+		if !s.rebalancing(ctrl) {
+			continue
+		}
+		conns := s.conns.ActiveTorrentConns(h)
+		var droppable []*conn.Conn
+		for _, c := range conns {
+			if s.sched.clock.Now().Sub(c.CreatedAt()) >= config.MinConnAge {
+				droppable = append(droppable, c)
+			}
+		}
+		if drops := config.drops(len(conns), len(droppable)); drops > 0 {
+			rand.Shuffle(len(droppable), func(i, j int) {
+				droppable[i], droppable[j] = droppable[j], droppable[i]
+			})
+			for _, c := range droppable[:drops] {
+				s.log("conn", c).Debug("Dropping conn to rebalance swarm")
+				s.sched.stats.Counter("rebalance_dropped_conns").Inc(1)
+				c.Close()
+			}
+		}
+		if !ctrl.dispatcher.Complete() && len(s.conns.Peers(h)) < config.TargetDegree {
+			s.sched.stats.Counter("rebalance_announces").Inc(1)
+			go s.sched.announce(ctrl.dispatcher.Digest(), h, false, s.announceExclude(ctrl))
+		}
+	}
+}
+
 // emitStatsEvent occurs periodically to emit scheduler stats.
 type emitStatsEvent struct{}
 
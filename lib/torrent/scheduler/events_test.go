@@ -14,9 +14,11 @@
 package scheduler
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
@@ -26,6 +28,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
 	mockannounceclient "github.com/uber/kraken/mocks/tracker/announceclient"
@@ -92,7 +95,7 @@ func newStateMocks(t *testing.T) (*stateMocks, func()) {
 	return mocks, cleanup.Run
 }
 
-func (m *stateMocks) newState(config Config) *state {
+func (m *stateMocks) newState(config Config, options ...option) *state {
 	sched, err := newScheduler(
 		config,
 		m.torrentArchive,
@@ -100,7 +103,7 @@ func (m *stateMocks) newState(config Config) *state {
 		core.PeerContextFixture(),
 		m.announceClient,
 		networkevent.NewTestProducer(),
-		withEventLoop(m.eventLoop))
+		append([]option{withEventLoop(m.eventLoop)}, options...)...)
 	if err != nil {
 		panic(err)
 	}
@@ -142,7 +145,8 @@ func TestAnnounceTickEvent(t *testing.T) {
 			ctrls[0].dispatcher.Digest(),
 			ctrls[0].dispatcher.InfoHash(),
 			false,
-			announceclient.V1).
+			announceclient.V1,
+			nil).
 		Return(nil, time.Second, nil)
 
 	announceTickEvent{}.apply(state)
@@ -187,7 +191,8 @@ func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 			empty.dispatcher.Digest(),
 			empty.dispatcher.InfoHash(),
 			false,
-			announceclient.V1).
+			announceclient.V1,
+			nil).
 		Return(nil, time.Second, nil)
 
 	announceTickEvent{}.apply(state)
@@ -219,7 +224,8 @@ func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 			full.dispatcher.Digest(),
 			full.dispatcher.InfoHash(),
 			false,
-			announceclient.V1).
+			announceclient.V1,
+			nil).
 		Return(nil, time.Second, nil)
 
 	announceTickEvent{}.apply(state)
@@ -229,3 +235,322 @@ func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 		infoHash: full.dispatcher.InfoHash(),
 	})
 }
+
+func rebalanceConfigFixture(targetDegree int) Config {
+	return Config{
+		ConnState: connstate.Config{
+			MaxOpenConnectionsPerTorrent: 5,
+		},
+		Rebalance: RebalanceConfig{
+			Enable:         true,
+			MinTorrentSize: 1,
+			TargetDegree:   targetDegree,
+			MinConnAge:     time.Nanosecond,
+		},
+	}
+}
+
+func TestAnnounceResultEventOpensConnsUpToTargetDegree(t *testing.T) {
+	tests := []struct {
+		desc     string
+		conns    int
+		expected int
+	}{
+		{"below target degree", 1, 3},
+		{"at target degree", 3, 3},
+		{"above target degree", 4, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newStateMocks(t)
+			defer cleanup()
+
+			config := rebalanceConfigFixture(3)
+			config.ConnState.MaxOpenConnectionsPerTorrent = 10
+			state := mocks.newState(config)
+
+			ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+			require.NoError(err)
+			h := ctrl.dispatcher.InfoHash()
+
+			for i := 0; i < test.conns; i++ {
+				require.NoError(state.conns.AddPending(core.PeerIDFixture(), h, nil))
+			}
+
+			var peers []*core.PeerInfo
+			for i := 0; i < 5; i++ {
+				peers = append(peers, core.PeerInfoFixture())
+			}
+
+			announceResultEvent{h, peers}.apply(state)
+
+			require.Len(state.conns.Peers(h), test.expected)
+		})
+	}
+}
+
+func TestAnnounceErrEventProducesNetworkEvent(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(Config{})
+
+	ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+	require.NoError(err)
+	h := ctrl.dispatcher.InfoHash()
+
+	announceErrEvent{h, errors.New("some error")}.apply(state)
+
+	events := networkevent.Filter(
+		state.sched.netevents.(*networkevent.TestProducer).Events(), networkevent.AnnounceFailed)
+	require.Len(events, 1)
+	require.Equal(h.String(), events[0].Torrent)
+	require.Equal("some error", events[0].Error)
+}
+
+func TestRebalanceTickEventDropsExcessConns(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(rebalanceConfigFixture(2))
+
+	ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+	require.NoError(err)
+
+	info := ctrl.dispatcher.Stat()
+
+	var conns []*conn.Conn
+	for i := 0; i < 5; i++ {
+		_, c, cleanup := conn.PipeFixture(conn.Config{}, info)
+		defer cleanup()
+
+		require.NoError(state.conns.AddPending(c.PeerID(), c.InfoHash(), nil))
+		require.NoError(state.addOutgoingConn(c, info.Bitfield(), info))
+		conns = append(conns, c)
+	}
+
+	rebalanceTickEvent{}.apply(state)
+
+	var closed int
+	for _, c := range conns {
+		if c.IsClosed() {
+			closed++
+		}
+	}
+	require.Equal(3, closed)
+}
+
+func TestRebalanceTickEventAnnouncesBelowTargetDegree(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(rebalanceConfigFixture(2))
+
+	ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+	require.NoError(err)
+
+	info := ctrl.dispatcher.Stat()
+
+	_, c, cleanup := conn.PipeFixture(conn.Config{}, info)
+	defer cleanup()
+
+	require.NoError(state.conns.AddPending(c.PeerID(), c.InfoHash(), nil))
+	require.NoError(state.addOutgoingConn(c, info.Bitfield(), info))
+
+	// Connected peers are excluded from the handout.
+	mocks.announceClient.EXPECT().
+		Announce(
+			ctrl.dispatcher.Digest(),
+			ctrl.dispatcher.InfoHash(),
+			false,
+			announceclient.V1,
+			[]core.PeerID{c.PeerID()}).
+		Return(nil, time.Second, nil)
+
+	rebalanceTickEvent{}.apply(state)
+
+	mocks.eventLoop.expect(announceResultEvent{
+		infoHash: ctrl.dispatcher.InfoHash(),
+	})
+	require.False(c.IsClosed())
+}
+
+func TestRebalancingConvergesToTargetDegree(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	config := rebalanceConfigFixture(3)
+	config.ConnState.MaxOpenConnectionsPerTorrent = 10
+	config.Rebalance.MinConnAge = time.Minute
+	state := mocks.newState(config, withClock(clk))
+
+	ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+	require.NoError(err)
+	h := ctrl.dispatcher.InfoHash()
+	info := ctrl.dispatcher.Stat()
+
+	var conns []*conn.Conn
+	for i := 0; i < 6; i++ {
+		_, c, cleanup := conn.PipeFixture(conn.Config{}, info)
+		defer cleanup()
+
+		require.NoError(state.conns.AddPending(c.PeerID(), c.InfoHash(), nil))
+		require.NoError(state.addOutgoingConn(c, info.Bitfield(), info))
+		conns = append(conns, c)
+	}
+	numOpen := func() int {
+		var n int
+		for _, c := range conns {
+			if !c.IsClosed() {
+				n++
+			}
+		}
+		return n
+	}
+	// Conn fixtures aren't connected to our event loop, so closed conns must
+	// be removed manually.
+	removed := make(map[*conn.Conn]bool)
+	removeClosed := func() {
+		for _, c := range conns {
+			if c.IsClosed() && !removed[c] {
+				connClosedEvent{c}.apply(state)
+				removed[c] = true
+				select {
+				case e := <-mocks.eventLoop.c:
+					require.IsType(peerRemovedEvent{}, e)
+				case <-time.After(5 * time.Second):
+					require.FailNow("timed out waiting for peerRemovedEvent")
+				}
+			}
+		}
+	}
+
+	// Young conns are never dropped.
+	rebalanceTickEvent{}.apply(state)
+	require.Equal(6, numOpen())
+
+	// Conns above the target degree are dropped once old enough, without
+	// announcing for more peers.
+	clk.Add(2 * config.Rebalance.MinConnAge)
+	rebalanceTickEvent{}.apply(state)
+	require.Equal(3, numOpen())
+	removeClosed()
+	require.Len(state.conns.Peers(h), 3)
+
+	rebalanceTickEvent{}.apply(state)
+	require.Equal(3, numOpen())
+
+	// Once a neighbor leaves, the torrent announces for peers it is not yet
+	// connected to.
+	for _, c := range conns {
+		if !c.IsClosed() {
+			c.Close()
+			break
+		}
+	}
+	removeClosed()
+	connected := state.conns.Peers(h)
+	require.Len(connected, 2)
+
+	mocks.announceClient.EXPECT().
+		Announce(
+			ctrl.dispatcher.Digest(), h, false, announceclient.V1, gomock.Any()).
+		DoAndReturn(func(
+			d core.Digest, h core.InfoHash, complete bool, version int,
+			exclude []core.PeerID) ([]*core.PeerInfo, time.Duration, error) {
+
+			require.ElementsMatch(connected, exclude)
+			return nil, time.Second, nil
+		})
+
+	rebalanceTickEvent{}.apply(state)
+	mocks.eventLoop.expect(announceResultEvent{infoHash: h})
+
+	// Only enough peers of the handout are connected to reach the target
+	// degree.
+	var peers []*core.PeerInfo
+	for i := 0; i < 5; i++ {
+		peers = append(peers, core.PeerInfoFixture())
+	}
+	announceResultEvent{h, peers}.apply(state)
+	require.Len(state.conns.Peers(h), 3)
+}
+
+func TestRebalanceTickEventSkipsSmallTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	config := rebalanceConfigFixture(1)
+	config.Rebalance.MinTorrentSize = 1 << 40
+	state := mocks.newState(config)
+
+	ctrl, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+	require.NoError(err)
+
+	info := ctrl.dispatcher.Stat()
+
+	var conns []*conn.Conn
+	for i := 0; i < 3; i++ {
+		_, c, cleanup := conn.PipeFixture(conn.Config{}, info)
+		defer cleanup()
+
+		require.NoError(state.conns.AddPending(c.PeerID(), c.InfoHash(), nil))
+		require.NoError(state.addOutgoingConn(c, info.Bitfield(), info))
+		conns = append(conns, c)
+	}
+
+	rebalanceTickEvent{}.apply(state)
+
+	for _, c := range conns {
+		require.False(c.IsClosed())
+	}
+}
+
+func TestNewRangeEventSetsPieceRequestPolicy(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(Config{})
+
+	tor := mocks.newTorrent()
+	ctrl, err := state.addTorrent(_testNamespace, tor, true)
+	require.NoError(err)
+	require.Equal(piecerequest.DefaultPolicy, ctrl.dispatcher.PieceRequestPolicy())
+
+	newRangeEvent{
+		namespace: _testNamespace,
+		torrent:   tor,
+		pieces:    []int{0},
+		policy:    piecerequest.SequentialPolicy,
+		errc:      make(chan error, 1),
+		waitc:     make(chan (<-chan struct{}), 1),
+	}.apply(state)
+	require.Equal(piecerequest.SequentialPolicy, ctrl.dispatcher.PieceRequestPolicy())
+
+	// Ranges without a policy restore the configured policy.
+	newRangeEvent{
+		namespace: _testNamespace,
+		torrent:   tor,
+		pieces:    []int{0},
+		errc:      make(chan error, 1),
+		waitc:     make(chan (<-chan struct{}), 1),
+	}.apply(state)
+	require.Equal(piecerequest.DefaultPolicy, ctrl.dispatcher.PieceRequestPolicy())
+}
@@ -17,6 +17,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/announcer"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/tracker/announceclient"
@@ -49,6 +52,7 @@ var (
 type Scheduler interface {
 	Stop()
 	Download(namespace string, d core.Digest) error
+	DownloadRange(namespace string, d core.Digest, offset, length int64, policy string) error
 	BlacklistSnapshot() ([]connstate.BlacklistedConn, error)
 	RemoveTorrent(d core.Digest) error
 	Probe() error
@@ -72,9 +76,11 @@ type scheduler struct {
 
 	eventLoop *liftedEventLoop
 
-	listener net.Listener
+	// Listeners for each transport peers may connect over.
+	listeners []net.Listener
 
 	preemptionTick <-chan time.Time
+	rebalanceTick  <-chan time.Time
 	emitStatsTick  <-chan time.Time
 
 	// TODO(codyg): We only need this hold on this reference for reloading the scheduler...
@@ -91,7 +97,7 @@ type scheduler struct {
 	// The following fields orchestrate the stopping of the scheduler.
 	stopOnce sync.Once      // Ensures the stop sequence is executed only once.
 	done     chan struct{}  // Signals all goroutines to exit.
-	wg       sync.WaitGroup // Waits for eventLoop and listenLoop to exit.
+	wg       sync.WaitGroup // Waits for all loops to exit.
 }
 
 // schedOverrides defines scheduler fields which may be overrided for testing
@@ -150,6 +156,11 @@ func newScheduler(
 		preemptionTick = overrides.clock.Tick(config.PreemptionInterval)
 	}
 
+	var rebalanceTick <-chan time.Time
+	if config.Rebalance.Enable {
+		rebalanceTick = overrides.clock.Tick(config.Rebalance.Interval)
+	}
+
 	handshaker, err := conn.NewHandshaker(
 		config.Conn, stats, overrides.clock, netevents, pctx.PeerID, eventLoop, slogger)
 	if err != nil {
@@ -170,6 +181,7 @@ func newScheduler(
 		handshaker:     handshaker,
 		eventLoop:      eventLoop,
 		preemptionTick: preemptionTick,
+		rebalanceTick:  rebalanceTick,
 		emitStatsTick:  overrides.clock.Tick(config.EmitStatsInterval),
 		announceClient: announceClient,
 		announcer:      announcer.Default(announceClient, eventLoop, overrides.clock, slogger),
@@ -195,21 +207,28 @@ func newScheduler(
 // "unstarted" scheduler in certain cases.
 func (s *scheduler) start(aq announcequeue.Queue) error {
 	s.log().Infof(
-		"Scheduler starting as peer %s on addr %s:%d",
-		s.pctx.PeerID, s.pctx.IP, s.pctx.Port)
+		"Scheduler starting as peer %s on addr %s",
+		s.pctx.PeerID, net.JoinHostPort(s.pctx.IP, strconv.Itoa(s.pctx.Port)))
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.pctx.Port))
+	listeners, err := conn.Listen(s.config.Conn, fmt.Sprintf(":%d", s.pctx.Port))
 	if err != nil {
 		return err
 	}
-	s.listener = l
+	s.listeners = listeners
 
-	s.wg.Add(4)
+	s.wg.Add(3 + len(listeners))
 	go s.runEventLoop(aq) // Careful, this should be the only reference to aq.
-	go s.listenLoop()
+	for _, l := range listeners {
+		go s.listenLoop(l)
+	}
 	go s.tickerLoop()
 	go s.announceLoop()
 
+	if !s.config.Restore.Disable {
+		s.wg.Add(1)
+		go s.restoreTorrents()
+	}
+
 	return nil
 }
 
@@ -219,7 +238,9 @@ func (s *scheduler) Stop() {
 		s.log().Info("Stopping scheduler...")
 
 		close(s.done)
-		s.listener.Close()
+		for _, l := range s.listeners {
+			l.Close()
+		}
 		s.eventLoop.send(shutdownEvent{})
 
 		// Waits for all loops to stop.
@@ -254,21 +275,8 @@ func (s *scheduler) Download(namespace string, d core.Digest) error {
 	start := time.Now()
 	size, err := s.doDownload(namespace, d)
 	if err != nil {
-		var errTag string
-		switch err {
-		case ErrTorrentNotFound:
-			errTag = "not_found"
-		case ErrTorrentTimeout:
-			errTag = "timeout"
-		case ErrSchedulerStopped:
-			errTag = "scheduler_stopped"
-		case ErrTorrentRemoved:
-			errTag = "removed"
-		default:
-			errTag = "unknown"
-		}
 		s.stats.Tagged(map[string]string{
-			"error": errTag,
+			"error": errorTag(err),
 		}).Counter("download_errors").Inc(1)
 		s.torrentlog.DownloadFailure(namespace, d, size, err)
 	} else {
@@ -279,6 +287,103 @@ func (s *scheduler) Download(namespace string, d core.Digest) error {
 	return err
 }
 
+func errorTag(err error) string {
+	switch err {
+	case ErrTorrentNotFound:
+		return "not_found"
+	case ErrTorrentTimeout:
+		return "timeout"
+	case ErrSchedulerStopped:
+		return "scheduler_stopped"
+	case ErrTorrentRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// DownloadRange downloads the pieces of the torrent of d which cover length
+// bytes at offset ahead of all other pieces, and returns once they are
+// available. Unlike Download, it does not wait for the rest of the torrent,
+// which continues downloading in the background. A negative length covers
+// the rest of the blob.
+//
+// If set, policy selects the piece request policy of the torrent, e.g.
+// piecerequest.SequentialPolicy for streaming reads. Otherwise, the torrent
+// uses the configured piece request policy.
+func (s *scheduler) DownloadRange(
+	namespace string, d core.Digest, offset, length int64, policy string) error {
+
+	err := s.doDownloadRange(namespace, d, offset, length, policy)
+	if err != nil {
+		s.stats.Tagged(map[string]string{
+			"error": errorTag(err),
+		}).Counter("range_download_errors").Inc(1)
+	} else {
+		s.stats.Counter("range_downloads").Inc(1)
+	}
+	return err
+}
+
+func (s *scheduler) doDownloadRange(
+	namespace string, d core.Digest, offset, length int64, policy string) error {
+
+	if policy != "" {
+		if err := piecerequest.ValidatePolicy(policy); err != nil {
+			return err
+		}
+	}
+	t, err := s.torrentArchive.CreateTorrent(namespace, d)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return ErrTorrentNotFound
+		}
+		return fmt.Errorf("create torrent: %s", err)
+	}
+	// Ranges of incomplete torrents are always sent to the event loop, even
+	// if their pieces are present, such that the policy is applied.
+	if t.Complete() {
+		return nil
+	}
+	pieces := piecesInRange(t, offset, length)
+
+	// Buffer size of 1 so sends do not block.
+	errc := make(chan error, 1)
+	waitc := make(chan (<-chan struct{}), 1)
+	if !s.eventLoop.send(newRangeEvent{namespace, t, pieces, policy, errc, waitc}) {
+		return ErrSchedulerStopped
+	}
+	select {
+	case err := <-errc:
+		return err
+	case wait := <-waitc:
+		select {
+		case <-wait:
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+// piecesInRange returns the indexes of the pieces of t which cover length
+// bytes at offset.
+func piecesInRange(t storage.Torrent, offset, length int64) []int {
+	end := t.Length()
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	if offset < 0 || offset >= end {
+		return nil
+	}
+	pieceLength := t.MaxPieceLength()
+	var pieces []int
+	for i := int(offset / pieceLength); i <= int((end-1)/pieceLength); i++ {
+		pieces = append(pieces, i)
+	}
+	return pieces
+}
+
 // BlacklistSnapshot returns a snapshot of the current connection blacklist.
 func (s *scheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	result := make(chan []connstate.BlacklistedConn)
@@ -310,13 +415,13 @@ func (s *scheduler) runEventLoop(aq announcequeue.Queue) {
 	s.eventLoop.run(newState(s, aq))
 }
 
-// listenLoop accepts incoming connections.
-func (s *scheduler) listenLoop() {
+// listenLoop accepts incoming connections on l.
+func (s *scheduler) listenLoop(l net.Listener) {
 	defer s.wg.Done()
 
-	s.log().Infof("Listening on %s", s.listener.Addr().String())
+	s.log().Infof("Listening on %s %s", l.Addr().Network(), l.Addr().String())
 	for {
-		nc, err := s.listener.Accept()
+		nc, err := l.Accept()
 		if err != nil {
 			// TODO Need some way to make this gracefully exit.
 			s.log().Infof("Error accepting new conn, exiting listen loop: %s", err)
@@ -342,6 +447,8 @@ func (s *scheduler) tickerLoop() {
 		select {
 		case <-s.preemptionTick:
 			s.eventLoop.send(preemptionTickEvent{})
+		case <-s.rebalanceTick:
+			s.eventLoop.send(rebalanceTickEvent{})
 		case <-s.emitStatsTick:
 			s.eventLoop.send(emitStatsEvent{})
 		case <-s.done:
@@ -357,8 +464,68 @@ func (s *scheduler) announceLoop() {
 	s.announcer.Ticker(s.done)
 }
 
-func (s *scheduler) announce(d core.Digest, h core.InfoHash, complete bool) {
-	peers, err := s.announcer.Announce(d, h, complete)
+// restoreTorrents adds torrents persisted by a previous run of the scheduler,
+// such that completed blobs are seeded and partial downloads are resumed
+// without waiting for a new request.
+func (s *scheduler) restoreTorrents() {
+	defer s.wg.Done()
+
+	records, err := s.torrentArchive.ListTorrents()
+	if err != nil {
+		s.log().Errorf("Error listing torrents to restore: %s", err)
+		return
+	}
+	records = s.selectRestorableTorrents(records)
+
+	var restored int
+	for _, r := range records {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		t, err := s.torrentArchive.GetTorrent(r.Namespace, r.Digest)
+		if err != nil {
+			s.log("digest", r.Digest).Infof("Error restoring torrent: %s", err)
+			s.stats.Counter("restore_errors").Inc(1)
+			continue
+		}
+		if !s.eventLoop.send(restoreTorrentEvent{r.Namespace, t}) {
+			return
+		}
+		restored++
+	}
+	s.log().Infof("Restored %d torrents", restored)
+}
+
+// selectRestorableTorrents filters and orders records according to the
+// restore budget.
+func (s *scheduler) selectRestorableTorrents(
+	records []*storage.TorrentRecord) []*storage.TorrentRecord {
+
+	var selected []*storage.TorrentRecord
+	for _, r := range records {
+		if r.Complete && s.clock.Now().Sub(r.LastAccessTime) > s.config.Restore.MaxIdleTime {
+			continue
+		}
+		selected = append(selected, r)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].Complete != selected[j].Complete {
+			return !selected[i].Complete
+		}
+		return selected[i].LastAccessTime.After(selected[j].LastAccessTime)
+	})
+	if len(selected) > s.config.Restore.MaxTorrents {
+		selected = selected[:s.config.Restore.MaxTorrents]
+	}
+	return selected
+}
+
+func (s *scheduler) announce(
+	d core.Digest, h core.InfoHash, complete bool, exclude []core.PeerID) {
+
+	peers, err := s.announcer.Announce(d, h, complete, exclude)
 	if err != nil {
 		if err != announceclient.ErrDisabled {
 			s.eventLoop.send(announceErrEvent{h, err})
@@ -398,7 +565,7 @@ func (s *scheduler) establishIncomingHandshake(pc *conn.PendingConn, rb conn.Rem
 func (s *scheduler) initializeOutgoingHandshake(
 	p *core.PeerInfo, info *storage.TorrentInfo, rb conn.RemoteBitfields, namespace string) {
 
-	addr := fmt.Sprintf("%s:%d", p.IP, p.Port)
+	addr := p.Addr()
 	result, err := s.handshaker.Initialize(p.PeerID, addr, info, rb, namespace)
 	if err != nil {
 		s.log(
@@ -24,9 +24,13 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 	"github.com/uber/kraken/tracker/announceclient"
 	"github.com/uber/kraken/utils/bitsetutil"
+	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
@@ -56,6 +60,41 @@ func TestDownloadTorrentWithSeederAndLeecher(t *testing.T) {
 	leecher.checkTorrent(t, namespace, blob)
 }
 
+func TestDownloadTorrentWithMixedTransports(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	tcpConfig := configFixture()
+	quicConfig := configFixture()
+	quicConfig.Conn.Transport = conn.QUIC
+
+	seeder := mocks.newPeer(quicConfig)
+	leechers := []*testPeer{mocks.newPeer(quicConfig), mocks.newPeer(tcpConfig)}
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(3)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+
+	var wg sync.WaitGroup
+	for _, p := range leechers {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(p.scheduler.Download(namespace, blob.Digest))
+			p.checkTorrent(t, namespace, blob)
+		}()
+	}
+	wg.Wait()
+}
+
 func TestDownloadManyTorrentsWithSeederAndLeecher(t *testing.T) {
 	require := require.New(t)
 
@@ -290,6 +329,67 @@ func TestMultipleDownloadsForSameTorrentSucceed(t *testing.T) {
 	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
 }
 
+func TestDownloadRange(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	blob := core.SizedBlobFixture(1024, 16)
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).AnyTimes()
+
+	config := configFixture()
+
+	seeder := mocks.newPeer(config)
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+
+	leecher := mocks.newPeer(config)
+
+	require.NoError(leecher.scheduler.DownloadRange(namespace, blob.Digest, 100, 200, ""))
+
+	tor, err := leecher.torrentArchive.GetTorrent(namespace, blob.Digest)
+	require.NoError(err)
+	for _, i := range piecesInRange(tor, 100, 200) {
+		require.True(tor.HasPiece(i))
+	}
+
+	// The rest of the torrent continues downloading in the background.
+	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+	leecher.checkTorrent(t, namespace, blob)
+
+	// Ranges of complete torrents return immediately.
+	require.NoError(leecher.scheduler.DownloadRange(namespace, blob.Digest, 0, -1, ""))
+}
+
+func TestPiecesInRange(t *testing.T) {
+	tor, cleanup := agentstorage.TorrentFixture(core.SizedBlobFixture(100, 10).MetaInfo)
+	defer cleanup()
+
+	tests := []struct {
+		desc     string
+		offset   int64
+		length   int64
+		expected []int
+	}{
+		{"single piece", 12, 5, []int{1}},
+		{"piece boundaries", 10, 20, []int{1, 2}},
+		{"spans pieces", 15, 20, []int{1, 2, 3}},
+		{"until end", 85, -1, []int{8, 9}},
+		{"length past end", 95, 100, []int{9}},
+		{"offset past end", 100, 10, nil},
+		{"empty", 50, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require.Equal(t, test.expected, piecesInRange(tor, test.offset, test.length))
+		})
+	}
+}
+
 func TestEmitStatsEventTriggers(t *testing.T) {
 	mocks, cleanup := newTestMocks(t)
 	defer cleanup()
@@ -386,7 +486,7 @@ func TestPullInactiveTorrent(t *testing.T) {
 	// Force announce the scheduler for this torrent to simulate a peer which
 	// is registered in tracker but does not have the torrent in memory.
 	ac := announceclient.New(seeder.pctx, hashring.NoopPassiveRing(hostlist.Fixture(mocks.trackerAddr)), nil)
-	ac.Announce(blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V1)
+	ac.Announce(blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V1, nil)
 
 	leecher := mocks.newPeer(config)
 
@@ -503,3 +603,78 @@ func TestSchedulerProbeTimeoutsIfDeadlocked(t *testing.T) {
 
 	close(release)
 }
+
+func TestSchedulerRestoresTorrentsOnStart(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	namespace := core.TagFixture()
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	blob := core.NewBlobFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	// Seeder has the blob on disk, but the scheduler never learned about it.
+	seeder.writeTorrent(namespace, blob)
+
+	// Leecher has a partially downloaded blob on disk.
+	tor, err := leecher.torrentArchive.CreateTorrent(namespace, blob.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(
+		piecereader.NewBuffer(blob.Content[:tor.PieceLength(0)]), 0))
+
+	config.Restore.Disable = false
+	for _, p := range []*testPeer{seeder, leecher} {
+		rs := makeReloadable(p.scheduler, func() announcequeue.Queue { return announcequeue.New() })
+		rs.Reload(config)
+		p.scheduler = rs.scheduler
+		waitForTorrentAdded(t, p.scheduler, blob.MetaInfo.InfoHash())
+	}
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		tor, err := leecher.torrentArchive.GetTorrent(namespace, blob.Digest)
+		return err == nil && tor.Complete()
+	}))
+	leecher.checkTorrent(t, namespace, blob)
+}
+
+func TestSchedulerRestoreBudget(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	config.Restore.MaxTorrents = 2
+	config.Restore.MaxIdleTime = time.Hour
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	p := mocks.newPeer(config, withClock(clk))
+
+	d1 := core.DigestFixture()
+	d2 := core.DigestFixture()
+	d3 := core.DigestFixture()
+	d4 := core.DigestFixture()
+
+	records := []*storage.TorrentRecord{
+		{Digest: d1, Complete: true, LastAccessTime: clk.Now().Add(-time.Minute)},
+		{Digest: d2, Complete: true, LastAccessTime: clk.Now().Add(-2 * time.Hour)},
+		{Digest: d3, Complete: false},
+		{Digest: d4, Complete: true, LastAccessTime: clk.Now().Add(-2 * time.Minute)},
+	}
+
+	var result []core.Digest
+	for _, r := range p.scheduler.selectRestorableTorrents(records) {
+		result = append(result, r.Digest)
+	}
+	require.Equal([]core.Digest{d3, d1}, result)
+}
@@ -0,0 +1,328 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+// swarmSimulation is a deterministic model of a swarm downloading a single
+// torrent. Time is driven by a mock clock in fixed ticks, and each peer
+// uploads at most a fixed number of pieces per tick to its neighbors. Conns
+// follow the scheduler's rules: peers open conns to announce handouts up to
+// the max open conns, conns between completed peers and idle conns are
+// closed, and rebalanced swarms drop conns above the target degree.
+type swarmSimulation struct {
+	config           Config
+	clk              *clock.Mock
+	rand             *rand.Rand
+	tick             time.Duration
+	announceInterval time.Duration
+	numPieces        int
+	pieceLength      uint64
+	piecesPerTick    int
+	peers            []*simPeer
+	lastRebalance    time.Time
+}
+
+type simConn struct {
+	createdAt  time.Time
+	lastActive time.Time
+}
+
+type simPeer struct {
+	id           int
+	joinAt       time.Time
+	joined       bool
+	have         []bool
+	numHave      int
+	conns        map[int]*simConn
+	nextAnnounce time.Time
+	completedAt  time.Time
+}
+
+func (p *simPeer) complete() bool {
+	return p.numHave == len(p.have)
+}
+
+// neighbors returns the ids of p's neighbors in a deterministic order.
+func (p *simPeer) neighbors() []int {
+	var ids []int
+	for id := range p.conns {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// newSwarmSimulation creates a swarm of one seeder, early peers which join
+// immediately, and late peers which join after lateJoin.
+func newSwarmSimulation(config Config, early, late int, lateJoin time.Duration) *swarmSimulation {
+	clk := clock.NewMock()
+	clk.Set(time.Unix(0, 0))
+
+	tick := 100 * time.Millisecond
+	pieceLength := 64 * memsize.KB
+	egress := config.Conn.Bandwidth.EgressBitsPerSec / 8 * uint64(tick) / uint64(time.Second)
+
+	s := &swarmSimulation{
+		config:           config,
+		clk:              clk,
+		rand:             rand.New(rand.NewSource(1)),
+		tick:             tick,
+		announceInterval: 3 * time.Second,
+		numPieces:        64,
+		pieceLength:      pieceLength,
+		piecesPerTick:    int(egress / pieceLength),
+		lastRebalance:    clk.Now(),
+	}
+	for i := 0; i < 1+early+late; i++ {
+		p := &simPeer{
+			id:     i,
+			joinAt: clk.Now(),
+			have:   make([]bool, s.numPieces),
+			conns:  make(map[int]*simConn),
+		}
+		if i == 0 {
+			for j := range p.have {
+				p.have[j] = true
+			}
+			p.numHave = s.numPieces
+		}
+		if i > early {
+			p.joinAt = clk.Now().Add(lateJoin)
+		}
+		s.peers = append(s.peers, p)
+	}
+	return s
+}
+
+func (s *swarmSimulation) rebalancing() bool {
+	config := s.config.Rebalance
+	return config.Enable && uint64(s.numPieces)*s.pieceLength >= config.MinTorrentSize
+}
+
+func (s *swarmSimulation) connect(a, b *simPeer) {
+	c := &simConn{createdAt: s.clk.Now(), lastActive: s.clk.Now()}
+	a.conns[b.id] = c
+	b.conns[a.id] = c
+}
+
+func (s *swarmSimulation) disconnect(a, b *simPeer) {
+	delete(a.conns, b.id)
+	delete(b.conns, a.id)
+}
+
+// announce opens conns from p to a random handout of the swarm.
+func (s *swarmSimulation) announce(p *simPeer) {
+	p.nextAnnounce = s.clk.Now().Add(s.announceInterval)
+	if p.complete() {
+		return
+	}
+	maxOpen := s.config.ConnState.MaxOpenConnectionsPerTorrent
+	capacity := maxOpen
+	if s.rebalancing() {
+		capacity = s.config.Rebalance.TargetDegree
+	}
+	for _, i := range s.rand.Perm(len(s.peers)) {
+		o := s.peers[i]
+		if len(p.conns) >= capacity {
+			return
+		}
+		if o == p || !o.joined {
+			continue
+		}
+		if _, ok := p.conns[o.id]; ok {
+			continue
+		}
+		if len(o.conns) >= maxOpen {
+			// Saturated peers reject the conn.
+			continue
+		}
+		s.connect(p, o)
+	}
+}
+
+func (s *swarmSimulation) rebalance() {
+	config := s.config.Rebalance
+	for _, p := range s.peers {
+		if !p.joined {
+			continue
+		}
+		var droppable []int
+		for _, id := range p.neighbors() {
+			if s.clk.Now().Sub(p.conns[id].createdAt) >= config.MinConnAge {
+				droppable = append(droppable, id)
+			}
+		}
+		if drops := config.drops(len(p.conns), len(droppable)); drops > 0 {
+			s.rand.Shuffle(len(droppable), func(i, j int) {
+				droppable[i], droppable[j] = droppable[j], droppable[i]
+			})
+			for _, id := range droppable[:drops] {
+				s.disconnect(p, s.peers[id])
+			}
+		}
+	}
+	for _, p := range s.peers {
+		if p.joined && !p.complete() && len(p.conns) < config.TargetDegree {
+			s.announce(p)
+		}
+	}
+}
+
+// transfer uploads up to piecesPerTick random pieces from each peer to its
+// neighbors. Pieces received in a tick can only be uploaded the next tick.
+func (s *swarmSimulation) transfer() {
+	type piece struct{ to, index int }
+	var received []piece
+	pending := make(map[piece]bool)
+	for _, p := range s.peers {
+		neighbors := p.neighbors()
+		s.rand.Shuffle(len(neighbors), func(i, j int) {
+			neighbors[i], neighbors[j] = neighbors[j], neighbors[i]
+		})
+		budget := s.piecesPerTick
+		for budget > 0 {
+			sent := false
+			for _, id := range neighbors {
+				if budget == 0 {
+					break
+				}
+				o := s.peers[id]
+				var candidates []int
+				for i := range p.have {
+					if p.have[i] && !o.have[i] && !pending[piece{o.id, i}] {
+						candidates = append(candidates, i)
+					}
+				}
+				if len(candidates) == 0 {
+					continue
+				}
+				pc := piece{o.id, candidates[s.rand.Intn(len(candidates))]}
+				pending[pc] = true
+				received = append(received, pc)
+				p.conns[id].lastActive = s.clk.Now()
+				budget--
+				sent = true
+			}
+			if !sent {
+				break
+			}
+		}
+	}
+	for _, pc := range received {
+		p := s.peers[pc.to]
+		p.have[pc.index] = true
+		p.numHave++
+		if p.complete() {
+			p.completedAt = s.clk.Now()
+		}
+	}
+}
+
+// closeConns closes conns between completed peers and idle conns.
+func (s *swarmSimulation) closeConns() {
+	for _, p := range s.peers {
+		for _, id := range p.neighbors() {
+			o := s.peers[id]
+			c := p.conns[id]
+			if (p.complete() && o.complete()) || s.clk.Now().Sub(c.lastActive) >= s.config.ConnTTI {
+				s.disconnect(p, o)
+			}
+		}
+	}
+}
+
+// run runs the simulation until all peers complete, returning the download
+// times of the late peers.
+func (s *swarmSimulation) run(t *testing.T, late int) []time.Duration {
+	deadline := s.clk.Now().Add(time.Hour)
+	for {
+		done := true
+		for _, p := range s.peers {
+			if !p.complete() {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		require.True(t, s.clk.Now().Before(deadline), "simulation did not converge")
+
+		s.clk.Add(s.tick)
+		for _, p := range s.peers {
+			if !p.joined && !s.clk.Now().Before(p.joinAt) {
+				p.joined = true
+				s.announce(p)
+			}
+		}
+		for _, p := range s.peers {
+			if p.joined && !s.clk.Now().Before(p.nextAnnounce) {
+				s.announce(p)
+			}
+		}
+		if s.rebalancing() && s.clk.Now().Sub(s.lastRebalance) >= s.config.Rebalance.Interval {
+			s.lastRebalance = s.clk.Now()
+			s.rebalance()
+		}
+		s.transfer()
+		s.closeConns()
+	}
+	var times []time.Duration
+	for _, p := range s.peers[len(s.peers)-late:] {
+		times = append(times, p.completedAt.Sub(p.joinAt))
+	}
+	return times
+}
+
+func TestSimulateSwarmRebalancing(t *testing.T) {
+	const early, late = 16, 16
+
+	mean := func(ds []time.Duration) time.Duration {
+		var sum time.Duration
+		for _, d := range ds {
+			sum += d
+		}
+		return sum / time.Duration(len(ds))
+	}
+
+	config := configFixture()
+	config.ConnState.MaxOpenConnectionsPerTorrent = 4
+	// Peers upload at 2MB/s.
+	config.Conn.Bandwidth.EgressBitsPerSec = 16 * memsize.Mbit
+
+	baseline := newSwarmSimulation(config, early, late, 2*time.Second).run(t, late)
+
+	config.Rebalance = RebalanceConfig{
+		Enable:         true,
+		MinTorrentSize: 1,
+		TargetDegree:   3,
+		Interval:       time.Second,
+		MinConnAge:     time.Second,
+	}
+	rebalanced := newSwarmSimulation(config, early, late, 2*time.Second).run(t, late)
+
+	t.Logf("Mean late joiner download time: baseline %s, rebalanced %s",
+		mean(baseline), mean(rebalanced))
+	require.True(t, mean(rebalanced) < mean(baseline))
+}
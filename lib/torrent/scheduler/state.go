@@ -160,6 +160,22 @@ func (s *state) addIncomingConn(
 	return nil
 }
 
+// rebalancing returns true if the swarm of ctrl's torrent is rebalanced.
+func (s *state) rebalancing(ctrl *torrentControl) bool {
+	config := s.sched.config.Rebalance
+	return config.Enable && uint64(ctrl.dispatcher.Length()) >= config.MinTorrentSize
+}
+
+// announceExclude returns the peers to exclude from the announce handout of
+// ctrl's torrent. Rebalanced torrents exclude the peers they are connected to,
+// such that handouts only contain peers which may become new neighbors.
+func (s *state) announceExclude(ctrl *torrentControl) []core.PeerID {
+	if !s.rebalancing(ctrl) {
+		return nil
+	}
+	return s.conns.Peers(ctrl.dispatcher.InfoHash())
+}
+
 func (s *state) log(args ...interface{}) *zap.SugaredLogger {
 	return s.sched.log(args...)
 }
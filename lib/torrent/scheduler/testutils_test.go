@@ -78,6 +78,8 @@ func configFixture() Config {
 		Conn:               conn.ConfigFixture(),
 		Dispatch:           dispatch.Config{},
 		TorrentLog:         log.Config{Disable: true},
+		// Restoring races with tests which write torrents to disk directly.
+		Restore: RestoreConfig{Disable: true},
 	}.applyDefaults()
 }
 
@@ -16,6 +16,7 @@ package agentstorage
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/uber-go/tally"
 	"github.com/willf/bitset"
@@ -98,6 +99,9 @@ func (a *TorrentArchive) CreateTorrent(namespace string, d core.Digest) (storage
 		if err := a.cads.Any().GetOrSetMetadata(d.Hex(), &tm); err != nil {
 			return nil, fmt.Errorf("get or set metainfo: %s", err)
 		}
+		if err := a.cads.Any().GetOrSetMetadata(d.Hex(), metadata.NewNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("get or set namespace: %s", err)
+		}
 	} else if err != nil {
 		return nil, fmt.Errorf("get metainfo: %s", err)
 	}
@@ -128,3 +132,48 @@ func (a *TorrentArchive) DeleteTorrent(d core.Digest) error {
 	}
 	return nil
 }
+
+// ListTorrents returns records of all torrents with metainfo on disk, in both
+// the download and cache directories. Torrents without a recorded namespace
+// (e.g. created by older agents) are skipped, since they cannot be announced.
+func (a *TorrentArchive) ListTorrents() ([]*storage.TorrentRecord, error) {
+	names, err := a.cads.Any().ListNames()
+	if err != nil {
+		return nil, fmt.Errorf("list names: %s", err)
+	}
+	var records []*storage.TorrentRecord
+	for _, name := range names {
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil {
+			continue
+		}
+		var tm metadata.TorrentMeta
+		if err := a.cads.Any().GetMetadata(name, &tm); err != nil {
+			// Files without metainfo were never initialized as torrents.
+			continue
+		}
+		var nm metadata.Namespace
+		if err := a.cads.Any().GetMetadata(name, &nm); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get namespace of %s: %s", name, err)
+		}
+		if nm.Value == "" {
+			continue
+		}
+		var lat time.Time
+		var latm metadata.LastAccessTime
+		if err := a.cads.Any().GetMetadata(name, &latm); err == nil {
+			lat = latm.Time
+		}
+		_, statErr := a.cads.Cache().GetFileStat(name)
+		records = append(records, &storage.TorrentRecord{
+			Namespace:      nm.Value,
+			Digest:         d,
+			Complete:       statErr == nil,
+			LastAccessTime: lat,
+		})
+	}
+	return records, nil
+}
@@ -218,3 +218,68 @@ func TestTorrentArchiveGetTorrent(t *testing.T) {
 	require.NoError(err)
 	require.NotNil(tor)
 }
+
+func TestTorrentArchiveListTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	namespace := core.TagFixture()
+
+	complete := core.SizedBlobFixture(4, 1)
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, complete.Digest).Return(complete.MetaInfo, nil)
+	tor, err := archive.CreateTorrent(namespace, complete.Digest)
+	require.NoError(err)
+	for i := 0; i < tor.NumPieces(); i++ {
+		require.NoError(tor.WritePiece(piecereader.NewBuffer(complete.Content[i:i+1]), i))
+	}
+
+	partial := core.SizedBlobFixture(4, 1)
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, partial.Digest).Return(partial.MetaInfo, nil)
+	tor, err = archive.CreateTorrent(namespace, partial.Digest)
+	require.NoError(err)
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(partial.Content[0:1]), 0))
+
+	records, err := archive.ListTorrents()
+	require.NoError(err)
+
+	result := make(map[core.Digest]bool)
+	for _, r := range records {
+		require.Equal(namespace, r.Namespace)
+		result[r.Digest] = r.Complete
+	}
+	require.Equal(map[core.Digest]bool{
+		complete.Digest: true,
+		partial.Digest:  false,
+	}, result)
+
+	// Restored partial torrents keep their piece status.
+	tor, err = archive.GetTorrent(namespace, partial.Digest)
+	require.NoError(err)
+	require.Equal(bitsetutil.FromBools(true, false, false, false), tor.Bitfield())
+}
+
+func TestTorrentArchiveListTorrentsSkipsTorrentsWithoutNamespace(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newArchiveMocks(t)
+	defer cleanup()
+
+	archive := mocks.new()
+
+	// Torrents created before namespaces were recorded.
+	blob := core.SizedBlobFixture(4, 1)
+	require.NoError(mocks.cads.CreateDownloadFile(blob.Digest.Hex(), blob.MetaInfo.Length()))
+	_, err := mocks.cads.Any().SetMetadata(
+		blob.Digest.Hex(), metadata.NewTorrentMeta(blob.MetaInfo))
+	require.NoError(err)
+
+	records, err := archive.ListTorrents()
+	require.NoError(err)
+	require.Empty(records)
+}
@@ -95,3 +95,9 @@ func (a *TorrentArchive) DeleteTorrent(d core.Digest) error {
 	}
 	return nil
 }
+
+// ListTorrents returns no torrents. Origins seed lazily from the cache
+// directory, so there is nothing to restore on startup.
+func (a *TorrentArchive) ListTorrents() ([]*storage.TorrentRecord, error) {
+	return nil, nil
+}
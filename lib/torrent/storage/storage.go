@@ -16,6 +16,7 @@ package storage
 import (
 	"errors"
 	"io"
+	"time"
 
 	"github.com/uber/kraken/core"
 
@@ -56,10 +57,19 @@ type Torrent interface {
 	GetPieceReader(piece int) (PieceReader, error)
 }
 
+// TorrentRecord describes a torrent which was persisted by a TorrentArchive.
+type TorrentRecord struct {
+	Namespace      string
+	Digest         core.Digest
+	Complete       bool
+	LastAccessTime time.Time
+}
+
 // TorrentArchive creates and open torrent file
 type TorrentArchive interface {
 	Stat(namespace string, d core.Digest) (*TorrentInfo, error)
 	CreateTorrent(namespace string, d core.Digest) (Torrent, error)
 	GetTorrent(namespace string, d core.Digest) (Torrent, error)
 	DeleteTorrent(d core.Digest) error
+	ListTorrents() ([]*TorrentRecord, error)
 }
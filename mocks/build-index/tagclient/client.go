@@ -9,6 +9,7 @@ import (
 	tagclient "github.com/uber/kraken/build-index/tagclient"
 	tagmodels "github.com/uber/kraken/build-index/tagmodels"
 	core "github.com/uber/kraken/core"
+	dockerutil "github.com/uber/kraken/utils/dockerutil"
 	reflect "reflect"
 	time "time"
 )
@@ -37,7 +38,7 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 }
 
 // DuplicatePut mocks base method
-func (m *MockClient) DuplicatePut(arg0 string, arg1 core.Digest, arg2 time.Duration) error {
+func (m *MockClient) DuplicatePut(arg0 string, arg1 tagmodels.TagRecord, arg2 time.Duration) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DuplicatePut", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
@@ -51,7 +52,7 @@ func (mr *MockClientMockRecorder) DuplicatePut(arg0, arg1, arg2 interface{}) *go
 }
 
 // DuplicateReplicate mocks base method
-func (m *MockClient) DuplicateReplicate(arg0 string, arg1 core.Digest, arg2 core.DigestList, arg3 time.Duration) error {
+func (m *MockClient) DuplicateReplicate(arg0 string, arg1 tagmodels.TagRecord, arg2 core.DigestList, arg3 time.Duration) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DuplicateReplicate", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
@@ -79,6 +80,36 @@ func (mr *MockClientMockRecorder) Get(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockClient)(nil).Get), arg0)
 }
 
+// GetRecord mocks base method
+func (m *MockClient) GetRecord(arg0 string) (tagmodels.TagRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecord", arg0)
+	ret0, _ := ret[0].(tagmodels.TagRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecord indicates an expected call of GetRecord
+func (mr *MockClientMockRecorder) GetRecord(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecord", reflect.TypeOf((*MockClient)(nil).GetRecord), arg0)
+}
+
+// GetReferrers mocks base method
+func (m *MockClient) GetReferrers(arg0 string, arg1 core.Digest, arg2 string) (*dockerutil.ImageIndex, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReferrers", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*dockerutil.ImageIndex)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReferrers indicates an expected call of GetReferrers
+func (mr *MockClientMockRecorder) GetReferrers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReferrers", reflect.TypeOf((*MockClient)(nil).GetReferrers), arg0, arg1, arg2)
+}
+
 // Has mocks base method
 func (m *MockClient) Has(arg0 string) (bool, error) {
 	m.ctrl.T.Helper()
@@ -197,6 +228,34 @@ func (mr *MockClientMockRecorder) PutAndReplicate(arg0, arg1 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutAndReplicate", reflect.TypeOf((*MockClient)(nil).PutAndReplicate), arg0, arg1)
 }
 
+// PutRecordAndReplicate mocks base method
+func (m *MockClient) PutRecordAndReplicate(arg0 string, arg1 tagmodels.TagRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutRecordAndReplicate", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutRecordAndReplicate indicates an expected call of PutRecordAndReplicate
+func (mr *MockClientMockRecorder) PutRecordAndReplicate(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutRecordAndReplicate", reflect.TypeOf((*MockClient)(nil).PutRecordAndReplicate), arg0, arg1)
+}
+
+// PutReferrer mocks base method
+func (m *MockClient) PutReferrer(arg0 string, arg1 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutReferrer", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutReferrer indicates an expected call of PutReferrer
+func (mr *MockClientMockRecorder) PutReferrer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutReferrer", reflect.TypeOf((*MockClient)(nil).PutReferrer), arg0, arg1)
+}
+
 // Replicate mocks base method
 func (m *MockClient) Replicate(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -49,6 +49,20 @@ func (mr *MockFileStoreMockRecorder) CreateCacheFile(arg0, arg1 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCacheFile", reflect.TypeOf((*MockFileStore)(nil).CreateCacheFile), arg0, arg1)
 }
 
+// GetCacheFileMetadata mocks base method
+func (m *MockFileStore) GetCacheFileMetadata(arg0 string, arg1 metadata.Metadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCacheFileMetadata", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetCacheFileMetadata indicates an expected call of GetCacheFileMetadata
+func (mr *MockFileStoreMockRecorder) GetCacheFileMetadata(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCacheFileMetadata", reflect.TypeOf((*MockFileStore)(nil).GetCacheFileMetadata), arg0, arg1)
+}
+
 // GetCacheFileReader mocks base method
 func (m *MockFileStore) GetCacheFileReader(arg0 string) (base.FileReader, error) {
 	m.ctrl.T.Helper()
@@ -64,6 +78,20 @@ func (mr *MockFileStoreMockRecorder) GetCacheFileReader(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCacheFileReader", reflect.TypeOf((*MockFileStore)(nil).GetCacheFileReader), arg0)
 }
 
+// OverwriteCacheFile mocks base method
+func (m *MockFileStore) OverwriteCacheFile(arg0 string, arg1 io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OverwriteCacheFile", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OverwriteCacheFile indicates an expected call of OverwriteCacheFile
+func (mr *MockFileStoreMockRecorder) OverwriteCacheFile(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OverwriteCacheFile", reflect.TypeOf((*MockFileStore)(nil).OverwriteCacheFile), arg0, arg1)
+}
+
 // SetCacheFileMetadata mocks base method
 func (m *MockFileStore) SetCacheFileMetadata(arg0 string, arg1 metadata.Metadata) (bool, error) {
 	m.ctrl.T.Helper()
@@ -6,6 +6,7 @@ package mocktagstore
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	tagmodels "github.com/uber/kraken/build-index/tagmodels"
 	core "github.com/uber/kraken/core"
 	reflect "reflect"
 	time "time"
@@ -49,12 +50,28 @@ func (mr *MockStoreMockRecorder) Get(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStore)(nil).Get), arg0)
 }
 
+// GetRecord mocks base method
+func (m *MockStore) GetRecord(arg0 string) (tagmodels.TagRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecord", arg0)
+	ret0, _ := ret[0].(tagmodels.TagRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecord indicates an expected call of GetRecord
+func (mr *MockStoreMockRecorder) GetRecord(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecord", reflect.TypeOf((*MockStore)(nil).GetRecord), arg0)
+}
+
 // Put mocks base method
-func (m *MockStore) Put(arg0 string, arg1 core.Digest, arg2 time.Duration) error {
+func (m *MockStore) Put(arg0 string, arg1 core.Digest, arg2 time.Duration) (tagmodels.TagRecord, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Put", arg0, arg1, arg2)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(tagmodels.TagRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // Put indicates an expected call of Put
@@ -62,3 +79,18 @@ func (mr *MockStoreMockRecorder) Put(arg0, arg1, arg2 interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockStore)(nil).Put), arg0, arg1, arg2)
 }
+
+// PutRecord mocks base method
+func (m *MockStore) PutRecord(arg0 string, arg1 tagmodels.TagRecord, arg2 time.Duration) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutRecord", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutRecord indicates an expected call of PutRecord
+func (mr *MockStoreMockRecorder) PutRecord(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutRecord", reflect.TypeOf((*MockStore)(nil).PutRecord), arg0, arg1, arg2)
+}
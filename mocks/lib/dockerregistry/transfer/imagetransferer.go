@@ -79,6 +79,20 @@ func (mr *MockImageTransfererMockRecorder) ListTags(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTags", reflect.TypeOf((*MockImageTransferer)(nil).ListTags), arg0)
 }
 
+// PutReferrer mocks base method
+func (m *MockImageTransferer) PutReferrer(arg0 string, arg1 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutReferrer", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutReferrer indicates an expected call of PutReferrer
+func (mr *MockImageTransfererMockRecorder) PutReferrer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutReferrer", reflect.TypeOf((*MockImageTransferer)(nil).PutReferrer), arg0, arg1)
+}
+
 // PutTag mocks base method
 func (m *MockImageTransferer) PutTag(arg0 string, arg1 core.Digest) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,46 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/uber/kraken/lib/persistedretry/notification (interfaces: Notifier)
+
+// Package mocknotification is a generated GoMock package.
+package mocknotification
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	notification "github.com/uber/kraken/lib/persistedretry/notification"
+	reflect "reflect"
+)
+
+// MockNotifier is a mock of Notifier interface
+type MockNotifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotifierMockRecorder
+}
+
+// MockNotifierMockRecorder is the mock recorder for MockNotifier
+type MockNotifierMockRecorder struct {
+	mock *MockNotifier
+}
+
+// NewMockNotifier creates a new mock instance
+func NewMockNotifier(ctrl *gomock.Controller) *MockNotifier {
+	mock := &MockNotifier{ctrl: ctrl}
+	mock.recorder = &MockNotifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockNotifier) EXPECT() *MockNotifierMockRecorder {
+	return m.recorder
+}
+
+// Notify mocks base method
+func (m *MockNotifier) Notify(arg0 *notification.Event) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Notify", arg0)
+}
+
+// Notify indicates an expected call of Notify
+func (mr *MockNotifierMockRecorder) Notify(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockNotifier)(nil).Notify), arg0)
+}
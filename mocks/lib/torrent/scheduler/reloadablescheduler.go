@@ -64,6 +64,20 @@ func (mr *MockReloadableSchedulerMockRecorder) Download(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockReloadableScheduler)(nil).Download), arg0, arg1)
 }
 
+// DownloadRange mocks base method
+func (m *MockReloadableScheduler) DownloadRange(arg0 string, arg1 core.Digest, arg2, arg3 int64, arg4 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadRange", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadRange indicates an expected call of DownloadRange
+func (mr *MockReloadableSchedulerMockRecorder) DownloadRange(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadRange", reflect.TypeOf((*MockReloadableScheduler)(nil).DownloadRange), arg0, arg1, arg2, arg3, arg4)
+}
+
 // Probe mocks base method
 func (m *MockReloadableScheduler) Probe() error {
 	m.ctrl.T.Helper()
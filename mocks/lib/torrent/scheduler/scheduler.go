@@ -63,6 +63,20 @@ func (mr *MockSchedulerMockRecorder) Download(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockScheduler)(nil).Download), arg0, arg1)
 }
 
+// DownloadRange mocks base method
+func (m *MockScheduler) DownloadRange(arg0 string, arg1 core.Digest, arg2, arg3 int64, arg4 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadRange", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadRange indicates an expected call of DownloadRange
+func (mr *MockSchedulerMockRecorder) DownloadRange(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadRange", reflect.TypeOf((*MockScheduler)(nil).DownloadRange), arg0, arg1, arg2, arg3, arg4)
+}
+
 // Probe mocks base method
 func (m *MockScheduler) Probe() error {
 	m.ctrl.T.Helper()
@@ -40,7 +40,7 @@ server {
 
     proxy_cache         tags;
     proxy_cache_methods GET;
-    proxy_cache_valid   200 5m;
+    proxy_cache_valid   200 10s;
     proxy_cache_valid   any 1s;
     proxy_cache_lock    on;
   }
@@ -59,6 +59,25 @@ server {
     proxy_set_header Host $hostheader:{{.}};
   }
 
+  location ~ ^/v2/.+/referrers/ {
+    proxy_pass http://registry-override;
+
+    set $hostheader $hostname;
+    if ( $host = "localhost" ) {
+      set $hostheader "localhost";
+    }
+    if ( $host = "127.0.0.1" ) {
+      set $hostheader "127.0.0.1";
+    }
+    if ( $host = "192.168.65.1" ) {
+      set $hostheader "192.168.65.1";
+    }
+    if ( $host = "host.docker.internal" ) {
+      set $hostheader "host.docker.internal";
+    }
+    proxy_set_header Host $hostheader:{{.}};
+  }
+
   location / {
     proxy_pass http://registry;
 
@@ -23,6 +23,7 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
 	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/mocks/origin/blobclient"
 	"github.com/uber/kraken/origin/blobclient"
@@ -65,6 +66,8 @@ func TestClusterClientResilientToUnavailableMasters(t *testing.T) {
 		s.writeBackManager.EXPECT().Add(
 			writeback.MatchTask(writeback.NewTask(
 				backend.NoopNamespace, blob.Digest.Hex(), 0))).Return(nil)
+		s.notifier.EXPECT().Notify(notification.NewBlobEvent(
+			notification.ActionPush, backend.NoopNamespace, blob.Digest))
 		require.NoError(cc.UploadBlob(backend.NoopNamespace, blob.Digest, bytes.NewReader(blob.Content)))
 
 		bi, err := cc.Stat(backend.NoopNamespace, blob.Digest)
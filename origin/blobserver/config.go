@@ -23,11 +23,33 @@ import (
 type Config struct {
 	Listener                  listener.Config `yaml:"listener"`
 	DuplicateWriteBackStagger time.Duration   `yaml:"duplicate_write_back_stagger"`
+
+	// DrainPollInterval is how often a draining origin checks whether it can
+	// proceed to the next drain state.
+	DrainPollInterval time.Duration `yaml:"drain_poll_interval"`
+
+	// DrainUploadTimeout is how long a draining origin waits for uploads which
+	// were started before the drain.
+	DrainUploadTimeout time.Duration `yaml:"drain_upload_timeout"`
+
+	// DrainMaxWriteBackFailures is how many times a write-back may fail before
+	// a draining origin stops waiting for it. Such write-backs are reported as
+	// failed in the drain status.
+	DrainMaxWriteBackFailures int `yaml:"drain_max_writeback_failures"`
 }
 
 func (c Config) applyDefaults() Config {
 	if c.DuplicateWriteBackStagger == 0 {
 		c.DuplicateWriteBackStagger = 30 * time.Minute
 	}
+	if c.DrainPollInterval == 0 {
+		c.DrainPollInterval = 10 * time.Second
+	}
+	if c.DrainUploadTimeout == 0 {
+		c.DrainUploadTimeout = 10 * time.Minute
+	}
+	if c.DrainMaxWriteBackFailures == 0 {
+		c.DrainMaxWriteBackFailures = 3
+	}
 	return c
 }
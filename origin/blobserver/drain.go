@@ -0,0 +1,253 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/origin/rebalancer"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Drain states.
+const (
+	// DrainStateServing is the normal state of an origin.
+	DrainStateServing = "serving"
+
+	// DrainStateDraining rejects new uploads and waits for in-flight uploads
+	// and pending write-backs to finish.
+	DrainStateDraining = "draining"
+
+	// DrainStateHandingOff reports unhealthy and transfers all blobs to the
+	// rest of the ring.
+	DrainStateHandingOff = "handing_off"
+
+	// DrainStateDrained indicates the origin can be safely removed.
+	DrainStateDrained = "drained"
+
+	// DrainStateNoTargets indicates the hand off stopped because some blobs
+	// have no other healthy owner in the ring. The origin must not be removed.
+	DrainStateNoTargets = "no_targets"
+)
+
+// DrainStatus describes the progress of draining an origin.
+type DrainStatus struct {
+	State             string              `json:"state"`
+	InFlightUploads   int                 `json:"in_flight_uploads"`
+	PendingWriteBacks int                 `json:"pending_writebacks"`
+	FailedWriteBacks  int                 `json:"failed_writebacks"`
+	HandOff           rebalancer.Progress `json:"hand_off"`
+}
+
+// drainer tracks the drain state of a Server.
+type drainer struct {
+	mu                sync.Mutex
+	state             string
+	uploads           map[string]time.Time // Started but uncommitted uploads.
+	pendingWriteBacks int
+	failedWriteBacks  int
+}
+
+func newDrainer() *drainer {
+	return &drainer{
+		state:   DrainStateServing,
+		uploads: make(map[string]time.Time),
+	}
+}
+
+func (d *drainer) getState() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.state
+}
+
+func (d *drainer) setState(state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state = state
+}
+
+// start transitions to draining. Returns false if already draining.
+func (d *drainer) start() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != DrainStateServing {
+		return false
+	}
+	d.state = DrainStateDraining
+	return true
+}
+
+func (d *drainer) acceptsUploads() bool {
+	return d.getState() == DrainStateServing
+}
+
+func (d *drainer) healthy() bool {
+	s := d.getState()
+	return s == DrainStateServing || s == DrainStateDraining
+}
+
+func (d *drainer) addUpload(uid string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.uploads[uid] = now
+}
+
+func (d *drainer) removeUpload(uid string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.uploads, uid)
+}
+
+// inFlightUploads returns the number of uploads started within timeout of
+// now which have not been committed yet. Older uploads are considered
+// abandoned.
+func (d *drainer) inFlightUploads(now time.Time, timeout time.Duration) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var n int
+	for uid, started := range d.uploads {
+		if now.Sub(started) > timeout {
+			delete(d.uploads, uid)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func (d *drainer) setWriteBacks(pending, failed int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pendingWriteBacks = pending
+	d.failedWriteBacks = failed
+}
+
+// drainHandler starts draining the origin. Draining cannot be undone without
+// restarting the origin.
+func (s *Server) drainHandler(w http.ResponseWriter, r *http.Request) error {
+	if s.drain.start() {
+		log.Info("Draining origin")
+		s.stats.Counter("drains").Inc(1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.runDrain()
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return s.writeDrainStatus(w)
+}
+
+// getDrainHandler returns the drain progress of the origin.
+func (s *Server) getDrainHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.writeDrainStatus(w)
+}
+
+func (s *Server) writeDrainStatus(w http.ResponseWriter) error {
+	s.drain.mu.Lock()
+	status := DrainStatus{
+		State:             s.drain.state,
+		InFlightUploads:   len(s.drain.uploads),
+		PendingWriteBacks: s.drain.pendingWriteBacks,
+		FailedWriteBacks:  s.drain.failedWriteBacks,
+		HandOff:           s.rebalancer.Progress(),
+	}
+	s.drain.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) runDrain() {
+	for !s.readyToHandOff() {
+		if !s.waitDrainPoll() {
+			return
+		}
+	}
+
+	log.Info("Uploads and write-backs finished, handing off blobs")
+	s.drain.setState(DrainStateHandingOff)
+	for {
+		// Picks up our own failing health check as soon as possible, such
+		// that we stop showing up as an owner of our blobs.
+		s.hashRing.Refresh()
+		failures, noOwners := s.rebalancer.Drain(s.addr, s.hashRing)
+		if failures == 0 {
+			break
+		}
+		if failures == noOwners {
+			// Retrying cannot succeed until other origins become available,
+			// which may never happen (e.g. in a single origin cluster).
+			log.With("blobs", noOwners).Error(
+				"No other owners to hand off blobs to, stopping drain")
+			s.stats.Counter("drain_no_targets").Inc(1)
+			s.drain.setState(DrainStateNoTargets)
+			return
+		}
+		log.With("failures", failures).Info("Failed to hand off some blobs, retrying")
+		if !s.waitDrainPoll() {
+			return
+		}
+	}
+
+	log.Info("Origin drained")
+	s.drain.setState(DrainStateDrained)
+}
+
+// waitDrainPoll waits for the next drain poll. Returns false if s was stopped.
+func (s *Server) waitDrainPoll() bool {
+	select {
+	case <-s.stop:
+		return false
+	case <-s.clk.After(s.config.DrainPollInterval):
+		return true
+	}
+}
+
+// readyToHandOff returns true once all in-flight uploads and write-backs have
+// finished. Write-backs which failed more than the configured max failures are
+// not waited for, since they may never succeed.
+func (s *Server) readyToHandOff() bool {
+	uploads := s.drain.inFlightUploads(s.clk.Now(), s.config.DrainUploadTimeout)
+	tasks, err := s.writeBackManager.Find(writeback.NewAllQuery())
+	if err != nil {
+		log.Errorf("Error finding pending write-backs: %s", err)
+		return false
+	}
+	var pending, failed int
+	for _, t := range tasks {
+		if t.GetFailures() >= s.config.DrainMaxWriteBackFailures {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	s.drain.setWriteBacks(pending, failed)
+	return uploads == 0 && pending == 0
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package blobserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/healthcheck"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+)
+
+func getDrainStatus(t *testing.T, addr string) DrainStatus {
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/drain", addr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var status DrainStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+func startDrain(t *testing.T, addr string) DrainStatus {
+	resp, err := httputil.Post(
+		fmt.Sprintf("http://%s/drain", addr),
+		httputil.SendAcceptedCodes(http.StatusAccepted))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var status DrainStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+// waitForDrainState advances the clock of s until it reaches state.
+func waitForDrainState(t *testing.T, s *testServer, state string) {
+	require.NoError(t, testutil.PollUntilTrue(5*time.Second, func() bool {
+		if getDrainStatus(t, s.addr).State == state {
+			return true
+		}
+		s.clk.Add(10 * time.Second)
+		return false
+	}))
+}
+
+func TestDrainHandsOffBlobs(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingSomeReplica()
+	cp := newTestClientProvider()
+
+	s1 := newTestServer(t, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServer(t, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForHosts(ring, s1.host, s2.host)
+	require.NoError(s1.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	s1.writeBackManager.EXPECT().Find(writeback.NewAllQuery()).Return(nil, nil).AnyTimes()
+
+	require.NotEqual(DrainStateServing, startDrain(t, s1.addr).State)
+
+	waitForDrainState(t, s1, DrainStateDrained)
+
+	status := getDrainStatus(t, s1.addr)
+	require.Equal(1, status.HandOff.Total)
+	require.Equal(0, status.HandOff.Remaining)
+
+	// Blob was moved to the other owner.
+	_, err := s1.cas.GetCacheFileStat(blob.Digest.Hex())
+	require.True(os.IsNotExist(err))
+	_, err = s2.cas.GetCacheFileStat(blob.Digest.Hex())
+	require.NoError(err)
+
+	// Drained origins are unhealthy and reject uploads.
+	_, err = httputil.Get(fmt.Sprintf("http://%s/health", s1.addr))
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+
+	_, err = httputil.Post(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s/uploads", s1.addr, "some-namespace", blob.Digest))
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+
+	// Draining again is a no-op.
+	require.Equal(DrainStateDrained, startDrain(t, s1.addr).State)
+}
+
+func TestDrainWaitsForUploadsAndWriteBacks(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	d := core.DigestFixture()
+
+	// Upload started before drain.
+	_, err := httputil.Post(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s/uploads", s.addr, "some-namespace", d))
+	require.NoError(err)
+
+	task := writeback.TaskFixture()
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewAllQuery()).Return([]persistedretry.Task{task}, nil)
+
+	startDrain(t, s.addr)
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return getDrainStatus(t, s.addr).PendingWriteBacks == 1
+	}))
+	status := getDrainStatus(t, s.addr)
+	require.Equal(DrainStateDraining, status.State)
+	require.Equal(1, status.InFlightUploads)
+	require.Equal(0, status.FailedWriteBacks)
+
+	// Still healthy while waiting for uploads and write-backs.
+	_, err = httputil.Get(fmt.Sprintf("http://%s/health", s.addr))
+	require.NoError(err)
+
+	// Write-backs finish and the upload is abandoned.
+	s.writeBackManager.EXPECT().Find(writeback.NewAllQuery()).Return(nil, nil).AnyTimes()
+	s.clk.Add(10 * time.Minute)
+
+	waitForDrainState(t, s, DrainStateDrained)
+}
+
+func TestDrainDoesNotWaitForFailedWriteBacks(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	failed := writeback.TaskFixture()
+	failed.Failures = Config{}.applyDefaults().DrainMaxWriteBackFailures
+
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewAllQuery()).Return([]persistedretry.Task{failed}, nil).AnyTimes()
+
+	startDrain(t, s.addr)
+
+	waitForDrainState(t, s, DrainStateDrained)
+
+	status := getDrainStatus(t, s.addr)
+	require.Equal(0, status.PendingWriteBacks)
+	require.Equal(1, status.FailedWriteBacks)
+}
+
+func TestDrainStopsWithoutOtherOwners(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashring.New(
+		hashring.Config{MaxReplica: 1},
+		hostlist.Fixture(master1),
+		healthcheck.IdentityFilter{})
+
+	s := newTestServer(t, master1, ring, newTestClientProvider())
+	defer s.cleanup()
+
+	blob := core.SizedBlobFixture(32, 4)
+	require.NoError(s.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	s.writeBackManager.EXPECT().Find(writeback.NewAllQuery()).Return(nil, nil).AnyTimes()
+
+	startDrain(t, s.addr)
+
+	waitForDrainState(t, s, DrainStateNoTargets)
+
+	// The blob is kept, and the origin stays unhealthy.
+	_, err := s.cas.GetCacheFileStat(blob.Digest.Hex())
+	require.NoError(err)
+
+	_, err = httputil.Get(fmt.Sprintf("http://%s/health", s.addr))
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+}
+
+func TestDrainExitsOnStop(t *testing.T) {
+	require := require.New(t)
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), newTestClientProvider())
+	defer s.cleanup()
+
+	task := writeback.TaskFixture()
+	s.writeBackManager.EXPECT().Find(
+		writeback.NewAllQuery()).Return([]persistedretry.Task{task}, nil).AnyTimes()
+
+	startDrain(t, s.addr)
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return getDrainStatus(t, s.addr).PendingWriteBacks == 1
+	}))
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.FailNow("drain did not exit on stop")
+	}
+	require.Equal(DrainStateDraining, getDrainStatus(t, s.addr).State)
+}
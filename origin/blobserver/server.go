@@ -15,6 +15,7 @@ package blobserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -33,10 +34,12 @@ import (
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/origin/rebalancer"
 	"github.com/uber/kraken/utils/errutil"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
@@ -52,6 +55,8 @@ import (
 
 const _uploadChunkSize = 16 * memsize.MB
 
+var errDraining = handler.Errorf("origin is draining").Status(http.StatusServiceUnavailable)
+
 // Server defines a server that serves blob data for agent.
 type Server struct {
 	config            Config
@@ -67,6 +72,13 @@ type Server struct {
 	metaInfoGenerator *metainfogen.Generator
 	uploader          *uploader
 	writeBackManager  persistedretry.Manager
+	notifier          notification.Notifier
+	rebalancer        *rebalancer.Rebalancer
+	drain             *drainer
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 
 	// This is an unfortunate coupling between the p2p client and the blob server.
 	// Tracker queries the origin cluster to discover which origins can seed
@@ -89,7 +101,9 @@ func New(
 	backends *backend.Manager,
 	blobRefresher *blobrefresh.Refresher,
 	metaInfoGenerator *metainfogen.Generator,
-	writeBackManager persistedretry.Manager) (*Server, error) {
+	writeBackManager persistedretry.Manager,
+	notifier notification.Notifier,
+	rebalancer *rebalancer.Rebalancer) (*Server, error) {
 
 	config = config.applyDefaults()
 
@@ -111,10 +125,20 @@ func New(
 		metaInfoGenerator: metaInfoGenerator,
 		uploader:          newUploader(cas),
 		writeBackManager:  writeBackManager,
+		notifier:          notifier,
+		rebalancer:        rebalancer,
+		drain:             newDrainer(),
+		stop:              make(chan struct{}),
 		pctx:              pctx,
 	}, nil
 }
 
+// Stop stops any background work of s, such as a running drain.
+func (s *Server) Stop() {
+	s.closeOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}
+
 // Addr returns the address the blob server is configured on.
 func (s *Server) Addr() string {
 	return s.addr
@@ -143,6 +167,9 @@ func (s *Server) Handler() http.Handler {
 
 	r.Post("/forcecleanup", handler.Wrap(s.forceCleanupHandler))
 
+	r.Post("/drain", handler.Wrap(s.drainHandler))
+	r.Get("/drain", handler.Wrap(s.getDrainHandler))
+
 	// Internal endpoints:
 
 	r.Post("/internal/blobs/{digest}/uploads", handler.Wrap(s.startTransferHandler))
@@ -175,6 +202,9 @@ func (s *Server) ListenAndServe(h http.Handler) error {
 }
 
 func (s *Server) healthCheckHandler(w http.ResponseWriter, r *http.Request) error {
+	if !s.drain.healthy() {
+		return handler.Errorf("origin is draining").Status(http.StatusServiceUnavailable)
+	}
 	fmt.Fprintln(w, "OK")
 	return nil
 }
@@ -484,6 +514,9 @@ func (s *Server) startTransferHandler(w http.ResponseWriter, r *http.Request) er
 	if err != nil {
 		return err
 	}
+	if !s.drain.acceptsUploads() {
+		return errDraining
+	}
 	if ok, err := blobExists(s.cas, d); err != nil {
 		return handler.Errorf("check blob: %s", err)
 	} else if ok {
@@ -558,10 +591,14 @@ func (s *Server) startClusterUploadHandler(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return err
 	}
+	if !s.drain.acceptsUploads() {
+		return errDraining
+	}
 	uid, err := s.uploader.start(d)
 	if err != nil {
 		return s.handleUploadConflict(err, namespace, d)
 	}
+	s.drain.addUpload(uid, s.clk.Now())
 	setUploadLocation(w, uid)
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -608,9 +645,12 @@ func (s *Server) commitClusterUploadHandler(w http.ResponseWriter, r *http.Reque
 		return err
 	}
 
+	defer s.drain.removeUpload(uid)
+
 	if err := s.uploader.commit(d, uid); err != nil {
 		return s.handleUploadConflict(err, namespace, d)
 	}
+	s.notifier.Notify(notification.NewBlobEvent(notification.ActionPush, namespace, d))
 	if err := s.writeBack(namespace, d, 0); err != nil {
 		return err
 	}
@@ -664,6 +704,9 @@ func (s *Server) writeBack(namespace string, d core.Digest, delay time.Duration)
 	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), metadata.NewPersist(true)); err != nil {
 		return handler.Errorf("set persist metadata: %s", err)
 	}
+	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), metadata.NewNamespace(namespace)); err != nil {
+		return handler.Errorf("set namespace metadata: %s", err)
+	}
 	task := writeback.NewTask(namespace, d.Hex(), delay)
 	if err := s.writeBackManager.Add(task); err != nil {
 		return handler.Errorf("add write-back task: %s", err)
@@ -748,3 +791,41 @@ func (s *Server) maybeDelete(name string, ttl time.Duration) (deleted bool, err
 	}
 	return false, nil
 }
+
+// FetchCorruptBlob downloads d from another origin which owns it, to replace a
+// corrupt local copy that has not been written back yet. Implements
+// store.CorruptBlobFetcher.
+func (s *Server) FetchCorruptBlob(d core.Digest, namespace string, dst io.Writer) error {
+	var errs []error
+	for _, addr := range s.hashRing.Locations(d) {
+		if addr == s.addr {
+			continue
+		}
+		w := &countingWriter{w: dst}
+		err := s.clientProvider.Provide(addr).DownloadBlob(namespace, d, w)
+		if err == nil {
+			return nil
+		}
+		if w.n > 0 {
+			// dst is partially written and cannot be retried from another origin.
+			return fmt.Errorf("download from %s: %s", addr, err)
+		}
+		errs = append(errs, fmt.Errorf("download from %s: %s", addr, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no other origins own blob")
+	}
+	return errutil.Join(errs)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend/backenderrors"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/origin/blobclient"
@@ -306,6 +307,43 @@ func TestGetMetaInfoDownloadsBlobAndReplicates(t *testing.T) {
 	}))
 }
 
+func TestFetchCorruptBlobDownloadsFromOtherOwner(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingSomeReplica()
+	cp := newTestClientProvider()
+	namespace := core.TagFixture()
+
+	s1 := newTestServer(t, master1, ring, cp)
+	defer s1.cleanup()
+
+	s2 := newTestServer(t, master2, ring, cp)
+	defer s2.cleanup()
+
+	blob := computeBlobForHosts(ring, s1.host, s2.host)
+
+	require.NoError(s2.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	var buf bytes.Buffer
+	require.NoError(s1.server.FetchCorruptBlob(blob.Digest, namespace, &buf))
+	require.Equal(blob.Content, buf.Bytes())
+}
+
+func TestFetchCorruptBlobErrorsWithoutOtherOwners(t *testing.T) {
+	require := require.New(t)
+
+	ring := hashRingNoReplica()
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, ring, cp)
+	defer s.cleanup()
+
+	blob := computeBlobForHosts(ring, s.host)
+
+	var buf bytes.Buffer
+	require.Error(s.server.FetchCorruptBlob(blob.Digest, core.TagFixture(), &buf))
+}
+
 func TestGetMetaInfoBlobNotFound(t *testing.T) {
 	require := require.New(t)
 
@@ -617,6 +655,8 @@ func TestUploadBlobDuplicatesWriteBackTaskToReplicas(t *testing.T) {
 
 	s1.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s1.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest))
 	s2.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 30*time.Minute)))
 
@@ -647,6 +687,8 @@ func TestUploadBlobRetriesWriteBackFailure(t *testing.T) {
 	expectedTask := writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))
 
 	gomock.InOrder(
+		s.notifier.EXPECT().Notify(
+			notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest)),
 		s.writeBackManager.EXPECT().Add(expectedTask).Return(errors.New("some error")),
 		s.writeBackManager.EXPECT().Add(expectedTask).Return(nil),
 	)
@@ -682,6 +724,8 @@ func TestUploadBlobResilientToDuplicationFailure(t *testing.T) {
 
 	s.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest))
 
 	err := cp.Provide(s.host).UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
 	require.NoError(err)
@@ -706,6 +750,8 @@ func TestForceCleanupTTL(t *testing.T) {
 
 	s.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest))
 
 	require.NoError(client.UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content)))
 
@@ -748,6 +794,8 @@ func TestForceCleanupNonOwner(t *testing.T) {
 
 	s1.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 0))).Return(nil)
+	s1.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest))
 
 	s2.writeBackManager.EXPECT().Add(
 		writeback.MatchTask(writeback.NewTask(namespace, blob.Digest.Hex(), 30*time.Minute)))
@@ -783,6 +831,8 @@ func TestForceCleanupWriteBackFailures(t *testing.T) {
 	task := writeback.NewTask(namespace, blob.Digest.Hex(), 0)
 
 	s.writeBackManager.EXPECT().Add(writeback.MatchTask(task)).Return(nil)
+	s.notifier.EXPECT().Notify(
+		notification.NewBlobEvent(notification.ActionPush, namespace, blob.Digest))
 
 	require.NoError(client.UploadBlob(namespace, blob.Digest, bytes.NewReader(blob.Content)))
 
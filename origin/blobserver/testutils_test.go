@@ -35,8 +35,10 @@ import (
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/mocks/lib/persistedretry"
+	"github.com/uber/kraken/mocks/lib/persistedretry/notification"
 	"github.com/uber/kraken/mocks/origin/blobclient"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/origin/rebalancer"
 	"github.com/uber/kraken/utils/log"
 	"github.com/uber/kraken/utils/stringset"
 	"github.com/uber/kraken/utils/testutil"
@@ -100,7 +102,9 @@ type testServer struct {
 	pctx             core.PeerContext
 	backendManager   *backend.Manager
 	writeBackManager *mockpersistedretry.MockManager
+	notifier         *mocknotification.MockNotifier
 	clk              *clock.Mock
+	server           *Server
 	cleanup          func()
 }
 
@@ -124,6 +128,8 @@ func newTestServer(
 
 	writeBackManager := mockpersistedretry.NewMockManager(ctrl)
 
+	notifier := mocknotification.NewMockNotifier(ctrl)
+
 	mg := metainfogen.Fixture(cas, 4)
 
 	br := blobrefresh.New(blobrefresh.Config{}, tally.NoopScope, cas, bm, mg)
@@ -131,12 +137,18 @@ func newTestServer(
 	clk := clock.NewMock()
 	clk.Set(time.Now())
 
+	rb, err := rebalancer.New(rebalancer.Config{}, tally.NoopScope, clk, cas, cp, writeBackManager)
+	if err != nil {
+		panic(err)
+	}
+
 	s, err := New(
 		Config{}, tally.NoopScope, clk, host, ring, cas, cp, clusterProvider, pctx,
-		bm, br, mg, writeBackManager)
+		bm, br, mg, writeBackManager, notifier, rb)
 	if err != nil {
 		panic(err)
 	}
+	cleanup.Add(s.Stop)
 
 	addr, stop := testutil.StartServer(s.Handler())
 	cleanup.Add(stop)
@@ -153,7 +165,9 @@ func newTestServer(
 		pctx:             pctx,
 		backendManager:   bm,
 		writeBackManager: writeBackManager,
+		notifier:         notifier,
 		clk:              clk,
+		server:           s,
 		cleanup:          cleanup.Run,
 	}
 }
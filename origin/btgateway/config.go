@@ -0,0 +1,100 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btgateway
+
+import (
+	"time"
+
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/memsize"
+)
+
+// Config defines Gateway configuration.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+
+	// HTTPAddr is the address the tracker and torrent file endpoints listen on.
+	HTTPAddr string `yaml:"http_addr"`
+
+	// PeerPort is the port the peer wire protocol listens on.
+	PeerPort int `yaml:"peer_port"`
+
+	// AdvertiseIP is the ip of the gateway handed out to clients by the
+	// tracker. Defaults to the local ip.
+	AdvertiseIP string `yaml:"advertise_ip"`
+
+	// AnnounceURL is the tracker url embedded in generated torrent files.
+	// Defaults to the announce endpoint on AdvertiseIP.
+	AnnounceURL string `yaml:"announce_url"`
+
+	// AnnounceInterval is the interval clients are asked to announce in.
+	AnnounceInterval time.Duration `yaml:"announce_interval"`
+
+	// PeerTTL is the duration a client remains in the swarm after its last
+	// announce.
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+
+	// MaxPeersPerAnnounce is the maximum number of peers returned to clients
+	// which do not specify numwant.
+	MaxPeersPerAnnounce int `yaml:"max_peers_per_announce"`
+
+	// MaxConns is the maximum number of concurrent peer wire connections.
+	MaxConns int `yaml:"max_conns"`
+
+	// MaxRequestLength is the maximum block length clients may request.
+	MaxRequestLength int `yaml:"max_request_length"`
+
+	// ConnTTI is the duration after which idle peer wire connections are
+	// closed.
+	ConnTTI time.Duration `yaml:"conn_tti"`
+
+	// Bandwidth limits the rate at which blocks are served across all peer
+	// wire connections. Only egress applies, since the gateway never
+	// downloads.
+	Bandwidth bandwidth.Config `yaml:"bandwidth"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.HTTPAddr == "" {
+		c.HTTPAddr = ":6880"
+	}
+	if c.PeerPort == 0 {
+		c.PeerPort = 6881
+	}
+	if c.AnnounceInterval == 0 {
+		c.AnnounceInterval = 5 * time.Minute
+	}
+	if c.PeerTTL == 0 {
+		c.PeerTTL = 3 * c.AnnounceInterval
+	}
+	if c.MaxPeersPerAnnounce == 0 {
+		c.MaxPeersPerAnnounce = 50
+	}
+	if c.MaxConns == 0 {
+		c.MaxConns = 200
+	}
+	if c.MaxRequestLength == 0 {
+		c.MaxRequestLength = 128 * 1024
+	}
+	if c.ConnTTI == 0 {
+		c.ConnTTI = 5 * time.Minute
+	}
+	if c.Bandwidth.EgressBitsPerSec == 0 {
+		c.Bandwidth.EgressBitsPerSec = 200 * 8 * memsize.Mbit
+	}
+	if c.Bandwidth.IngressBitsPerSec == 0 {
+		c.Bandwidth.IngressBitsPerSec = 300 * 8 * memsize.Mbit
+	}
+	return c
+}
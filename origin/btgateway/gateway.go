@@ -0,0 +1,474 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package btgateway exposes blobs stored on origins as standard BitTorrent v1
+// torrents, such that external BitTorrent clients can download them. It serves
+// generated .torrent files, a BEP 3 HTTP tracker, and a peer wire listener
+// which seeds pieces from origin storage.
+package btgateway
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/blobrefresh"
+	"github.com/uber/kraken/lib/middleware"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/lib/torrent/storage/originstorage"
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/dedup"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/netutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/pressly/chi"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+)
+
+// Gateway serves origin blobs to BitTorrent clients.
+type Gateway struct {
+	config        Config
+	stats         tally.Scope
+	clk           clock.Clock
+	cas           *store.CAStore
+	blobRefresher *blobrefresh.Refresher
+	archive       *originstorage.TorrentArchive
+	peerID        [20]byte
+	self          *peer
+	announceURL   string
+	peers         *peerStore
+	numConns      atomic.Int32
+	bandwidth     *bandwidth.Limiter
+
+	// generations deduplicates torrent generation, which hashes the whole
+	// blob, across concurrent requests.
+	generations *dedup.RequestCache
+
+	mu       sync.RWMutex
+	torrents map[core.InfoHash]*torrent
+}
+
+// New creates a new Gateway.
+func New(
+	config Config,
+	stats tally.Scope,
+	clk clock.Clock,
+	cas *store.CAStore,
+	blobRefresher *blobrefresh.Refresher) (*Gateway, error) {
+
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "btgateway",
+	})
+
+	advertiseIP := config.AdvertiseIP
+	if advertiseIP == "" {
+		var err error
+		advertiseIP, err = netutil.GetLocalIP()
+		if err != nil {
+			return nil, fmt.Errorf("get local ip: %s", err)
+		}
+	}
+	ip := net.ParseIP(advertiseIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid advertise ip %q", advertiseIP)
+	}
+
+	announceURL := config.AnnounceURL
+	if announceURL == "" {
+		_, port, err := net.SplitHostPort(config.HTTPAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http addr: %s", err)
+		}
+		announceURL = fmt.Sprintf("http://%s/announce", net.JoinHostPort(advertiseIP, port))
+	}
+
+	// Azureus-style peer id.
+	var peerID [20]byte
+	copy(peerID[:], "-KR0100-")
+	if _, err := rand.Read(peerID[8:]); err != nil {
+		return nil, fmt.Errorf("generate peer id: %s", err)
+	}
+
+	bl, err := bandwidth.NewLimiter(config.Bandwidth)
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth: %s", err)
+	}
+
+	return &Gateway{
+		config:        config,
+		stats:         stats,
+		clk:           clk,
+		cas:           cas,
+		blobRefresher: blobRefresher,
+		archive:       originstorage.NewTorrentArchive(cas, blobRefresher),
+		peerID:        peerID,
+		self: &peer{
+			id:       string(peerID[:]),
+			ip:       ip,
+			port:     config.PeerPort,
+			complete: true,
+		},
+		announceURL: announceURL,
+		peers:       newPeerStore(clk, config.PeerTTL),
+		bandwidth:   bl,
+		generations: dedup.NewRequestCache(dedup.RequestCacheConfig{}, clk),
+		torrents:    make(map[core.InfoHash]*torrent),
+	}, nil
+}
+
+// Handler returns the HTTP handler of the tracker and torrent file endpoints.
+func (g *Gateway) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(middleware.StatusCounter(g.stats))
+	r.Use(middleware.LatencyTimer(g.stats))
+
+	r.Get("/health", handler.Wrap(g.healthHandler))
+
+	r.Get("/namespace/{namespace}/blobs/{digest}/torrent", handler.Wrap(g.getTorrentHandler))
+
+	r.Get("/announce", handler.Wrap(g.announceHandler))
+
+	return r
+}
+
+// ListenAndServe loads previously generated torrents, and serves the peer wire
+// protocol and the HTTP endpoints. Blocks until either fails.
+func (g *Gateway) ListenAndServe() error {
+	go g.loadTorrents()
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", g.config.PeerPort))
+	if err != nil {
+		return fmt.Errorf("listen peer wire: %s", err)
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- g.servePeers(l) }()
+	go func() {
+		log.Infof("Starting BitTorrent gateway on %s", g.config.HTTPAddr)
+		errc <- http.ListenAndServe(g.config.HTTPAddr, g.Handler())
+	}()
+	return <-errc
+}
+
+func (g *Gateway) healthHandler(w http.ResponseWriter, r *http.Request) error {
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
+// getTorrentHandler returns the .torrent file of a blob. Returns 202 while the
+// blob is refreshed from its backend or the torrent is generated.
+func (g *Gateway) getTorrentHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	t, err := g.getOrCreateTorrent(namespace, d)
+	if err != nil {
+		return err
+	}
+	b, err := t.metaInfo(g.announceURL, g.clk.Now())
+	if err != nil {
+		return handler.Errorf("metainfo: %s", err)
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set(
+		"Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.Hex()+".torrent"))
+	w.Write(b)
+	return nil
+}
+
+func (g *Gateway) getOrCreateTorrent(namespace string, d core.Digest) (*torrent, error) {
+	if _, err := g.cas.GetCacheFileStat(d.Hex()); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, handler.Errorf("stat: %s", err)
+		}
+		switch err := g.blobRefresher.Refresh(namespace, d); err {
+		case blobrefresh.ErrPending, nil:
+			return nil, handler.ErrorStatus(http.StatusAccepted)
+		case blobrefresh.ErrNotFound:
+			return nil, handler.ErrorStatus(http.StatusNotFound)
+		case blobrefresh.ErrWorkersBusy:
+			return nil, handler.ErrorStatus(http.StatusServiceUnavailable)
+		default:
+			return nil, handler.Errorf("refresh: %s", err)
+		}
+	}
+
+	var md metadata.BitTorrentInfo
+	if err := g.cas.GetCacheFileMetadata(d.Hex(), &md); err == nil {
+		t, err := newTorrent(namespace, d, md.Info)
+		if err != nil {
+			return nil, handler.Errorf("torrent: %s", err)
+		}
+		g.addTorrent(t)
+		return t, nil
+	} else if !os.IsNotExist(err) {
+		return nil, handler.Errorf("get bittorrent info: %s", err)
+	}
+
+	// Generating the torrent hashes the whole blob, so it runs in the
+	// background while clients poll.
+	err := g.generations.Start(d.Hex(), func() error {
+		return g.generateTorrent(namespace, d)
+	})
+	switch err {
+	case dedup.ErrRequestPending, nil:
+		return nil, handler.ErrorStatus(http.StatusAccepted)
+	case dedup.ErrWorkersBusy:
+		return nil, handler.ErrorStatus(http.StatusServiceUnavailable)
+	default:
+		return nil, handler.Errorf("generate torrent: %s", err)
+	}
+}
+
+// generateTorrent builds the info dictionary of a cached blob and persists it
+// alongside the blob.
+func (g *Gateway) generateTorrent(namespace string, d core.Digest) error {
+	kt, err := g.archive.GetTorrent(namespace, d)
+	if err != nil {
+		return fmt.Errorf("get torrent: %s", err)
+	}
+	info, err := buildInfo(d, kt)
+	if err != nil {
+		return fmt.Errorf("build info: %s", err)
+	}
+	if _, err := g.cas.SetCacheFileMetadata(d.Hex(), metadata.NewBitTorrentInfo(info)); err != nil {
+		return fmt.Errorf("set bittorrent info: %s", err)
+	}
+	t, err := newTorrent(namespace, d, info)
+	if err != nil {
+		return fmt.Errorf("torrent: %s", err)
+	}
+	g.stats.Counter("torrents_generated").Inc(1)
+	g.addTorrent(t)
+	return nil
+}
+
+func (g *Gateway) addTorrent(t *torrent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.torrents[t.infoHash] = t
+}
+
+func (g *Gateway) getTorrent(h core.InfoHash) (*torrent, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	t, ok := g.torrents[h]
+	return t, ok
+}
+
+// loadTorrents indexes the torrents previously generated for blobs in the
+// cache, such that clients may resume downloads after a restart.
+func (g *Gateway) loadTorrents() {
+	names, err := g.cas.ListCacheFiles()
+	if err != nil {
+		log.Errorf("Error listing cache files for BitTorrent gateway: %s", err)
+		return
+	}
+	var n int
+	for _, name := range names {
+		var md metadata.BitTorrentInfo
+		if err := g.cas.GetCacheFileMetadata(name, &md); err != nil {
+			continue
+		}
+		d, err := core.NewSHA256DigestFromHex(name)
+		if err != nil {
+			continue
+		}
+		var ns metadata.Namespace
+		if err := g.cas.GetCacheFileMetadata(name, &ns); err != nil && !os.IsNotExist(err) {
+			log.With("blob", name).Errorf("Error getting namespace: %s", err)
+		}
+		t, err := newTorrent(ns.Value, d, md.Info)
+		if err != nil {
+			log.With("blob", name).Errorf("Error loading BitTorrent info: %s", err)
+			continue
+		}
+		g.addTorrent(t)
+		n++
+	}
+	log.Infof("Loaded %d torrents into BitTorrent gateway", n)
+}
+
+// announceHandler implements the BEP 3 tracker announce. Failures are reported
+// to clients in the response body, as the protocol requires.
+func (g *Gateway) announceHandler(w http.ResponseWriter, r *http.Request) error {
+	resp, err := g.announce(r)
+	if err != nil {
+		g.stats.Counter("announce_failures").Inc(1)
+		resp = map[string]interface{}{"failure reason": err.Error()}
+	}
+	b, err := marshal(resp)
+	if err != nil {
+		return handler.Errorf("bencode: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b)
+	return nil
+}
+
+func (g *Gateway) announce(r *http.Request) (map[string]interface{}, error) {
+	req, err := parseAnnounceRequest(r.URL.Query(), r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := g.getTorrent(req.infoHash); !ok {
+		return nil, errUnknownTorrent
+	}
+	g.peers.update(req.infoHash, req.peer, req.event)
+
+	numWant := req.numWant
+	if numWant < 0 || numWant > g.config.MaxPeersPerAnnounce {
+		numWant = g.config.MaxPeersPerAnnounce
+	}
+	var peers []*peer
+	if numWant > 0 {
+		// The gateway always seeds, so it is handed out first.
+		peers = append(peers, g.self)
+		numWant--
+	}
+	others, complete, incomplete := g.peers.get(req.infoHash, req.peer.id, numWant)
+	peers = append(peers, others...)
+
+	resp := map[string]interface{}{
+		"interval":   int64(g.config.AnnounceInterval / time.Second),
+		"complete":   complete + 1,
+		"incomplete": incomplete,
+	}
+	encodePeers(resp, peers, req.compact)
+	return resp, nil
+}
+
+// servePeers accepts peer wire connections on l until l is closed.
+func (g *Gateway) servePeers(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if int(g.numConns.Inc()) > g.config.MaxConns {
+			g.numConns.Dec()
+			g.stats.Counter("conns_rejected").Inc(1)
+			nc.Close()
+			continue
+		}
+		g.stats.Counter("conns").Inc(1)
+		go func() {
+			defer g.numConns.Dec()
+			defer nc.Close()
+			if err := g.serveConn(nc); err != nil && err != io.EOF {
+				log.With("remote_addr", nc.RemoteAddr()).Infof(
+					"Closing BitTorrent peer wire conn: %s", err)
+			}
+		}()
+	}
+}
+
+// serveConn seeds a torrent to a client. The gateway never downloads, so the
+// client is unchoked immediately and all of its requests are served in order.
+func (g *Gateway) serveConn(nc net.Conn) error {
+	nc.SetDeadline(time.Now().Add(g.config.ConnTTI))
+	hs, err := readHandshake(nc)
+	if err != nil {
+		return fmt.Errorf("read handshake: %s", err)
+	}
+	t, ok := g.getTorrent(hs.infoHash)
+	if !ok {
+		return errUnknownTorrent
+	}
+	kt, err := g.archive.GetTorrent(t.namespace, t.digest)
+	if err != nil {
+		return fmt.Errorf("get torrent: %s", err)
+	}
+	if int64(kt.MaxPieceLength()) != t.pieceLength || kt.NumPieces() != t.numPieces {
+		return fmt.Errorf("torrent %s does not match blob pieces", t.infoHash)
+	}
+
+	w := bufio.NewWriter(nc)
+	if err := writeHandshake(w, &handshake{t.infoHash, g.peerID}); err != nil {
+		return fmt.Errorf("write handshake: %s", err)
+	}
+	if err := writeMessage(w, bitfieldMessage(t.numPieces)); err != nil {
+		return fmt.Errorf("write bitfield: %s", err)
+	}
+	if err := writeMessage(w, &message{id: msgUnchoke}); err != nil {
+		return fmt.Errorf("write unchoke: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush: %s", err)
+	}
+
+	blocks := &blockReader{torrent: kt}
+	defer blocks.close()
+
+	r := bufio.NewReader(nc)
+	for {
+		nc.SetDeadline(time.Now().Add(g.config.ConnTTI))
+		m, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		if m == nil || m.id != msgRequest {
+			// Keep-alives and all other messages require no action from a seeder.
+			continue
+		}
+		req, err := parseBlockRequest(m.payload)
+		if err != nil {
+			return err
+		}
+		if req.index >= t.numPieces ||
+			req.length <= 0 ||
+			req.length > int64(g.config.MaxRequestLength) ||
+			req.begin+req.length > t.getPieceLength(req.index) {
+			return fmt.Errorf("invalid request %+v", req)
+		}
+		block, err := blocks.read(req)
+		if err != nil {
+			return err
+		}
+		if err := g.bandwidth.ReserveEgress(req.length); err != nil {
+			return fmt.Errorf("egress bandwidth: %s", err)
+		}
+		if err := writeMessage(w, pieceMessage(req, block)); err != nil {
+			return fmt.Errorf("write piece: %s", err)
+		}
+		if r.Buffered() == 0 {
+			// Batch pipelined requests into fewer writes.
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("flush: %s", err)
+			}
+		}
+		g.stats.Counter("blocks_served").Inc(1)
+		g.stats.Counter("bytes_served").Inc(req.length)
+	}
+}
@@ -0,0 +1,693 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btgateway
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/backend"
+	"github.com/uber/kraken/lib/backend/backenderrors"
+	"github.com/uber/kraken/lib/blobrefresh"
+	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
+	mockbackend "github.com/uber/kraken/mocks/lib/backend"
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/mockutil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/cenkalti/backoff"
+	"github.com/golang/mock/gomock"
+	"github.com/jackpal/bencode-go"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const (
+	_testNamespace   = "test-namespace"
+	_testPieceLength = 32 * 1024
+	_testBlockLength = 16 * 1024
+)
+
+type gatewayMocks struct {
+	cas           *store.CAStore
+	backendClient *mockbackend.MockClient
+	blobRefresher *blobrefresh.Refresher
+	clk           *clock.Mock
+}
+
+func newGatewayMocks(t *testing.T) (*gatewayMocks, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	cas, c := store.CAStoreFixture()
+	cleanup.Add(c)
+
+	ctrl := gomock.NewController(t)
+	cleanup.Add(ctrl.Finish)
+
+	backendClient := mockbackend.NewMockClient(ctrl)
+	backends := backend.ManagerFixture()
+	backends.Register(_testNamespace, backendClient)
+
+	blobRefresher := blobrefresh.New(
+		blobrefresh.Config{}, tally.NoopScope, cas, backends,
+		metainfogen.Fixture(cas, _testPieceLength))
+
+	return &gatewayMocks{cas, backendClient, blobRefresher, clock.NewMock()}, cleanup.Run
+}
+
+func (m *gatewayMocks) new(config Config) *Gateway {
+	config.AdvertiseIP = "127.0.0.1"
+	g, err := New(config, tally.NoopScope, m.clk, m.cas, m.blobRefresher)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// addBlob adds a blob to the cache, as if it was uploaded to the origin.
+func (m *gatewayMocks) addBlob(size uint64) *core.BlobFixture {
+	blob := core.SizedBlobFixture(size, _testPieceLength)
+	if err := m.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)); err != nil {
+		panic(err)
+	}
+	if _, err := m.cas.SetCacheFileMetadata(
+		blob.Digest.Hex(), metadata.NewTorrentMeta(blob.MetaInfo)); err != nil {
+		panic(err)
+	}
+	return blob
+}
+
+// getTorrentFile polls for the torrent file of d until it is generated.
+func getTorrentFile(t *testing.T, addr string, d core.Digest) map[string]interface{} {
+	resp, err := httputil.PollAccepted(
+		fmt.Sprintf("http://%s/namespace/%s/blobs/%s/torrent", addr, _testNamespace, d),
+		backoff.NewConstantBackOff(10*time.Millisecond))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/x-bittorrent", resp.Header.Get("Content-Type"))
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	v, err := bencode.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	return v.(map[string]interface{})
+}
+
+// infoHashOf returns the info hash of a decoded torrent file.
+func infoHashOf(t *testing.T, mi map[string]interface{}) core.InfoHash {
+	info, err := marshal(mi["info"])
+	require.NoError(t, err)
+	return core.NewInfoHashFromBytes(info)
+}
+
+func pieceHashes(content []byte, pieceLength int) []byte {
+	var hashes []byte
+	for i := 0; i < len(content); i += pieceLength {
+		end := i + pieceLength
+		if end > len(content) {
+			end = len(content)
+		}
+		h := sha1.Sum(content[i:end])
+		hashes = append(hashes, h[:]...)
+	}
+	return hashes
+}
+
+func TestGetTorrent(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{HTTPAddr: ":6880"})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	blob := mocks.addBlob(100 * 1024)
+
+	mi := getTorrentFile(t, addr, blob.Digest)
+	require.Equal("http://127.0.0.1:6880/announce", mi["announce"])
+	require.Equal(map[string]interface{}{
+		"length":       int64(len(blob.Content)),
+		"name":         blob.Digest.Hex(),
+		"piece length": int64(_testPieceLength),
+		"pieces":       string(pieceHashes(blob.Content, _testPieceLength)),
+	}, mi["info"])
+
+	// The info dictionary is persisted alongside the blob.
+	var md metadata.BitTorrentInfo
+	require.NoError(mocks.cas.GetCacheFileMetadata(blob.Digest.Hex(), &md))
+	require.Equal(infoHashOf(t, mi), core.NewInfoHashFromBytes(md.Info))
+
+	_, ok := g.getTorrent(infoHashOf(t, mi))
+	require.True(ok)
+
+	// Generated torrents are indexed again on restart.
+	g = mocks.new(Config{})
+	g.loadTorrents()
+	tor, ok := g.getTorrent(infoHashOf(t, mi))
+	require.True(ok)
+	require.Equal(blob.Digest, tor.digest)
+}
+
+func TestGetTorrentGeneratesTorrentOnce(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	stats := tally.NewTestScope("", nil)
+	g, err := New(Config{AdvertiseIP: "127.0.0.1"}, stats, mocks.clk, mocks.cas, mocks.blobRefresher)
+	require.NoError(err)
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	blob := mocks.addBlob(100 * 1024)
+
+	// Concurrent requests are accepted while a single generation runs.
+	u := fmt.Sprintf("http://%s/namespace/%s/blobs/%s/torrent", addr, _testNamespace, blob.Digest)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			httputil.Get(u, httputil.SendAcceptedCodes(http.StatusOK, http.StatusAccepted))
+		}()
+	}
+	wg.Wait()
+	getTorrentFile(t, addr, blob.Digest)
+
+	var generated int64
+	for _, c := range stats.Snapshot().Counters() {
+		if c.Name() == "torrents_generated" {
+			generated += c.Value()
+		}
+	}
+	require.Equal(int64(1), generated)
+}
+
+func TestGetTorrentRefreshesMissingBlob(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	blob := core.SizedBlobFixture(100*1024, _testPieceLength)
+
+	mocks.backendClient.EXPECT().Stat(_testNamespace, blob.Digest.Hex()).Return(
+		core.NewBlobInfo(int64(len(blob.Content))), nil)
+	mocks.backendClient.EXPECT().Download(
+		_testNamespace, blob.Digest.Hex(), mockutil.MatchWriter(blob.Content)).Return(nil)
+
+	u := fmt.Sprintf("http://%s/namespace/%s/blobs/%s/torrent", addr, _testNamespace, blob.Digest)
+	resp, err := httputil.Get(u, httputil.SendAcceptedCodes(http.StatusOK, http.StatusAccepted))
+	require.NoError(err)
+	require.Equal(http.StatusAccepted, resp.StatusCode)
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		resp, err := httputil.Get(u)
+		return err == nil && resp.StatusCode == http.StatusOK
+	}))
+}
+
+func TestGetTorrentNotFound(t *testing.T) {
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	d := core.DigestFixture()
+
+	mocks.backendClient.EXPECT().Stat(_testNamespace, d.Hex()).Return(
+		nil, backenderrors.ErrBlobNotFound)
+
+	_, err := httputil.Get(
+		fmt.Sprintf("http://%s/namespace/%s/blobs/%s/torrent", addr, _testNamespace, d))
+	require.True(t, httputil.IsNotFound(err))
+}
+
+type announceParams struct {
+	infoHash core.InfoHash
+	peerID   string
+	port     int
+	event    string
+	left     int64
+	compact  bool
+	ip       string
+	remoteIP string
+}
+
+// announce sends an announce request to g from p.remoteIP, which defaults to
+// 127.0.0.1.
+func announce(t *testing.T, g *Gateway, p announceParams) map[string]interface{} {
+	q := url.Values{}
+	q.Set("info_hash", string(p.infoHash[:]))
+	q.Set("peer_id", p.peerID)
+	q.Set("port", fmt.Sprint(p.port))
+	q.Set("left", fmt.Sprint(p.left))
+	if p.event != "" {
+		q.Set("event", p.event)
+	}
+	if p.compact {
+		q.Set("compact", "1")
+	} else {
+		q.Set("compact", "0")
+	}
+	if p.ip != "" {
+		q.Set("ip", p.ip)
+	}
+	r := httptest.NewRequest("GET", "/announce?"+q.Encode(), nil)
+	if p.remoteIP != "" {
+		r.RemoteAddr = net.JoinHostPort(p.remoteIP, "50000")
+	} else {
+		r.RemoteAddr = "127.0.0.1:50000"
+	}
+	w := httptest.NewRecorder()
+	g.Handler().ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	v, err := bencode.Decode(w.Body)
+	require.NoError(t, err)
+	return v.(map[string]interface{})
+}
+
+func peerIDFixture(i int) string {
+	return fmt.Sprintf("-TEST00-%012d", i)
+}
+
+func TestAnnounceUnknownTorrent(t *testing.T) {
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+
+	resp := announce(t, g, announceParams{
+		infoHash: core.InfoHashFixture(),
+		peerID:   peerIDFixture(1),
+		port:     6881,
+	})
+	require.Equal(t, errUnknownTorrent.Error(), resp["failure reason"])
+}
+
+func TestAnnounceInvalidRequest(t *testing.T) {
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+
+	resp := announce(t, g, announceParams{
+		infoHash: core.InfoHashFixture(),
+		peerID:   "short",
+		port:     6881,
+	})
+	require.Equal(t, "invalid peer_id", resp["failure reason"])
+}
+
+func TestAnnounce(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{PeerPort: 7000, AnnounceInterval: time.Minute})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	blob := mocks.addBlob(100 * 1024)
+	h := infoHashOf(t, getTorrentFile(t, addr, blob.Digest))
+
+	gatewayPeer := "\x7f\x00\x00\x01\x1b\x58" // 127.0.0.1:7000
+
+	// The first client only receives the gateway.
+	resp := announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(1),
+		port:     6881,
+		event:    eventStarted,
+		left:     100,
+		compact:  true,
+		remoteIP: "10.0.0.1",
+	})
+	require.Equal(int64(60), resp["interval"])
+	require.Equal(int64(1), resp["complete"])
+	require.Equal(int64(1), resp["incomplete"])
+	require.Equal(gatewayPeer, resp["peers"])
+
+	// The second client also receives the first client.
+	resp = announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(2),
+		port:     6882,
+		event:    eventStarted,
+		compact:  true,
+		remoteIP: "::1",
+	})
+	require.Equal(int64(2), resp["complete"])
+	require.Equal(int64(1), resp["incomplete"])
+	require.Equal(gatewayPeer+"\x0a\x00\x00\x01\x1a\xe1", resp["peers"])
+
+	// IPv6 peers are returned separately, and non-compact responses are supported.
+	resp = announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(1),
+		port:     6881,
+		compact:  true,
+		remoteIP: "10.0.0.1",
+	})
+	require.Equal(gatewayPeer, resp["peers"])
+	require.Equal(string(net.ParseIP("::1"))+"\x1a\xe2", resp["peers6"])
+
+	resp = announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(1),
+		port:     6881,
+		remoteIP: "10.0.0.1",
+	})
+	peers := resp["peers"].([]interface{})
+	require.Len(peers, 2)
+	require.Equal(map[string]interface{}{
+		"peer id": g.self.id,
+		"ip":      "127.0.0.1",
+		"port":    int64(7000),
+	}, peers[0])
+
+	// Stopped clients are removed from the swarm.
+	announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(2),
+		port:     6882,
+		event:    eventStopped,
+		remoteIP: "::1",
+	})
+	resp = announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(1),
+		port:     6881,
+		compact:  true,
+		remoteIP: "10.0.0.1",
+	})
+	require.Equal(gatewayPeer, resp["peers"])
+	require.Nil(resp["peers6"])
+
+	// Clients expire once they stop announcing.
+	mocks.clk.Add(g.config.PeerTTL + time.Second)
+	resp = announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(2),
+		port:     6882,
+		compact:  true,
+		remoteIP: "10.0.0.2",
+	})
+	require.Equal(gatewayPeer, resp["peers"])
+	require.Equal(int64(0), resp["incomplete"])
+}
+
+func TestAnnounceIgnoresIPParam(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+
+	blob := mocks.addBlob(100 * 1024)
+	h := infoHashOf(t, getTorrentFile(t, addr, blob.Digest))
+
+	announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(1),
+		port:     6881,
+		left:     100,
+		ip:       "10.9.9.9",
+		remoteIP: "10.0.0.1",
+	})
+	resp := announce(t, g, announceParams{
+		infoHash: h,
+		peerID:   peerIDFixture(2),
+		port:     6882,
+		left:     100,
+		remoteIP: "10.0.0.2",
+	})
+	peers := resp["peers"].([]interface{})
+	require.Len(peers, 2)
+	require.Equal("10.0.0.1", peers[1].(map[string]interface{})["ip"])
+}
+
+// btClient is a minimal BitTorrent client which downloads from the gateway.
+type btClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func dialGateway(t *testing.T, addr string, h core.InfoHash) *btClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	var peerID [20]byte
+	copy(peerID[:], peerIDFixture(1))
+	require.NoError(t, writeHandshake(conn, &handshake{h, peerID}))
+	return &btClient{t, conn}
+}
+
+func (c *btClient) readMessage() *message {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readMessage(c.conn)
+	require.NoError(c.t, err)
+	return m
+}
+
+func (c *btClient) request(index, begin, length int) {
+	payload := make([]byte, 12)
+	for i, v := range []int{index, begin, length} {
+		payload[4*i] = byte(v >> 24)
+		payload[4*i+1] = byte(v >> 16)
+		payload[4*i+2] = byte(v >> 8)
+		payload[4*i+3] = byte(v)
+	}
+	require.NoError(c.t, writeMessage(c.conn, &message{id: msgRequest, payload: payload}))
+}
+
+func startPeerWire(g *Gateway) (string, func()) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	go g.servePeers(l)
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestPeerWireDownload(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+	peerAddr, stopPeers := startPeerWire(g)
+	defer stopPeers()
+
+	blob := mocks.addBlob(100 * 1024)
+	mi := getTorrentFile(t, addr, blob.Digest)
+	h := infoHashOf(t, mi)
+
+	c := dialGateway(t, peerAddr, h)
+	defer c.conn.Close()
+
+	hs, err := readHandshake(c.conn)
+	require.NoError(err)
+	require.Equal(h, hs.infoHash)
+	require.Equal(g.peerID, hs.peerID)
+
+	// 4 pieces, all of which the gateway has.
+	require.Equal(&message{id: msgBitfield, payload: []byte{0xf0}}, c.readMessage())
+	require.Equal(&message{id: msgUnchoke, payload: []byte{}}, c.readMessage())
+
+	require.NoError(writeMessage(c.conn, &message{id: msgInterested}))
+
+	// Pipeline requests for all blocks.
+	type block struct{ index, begin, length int }
+	var blocks []block
+	for i := 0; i < len(blob.Content); i += _testBlockLength {
+		length := _testBlockLength
+		if i+length > len(blob.Content) {
+			length = len(blob.Content) - i
+		}
+		blocks = append(blocks, block{
+			i / _testPieceLength, i % _testPieceLength, length})
+	}
+	for _, b := range blocks {
+		c.request(b.index, b.begin, b.length)
+	}
+
+	var content []byte
+	for range blocks {
+		m := c.readMessage()
+		require.Equal(msgPiece, m.id)
+		content = append(content, m.payload[8:]...)
+	}
+	require.Equal(blob.Content, content)
+	require.Equal(
+		mi["info"].(map[string]interface{})["pieces"],
+		string(pieceHashes(content, _testPieceLength)))
+
+	// Blocks can be re-requested out of order.
+	c.request(1, 0, 10)
+	m := c.readMessage()
+	require.Equal(blob.Content[_testPieceLength:_testPieceLength+10], m.payload[8:])
+}
+
+func TestPeerWireClosesConnOnInvalidRequest(t *testing.T) {
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+	peerAddr, stopPeers := startPeerWire(g)
+	defer stopPeers()
+
+	blob := mocks.addBlob(100 * 1024)
+	h := infoHashOf(t, getTorrentFile(t, addr, blob.Digest))
+
+	tests := []struct {
+		desc                 string
+		index, begin, length int
+	}{
+		{"invalid index", 4, 0, 10},
+		{"beyond piece", 3, 4 * 1024, 1},
+		{"too long", 0, 0, 256 * 1024},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			c := dialGateway(t, peerAddr, h)
+			defer c.conn.Close()
+
+			_, err := readHandshake(c.conn)
+			require.NoError(t, err)
+			c.readMessage() // Bitfield.
+			c.readMessage() // Unchoke.
+
+			c.request(test.index, test.begin, test.length)
+			c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, err = readMessage(c.conn)
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestPeerWireClosesConnForUnknownTorrent(t *testing.T) {
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{})
+	peerAddr, stopPeers := startPeerWire(g)
+	defer stopPeers()
+
+	c := dialGateway(t, peerAddr, core.InfoHashFixture())
+	defer c.conn.Close()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := readHandshake(c.conn)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestPeerWireConnLimit(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	g := mocks.new(Config{MaxConns: 1})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+	peerAddr, stopPeers := startPeerWire(g)
+	defer stopPeers()
+
+	blob := mocks.addBlob(100 * 1024)
+	h := infoHashOf(t, getTorrentFile(t, addr, blob.Digest))
+
+	c1 := dialGateway(t, peerAddr, h)
+	defer c1.conn.Close()
+	_, err := readHandshake(c1.conn)
+	require.NoError(err)
+
+	c2 := dialGateway(t, peerAddr, h)
+	defer c2.conn.Close()
+	c2.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = readHandshake(c2.conn)
+	require.Error(err)
+}
+
+func TestPeerWireLimitsEgressBandwidth(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newGatewayMocks(t)
+	defer cleanup()
+
+	// One 8KB token per second, i.e. only the first block is served within the
+	// first second.
+	g := mocks.new(Config{Bandwidth: bandwidth.Config{
+		Enable:            true,
+		EgressBitsPerSec:  8 * 8 * memsize.Kbit,
+		IngressBitsPerSec: 8 * 8 * memsize.Kbit,
+		TokenSize:         8 * 8 * memsize.Kbit,
+	}})
+	addr, stop := testutil.StartServer(g.Handler())
+	defer stop()
+	peerAddr, stopPeers := startPeerWire(g)
+	defer stopPeers()
+
+	blob := mocks.addBlob(100 * 1024)
+	h := infoHashOf(t, getTorrentFile(t, addr, blob.Digest))
+
+	c := dialGateway(t, peerAddr, h)
+	defer c.conn.Close()
+
+	_, err := readHandshake(c.conn)
+	require.NoError(err)
+	require.Equal(msgBitfield, c.readMessage().id)
+	require.Equal(msgUnchoke, c.readMessage().id)
+
+	start := time.Now()
+	c.request(0, 0, 8*1024)
+	c.request(0, 8*1024, 8*1024)
+	for i := 0; i < 2; i++ {
+		require.Equal(msgPiece, c.readMessage().id)
+	}
+	require.True(time.Since(start) >= 900*time.Millisecond)
+}
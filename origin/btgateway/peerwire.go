@@ -0,0 +1,198 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btgateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// protocolName identifies the BitTorrent v1 peer wire protocol in handshakes.
+const protocolName = "BitTorrent protocol"
+
+// maxMessageLength bounds the length of messages read from clients.
+const maxMessageLength = 1 << 20
+
+// Peer wire message ids.
+const (
+	msgChoke         byte = 0
+	msgUnchoke       byte = 1
+	msgInterested    byte = 2
+	msgNotInterested byte = 3
+	msgHave          byte = 4
+	msgBitfield      byte = 5
+	msgRequest       byte = 6
+	msgPiece         byte = 7
+	msgCancel        byte = 8
+)
+
+// handshake is the first message sent by each side of a connection.
+type handshake struct {
+	infoHash core.InfoHash
+	peerID   [20]byte
+}
+
+func readHandshake(r io.Reader) (*handshake, error) {
+	var pstrlen [1]byte
+	if _, err := io.ReadFull(r, pstrlen[:]); err != nil {
+		return nil, err
+	}
+	if int(pstrlen[0]) != len(protocolName) {
+		return nil, fmt.Errorf("invalid protocol name length %d", pstrlen[0])
+	}
+	b := make([]byte, len(protocolName)+8+20+20)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if string(b[:len(protocolName)]) != protocolName {
+		return nil, fmt.Errorf("invalid protocol name %q", b[:len(protocolName)])
+	}
+	b = b[len(protocolName)+8:] // Ignore reserved extension bits.
+	hs := new(handshake)
+	copy(hs.infoHash[:], b[:20])
+	copy(hs.peerID[:], b[20:])
+	return hs, nil
+}
+
+func writeHandshake(w io.Writer, hs *handshake) error {
+	var b bytes.Buffer
+	b.WriteByte(byte(len(protocolName)))
+	b.WriteString(protocolName)
+	b.Write(make([]byte, 8))
+	b.Write(hs.infoHash[:])
+	b.Write(hs.peerID[:])
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// message is a length-prefixed peer wire message.
+type message struct {
+	id      byte
+	payload []byte
+}
+
+// readMessage reads the next message from r. Returns nil for keep-alives.
+func readMessage(r io.Reader) (*message, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxMessageLength {
+		return nil, fmt.Errorf("message length %d exceeds limit", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return &message{id: b[0], payload: b[1:]}, nil
+}
+
+func writeMessage(w io.Writer, m *message) error {
+	b := make([]byte, 5, 5+len(m.payload))
+	binary.BigEndian.PutUint32(b, uint32(1+len(m.payload)))
+	b[4] = m.id
+	_, err := w.Write(append(b, m.payload...))
+	return err
+}
+
+// bitfieldMessage returns a bitfield message for a complete torrent of n pieces.
+func bitfieldMessage(n int) *message {
+	b := make([]byte, (n+7)/8)
+	for i := 0; i < n; i++ {
+		b[i/8] |= 0x80 >> uint(i%8)
+	}
+	return &message{id: msgBitfield, payload: b}
+}
+
+// blockRequest is the payload of request and cancel messages.
+type blockRequest struct {
+	index  int
+	begin  int64
+	length int64
+}
+
+func parseBlockRequest(payload []byte) (blockRequest, error) {
+	if len(payload) != 12 {
+		return blockRequest{}, fmt.Errorf("invalid request length %d", len(payload))
+	}
+	return blockRequest{
+		index:  int(binary.BigEndian.Uint32(payload[0:4])),
+		begin:  int64(binary.BigEndian.Uint32(payload[4:8])),
+		length: int64(binary.BigEndian.Uint32(payload[8:12])),
+	}, nil
+}
+
+func pieceMessage(r blockRequest, block []byte) *message {
+	b := make([]byte, 8, 8+len(block))
+	binary.BigEndian.PutUint32(b[0:4], uint32(r.index))
+	binary.BigEndian.PutUint32(b[4:8], uint32(r.begin))
+	return &message{id: msgPiece, payload: append(b, block...)}
+}
+
+// blockReader reads requested blocks from the pieces of a torrent. Clients
+// request the blocks of a piece in order, so the current piece is read
+// sequentially instead of being re-read for every block.
+type blockReader struct {
+	torrent storage.Torrent
+	index   int
+	offset  int64
+	reader  storage.PieceReader
+}
+
+func (b *blockReader) read(r blockRequest) ([]byte, error) {
+	if b.reader == nil || r.index != b.index || r.begin < b.offset {
+		b.close()
+		reader, err := b.torrent.GetPieceReader(r.index)
+		if err != nil {
+			return nil, fmt.Errorf("get piece reader: %s", err)
+		}
+		b.reader = reader
+		b.index = r.index
+		b.offset = 0
+	}
+	if r.begin > b.offset {
+		if _, err := io.CopyN(ioutil.Discard, b.reader, r.begin-b.offset); err != nil {
+			b.close()
+			return nil, fmt.Errorf("skip to block: %s", err)
+		}
+		b.offset = r.begin
+	}
+	block := make([]byte, r.length)
+	if _, err := io.ReadFull(b.reader, block); err != nil {
+		b.close()
+		return nil, fmt.Errorf("read block: %s", err)
+	}
+	b.offset += r.length
+	return block, nil
+}
+
+func (b *blockReader) close() {
+	if b.reader != nil {
+		b.reader.Close()
+		b.reader = nil
+	}
+}
+
+var errUnknownTorrent = errors.New("unknown torrent")
@@ -0,0 +1,127 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btgateway
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+
+	"github.com/jackpal/bencode-go"
+)
+
+// torrent describes a blob served as a BitTorrent v1 torrent. Pieces of the
+// torrent are the same as the pieces of the blob's Kraken torrent, such that
+// they can be read from origin storage directly.
+type torrent struct {
+	namespace   string
+	digest      core.Digest
+	infoHash    core.InfoHash
+	info        []byte // Bencoded info dictionary.
+	length      int64
+	pieceLength int64
+	numPieces   int
+}
+
+func newTorrent(namespace string, d core.Digest, info []byte) (*torrent, error) {
+	v, err := bencode.Decode(bytes.NewReader(info))
+	if err != nil {
+		return nil, fmt.Errorf("bencode: %s", err)
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("info is not a dictionary")
+	}
+	length, ok := dict["length"].(int64)
+	if !ok || length <= 0 {
+		return nil, errors.New("invalid length")
+	}
+	pieceLength, ok := dict["piece length"].(int64)
+	if !ok || pieceLength <= 0 {
+		return nil, errors.New("invalid piece length")
+	}
+	return &torrent{
+		namespace:   namespace,
+		digest:      d,
+		infoHash:    core.NewInfoHashFromBytes(info),
+		info:        info,
+		length:      length,
+		pieceLength: pieceLength,
+		numPieces:   int((length + pieceLength - 1) / pieceLength),
+	}, nil
+}
+
+// getPieceLength returns the length of piece i.
+func (t *torrent) getPieceLength(i int) int64 {
+	if i == t.numPieces-1 {
+		return t.length - t.pieceLength*int64(i)
+	}
+	return t.pieceLength
+}
+
+// metaInfo returns the bencoded .torrent file of t.
+func (t *torrent) metaInfo(announceURL string, now time.Time) ([]byte, error) {
+	// Dictionaries are encoded with sorted keys, so re-encoding the info
+	// dictionary built by buildInfo yields the same bytes and info hash.
+	info, err := bencode.Decode(bytes.NewReader(t.info))
+	if err != nil {
+		return nil, fmt.Errorf("decode info: %s", err)
+	}
+	return marshal(map[string]interface{}{
+		"announce":      announceURL,
+		"created by":    "kraken",
+		"creation date": now.Unix(),
+		"info":          info,
+	})
+}
+
+// buildInfo returns the bencoded info dictionary of the torrent of blob d,
+// hashing each piece of kt.
+func buildInfo(d core.Digest, kt storage.Torrent) ([]byte, error) {
+	pieces := make([]byte, 0, sha1.Size*kt.NumPieces())
+	for i := 0; i < kt.NumPieces(); i++ {
+		r, err := kt.GetPieceReader(i)
+		if err != nil {
+			return nil, fmt.Errorf("get piece reader %d: %s", i, err)
+		}
+		h := sha1.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read piece %d: %s", i, err)
+		}
+		pieces = h.Sum(pieces)
+	}
+	return marshal(map[string]interface{}{
+		"length":       kt.Length(),
+		"name":         d.Hex(),
+		"piece length": kt.MaxPieceLength(),
+		"pieces":       pieces,
+	})
+}
+
+// marshal returns the bencoding of v.
+func marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := bencode.Marshal(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package btgateway
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// Announce events, as sent by clients.
+const (
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventStopped   = "stopped"
+)
+
+// peer is a BitTorrent client which announced to the tracker.
+type peer struct {
+	id        string
+	ip        net.IP
+	port      int
+	complete  bool
+	expiresAt time.Time
+}
+
+// peerStore stores the swarms of all torrents in memory. Clients are removed
+// from a swarm once they stop announcing.
+type peerStore struct {
+	sync.Mutex
+	clk    clock.Clock
+	ttl    time.Duration
+	swarms map[core.InfoHash]map[string]*peer
+}
+
+func newPeerStore(clk clock.Clock, ttl time.Duration) *peerStore {
+	return &peerStore{
+		clk:    clk,
+		ttl:    ttl,
+		swarms: make(map[core.InfoHash]map[string]*peer),
+	}
+}
+
+// update adds or refreshes p in the swarm of h, or removes p if it stopped.
+func (s *peerStore) update(h core.InfoHash, p *peer, event string) {
+	s.Lock()
+	defer s.Unlock()
+
+	swarm, ok := s.swarms[h]
+	if !ok {
+		swarm = make(map[string]*peer)
+		s.swarms[h] = swarm
+	}
+	if event == eventStopped {
+		delete(swarm, p.id)
+	} else {
+		p.expiresAt = s.clk.Now().Add(s.ttl)
+		swarm[p.id] = p
+	}
+	if len(swarm) == 0 {
+		delete(s.swarms, h)
+	}
+}
+
+// get returns up to n random peers of the swarm of h excluding the peer with
+// id exclude, and the number of complete and incomplete peers in the swarm.
+func (s *peerStore) get(
+	h core.InfoHash, exclude string, n int) (peers []*peer, complete, incomplete int) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	swarm := s.swarms[h]
+	for id, p := range swarm {
+		if s.clk.Now().After(p.expiresAt) {
+			delete(swarm, id)
+			continue
+		}
+		if p.complete {
+			complete++
+		} else {
+			incomplete++
+		}
+		if id != exclude {
+			peers = append(peers, p)
+		}
+	}
+	if len(swarm) == 0 {
+		delete(s.swarms, h)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers, complete, incomplete
+}
+
+// announceRequest is a BEP 3 announce request.
+type announceRequest struct {
+	infoHash core.InfoHash
+	peer     *peer
+	event    string
+	numWant  int
+	compact  bool
+}
+
+func parseAnnounceRequest(q url.Values, remoteAddr string) (*announceRequest, error) {
+	rawInfoHash := q.Get("info_hash")
+	if len(rawInfoHash) != 20 {
+		return nil, errors.New("invalid info_hash")
+	}
+	var h core.InfoHash
+	copy(h[:], rawInfoHash)
+
+	id := q.Get("peer_id")
+	if len(id) != 20 {
+		return nil, errors.New("invalid peer_id")
+	}
+	port, err := strconv.Atoi(q.Get("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.New("invalid port")
+	}
+	event := q.Get("event")
+	switch event {
+	case "", eventStarted, eventCompleted, eventStopped:
+	default:
+		return nil, fmt.Errorf("invalid event %q", event)
+	}
+	complete := event == eventCompleted
+	if left := q.Get("left"); left != "" {
+		n, err := strconv.ParseInt(left, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid left")
+		}
+		complete = n == 0
+	}
+	numWant := -1
+	if s := q.Get("numwant"); s != "" {
+		numWant, err = strconv.Atoi(s)
+		if err != nil || numWant < 0 {
+			return nil, errors.New("invalid numwant")
+		}
+	}
+
+	// The optional ip parameter is ignored, such that clients cannot direct
+	// other clients at arbitrary hosts.
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote addr: %s", err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid remote ip %q", host)
+	}
+
+	return &announceRequest{
+		infoHash: h,
+		peer: &peer{
+			id:       id,
+			ip:       ip,
+			port:     port,
+			complete: complete,
+		},
+		event:   event,
+		numWant: numWant,
+		compact: q.Get("compact") != "0",
+	}, nil
+}
+
+// encodePeers encodes peers in the format requested by the client. Compact
+// peers are split into IPv4 "peers" and IPv6 "peers6" (BEP 7).
+func encodePeers(resp map[string]interface{}, peers []*peer, compact bool) {
+	if !compact {
+		var l []map[string]interface{}
+		for _, p := range peers {
+			l = append(l, map[string]interface{}{
+				"peer id": p.id,
+				"ip":      p.ip.String(),
+				"port":    p.port,
+			})
+		}
+		if l == nil {
+			l = []map[string]interface{}{}
+		}
+		resp["peers"] = l
+		return
+	}
+	var peers4, peers6 []byte
+	for _, p := range peers {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, uint16(p.port))
+		if ip4 := p.ip.To4(); ip4 != nil {
+			peers4 = append(append(peers4, ip4...), port...)
+		} else {
+			peers6 = append(append(peers6, p.ip.To16()...), port...)
+		}
+	}
+	resp["peers"] = peers4
+	if len(peers6) > 0 {
+		resp["peers6"] = peers6
+	}
+}
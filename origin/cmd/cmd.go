@@ -16,9 +16,10 @@ package cmd
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/backend"
@@ -28,6 +29,7 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
@@ -37,6 +39,8 @@ import (
 	"github.com/uber/kraken/nginx"
 	"github.com/uber/kraken/origin/blobclient"
 	"github.com/uber/kraken/origin/blobserver"
+	"github.com/uber/kraken/origin/btgateway"
+	"github.com/uber/kraken/origin/rebalancer"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/log"
@@ -51,6 +55,7 @@ import (
 // Flags defines origin CLI flags.
 type Flags struct {
 	PeerIP             string
+	PeerSecondaryIP    string
 	PeerPort           int
 	BlobServerHostName string
 	BlobServerPort     int
@@ -65,6 +70,10 @@ func ParseFlags() *Flags {
 	var flags Flags
 	flag.StringVar(
 		&flags.PeerIP, "peer-ip", "", "ip which peer will announce itself as")
+	flag.StringVar(
+		&flags.PeerSecondaryIP, "peer-secondary-ip", "",
+		"ip in the other family which a dual-stack peer will also announce itself as, "+
+			"or \"auto\" to detect it")
 	flag.IntVar(
 		&flags.PeerPort, "peer-port", 0, "port which peer will announce itself as")
 	flag.StringVar(
@@ -185,6 +194,18 @@ func Run(flags *Flags, opts ...Option) {
 	if err != nil {
 		log.Fatalf("Failed to create peer context: %s", err)
 	}
+	if flags.PeerSecondaryIP == "auto" {
+		ip, err := netutil.GetSecondaryLocalIP(pctx.IP)
+		if err != nil {
+			log.Fatalf("Error getting secondary local ip: %s", err)
+		}
+		flags.PeerSecondaryIP = ip
+	}
+	if flags.PeerSecondaryIP != "" {
+		if err := pctx.SetSecondaryIP(flags.PeerSecondaryIP); err != nil {
+			log.Fatalf("Invalid secondary peer ip: %s", err)
+		}
+	}
 
 	backendManager, err := backend.NewManager(config.Backends, config.Auth)
 	if err != nil {
@@ -196,11 +217,16 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error creating local db: %s", err)
 	}
 
+	notifier, err := notification.New(config.Notification, stats, localDB)
+	if err != nil {
+		log.Fatalf("Error creating notifier: %s", err)
+	}
+
 	writeBackManager, err := persistedretry.NewManager(
 		config.WriteBack,
 		stats,
 		writeback.NewStore(localDB),
-		writeback.NewExecutor(stats, cas, backendManager))
+		writeback.NewExecutor(stats, cas, backendManager, notifier))
 	if err != nil {
 		log.Fatalf("Error creating write-back manager: %s", err)
 	}
@@ -211,6 +237,7 @@ func Run(flags *Flags, opts ...Option) {
 	}
 
 	blobRefresher := blobrefresh.New(config.BlobRefresh, stats, cas, backendManager, metaInfoGenerator)
+	cas.OnCorruptBlob(blobRefresher.HandleCorruptBlob)
 
 	netevents, err := networkevent.NewProducer(config.NetworkEvent)
 	if err != nil {
@@ -235,14 +262,26 @@ func Run(flags *Flags, opts ...Option) {
 
 	healthCheckFilter := healthcheck.NewFilter(config.HealthCheck, healthcheck.Default(tls))
 
+	blobRebalancer, err := rebalancer.New(
+		config.Rebalancer,
+		stats,
+		clock.New(),
+		cas,
+		blobclient.NewProvider(blobclient.WithTLS(tls)),
+		writeBackManager)
+	if err != nil {
+		log.Fatalf("Error creating rebalancer: %s", err)
+	}
+
 	hashRing := hashring.New(
 		config.HashRing,
 		cluster,
 		healthCheckFilter,
-		hashring.WithWatcher(backend.NewBandwidthWatcher(backendManager)))
+		hashring.WithWatcher(backend.NewBandwidthWatcher(backendManager)),
+		hashring.WithWatcher(blobRebalancer))
 	go hashRing.Monitor(nil)
 
-	addr := fmt.Sprintf("%s:%d", hostname, flags.BlobServerPort)
+	addr := net.JoinHostPort(hostname, strconv.Itoa(flags.BlobServerPort))
 	if !hashRing.Contains(addr) {
 		// When DNS is used for hash ring membership, the members will be IP
 		// addresses instead of hostnames.
@@ -250,7 +289,7 @@ func Run(flags *Flags, opts ...Option) {
 		if err != nil {
 			log.Fatalf("Error getting local ip: %s", err)
 		}
-		addr = fmt.Sprintf("%s:%d", ip, flags.BlobServerPort)
+		addr = net.JoinHostPort(ip, strconv.Itoa(flags.BlobServerPort))
 		if !hashRing.Contains(addr) {
 			log.Fatalf(
 				"Neither %s nor %s (port %d) found in hash ring",
@@ -258,6 +297,8 @@ func Run(flags *Flags, opts ...Option) {
 		}
 	}
 
+	blobRebalancer.Start(addr, hashRing)
+
 	server, err := blobserver.New(
 		config.BlobServer,
 		stats,
@@ -271,10 +312,27 @@ func Run(flags *Flags, opts ...Option) {
 		backendManager,
 		blobRefresher,
 		metaInfoGenerator,
-		writeBackManager)
+		writeBackManager,
+		notifier,
+		blobRebalancer)
 	if err != nil {
 		log.Fatalf("Error initializing blob server: %s", err)
 	}
+	cas.OnCorruptPersistedBlob(server.FetchCorruptBlob)
+
+	if config.BTGateway.Enabled {
+		gatewayConfig := config.BTGateway
+		if !gatewayConfig.Bandwidth.Enable {
+			// Seeding to BitTorrent clients is subject to the same limits as
+			// seeding to agents, unless configured separately.
+			gatewayConfig.Bandwidth = config.Scheduler.Conn.Bandwidth
+		}
+		gateway, err := btgateway.New(gatewayConfig, stats, clock.New(), cas, blobRefresher)
+		if err != nil {
+			log.Fatalf("Error creating BitTorrent gateway: %s", err)
+		}
+		go func() { log.Fatal(gateway.ListenAndServe()) }()
+	}
 
 	h := addTorrentDebugEndpoints(server.Handler(), sched)
 
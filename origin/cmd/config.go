@@ -22,6 +22,7 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/notification"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
@@ -29,6 +30,8 @@ import (
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
 	"github.com/uber/kraken/origin/blobserver"
+	"github.com/uber/kraken/origin/btgateway"
+	"github.com/uber/kraken/origin/rebalancer"
 	"github.com/uber/kraken/utils/httputil"
 
 	"go.uber.org/zap"
@@ -54,6 +57,9 @@ type Config struct {
 	BlobRefresh   blobrefresh.Config       `yaml:"blobrefresh"`
 	LocalDB       localdb.Config           `yaml:"localdb"`
 	WriteBack     persistedretry.Config    `yaml:"writeback"`
+	Notification  notification.Config      `yaml:"notification"`
+	Rebalancer    rebalancer.Config        `yaml:"rebalancer"`
+	BTGateway     btgateway.Config         `yaml:"btgateway"`
 	Nginx         nginx.Config             `yaml:"nginx"`
 	TLS           httputil.TLSConfig       `yaml:"tls"`
 }
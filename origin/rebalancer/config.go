@@ -0,0 +1,48 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rebalancer
+
+import (
+	"time"
+
+	"github.com/uber/kraken/utils/bandwidth"
+)
+
+// Config defines Rebalancer configuration.
+type Config struct {
+	// Enabled turns on moving blobs to their new owners when origin cluster
+	// membership changes.
+	Enabled bool `yaml:"enabled"`
+
+	// SettleDelay is how long to wait after a membership change before
+	// scanning, allowing health checks to catch up and further changes to be
+	// batched into a single pass.
+	SettleDelay time.Duration `yaml:"settle_delay"`
+
+	// Bandwidth limits the egress used for transferring blobs.
+	Bandwidth bandwidth.Config `yaml:"bandwidth"`
+
+	// ProgressInterval is the number of scanned blobs between progress logs.
+	ProgressInterval int `yaml:"progress_interval"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.SettleDelay == 0 {
+		c.SettleDelay = time.Minute
+	}
+	if c.ProgressInterval == 0 {
+		c.ProgressInterval = 1000
+	}
+	return c
+}
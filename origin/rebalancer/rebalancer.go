@@ -0,0 +1,346 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rebalancer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/stringset"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// errNoOwners occurs when draining a blob which no other origin owns.
+var errNoOwners = errors.New("no other owners available")
+
+// Rebalancer moves blobs cached on an origin to their owners whenever the
+// membership of the origin hash ring changes. Blobs which the origin no longer
+// owns are deleted locally once every owner has committed them and all of
+// their write-backs have completed.
+type Rebalancer struct {
+	config           Config
+	stats            tally.Scope
+	clk              clock.Clock
+	cas              *store.CAStore
+	provider         blobclient.Provider
+	writeBackManager persistedretry.Manager
+	bandwidth        *bandwidth.Limiter
+
+	mu      sync.Mutex // Protects members.
+	members stringset.Set
+
+	passMu sync.Mutex // Serializes passes.
+
+	progressMu sync.Mutex
+	progress   Progress
+
+	trigger   chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+var _ hashring.Watcher = (*Rebalancer)(nil)
+
+// Progress describes the most recent rebalancing pass.
+type Progress struct {
+	Running   bool `json:"running"`
+	Total     int  `json:"total"`
+	Remaining int  `json:"remaining"`
+	Failures  int  `json:"failures"`
+}
+
+// New creates a new Rebalancer. Rebalancing does not begin until Start is
+// called.
+func New(
+	config Config,
+	stats tally.Scope,
+	clk clock.Clock,
+	cas *store.CAStore,
+	provider blobclient.Provider,
+	writeBackManager persistedretry.Manager) (*Rebalancer, error) {
+
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "rebalancer",
+	})
+
+	bl, err := bandwidth.NewLimiter(config.Bandwidth)
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth: %s", err)
+	}
+
+	return &Rebalancer{
+		config:           config,
+		stats:            stats,
+		clk:              clk,
+		cas:              cas,
+		provider:         provider,
+		writeBackManager: writeBackManager,
+		bandwidth:        bl,
+		trigger:          make(chan struct{}, 1),
+		stop:             make(chan struct{}),
+	}, nil
+}
+
+// Notify implements hashring.Watcher. The first notification only records the
+// initial membership of the ring; every following change schedules a
+// rebalancing pass.
+func (r *Rebalancer) Notify(latest stringset.Set) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.members == nil {
+		r.members = latest
+		return
+	}
+	if stringset.Equal(r.members, latest) {
+		return
+	}
+	log.With("members", latest.ToSlice()).Info("Hash ring membership changed, scheduling rebalance")
+	r.members = latest
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+		// A pass is already scheduled, and will observe the latest ring.
+	}
+}
+
+// Start runs rebalancing passes in the background for the origin at addr,
+// using ring to determine the owners of each blob. No-op if rebalancing is
+// disabled.
+func (r *Rebalancer) Start(addr string, ring hashring.Ring) {
+	if !r.config.Enabled {
+		log.Info("Origin rebalancing disabled")
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.loop(addr, ring)
+	}()
+}
+
+// Stop stops any running rebalancing pass.
+func (r *Rebalancer) Stop() {
+	r.closeOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+func (r *Rebalancer) loop(addr string, ring hashring.Ring) {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.trigger:
+		}
+		// Wait for the ring to settle, since Notify is called before the ring
+		// applies the new membership.
+		select {
+		case <-r.stop:
+			return
+		case <-r.clk.After(r.config.SettleDelay):
+		}
+		r.rebalance(addr, ring, false)
+	}
+}
+
+// Drain hands every cached blob over to the other owners in ring and deletes
+// the local copies, regardless of whether addr still owns them. Blocks until
+// the pass completes and returns the number of blobs which could not be
+// handed over, of which noOwners had no other owner to hand them to.
+func (r *Rebalancer) Drain(addr string, ring hashring.Ring) (failures, noOwners int) {
+	return r.rebalance(addr, ring, true)
+}
+
+// Progress returns the progress of the current or most recent pass.
+func (r *Rebalancer) Progress() Progress {
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+
+	return r.progress
+}
+
+func (r *Rebalancer) updateProgress(f func(p *Progress)) {
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+
+	f(&r.progress)
+	r.stats.Gauge("pending_blobs").Update(float64(r.progress.Remaining))
+}
+
+// rebalance runs a single pass over all cached blobs. If drain is set, addr is
+// treated as if it owned no blobs. Returns the number of failed blobs, and how
+// many of them had no other owners.
+func (r *Rebalancer) rebalance(
+	addr string, ring hashring.Ring, drain bool) (failures, noOwners int) {
+
+	r.passMu.Lock()
+	defer r.passMu.Unlock()
+
+	start := r.clk.Now()
+
+	names, err := r.cas.ListCacheFiles()
+	if err != nil {
+		log.Errorf("Error listing cache files for rebalance: %s", err)
+		r.stats.Counter("list_failures").Inc(1)
+		return 0, 0
+	}
+	log.With("blobs", len(names), "drain", drain).Info("Starting rebalance pass")
+
+	r.updateProgress(func(p *Progress) {
+		*p = Progress{Running: true, Total: len(names), Remaining: len(names)}
+	})
+	defer r.updateProgress(func(p *Progress) { p.Running = false })
+
+	for i, name := range names {
+		select {
+		case <-r.stop:
+			return failures, noOwners
+		default:
+		}
+		if err := r.rebalanceBlob(addr, ring, name, drain); err != nil {
+			log.With("name", name).Errorf("Error rebalancing blob: %s", err)
+			r.stats.Counter("failures").Inc(1)
+			failures++
+			if err == errNoOwners {
+				noOwners++
+			}
+		}
+		r.stats.Counter("scanned").Inc(1)
+		r.updateProgress(func(p *Progress) {
+			p.Remaining--
+			p.Failures = failures
+		})
+		if (i+1)%r.config.ProgressInterval == 0 {
+			log.With("scanned", i+1, "total", len(names)).Info("Rebalance in progress")
+		}
+	}
+	r.stats.Timer("pass").Record(r.clk.Now().Sub(start))
+	log.With("blobs", len(names), "failures", failures).Info("Finished rebalance pass")
+	return failures, noOwners
+}
+
+// rebalanceBlob transfers name to every owner other than addr. If addr no
+// longer owns name (or is draining), the local copy is deleted once all owners
+// have it.
+func (r *Rebalancer) rebalanceBlob(
+	addr string, ring hashring.Ring, name string, drain bool) error {
+
+	d, err := core.NewSHA256DigestFromHex(name)
+	if err != nil {
+		return fmt.Errorf("parse digest: %s", err)
+	}
+	owners := stringset.FromSlice(ring.Locations(d))
+	owned := owners.Has(addr) && !drain
+	owners.Remove(addr)
+	if len(owners) == 0 {
+		// Either we are the only owner, or every other owner is unhealthy. In
+		// both cases the blob must stay here.
+		if drain {
+			return errNoOwners
+		}
+		return nil
+	}
+	for owner := range owners {
+		if err := r.transfer(owner, d); err != nil {
+			return fmt.Errorf("transfer to %s: %s", owner, err)
+		}
+	}
+	if owned {
+		return nil
+	}
+	return r.delete(name)
+}
+
+func (r *Rebalancer) transfer(owner string, d core.Digest) error {
+	f, err := r.cas.GetCacheFileReader(d.Hex())
+	if err != nil {
+		return fmt.Errorf("get cache file: %s", err)
+	}
+	defer f.Close()
+
+	// Owners which already have the blob reject the transfer before reading
+	// any content, so only blobs which are actually moved consume bandwidth.
+	tr := &throttledReader{r: f, bandwidth: r.bandwidth}
+	if err := r.provider.Provide(owner).TransferBlob(d, tr); err != nil {
+		return err
+	}
+	if tr.n > 0 {
+		r.stats.Counter("transferred").Inc(1)
+		r.stats.Counter("transferred_bytes").Inc(tr.n)
+	}
+	return nil
+}
+
+// delete removes name from the local cache, ensuring it is written back first.
+func (r *Rebalancer) delete(name string) error {
+	var pm metadata.Persist
+	if err := r.cas.GetCacheFileMetadata(name, &pm); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("get persist metadata: %s", err)
+	}
+	if pm.Value {
+		tasks, err := r.writeBackManager.Find(writeback.NewNameQuery(name))
+		if err != nil {
+			return fmt.Errorf("find writeback tasks: %s", err)
+		}
+		for _, task := range tasks {
+			if err := r.writeBackManager.SyncExec(task); err != nil {
+				return fmt.Errorf("writeback: %s", err)
+			}
+		}
+		if err := r.cas.DeleteCacheFileMetadata(name, &metadata.Persist{}); err != nil {
+			return fmt.Errorf("delete persist metadata: %s", err)
+		}
+	}
+	if err := r.cas.DeleteCacheFile(name); err != nil {
+		return fmt.Errorf("delete cache file: %s", err)
+	}
+	r.stats.Counter("deleted").Inc(1)
+	return nil
+}
+
+// throttledReader reserves egress bandwidth for all bytes read through it.
+type throttledReader struct {
+	r         io.Reader
+	bandwidth *bandwidth.Limiter
+	n         int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.n += int64(n)
+		if err := t.bandwidth.ReserveEgress(int64(n)); err != nil {
+			log.Errorf("Error reserving egress: %s", err)
+			// Ignore error.
+		}
+	}
+	return n, err
+}
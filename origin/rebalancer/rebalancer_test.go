@@ -0,0 +1,268 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rebalancer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/persistedretry"
+	"github.com/uber/kraken/lib/persistedretry/writeback"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/mocks/lib/hashring"
+	"github.com/uber/kraken/mocks/lib/persistedretry"
+	"github.com/uber/kraken/mocks/origin/blobclient"
+	"github.com/uber/kraken/utils/mockutil"
+	"github.com/uber/kraken/utils/stringset"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const (
+	_self  = "origin1:80"
+	_peer1 = "origin2:80"
+	_peer2 = "origin3:80"
+)
+
+type rebalancerMocks struct {
+	ctrl             *gomock.Controller
+	clk              *clock.Mock
+	cas              *store.CAStore
+	ring             *mockhashring.MockRing
+	provider         *mockblobclient.MockProvider
+	writeBackManager *mockpersistedretry.MockManager
+}
+
+func newRebalancerMocks(t *testing.T) (*rebalancerMocks, func()) {
+	ctrl := gomock.NewController(t)
+	cas, cleanup := store.CAStoreFixture()
+	return &rebalancerMocks{
+		ctrl:             ctrl,
+		clk:              clock.NewMock(),
+		cas:              cas,
+		ring:             mockhashring.NewMockRing(ctrl),
+		provider:         mockblobclient.NewMockProvider(ctrl),
+		writeBackManager: mockpersistedretry.NewMockManager(ctrl),
+	}, func() {
+		cleanup()
+		ctrl.Finish()
+	}
+}
+
+func (m *rebalancerMocks) new(t *testing.T, config Config) *Rebalancer {
+	r, err := New(config, tally.NoopScope, m.clk, m.cas, m.provider, m.writeBackManager)
+	require.NoError(t, err)
+	return r
+}
+
+func (m *rebalancerMocks) client(addr string) *mockblobclient.MockClient {
+	c := mockblobclient.NewMockClient(m.ctrl)
+	m.provider.EXPECT().Provide(addr).Return(c).AnyTimes()
+	return c
+}
+
+func (m *rebalancerMocks) blob(t *testing.T) *core.BlobFixture {
+	blob := core.SizedBlobFixture(256, 8)
+	require.NoError(t, m.cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+	return blob
+}
+
+func (m *rebalancerMocks) exists(name string) bool {
+	_, err := m.cas.GetCacheFileStat(name)
+	return !os.IsNotExist(err)
+}
+
+func TestRebalanceTransfersToNewOwners(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	blob := mocks.blob(t)
+
+	mocks.ring.EXPECT().Locations(blob.Digest).Return([]string{_peer1, _self})
+	mocks.client(_peer1).EXPECT().TransferBlob(
+		blob.Digest, mockutil.MatchReader(blob.Content)).Return(nil)
+
+	r.rebalance(_self, mocks.ring, false)
+
+	// Still owned, so the local copy is kept.
+	require.True(mocks.exists(blob.Digest.Hex()))
+}
+
+func TestRebalanceDeletesBlobsNoLongerOwned(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	blob := mocks.blob(t)
+
+	mocks.ring.EXPECT().Locations(blob.Digest).Return([]string{_peer1, _peer2})
+	mocks.client(_peer1).EXPECT().TransferBlob(
+		blob.Digest, mockutil.MatchReader(blob.Content)).Return(nil)
+	mocks.client(_peer2).EXPECT().TransferBlob(
+		blob.Digest, mockutil.MatchReader(blob.Content)).Return(nil)
+
+	r.rebalance(_self, mocks.ring, false)
+
+	require.False(mocks.exists(blob.Digest.Hex()))
+}
+
+func TestRebalanceKeepsBlobWhenTransferFails(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	blob := mocks.blob(t)
+
+	mocks.ring.EXPECT().Locations(blob.Digest).Return([]string{_peer1})
+	mocks.client(_peer1).EXPECT().TransferBlob(
+		blob.Digest, gomock.Any()).Return(errors.New("some error"))
+
+	r.rebalance(_self, mocks.ring, false)
+
+	require.True(mocks.exists(blob.Digest.Hex()))
+}
+
+func TestRebalanceWritesBackBeforeDelete(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	blob := mocks.blob(t)
+	name := blob.Digest.Hex()
+
+	_, err := mocks.cas.SetCacheFileMetadata(name, metadata.NewPersist(true))
+	require.NoError(err)
+
+	task := writeback.NewTask("some-namespace", name, 0)
+	peer := mocks.client(_peer1)
+
+	mocks.ring.EXPECT().Locations(blob.Digest).Return([]string{_peer1}).Times(2)
+	peer.EXPECT().TransferBlob(blob.Digest, gomock.Any()).Return(nil).Times(2)
+	gomock.InOrder(
+		mocks.writeBackManager.EXPECT().Find(
+			writeback.NewNameQuery(name)).Return([]persistedretry.Task{task}, nil),
+		mocks.writeBackManager.EXPECT().SyncExec(task).Return(errors.New("some error")),
+		mocks.writeBackManager.EXPECT().Find(
+			writeback.NewNameQuery(name)).Return([]persistedretry.Task{task}, nil),
+		mocks.writeBackManager.EXPECT().SyncExec(task).Return(nil),
+	)
+
+	// Blob must not be deleted until it is written back.
+	r.rebalance(_self, mocks.ring, false)
+	require.True(mocks.exists(name))
+
+	r.rebalance(_self, mocks.ring, false)
+	require.False(mocks.exists(name))
+}
+
+func TestNotifyTriggersRebalanceAfterSettleDelay(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	config := Config{Enabled: true, SettleDelay: time.Minute}
+	r := mocks.new(t, config)
+	blob := mocks.blob(t)
+
+	// Initial membership does not trigger a pass.
+	r.Notify(stringset.New(_self, _peer1))
+
+	r.Start(_self, mocks.ring)
+	defer r.Stop()
+
+	done := make(chan struct{})
+	mocks.ring.EXPECT().Locations(blob.Digest).Return([]string{_peer2})
+	mocks.client(_peer2).EXPECT().TransferBlob(blob.Digest, gomock.Any()).DoAndReturn(
+		func(core.Digest, io.Reader) error {
+			close(done)
+			return nil
+		})
+
+	r.Notify(stringset.New(_self, _peer1))
+	r.Notify(stringset.New(_self, _peer1, _peer2))
+
+	// Wait for the loop to block on the settle delay.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		require.FailNow("rebalanced before settle delay")
+	default:
+	}
+
+	mocks.clk.Add(config.SettleDelay)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("rebalance did not run")
+	}
+}
+
+func TestStartDisabledIsNoop(t *testing.T) {
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	mocks.blob(t)
+
+	r.Notify(stringset.New(_self))
+	r.Start(_self, mocks.ring)
+	r.Notify(stringset.New(_self, _peer1))
+	mocks.clk.Add(time.Hour)
+	r.Stop()
+}
+
+func TestDrain(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newRebalancerMocks(t)
+	defer cleanup()
+
+	r := mocks.new(t, Config{})
+	blob1 := mocks.blob(t)
+	blob2 := mocks.blob(t)
+
+	// Owned blobs are handed over as well.
+	mocks.ring.EXPECT().Locations(blob1.Digest).Return([]string{_self, _peer1})
+	mocks.client(_peer1).EXPECT().TransferBlob(blob1.Digest, gomock.Any()).Return(nil)
+
+	// Blobs without any other owner are kept.
+	mocks.ring.EXPECT().Locations(blob2.Digest).Return([]string{_self})
+
+	failures, noOwners := r.Drain(_self, mocks.ring)
+	require.Equal(1, failures)
+	require.Equal(1, noOwners)
+	require.False(mocks.exists(blob1.Digest.Hex()))
+	require.True(mocks.exists(blob2.Digest.Hex()))
+	require.Equal(Progress{Total: 2, Failures: 1}, r.Progress())
+}
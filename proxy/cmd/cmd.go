@@ -27,12 +27,15 @@ import (
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/proxy/k8spreheat"
 	"github.com/uber/kraken/proxy/proxyserver"
 	"github.com/uber/kraken/proxy/registryoverride"
+	"github.com/uber/kraken/tracker/prefetchclient"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/flagutil"
 	"github.com/uber/kraken/utils/log"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
@@ -159,9 +162,34 @@ func Run(flags *Flags, opts ...Option) {
 
 	transferer := transfer.NewReadWriteTransferer(stats, tagClient, originCluster, cas)
 
+	var rollouts *k8spreheat.Controller
+	if config.K8sPreheat.Enabled {
+		trackers, err := config.Tracker.Build()
+		if err != nil {
+			log.Fatalf("Error building tracker upstream: %s", err)
+		}
+		kubeTLS, err := config.K8sPreheat.TLS.BuildClient()
+		if err != nil {
+			log.Fatalf("Error building kubernetes client tls config: %s", err)
+		}
+		kube, err := k8spreheat.NewKubeClient(config.K8sPreheat, kubeTLS)
+		if err != nil {
+			log.Fatalf("Error creating kubernetes client: %s", err)
+		}
+		rollouts = k8spreheat.New(
+			config.K8sPreheat,
+			stats,
+			clock.New(),
+			kube,
+			tagClient,
+			prefetchclient.New(trackers, tls))
+		log.Info("Starting kubernetes preheat controller...")
+		rollouts.Start()
+	}
+
 	// Open preheat function only if server-port was defined.
 	if flags.ServerPort != 0 {
-		server := proxyserver.New(stats, originCluster)
+		server := proxyserver.New(stats, originCluster, rollouts)
 		addr := fmt.Sprintf(":%d", flags.ServerPort)
 		log.Infof("Starting http server on %s", addr)
 		go func() {
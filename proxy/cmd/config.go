@@ -19,6 +19,7 @@ import (
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/proxy/k8spreheat"
 	"github.com/uber/kraken/proxy/registryoverride"
 	"github.com/uber/kraken/utils/httputil"
 
@@ -36,4 +37,8 @@ type Config struct {
 	RegistryOverride registryoverride.Config `yaml:"registryoverride"`
 	Nginx            nginx.Config            `yaml:"nginx"`
 	TLS              httputil.TLSConfig      `yaml:"tls"`
+
+	// Tracker and K8sPreheat are only used if K8sPreheat is enabled.
+	Tracker    upstream.PassiveHashRingConfig `yaml:"tracker"`
+	K8sPreheat k8spreheat.Config              `yaml:"k8s_preheat"`
 }
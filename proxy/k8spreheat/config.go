@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// Config defines Controller configuration.
+type Config struct {
+
+	// Enabled enables watching the Kubernetes API server for rollouts.
+	Enabled bool `yaml:"enabled"`
+
+	// APIServer is the address of the Kubernetes API server.
+	APIServer string `yaml:"api_server"`
+
+	// BearerTokenFile is a file containing the service account token used to
+	// authenticate with the API server. Requests are unauthenticated if empty.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+
+	// TLS configures the client TLS used to connect to the API server.
+	TLS httputil.TLSConfig `yaml:"tls"`
+
+	// Namespace restricts the controller to a single namespace. Watches all
+	// namespaces if empty.
+	Namespace string `yaml:"namespace"`
+
+	// Registries lists the registry hosts served by Kraken. Images hosted by
+	// other registries are ignored. If empty, images from any registry are
+	// preheated.
+	Registries []string `yaml:"registries"`
+
+	// WatchTimeout is the duration after which the API server closes a watch,
+	// upon which the watch is resumed from the last seen resource version.
+	WatchTimeout time.Duration `yaml:"watch_timeout"`
+
+	// RetryInterval is the interval to wait before relisting after a failed
+	// list or watch.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+
+	// PollInterval is the interval at which the status of in-progress
+	// prefetches is polled from the tracker.
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// PrefetchTimeout is the duration after which nodes which have not
+	// finished a prefetch are considered failed.
+	PrefetchTimeout time.Duration `yaml:"prefetch_timeout"`
+
+	// RetentionTTL is the duration rollout statuses are kept after their last
+	// update.
+	RetentionTTL time.Duration `yaml:"retention_ttl"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.WatchTimeout == 0 {
+		c.WatchTimeout = 5 * time.Minute
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 5 * time.Second
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = 5 * time.Second
+	}
+	if c.PrefetchTimeout == 0 {
+		c.PrefetchTimeout = 30 * time.Minute
+	}
+	if c.RetentionTTL == 0 {
+		c.RetentionTTL = time.Hour
+	}
+	return c
+}
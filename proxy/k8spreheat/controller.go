@@ -0,0 +1,777 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/stringset"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// ErrRolloutNotFound is returned when no rollout exists for a workload.
+var ErrRolloutNotFound = errors.New("rollout not found")
+
+// Workload kinds which are preheated.
+const (
+	KindDeployment = "deployment"
+	KindPod        = "pod"
+)
+
+// Preheat states of a node.
+const (
+	NodePending = "pending"
+	NodeRunning = "running"
+	NodeReady   = "ready"
+	NodeFailed  = "failed"
+)
+
+// ImageStatus is the preheat status of a single image of a rollout.
+type ImageStatus struct {
+	Image      string   `json:"image"`
+	Tag        string   `json:"tag,omitempty"`
+	Digest     string   `json:"digest,omitempty"`
+	Prefetches []string `json:"prefetches,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// NodeStatus is the preheat status of a single node of a rollout.
+type NodeStatus struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// RolloutStatus is the preheat status of a workload whose images changed.
+// Ready is set once every node has prefetched every image.
+type RolloutStatus struct {
+	Kind      string                 `json:"kind"`
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Images    []*ImageStatus         `json:"images"`
+	Nodes     map[string]*NodeStatus `json:"nodes"`
+	Ready     bool                   `json:"ready"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// prefetch tracks a tracker prefetch of an image on a set of nodes.
+type prefetch struct {
+	id       string
+	digest   core.Digest
+	nodes    stringset.Set
+	statuses map[string]*prefetchstore.HostStatus
+	created  time.Time
+	done     bool
+}
+
+// covers returns true if p targets node and node has not failed.
+func (p *prefetch) covers(node string) bool {
+	if !p.nodes.Has(node) {
+		return false
+	}
+	s, ok := p.statuses[node]
+	return !ok || s.State != prefetchstore.StateFailed
+}
+
+type imageState struct {
+	image      string
+	tag        string
+	digest     core.Digest
+	err        error
+	prefetches []*prefetch
+}
+
+// nodeState returns the prefetch state of the image on node.
+func (s *imageState) nodeState(node string) (string, string) {
+	for _, p := range s.prefetches {
+		if !p.nodes.Has(node) {
+			continue
+		}
+		status, ok := p.statuses[node]
+		if !ok {
+			return NodePending, ""
+		}
+		switch status.State {
+		case prefetchstore.StateComplete:
+			return NodeReady, ""
+		case prefetchstore.StateFailed:
+			return NodeFailed, status.Error
+		default:
+			return NodeRunning, ""
+		}
+	}
+	return NodeFailed, "no prefetch"
+}
+
+type rollout struct {
+	kind      string
+	namespace string
+	name      string
+	nodes     []string
+	images    []*imageState
+	updated   time.Time
+}
+
+func (r *rollout) status() *RolloutStatus {
+	s := &RolloutStatus{
+		Kind:      r.kind,
+		Namespace: r.namespace,
+		Name:      r.name,
+		Nodes:     make(map[string]*NodeStatus),
+		Ready:     len(r.nodes) > 0,
+		UpdatedAt: r.updated,
+	}
+	for _, img := range r.images {
+		is := &ImageStatus{Image: img.image, Tag: img.tag}
+		if img.err != nil {
+			is.Error = img.err.Error()
+		} else {
+			is.Digest = img.digest.String()
+		}
+		for _, p := range img.prefetches {
+			is.Prefetches = append(is.Prefetches, p.id)
+		}
+		s.Images = append(s.Images, is)
+	}
+	for _, node := range r.nodes {
+		ns := &NodeStatus{State: NodeReady}
+		var started bool
+		for _, img := range r.images {
+			if img.err != nil {
+				// Images which cannot be prefetched do not block readiness.
+				continue
+			}
+			state, errMsg := img.nodeState(node)
+			switch state {
+			case NodeFailed:
+				ns.State = NodeFailed
+				ns.Error = fmt.Sprintf("%s: %s", img.image, errMsg)
+			case NodeReady:
+				started = true
+			default:
+				if ns.State != NodeFailed {
+					ns.State = state
+				}
+				if state == NodeRunning {
+					started = true
+				}
+			}
+		}
+		if ns.State == NodePending && started {
+			ns.State = NodeRunning
+		}
+		if ns.State != NodeReady {
+			s.Ready = false
+		}
+		s.Nodes[node] = ns
+	}
+	return s
+}
+
+// Controller watches a Kubernetes API server for new or updated Deployment
+// and Pod specs, and asks the agents on the nodes they may be scheduled onto
+// to prefetch their images via the tracker, before the pods start pulling.
+//
+// Workloads which exist when the controller starts are not preheated, since
+// their images have already been pulled.
+type Controller struct {
+	config     Config
+	stats      tally.Scope
+	clk        clock.Clock
+	kube       KubeClient
+	tags       tagclient.Client
+	prefetches prefetchclient.Client
+
+	mu       sync.Mutex
+	specs    map[string]string
+	synced   map[string]bool
+	rollouts map[string]*rollout
+	active   map[string]*prefetch
+
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a new Controller.
+func New(
+	config Config,
+	stats tally.Scope,
+	clk clock.Clock,
+	kube KubeClient,
+	tags tagclient.Client,
+	prefetches prefetchclient.Client) *Controller {
+
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "k8spreheat",
+	})
+
+	return &Controller{
+		config:     config,
+		stats:      stats,
+		clk:        clk,
+		kube:       kube,
+		tags:       tags,
+		prefetches: prefetches,
+		specs:      make(map[string]string),
+		synced:     make(map[string]bool),
+		rollouts:   make(map[string]*rollout),
+		active:     make(map[string]*prefetch),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start starts watching deployments and pods in background goroutines.
+func (c *Controller) Start() {
+	c.wg.Add(3)
+	go c.watchLoop(_deployments)
+	go c.watchLoop(_pods)
+	go c.pollLoop()
+}
+
+// Stop stops all watches and waits for in-flight work to finish.
+func (c *Controller) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+}
+
+// Rollouts returns the status of all tracked rollouts.
+func (c *Controller) Rollouts() []*RolloutStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	for k := range c.rollouts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	statuses := []*RolloutStatus{}
+	for _, k := range keys {
+		statuses = append(statuses, c.rollouts[k].status())
+	}
+	return statuses
+}
+
+// Rollout returns the status of the rollout of the workload of kind.
+func (c *Controller) Rollout(kind, namespace, name string) (*RolloutStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.rollouts[workloadKey(kind, namespace, name)]
+	if !ok {
+		return nil, ErrRolloutNotFound
+	}
+	return r.status(), nil
+}
+
+// hasSynced returns true once the initial list of all watched resources has
+// been recorded.
+func (c *Controller) hasSynced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.synced[_deployments] && c.synced[_pods]
+}
+
+func workloadKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// sleep waits for d, returning false if the controller was stopped.
+func (c *Controller) sleep(d time.Duration) bool {
+	select {
+	case <-c.clk.After(d):
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *Controller) stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchLoop lists resource and watches it for changes, relisting whenever
+// the watch cannot be resumed.
+func (c *Controller) watchLoop(resource string) {
+	defer c.wg.Done()
+
+	logger := log.With("resource", resource)
+
+	initial := true
+	for !c.stopped() {
+		rv, err := c.list(resource, initial)
+		if err != nil {
+			logger.Errorf("Error listing: %s", err)
+			c.stats.Counter("list_errors").Inc(1)
+			if !c.sleep(c.config.RetryInterval) {
+				return
+			}
+			continue
+		}
+		if initial {
+			c.mu.Lock()
+			c.synced[resource] = true
+			c.mu.Unlock()
+		}
+		initial = false
+		for err == nil && !c.stopped() {
+			rv, err = c.watch(resource, rv)
+		}
+		if err == ErrResourceVersionExpired {
+			logger.Info("Watch expired, relisting")
+			continue
+		}
+		if err != nil && !c.stopped() {
+			logger.Errorf("Error watching: %s", err)
+			c.stats.Counter("watch_errors").Inc(1)
+			if !c.sleep(c.config.RetryInterval) {
+				return
+			}
+		}
+	}
+}
+
+// list handles every object of resource, and returns the resource version to
+// start watching from. Objects of the initial list are recorded without being
+// preheated.
+func (c *Controller) list(resource string, initial bool) (string, error) {
+	seen := make(map[string]bool)
+	var rv string
+	switch resource {
+	case _deployments:
+		l, err := c.kube.ListDeployments(c.config.Namespace)
+		if err != nil {
+			return "", err
+		}
+		for i := range l.Items {
+			d := &l.Items[i]
+			seen[workloadKey(KindDeployment, d.Metadata.Namespace, d.Metadata.Name)] = true
+			c.handleDeployment(EventAdded, d, initial)
+		}
+		rv = l.Metadata.ResourceVersion
+		c.forgetUnseen(KindDeployment, seen)
+	case _pods:
+		l, err := c.kube.ListPods(c.config.Namespace)
+		if err != nil {
+			return "", err
+		}
+		for i := range l.Items {
+			p := &l.Items[i]
+			seen[workloadKey(KindPod, p.Metadata.Namespace, p.Metadata.Name)] = true
+			c.handlePod(EventAdded, p, initial)
+		}
+		rv = l.Metadata.ResourceVersion
+		c.forgetUnseen(KindPod, seen)
+	default:
+		return "", fmt.Errorf("unsupported resource %q", resource)
+	}
+	return rv, nil
+}
+
+// forgetUnseen drops workloads of kind which were deleted while no watch was
+// running.
+func (c *Controller) forgetUnseen(kind string, seen map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.specs {
+		if strings.HasPrefix(k, kind+"/") && !seen[k] {
+			delete(c.specs, k)
+			delete(c.rollouts, k)
+		}
+	}
+}
+
+// watch handles watch events of resource starting after rv until the API
+// server closes the watch. Returns the last seen resource version.
+func (c *Controller) watch(resource, rv string) (string, error) {
+	w, err := c.kube.Watch(resource, c.config.Namespace, rv, c.config.WatchTimeout)
+	if err != nil {
+		return rv, err
+	}
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-c.done:
+			w.Close()
+		case <-closed:
+			w.Close()
+		}
+	}()
+
+	for {
+		e, err := w.Next()
+		if err == io.EOF {
+			return rv, nil
+		}
+		if err != nil {
+			return rv, err
+		}
+		var meta struct {
+			Metadata ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(e.Object, &meta); err != nil {
+			return rv, fmt.Errorf("decode metadata: %s", err)
+		}
+		if meta.Metadata.ResourceVersion != "" {
+			rv = meta.Metadata.ResourceVersion
+		}
+		if e.Type == EventBookmark {
+			continue
+		}
+		switch resource {
+		case _deployments:
+			var d Deployment
+			if err := json.Unmarshal(e.Object, &d); err != nil {
+				return rv, fmt.Errorf("decode deployment: %s", err)
+			}
+			c.handleDeployment(e.Type, &d, false)
+		case _pods:
+			var p Pod
+			if err := json.Unmarshal(e.Object, &p); err != nil {
+				return rv, fmt.Errorf("decode pod: %s", err)
+			}
+			c.handlePod(e.Type, &p, false)
+		}
+	}
+}
+
+// observe records the spec signature of the workload of key, and returns
+// whether the workload should be preheated.
+func (c *Controller) observe(key, eventType, sig string, initial bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if eventType == EventDeleted {
+		delete(c.specs, key)
+		delete(c.rollouts, key)
+		return false
+	}
+	prev, ok := c.specs[key]
+	c.specs[key] = sig
+	if initial && !ok {
+		return false
+	}
+	return !ok || prev != sig
+}
+
+func (c *Controller) handleDeployment(eventType string, d *Deployment, initial bool) {
+	key := workloadKey(KindDeployment, d.Metadata.Namespace, d.Metadata.Name)
+	spec := d.Spec.Template.Spec
+	images := spec.Images()
+	if !c.observe(key, eventType, strings.Join(images, ","), initial) {
+		return
+	}
+	if d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+		return
+	}
+	nodes, err := c.eligibleNodes(spec.NodeSelector)
+	if err != nil {
+		log.With("deployment", key).Errorf("Error listing nodes: %s", err)
+		c.stats.Counter("list_errors").Inc(1)
+		return
+	}
+	c.preheat(KindDeployment, d.Metadata.Namespace, d.Metadata.Name, images, nodes)
+}
+
+func (c *Controller) handlePod(eventType string, p *Pod, initial bool) {
+	key := workloadKey(KindPod, p.Metadata.Namespace, p.Metadata.Name)
+	if p.Metadata.ControlledBy("ReplicaSet") {
+		// Pods of deployments are preheated with their deployment.
+		return
+	}
+	if p.Status.Phase != "" && p.Status.Phase != PodPending {
+		// Images of running or terminated pods have already been pulled.
+		if eventType != EventDeleted {
+			eventType = EventModified
+		}
+		c.observe(key, eventType, "", true)
+		return
+	}
+	images := p.Spec.Images()
+	sig := p.Spec.NodeName + "|" + strings.Join(images, ",")
+	if !c.observe(key, eventType, sig, initial) {
+		return
+	}
+	var nodes []string
+	if p.Spec.NodeName != "" {
+		nodes = []string{p.Spec.NodeName}
+	} else {
+		var err error
+		nodes, err = c.eligibleNodes(p.Spec.NodeSelector)
+		if err != nil {
+			log.With("pod", key).Errorf("Error listing nodes: %s", err)
+			c.stats.Counter("list_errors").Inc(1)
+			return
+		}
+	}
+	c.preheat(KindPod, p.Metadata.Namespace, p.Metadata.Name, images, nodes)
+}
+
+// eligibleNodes returns the names of the schedulable nodes matching selector.
+func (c *Controller) eligibleNodes(selector map[string]string) ([]string, error) {
+	l, err := c.kube.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, n := range l.Items {
+		if n.Spec.Unschedulable || !matchLabels(selector, n.Metadata.Labels) {
+			continue
+		}
+		nodes = append(nodes, n.Metadata.Name)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+func matchLabels(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// preheat resolves images and prefetches them on nodes, replacing any
+// previous rollout of the workload.
+func (c *Controller) preheat(kind, namespace, name string, images, nodes []string) {
+	logger := log.With("kind", kind, "namespace", namespace, "name", name)
+
+	r := &rollout{
+		kind:      kind,
+		namespace: namespace,
+		name:      name,
+		nodes:     nodes,
+		updated:   c.clk.Now(),
+	}
+	for _, image := range images {
+		s := &imageState{image: image}
+		r.images = append(r.images, s)
+
+		tag, err := imageTag(image, c.config.Registries)
+		if err != nil {
+			s.err = err
+			c.stats.Counter("skipped_images").Inc(1)
+			continue
+		}
+		s.tag = tag
+		d, err := c.tags.Get(tag)
+		if err != nil {
+			if err == tagclient.ErrTagNotFound {
+				c.stats.Counter("tag_not_found").Inc(1)
+			} else {
+				logger.Errorf("Error resolving tag %s: %s", tag, err)
+				c.stats.Counter("tag_errors").Inc(1)
+			}
+			s.err = fmt.Errorf("resolve tag: %s", err)
+			continue
+		}
+		s.digest = d
+		if len(nodes) == 0 {
+			continue
+		}
+		s.prefetches, err = c.prefetch(tag, d, nodes)
+		if err != nil {
+			logger.Errorf("Error creating prefetch of %s: %s", tag, err)
+			c.stats.Counter("prefetch_errors").Inc(1)
+			s.err = fmt.Errorf("create prefetch: %s", err)
+		}
+	}
+	logger.Infof("Preheating %d images on %d nodes", len(images), len(nodes))
+	c.stats.Counter("rollouts").Inc(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollouts[workloadKey(kind, namespace, name)] = r
+}
+
+// prefetch returns prefetches of d covering nodes, reusing existing
+// prefetches where possible and creating a new prefetch for the rest.
+func (c *Controller) prefetch(tag string, d core.Digest, nodes []string) ([]*prefetch, error) {
+	var result []*prefetch
+	remaining := stringset.FromSlice(nodes)
+
+	c.mu.Lock()
+	for _, p := range c.active {
+		if p.digest != d {
+			continue
+		}
+		var used bool
+		for node := range remaining {
+			if p.covers(node) {
+				remaining.Remove(node)
+				used = true
+			}
+		}
+		if used {
+			result = append(result, p)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(remaining) == 0 {
+		return result, nil
+	}
+	pf, err := c.prefetches.Create(tag, hostsRegexp(remaining.ToSlice()))
+	if err != nil {
+		return nil, err
+	}
+	p := &prefetch{
+		id:       pf.ID,
+		digest:   d,
+		nodes:    remaining,
+		statuses: make(map[string]*prefetchstore.HostStatus),
+		created:  c.clk.Now(),
+	}
+	c.stats.Counter("prefetches").Inc(1)
+
+	c.mu.Lock()
+	c.active[p.id] = p
+	c.mu.Unlock()
+
+	return append(result, p), nil
+}
+
+// hostsRegexp returns a regular expression matching exactly nodes.
+func hostsRegexp(nodes []string) string {
+	sort.Strings(nodes)
+	quoted := make([]string, len(nodes))
+	for i, n := range nodes {
+		quoted[i] = regexp.QuoteMeta(n)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// pollLoop periodically refreshes the per-node status of unfinished
+// prefetches, and expires old rollouts.
+func (c *Controller) pollLoop() {
+	defer c.wg.Done()
+
+	for c.sleep(c.config.PollInterval) {
+		c.poll()
+		c.cleanup()
+	}
+}
+
+func (c *Controller) poll() {
+	var pending []string
+	c.mu.Lock()
+	for id, p := range c.active {
+		if !p.done {
+			pending = append(pending, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, id := range pending {
+		pf, err := c.prefetches.Get(id)
+		if err != nil && err != prefetchclient.ErrNotFound {
+			log.With("id", id).Errorf("Error getting prefetch: %s", err)
+			c.stats.Counter("poll_errors").Inc(1)
+			continue
+		}
+		c.mu.Lock()
+		p, ok := c.active[id]
+		if ok {
+			c.update(p, pf)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// update applies the statuses of pf to p. A nil pf means the tracker no
+// longer knows of the prefetch.
+func (c *Controller) update(p *prefetch, pf *prefetchstore.Prefetch) {
+	if pf != nil {
+		for node, s := range pf.Statuses {
+			if p.nodes.Has(node) {
+				p.statuses[node] = s
+			}
+		}
+	}
+	done := true
+	for node := range p.nodes {
+		if s, ok := p.statuses[node]; !ok || !s.Done() {
+			done = false
+		}
+	}
+	if done {
+		p.done = true
+		return
+	}
+	var reason string
+	if pf == nil {
+		reason = "prefetch expired"
+	} else if c.clk.Now().Sub(p.created) > c.config.PrefetchTimeout {
+		reason = "prefetch timed out"
+	} else {
+		return
+	}
+	for node := range p.nodes {
+		if s, ok := p.statuses[node]; !ok || !s.Done() {
+			p.statuses[node] = &prefetchstore.HostStatus{
+				State:     prefetchstore.StateFailed,
+				Error:     reason,
+				UpdatedAt: c.clk.Now(),
+			}
+		}
+	}
+	p.done = true
+}
+
+// cleanup removes rollouts and finished prefetches older than the retention
+// TTL.
+func (c *Controller) cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clk.Now()
+	for k, r := range c.rollouts {
+		if now.Sub(r.updated) > c.config.RetentionTTL {
+			delete(c.rollouts, k)
+		}
+	}
+	for id, p := range c.active {
+		if p.done && now.Sub(p.created) > c.config.RetentionTTL {
+			delete(c.active, id)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/core"
+	mocktagclient "github.com/uber/kraken/mocks/build-index/tagclient"
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _registry = "kraken.example.com"
+
+// fakePrefetchClient is a prefetchclient.Client backed by a local store.
+type fakePrefetchClient struct {
+	sync.Mutex
+	store   *prefetchstore.LocalStore
+	created []*prefetchstore.Prefetch
+}
+
+func newFakePrefetchClient() *fakePrefetchClient {
+	return &fakePrefetchClient{
+		store: prefetchstore.NewLocalStore(prefetchstore.LocalConfig{}, clock.New()),
+	}
+}
+
+func (c *fakePrefetchClient) Create(tag, hosts string) (*prefetchstore.Prefetch, error) {
+	c.Lock()
+	defer c.Unlock()
+	p := prefetchstore.PrefetchFixture(hosts)
+	p.Tag = tag
+	if err := c.store.Add(p); err != nil {
+		return nil, err
+	}
+	c.created = append(c.created, p)
+	return p, nil
+}
+
+func (c *fakePrefetchClient) Get(id string) (*prefetchstore.Prefetch, error) {
+	p, err := c.store.Get(id)
+	if err == prefetchstore.ErrNotFound {
+		return nil, prefetchclient.ErrNotFound
+	}
+	return p, err
+}
+
+func (c *fakePrefetchClient) Pending(hostname string) (*prefetchclient.PendingResponse, error) {
+	ps, err := prefetchstore.Pending(c.store, hostname)
+	if err != nil {
+		return nil, err
+	}
+	resp := &prefetchclient.PendingResponse{}
+	for _, p := range ps {
+		resp.Prefetches = append(resp.Prefetches, prefetchclient.NewPendingPrefetch(p, hostname))
+	}
+	return resp, nil
+}
+
+func (c *fakePrefetchClient) UpdateStatus(
+	id, hostname string, status *prefetchstore.HostStatus) error {
+
+	return c.store.UpdateStatus(id, hostname, status)
+}
+
+func (c *fakePrefetchClient) getCreated() []*prefetchstore.Prefetch {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*prefetchstore.Prefetch(nil), c.created...)
+}
+
+func (c *fakePrefetchClient) complete(t *testing.T, id, node string) {
+	require.NoError(t, c.store.UpdateStatus(id, node, &prefetchstore.HostStatus{
+		State:     prefetchstore.StateComplete,
+		UpdatedAt: time.Now(),
+	}))
+}
+
+type controllerMocks struct {
+	api        *FakeAPIServer
+	addr       string
+	tags       *mocktagclient.MockClient
+	prefetches *fakePrefetchClient
+	cleanup    *testutil.Cleanup
+}
+
+func newControllerMocks(t *testing.T) (*controllerMocks, func()) {
+	var cleanup testutil.Cleanup
+
+	ctrl := gomock.NewController(t)
+	cleanup.Add(ctrl.Finish)
+
+	api := NewFakeAPIServer()
+	addr, stop := testutil.StartServer(api.Handler())
+	cleanup.Add(stop)
+
+	return &controllerMocks{
+		api:        api,
+		addr:       addr,
+		tags:       mocktagclient.NewMockClient(ctrl),
+		prefetches: newFakePrefetchClient(),
+		cleanup:    &cleanup,
+	}, cleanup.Run
+}
+
+func (m *controllerMocks) new(t *testing.T, config Config) *Controller {
+	config.APIServer = m.addr
+	config.Registries = []string{_registry}
+	if config.WatchTimeout == 0 {
+		config.WatchTimeout = time.Second
+	}
+	config.RetryInterval = 10 * time.Millisecond
+	config.PollInterval = 10 * time.Millisecond
+
+	kube, err := NewKubeClient(config, nil)
+	require.NoError(t, err)
+
+	c := New(config, tally.NoopScope, clock.New(), kube, m.tags, m.prefetches)
+	c.Start()
+	m.cleanup.Add(c.Stop)
+	require.Eventually(t, c.hasSynced, 5*time.Second, 10*time.Millisecond)
+	return c
+}
+
+func (m *controllerMocks) waitForRollout(
+	t *testing.T, c *Controller, kind, namespace, name string,
+	cond func(*RolloutStatus) bool) *RolloutStatus {
+
+	var s *RolloutStatus
+	require.Eventually(t, func() bool {
+		var err error
+		s, err = c.Rollout(kind, namespace, name)
+		return err == nil && cond(s)
+	}, 5*time.Second, 10*time.Millisecond)
+	return s
+}
+
+func anyRollout(*RolloutStatus) bool { return true }
+
+func nodeFixture(name string, labels map[string]string) Node {
+	return Node{Metadata: ObjectMeta{Name: name, Labels: labels}}
+}
+
+func deploymentFixture(name string, selector map[string]string, images ...string) Deployment {
+	var containers []Container
+	for _, image := range images {
+		containers = append(containers, Container{Name: "c", Image: image})
+	}
+	return Deployment{
+		Metadata: ObjectMeta{Namespace: "default", Name: name},
+		Spec: DeploymentSpec{
+			Template: PodTemplateSpec{
+				Spec: PodSpec{NodeSelector: selector, Containers: containers},
+			},
+		},
+	}
+}
+
+func podFixture(name, node string, images ...string) Pod {
+	var containers []Container
+	for _, image := range images {
+		containers = append(containers, Container{Name: "c", Image: image})
+	}
+	return Pod{
+		Metadata: ObjectMeta{Namespace: "default", Name: name},
+		Spec:     PodSpec{NodeName: node, Containers: containers},
+		Status:   PodStatus{Phase: PodPending},
+	}
+}
+
+func TestControllerPreheatsUpdatedDeployment(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	pool := map[string]string{"pool": "a"}
+	mocks.api.ApplyNode(nodeFixture("node-1", pool))
+	mocks.api.ApplyNode(nodeFixture("node-2", pool))
+	mocks.api.ApplyNode(nodeFixture("node-3", map[string]string{"pool": "b"}))
+	cordoned := nodeFixture("node-4", pool)
+	cordoned.Spec.Unschedulable = true
+	mocks.api.ApplyNode(cordoned)
+
+	d := deploymentFixture("app", pool, _registry+"/team/app:v1")
+	mocks.api.ApplyDeployment(d)
+
+	c := mocks.new(t, Config{})
+
+	// The existing deployment must not be preheated.
+	require.Empty(c.Rollouts())
+
+	digest := core.DigestFixture()
+	mocks.tags.EXPECT().Get("team/app:v2").Return(digest, nil)
+
+	d.Spec.Template.Spec.Containers[0].Image = _registry + "/team/app:v2"
+	mocks.api.ApplyDeployment(d)
+
+	s := mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+	require.Len(s.Images, 1)
+	require.Equal("team/app:v2", s.Images[0].Tag)
+	require.Equal(digest.String(), s.Images[0].Digest)
+	require.Equal(map[string]*NodeStatus{
+		"node-1": {State: NodePending},
+		"node-2": {State: NodePending},
+	}, s.Nodes)
+	require.False(s.Ready)
+
+	created := mocks.prefetches.getCreated()
+	require.Len(created, 1)
+	require.Equal("team/app:v2", created[0].Tag)
+	require.Equal(`^(node-1|node-2)$`, created[0].Hosts)
+
+	mocks.prefetches.complete(t, created[0].ID, "node-1")
+	s = mocks.waitForRollout(t, c, KindDeployment, "default", "app", func(s *RolloutStatus) bool {
+		return s.Nodes["node-1"].State == NodeReady
+	})
+	require.Equal(NodePending, s.Nodes["node-2"].State)
+	require.False(s.Ready)
+
+	mocks.prefetches.complete(t, created[0].ID, "node-2")
+	mocks.waitForRollout(t, c, KindDeployment, "default", "app", func(s *RolloutStatus) bool {
+		return s.Ready
+	})
+}
+
+func TestControllerSkipsImagesWhichCannotBePrefetched(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	mocks.api.ApplyNode(nodeFixture("node-1", nil))
+
+	c := mocks.new(t, Config{})
+
+	mocks.tags.EXPECT().Get("team/missing:v1").Return(core.Digest{}, tagclient.ErrTagNotFound)
+
+	mocks.api.ApplyDeployment(deploymentFixture(
+		"app", nil,
+		"docker.io/library/nginx:1.19",
+		_registry+"/team/app@"+core.DigestFixture().String(),
+		_registry+"/team/missing:v1"))
+
+	s := mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+	require.Len(s.Images, 3)
+	for _, img := range s.Images {
+		require.NotEmpty(img.Error)
+		require.Empty(img.Prefetches)
+	}
+	require.Empty(mocks.prefetches.getCreated())
+
+	// Nothing can be prefetched, so nothing blocks the rollout.
+	require.True(s.Ready)
+}
+
+func TestControllerReusesPrefetchesAcrossWorkloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	mocks.api.ApplyNode(nodeFixture("node-1", nil))
+	mocks.api.ApplyNode(nodeFixture("node-2", nil))
+
+	c := mocks.new(t, Config{})
+
+	image := _registry + "/team/app:v1"
+	digest := core.DigestFixture()
+	mocks.tags.EXPECT().Get("team/app:v1").Return(digest, nil).Times(2)
+
+	mocks.api.ApplyDeployment(deploymentFixture("app", nil, image))
+	mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+
+	// Pods of deployments are preheated with their deployment.
+	owned := podFixture("app-xyz", "node-1", image)
+	owned.Metadata.OwnerReferences = []OwnerReference{
+		{Kind: "ReplicaSet", Name: "app-abc", Controller: true},
+	}
+	mocks.api.ApplyPod(owned)
+
+	mocks.api.ApplyPod(podFixture("job", "node-1", image))
+
+	s := mocks.waitForRollout(t, c, KindPod, "default", "job", anyRollout)
+	require.Equal([]string{"node-1"}, keys(s.Nodes))
+
+	created := mocks.prefetches.getCreated()
+	require.Len(created, 1)
+	require.Equal([]string{created[0].ID}, s.Images[0].Prefetches)
+
+	_, err := c.Rollout(KindPod, "default", "app-xyz")
+	require.Equal(ErrRolloutNotFound, err)
+}
+
+func TestControllerForgetsDeletedWorkloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	mocks.api.ApplyNode(nodeFixture("node-1", nil))
+
+	c := mocks.new(t, Config{})
+
+	mocks.tags.EXPECT().Get("team/app:v1").Return(core.DigestFixture(), nil)
+
+	mocks.api.ApplyDeployment(deploymentFixture("app", nil, _registry+"/team/app:v1"))
+	mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+
+	mocks.api.DeleteDeployment("default", "app")
+	require.Eventually(func() bool {
+		_, err := c.Rollout(KindDeployment, "default", "app")
+		return err == ErrRolloutNotFound
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestControllerResumesWatchAfterTimeout(t *testing.T) {
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	mocks.api.ApplyNode(nodeFixture("node-1", nil))
+
+	c := mocks.new(t, Config{})
+
+	mocks.tags.EXPECT().Get("team/app:v1").Return(core.DigestFixture(), nil)
+
+	// The fake API server closes watches after one second.
+	time.Sleep(1500 * time.Millisecond)
+
+	mocks.api.ApplyDeployment(deploymentFixture("app", nil, _registry+"/team/app:v1"))
+	mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+}
+
+func TestControllerFailsNodesOnPrefetchTimeout(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newControllerMocks(t)
+	defer cleanup()
+
+	mocks.api.ApplyNode(nodeFixture("node-1", nil))
+	mocks.api.ApplyNode(nodeFixture("node-2", nil))
+
+	c := mocks.new(t, Config{PrefetchTimeout: 500 * time.Millisecond})
+
+	mocks.tags.EXPECT().Get("team/app:v1").Return(core.DigestFixture(), nil)
+
+	mocks.api.ApplyDeployment(deploymentFixture("app", nil, _registry+"/team/app:v1"))
+	mocks.waitForRollout(t, c, KindDeployment, "default", "app", anyRollout)
+
+	created := mocks.prefetches.getCreated()
+	require.Len(created, 1)
+	mocks.prefetches.complete(t, created[0].ID, "node-1")
+
+	s := mocks.waitForRollout(t, c, KindDeployment, "default", "app", func(s *RolloutStatus) bool {
+		return s.Nodes["node-2"].State == NodeFailed
+	})
+	require.Equal(NodeReady, s.Nodes["node-1"].State)
+	require.Contains(s.Nodes["node-2"].Error, "timed out")
+	require.False(s.Ready)
+}
+
+func keys(m map[string]*NodeStatus) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pressly/chi"
+)
+
+type fakeObject struct {
+	namespace string
+	raw       json.RawMessage
+}
+
+type fakeEvent struct {
+	resource  string
+	namespace string
+	version   int
+	event     WatchEvent
+}
+
+// FakeAPIServer is an in-memory stand-in for the Kubernetes API server which
+// supports listing and watching nodes, pods and deployments.
+type FakeAPIServer struct {
+	mu      sync.Mutex
+	version int
+	objects map[string]map[string]*fakeObject
+	history []fakeEvent
+	changed chan struct{}
+}
+
+// NewFakeAPIServer creates a new FakeAPIServer.
+func NewFakeAPIServer() *FakeAPIServer {
+	return &FakeAPIServer{
+		objects: map[string]map[string]*fakeObject{
+			_nodes:       {},
+			_pods:        {},
+			_deployments: {},
+		},
+		changed: make(chan struct{}),
+	}
+}
+
+// ApplyNode adds or updates n.
+func (s *FakeAPIServer) ApplyNode(n Node) {
+	s.apply(_nodes, &n.Metadata, &n)
+}
+
+// ApplyPod adds or updates p.
+func (s *FakeAPIServer) ApplyPod(p Pod) {
+	s.apply(_pods, &p.Metadata, &p)
+}
+
+// ApplyDeployment adds or updates d.
+func (s *FakeAPIServer) ApplyDeployment(d Deployment) {
+	s.apply(_deployments, &d.Metadata, &d)
+}
+
+// DeletePod deletes the pod of namespace and name.
+func (s *FakeAPIServer) DeletePod(namespace, name string) {
+	s.delete(_pods, namespace, name)
+}
+
+// DeleteDeployment deletes the deployment of namespace and name.
+func (s *FakeAPIServer) DeleteDeployment(namespace, name string) {
+	s.delete(_deployments, namespace, name)
+}
+
+func (s *FakeAPIServer) apply(resource string, meta *ObjectMeta, obj interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version++
+	meta.ResourceVersion = strconv.Itoa(s.version)
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	key := meta.Namespace + "/" + meta.Name
+	eventType := EventModified
+	if _, ok := s.objects[resource][key]; !ok {
+		eventType = EventAdded
+	}
+	s.objects[resource][key] = &fakeObject{meta.Namespace, raw}
+	s.record(resource, meta.Namespace, WatchEvent{eventType, raw})
+}
+
+func (s *FakeAPIServer) delete(resource, namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := namespace + "/" + name
+	o, ok := s.objects[resource][key]
+	if !ok {
+		return
+	}
+	delete(s.objects[resource], key)
+	s.version++
+
+	// Deleted objects carry the resource version of their deletion.
+	var obj map[string]interface{}
+	if err := json.Unmarshal(o.raw, &obj); err != nil {
+		panic(err)
+	}
+	obj["metadata"].(map[string]interface{})["resourceVersion"] = strconv.Itoa(s.version)
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	s.record(resource, namespace, WatchEvent{EventDeleted, raw})
+}
+
+func (s *FakeAPIServer) record(resource, namespace string, e WatchEvent) {
+	s.history = append(s.history, fakeEvent{resource, namespace, s.version, e})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Handler returns an HTTP handler serving the Kubernetes API paths used by
+// KubeClient.
+func (s *FakeAPIServer) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/api/v1/{resource}", s.serve)
+	r.Get("/api/v1/namespaces/{namespace}/{resource}", s.serve)
+	r.Get("/apis/apps/v1/{resource}", s.serve)
+	r.Get("/apis/apps/v1/namespaces/{namespace}/{resource}", s.serve)
+	return r
+}
+
+func (s *FakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	resource := chi.URLParam(r, "resource")
+	namespace := chi.URLParam(r, "namespace")
+	if _, ok := s.objects[resource]; !ok {
+		http.Error(w, "unknown resource", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("watch") == "true" {
+		s.watch(w, r, resource, namespace)
+		return
+	}
+	s.mu.Lock()
+	items := []json.RawMessage{}
+	for _, o := range s.objects[resource] {
+		if namespace == "" || o.namespace == namespace {
+			items = append(items, o.raw)
+		}
+	}
+	resp := map[string]interface{}{
+		"metadata": ListMeta{ResourceVersion: strconv.Itoa(s.version)},
+		"items":    items,
+	}
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *FakeAPIServer) watch(w http.ResponseWriter, r *http.Request, resource, namespace string) {
+	since, err := strconv.Atoi(r.URL.Query().Get("resourceVersion"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid resource version: %s", err), http.StatusBadRequest)
+		return
+	}
+	timeout := time.Minute
+	if v := r.URL.Query().Get("timeoutSeconds"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid timeout: %s", err), http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(secs) * time.Second
+	}
+	deadline := time.After(timeout)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for {
+		s.mu.Lock()
+		var events []WatchEvent
+		for _, e := range s.history {
+			if e.version <= since || e.resource != resource {
+				continue
+			}
+			if namespace == "" || e.namespace == namespace {
+				events = append(events, e.event)
+			}
+		}
+		since = s.version
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
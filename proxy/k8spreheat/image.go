@@ -0,0 +1,56 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/uber/kraken/utils/stringset"
+)
+
+var (
+	errDigestReference = errors.New("images referenced by digest cannot be prefetched")
+	errUnknownRegistry = errors.New("registry not served by kraken")
+)
+
+// imageTag converts the container image reference into the Kraken tag of the
+// image, i.e. the repository and tag without the registry host. If registries
+// is non-empty, the image must be hosted by one of them.
+func imageTag(image string, registries []string) (string, error) {
+	if strings.Contains(image, "@") {
+		return "", errDigestReference
+	}
+	var host string
+	repo := image
+	if i := strings.Index(image, "/"); i >= 0 {
+		first := image[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host = first
+			repo = image[i+1:]
+		}
+	}
+	if len(registries) > 0 && !stringset.FromSlice(registries).Has(host) {
+		return "", errUnknownRegistry
+	}
+	tag := "latest"
+	if i := strings.LastIndex(repo, ":"); i >= 0 {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	if repo == "" || tag == "" {
+		return "", fmt.Errorf("invalid image reference %q", image)
+	}
+	return repo + ":" + tag, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		image      string
+		registries []string
+		expected   string
+		err        error
+	}{
+		{"kraken.example.com/team/app:v1", nil, "team/app:v1", nil},
+		{"localhost:5000/app:v1", nil, "app:v1", nil},
+		{"kraken.example.com:5000/team/app", nil, "team/app:latest", nil},
+		{"team/app:v1", nil, "team/app:v1", nil},
+		{"app", nil, "app:latest", nil},
+		{"kraken.example.com/team/app:v1", []string{"kraken.example.com"}, "team/app:v1", nil},
+		{"docker.io/team/app:v1", []string{"kraken.example.com"}, "", errUnknownRegistry},
+		{"team/app:v1", []string{"kraken.example.com"}, "", errUnknownRegistry},
+		{"kraken.example.com/team/app@sha256:abc", nil, "", errDigestReference},
+	}
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			require := require.New(t)
+
+			tag, err := imageTag(test.image, test.registries)
+			if test.err != nil {
+				require.Equal(test.err, err)
+				return
+			}
+			require.NoError(err)
+			require.Equal(test.expected, tag)
+		})
+	}
+}
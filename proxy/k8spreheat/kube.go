@@ -0,0 +1,342 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package k8spreheat
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// The subset of the Kubernetes API objects needed to determine which images a
+// workload runs, and on which nodes.
+
+// ObjectMeta is Kubernetes object metadata.
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Generation      int64             `json:"generation,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	OwnerReferences []OwnerReference  `json:"ownerReferences,omitempty"`
+}
+
+// OwnerReference identifies the object which owns another.
+type OwnerReference struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Controller bool   `json:"controller,omitempty"`
+}
+
+// ControlledBy returns true if the object is controlled by an object of kind.
+func (m ObjectMeta) ControlledBy(kind string) bool {
+	for _, o := range m.OwnerReferences {
+		if o.Controller && o.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ListMeta is Kubernetes list metadata.
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// Container is a container of a pod.
+type Container struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// PodSpec is the specification of a pod.
+type PodSpec struct {
+	NodeName       string            `json:"nodeName,omitempty"`
+	NodeSelector   map[string]string `json:"nodeSelector,omitempty"`
+	InitContainers []Container       `json:"initContainers,omitempty"`
+	Containers     []Container       `json:"containers"`
+}
+
+// Images returns the distinct images of all containers in s, in order.
+func (s PodSpec) Images() []string {
+	var images []string
+	seen := make(map[string]bool)
+	for _, cs := range [][]Container{s.InitContainers, s.Containers} {
+		for _, c := range cs {
+			if c.Image == "" || seen[c.Image] {
+				continue
+			}
+			seen[c.Image] = true
+			images = append(images, c.Image)
+		}
+	}
+	return images
+}
+
+// Pod phases.
+const (
+	PodPending = "Pending"
+	PodRunning = "Running"
+)
+
+// PodStatus is the status of a pod.
+type PodStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+// Pod is a Kubernetes pod.
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+	Status   PodStatus  `json:"status,omitempty"`
+}
+
+// PodList is a list of pods.
+type PodList struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+// PodTemplateSpec is the template pods of a deployment are created from.
+type PodTemplateSpec struct {
+	Metadata ObjectMeta `json:"metadata,omitempty"`
+	Spec     PodSpec    `json:"spec"`
+}
+
+// DeploymentSpec is the specification of a deployment.
+type DeploymentSpec struct {
+	Replicas *int32          `json:"replicas,omitempty"`
+	Template PodTemplateSpec `json:"template"`
+}
+
+// Deployment is a Kubernetes deployment.
+type Deployment struct {
+	Metadata ObjectMeta     `json:"metadata"`
+	Spec     DeploymentSpec `json:"spec"`
+}
+
+// DeploymentList is a list of deployments.
+type DeploymentList struct {
+	Metadata ListMeta     `json:"metadata"`
+	Items    []Deployment `json:"items"`
+}
+
+// NodeSpec is the specification of a node.
+type NodeSpec struct {
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// Node is a Kubernetes node.
+type Node struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     NodeSpec   `json:"spec,omitempty"`
+}
+
+// NodeList is a list of nodes.
+type NodeList struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []Node   `json:"items"`
+}
+
+// Watch event types.
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+	EventBookmark = "BOOKMARK"
+	EventError    = "ERROR"
+)
+
+// WatchEvent is a single change streamed by a watch.
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Status is returned by the API server in ERROR watch events.
+type Status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ErrResourceVersionExpired is returned when a watch can no longer be resumed
+// from the requested resource version, and the resource must be relisted.
+var ErrResourceVersionExpired = errors.New("resource version expired")
+
+// Resource paths relative to the API server.
+const (
+	_nodes       = "nodes"
+	_pods        = "pods"
+	_deployments = "deployments"
+)
+
+// Watcher streams watch events until closed.
+type Watcher interface {
+	// Next blocks until the next event is received. Returns io.EOF once the
+	// API server closes the watch.
+	Next() (*WatchEvent, error)
+	Close() error
+}
+
+// KubeClient defines the Kubernetes API operations required by Controller.
+type KubeClient interface {
+	ListNodes() (*NodeList, error)
+	ListPods(namespace string) (*PodList, error)
+	ListDeployments(namespace string) (*DeploymentList, error)
+
+	// Watch watches resource, which is either "pods" or "deployments",
+	// starting after resourceVersion.
+	Watch(resource, namespace, resourceVersion string, timeout time.Duration) (Watcher, error)
+}
+
+type kubeClient struct {
+	addr  string
+	token string
+	tls   *tls.Config
+}
+
+// NewKubeClient creates a new KubeClient for the API server configured in
+// config.
+func NewKubeClient(config Config, tls *tls.Config) (KubeClient, error) {
+	if config.APIServer == "" {
+		return nil, errors.New("no api server configured")
+	}
+	var token string
+	if config.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(config.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read bearer token: %s", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	return &kubeClient{config.APIServer, token, tls}, nil
+}
+
+// resourcePath returns the path of resource in namespace. Nodes are cluster
+// scoped and deployments belong to the apps API group.
+func resourcePath(resource, namespace string) string {
+	prefix := "/api/v1"
+	if resource == _deployments {
+		prefix = "/apis/apps/v1"
+	}
+	if namespace == "" || resource == _nodes {
+		return fmt.Sprintf("%s/%s", prefix, resource)
+	}
+	return fmt.Sprintf("%s/namespaces/%s/%s", prefix, url.PathEscape(namespace), resource)
+}
+
+func (c *kubeClient) get(path string, timeout time.Duration) (*http.Response, error) {
+	headers := map[string]string{"Accept": "application/json"}
+	if c.token != "" {
+		headers["Authorization"] = "Bearer " + c.token
+	}
+	return httputil.Get(
+		fmt.Sprintf("http://%s%s", c.addr, path),
+		httputil.SendHeaders(headers),
+		httputil.SendTimeout(timeout),
+		httputil.SendTLS(c.tls))
+}
+
+func (c *kubeClient) list(resource, namespace string, result interface{}) error {
+	resp, err := c.get(resourcePath(resource, namespace), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("decode %s: %s", resource, err)
+	}
+	return nil
+}
+
+func (c *kubeClient) ListNodes() (*NodeList, error) {
+	var l NodeList
+	if err := c.list(_nodes, "", &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *kubeClient) ListPods(namespace string) (*PodList, error) {
+	var l PodList
+	if err := c.list(_pods, namespace, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *kubeClient) ListDeployments(namespace string) (*DeploymentList, error) {
+	var l DeploymentList
+	if err := c.list(_deployments, namespace, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *kubeClient) Watch(
+	resource, namespace, resourceVersion string, timeout time.Duration) (Watcher, error) {
+
+	q := url.Values{}
+	q.Set("watch", "true")
+	q.Set("resourceVersion", resourceVersion)
+	q.Set("timeoutSeconds", fmt.Sprintf("%d", int(timeout.Seconds())))
+
+	// The client timeout is padded so the API server closes the watch first.
+	resp, err := c.get(resourcePath(resource, namespace)+"?"+q.Encode(), timeout+30*time.Second)
+	if err != nil {
+		if httputil.IsStatus(err, http.StatusGone) {
+			return nil, ErrResourceVersionExpired
+		}
+		return nil, err
+	}
+	return &watcher{resp.Body, json.NewDecoder(resp.Body)}, nil
+}
+
+type watcher struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+func (w *watcher) Next() (*WatchEvent, error) {
+	var e WatchEvent
+	if err := w.dec.Decode(&e); err != nil {
+		return nil, err
+	}
+	if e.Type == EventError {
+		var s Status
+		if err := json.Unmarshal(e.Object, &s); err != nil {
+			return nil, fmt.Errorf("decode status: %s", err)
+		}
+		if s.Code == http.StatusGone {
+			return nil, ErrResourceVersionExpired
+		}
+		return nil, fmt.Errorf("watch error %d: %s", s.Code, s.Message)
+	}
+	return &e, nil
+}
+
+func (w *watcher) Close() error {
+	return w.body.Close()
+}
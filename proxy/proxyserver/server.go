@@ -14,6 +14,7 @@
 package proxyserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" // Registers /debug/pprof endpoints in http.DefaultServeMux.
@@ -22,6 +23,7 @@ import (
 	"github.com/uber-go/tally"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/proxy/k8spreheat"
 	"github.com/uber/kraken/utils/handler"
 )
 
@@ -29,16 +31,20 @@ import (
 type Server struct {
 	stats          tally.Scope
 	preheatHandler *PreheatHandler
+	rollouts       *k8spreheat.Controller
 }
 
-// New creates a new Server.
+// New creates a new Server. rollouts may be nil if Kubernetes preheating is
+// disabled.
 func New(
 	stats tally.Scope,
-	client blobclient.ClusterClient) *Server {
+	client blobclient.ClusterClient,
+	rollouts *k8spreheat.Controller) *Server {
 
 	return &Server{
 		stats.Tagged(map[string]string{"module": "proxyserver"}),
-		NewPreheatHandler(client)}
+		NewPreheatHandler(client),
+		rollouts}
 }
 
 // Handler returns the HTTP handler.
@@ -52,6 +58,9 @@ func (s *Server) Handler() http.Handler {
 
 	r.Post("/registry/notifications", handler.Wrap(s.preheatHandler.Handle))
 
+	r.Get("/preheat/rollouts", handler.Wrap(s.listRolloutsHandler))
+	r.Get("/preheat/rollouts/{kind}/{namespace}/{name}", handler.Wrap(s.getRolloutHandler))
+
 	// Serves /debug/pprof endpoints.
 	r.Mount("/", http.DefaultServeMux)
 
@@ -62,3 +71,33 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) error {
 	fmt.Fprintln(w, "OK")
 	return nil
 }
+
+// listRolloutsHandler returns the per-node preheat status of all rollouts
+// tracked by the Kubernetes preheat controller.
+func (s *Server) listRolloutsHandler(w http.ResponseWriter, r *http.Request) error {
+	if s.rollouts == nil {
+		return handler.ErrorStatus(http.StatusNotFound)
+	}
+	if err := json.NewEncoder(w).Encode(s.rollouts.Rollouts()); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+// getRolloutHandler returns the per-node preheat status of a single rollout.
+func (s *Server) getRolloutHandler(w http.ResponseWriter, r *http.Request) error {
+	if s.rollouts == nil {
+		return handler.ErrorStatus(http.StatusNotFound)
+	}
+	status, err := s.rollouts.Rollout(
+		chi.URLParam(r, "kind"), chi.URLParam(r, "namespace"), chi.URLParam(r, "name"))
+	if err == k8spreheat.ErrRolloutNotFound {
+		return handler.ErrorStatus(http.StatusNotFound)
+	} else if err != nil {
+		return handler.Errorf("get rollout: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
@@ -26,8 +26,11 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/proxy/k8spreheat"
 	"github.com/uber/kraken/utils/mockutil"
 )
 
@@ -142,3 +145,38 @@ func TestPreheat(t *testing.T) {
 		httputil.SendBody(bytes.NewReader(b)))
 	require.NoError(err)
 }
+
+func TestRolloutsDisabled(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/preheat/rollouts", addr))
+	require.True(httputil.IsNotFound(err))
+}
+
+func TestRollouts(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	mocks.rollouts = k8spreheat.New(
+		k8spreheat.Config{}, tally.NoopScope, clock.New(), nil, nil, nil)
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/preheat/rollouts", addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+	var rollouts []*k8spreheat.RolloutStatus
+	require.NoError(json.NewDecoder(resp.Body).Decode(&rollouts))
+	require.Empty(rollouts)
+
+	_, err = httputil.Get(
+		fmt.Sprintf("http://%s/preheat/rollouts/deployment/default/app", addr))
+	require.True(httputil.IsNotFound(err))
+}
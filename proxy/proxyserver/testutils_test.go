@@ -20,11 +20,13 @@ import (
 	"github.com/uber-go/tally"
 
 	mockblobclient "github.com/uber/kraken/mocks/origin/blobclient"
+	"github.com/uber/kraken/proxy/k8spreheat"
 	"github.com/uber/kraken/utils/testutil"
 )
 
 type serverMocks struct {
 	originClient *mockblobclient.MockClusterClient
+	rollouts     *k8spreheat.Controller
 	cleanup      *testutil.Cleanup
 }
 
@@ -44,7 +46,7 @@ func newServerMocks(t *testing.T) (*serverMocks, func()) {
 }
 
 func (m *serverMocks) startServer() string {
-	s := New(tally.NoopScope, m.originClient)
+	s := New(tally.NoopScope, m.originClient, m.rollouts)
 	addr, stop := testutil.StartServer(s.Handler())
 	m.cleanup.Add(stop)
 	return addr
@@ -19,11 +19,14 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/pressly/chi"
 	"github.com/uber/kraken/build-index/tagclient"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/listener"
 	"github.com/uber/kraken/utils/log"
@@ -45,6 +48,7 @@ func NewServer(config Config, tagClient tagclient.Client) *Server {
 func (s *Server) Handler() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/v2/_catalog", handler.Wrap(s.catalogHandler))
+	r.Get("/v2/*", handler.Wrap(s.referrersHandler))
 	return r
 }
 
@@ -138,3 +142,36 @@ func (s *Server) catalogHandler(w http.ResponseWriter, r *http.Request) error {
 	}
 	return nil
 }
+
+var _referrersPath = regexp.MustCompile("^/v2/(.+)/referrers/([^/]+)$")
+
+// referrersHandler handles the OCI referrers API, which lists the manifests
+// whose subject is the given digest. Subjects without referrers produce an
+// empty index.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#listing-referrers
+func (s *Server) referrersHandler(w http.ResponseWriter, r *http.Request) error {
+	matches := _referrersPath.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		return handler.ErrorStatus(http.StatusNotFound)
+	}
+	repo := matches[1]
+	subject, err := core.ParseSHA256Digest(matches[2])
+	if err != nil {
+		return handler.Errorf("parse digest: %s", err).Status(http.StatusBadRequest)
+	}
+	artifactType := r.URL.Query().Get("artifactType")
+
+	index, err := s.tagClient.GetReferrers(repo, subject, artifactType)
+	if err != nil {
+		return handler.Errorf("get referrers: %s", err)
+	}
+
+	w.Header().Set("Content-Type", dockerutil.MediaTypeImageIndex)
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	if err := json.NewEncoder(w).Encode(index); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package registryoverride
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/mocks/build-index/tagclient"
+	"github.com/uber/kraken/utils/dockerutil"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferrersHandler(t *testing.T) {
+	repo := "some/repo"
+	subject := core.DigestFixture()
+	sbomDigest, sbom := dockerutil.ReferrerManifestFixture(subject, "application/spdx+json")
+	_, desc, _, err := dockerutil.ParseReferrer(sbomDigest, sbom)
+	require.NoError(t, err)
+	index := dockerutil.NewImageIndex()
+	index.Add(desc)
+
+	tests := []struct {
+		desc           string
+		query          string
+		artifactType   string
+		filtersApplied string
+	}{
+		{"unfiltered", "", "", ""},
+		{
+			"filtered",
+			"?artifactType=application/spdx%2Bjson",
+			"application/spdx+json",
+			"artifactType",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			tagClient := mocktagclient.NewMockClient(ctrl)
+
+			addr, stop := testutil.StartServer(NewServer(Config{}, tagClient).Handler())
+			defer stop()
+
+			tagClient.EXPECT().GetReferrers(repo, subject, test.artifactType).Return(index, nil)
+
+			resp, err := httputil.Get(fmt.Sprintf(
+				"http://%s/v2/%s/referrers/%s%s", addr, repo, subject, test.query))
+			require.NoError(err)
+			defer resp.Body.Close()
+			require.Equal(dockerutil.MediaTypeImageIndex, resp.Header.Get("Content-Type"))
+			require.Equal(test.filtersApplied, resp.Header.Get("OCI-Filters-Applied"))
+
+			var result dockerutil.ImageIndex
+			require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+			require.Equal(index, &result)
+		})
+	}
+}
+
+func TestReferrersHandlerInvalidPath(t *testing.T) {
+	require := require.New(t)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	addr, stop := testutil.StartServer(
+		NewServer(Config{}, mocktagclient.NewMockClient(ctrl)).Handler())
+	defer stop()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/v2/some/repo/tags/list", addr))
+	require.True(httputil.IsNotFound(err))
+
+	_, err = httputil.Get(fmt.Sprintf("http://%s/v2/some/repo/referrers/foo", addr))
+	require.Equal(http.StatusBadRequest, err.(httputil.StatusError).Status)
+}
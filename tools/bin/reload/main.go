@@ -18,7 +18,9 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -87,7 +89,7 @@ func main() {
 		wg.Add(1)
 		go func(host string) {
 			defer wg.Done()
-			addr := fmt.Sprintf("%s:%d", host, *port)
+			addr := net.JoinHostPort(host, strconv.Itoa(*port))
 			if err := reload(addr, config.Scheduler); err != nil {
 				errs <- err
 			}
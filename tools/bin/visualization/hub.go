@@ -0,0 +1,216 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
+)
+
+// graphEvents are the events which the visualization renders.
+var graphEvents = []networkevent.Name{
+	networkevent.AddTorrent,
+	networkevent.AddActiveConn,
+	networkevent.DropActiveConn,
+	networkevent.BlacklistConn,
+	networkevent.ReceivePiece,
+	networkevent.TorrentComplete,
+	networkevent.TorrentCancelled,
+}
+
+// subscriberBuffer is the number of events buffered per live subscriber.
+// Subscribers which fall further behind are disconnected.
+const subscriberBuffer = 4096
+
+type subscriber chan *networkevent.Event
+
+type torrentStream struct {
+	history   []*networkevent.Event
+	truncated bool
+	lastEvent time.Time
+
+	// lastActive is when the stream last received an event or lost its last
+	// subscriber. Unlike lastEvent, it is not affected by replayed event times.
+	lastActive time.Time
+}
+
+// torrentSummary describes a torrent with live events.
+type torrentSummary struct {
+	Torrent   string    `json:"torrent"`
+	NumEvents int       `json:"num_events"`
+	LastEvent time.Time `json:"last_event"`
+}
+
+// hub fans out live events to subscribers of their torrent. Events of each
+// torrent are recorded, such that new subscribers can rebuild the graph.
+// Recorded events are dropped once a torrent has had neither events nor
+// subscribers for ttl.
+type hub struct {
+	sync.Mutex
+	clk         clock.Clock
+	maxHistory  int
+	ttl         time.Duration
+	torrents    map[string]*torrentStream
+	subscribers map[string]map[subscriber]bool
+}
+
+func newHub(clk clock.Clock, maxHistory int, ttl time.Duration) *hub {
+	return &hub{
+		clk:         clk,
+		maxHistory:  maxHistory,
+		ttl:         ttl,
+		torrents:    make(map[string]*torrentStream),
+		subscribers: make(map[string]map[subscriber]bool),
+	}
+}
+
+// publish records e and sends it to all subscribers of its torrent.
+func (h *hub) publish(e *networkevent.Event) {
+	if len(networkevent.Filter([]*networkevent.Event{e}, graphEvents...)) == 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	s, ok := h.torrents[e.Torrent]
+	if !ok {
+		s = &torrentStream{}
+		h.torrents[e.Torrent] = s
+	}
+	s.lastActive = h.clk.Now()
+	if len(s.history) < h.maxHistory {
+		s.history = append(s.history, e)
+	} else if !s.truncated {
+		log.Printf("Torrent %s exceeded %d events, no longer recording history\n",
+			e.Torrent, h.maxHistory)
+		s.truncated = true
+	}
+	if e.Time.After(s.lastEvent) {
+		s.lastEvent = e.Time
+	}
+	for sub := range h.subscribers[e.Torrent] {
+		select {
+		case sub <- e:
+		default:
+			log.Printf("Disconnecting slow subscriber of torrent %s\n", e.Torrent)
+			h.removeSubscriber(e.Torrent, sub)
+		}
+	}
+}
+
+// removeSubscriber closes sub and removes it from torrent. Must be called
+// with the lock held.
+func (h *hub) removeSubscriber(torrent string, sub subscriber) {
+	subs := h.subscribers[torrent]
+	if !subs[sub] {
+		return
+	}
+	delete(subs, sub)
+	close(sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, torrent)
+		if s, ok := h.torrents[torrent]; ok {
+			s.lastActive = h.clk.Now()
+		}
+	}
+}
+
+// subscribe returns the recorded events of torrent, and a channel of its
+// subsequent events. The channel is closed if the subscriber falls behind, or
+// once unsubscribe is called. Subscribing to a torrent without events does not
+// record anything for it until its first event.
+func (h *hub) subscribe(torrent string) (
+	history []*networkevent.Event, events subscriber, unsubscribe func()) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	if s, ok := h.torrents[torrent]; ok {
+		history = make([]*networkevent.Event, len(s.history))
+		copy(history, s.history)
+		networkevent.Sort(history)
+	}
+
+	events = make(subscriber, subscriberBuffer)
+	subs, ok := h.subscribers[torrent]
+	if !ok {
+		subs = make(map[subscriber]bool)
+		h.subscribers[torrent] = subs
+	}
+	subs[events] = true
+
+	unsubscribe = func() {
+		h.Lock()
+		defer h.Unlock()
+
+		h.removeSubscriber(torrent, events)
+	}
+	return history, events, unsubscribe
+}
+
+// evictIdle drops the recorded events of torrents without subscribers which
+// have been idle for longer than the ttl.
+func (h *hub) evictIdle() {
+	h.Lock()
+	defer h.Unlock()
+
+	now := h.clk.Now()
+	for torrent, s := range h.torrents {
+		if len(h.subscribers[torrent]) == 0 && now.Sub(s.lastActive) > h.ttl {
+			delete(h.torrents, torrent)
+		}
+	}
+}
+
+// evictIdleLoop runs evictIdle periodically, forever.
+func (h *hub) evictIdleLoop() {
+	for range h.clk.Tick(h.ttl / 2) {
+		h.evictIdle()
+	}
+}
+
+// list returns the torrents with recorded events, most recently active first.
+func (h *hub) list() []torrentSummary {
+	h.Lock()
+	defer h.Unlock()
+
+	var result []torrentSummary
+	for torrent, s := range h.torrents {
+		result = append(result, torrentSummary{torrent, len(s.history), s.lastEvent})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastEvent.After(result[j].LastEvent)
+	})
+	return result
+}
+
+// replay publishes recorded events to h, preserving the delays between them
+// scaled down by speed. Events are published without delay if speed is not
+// positive.
+func replay(h *hub, events []*networkevent.Event, speed float64) {
+	for i, e := range events {
+		if i > 0 && speed > 0 {
+			d := e.Time.Sub(events[i-1].Time)
+			time.Sleep(time.Duration(float64(d) / speed))
+		}
+		h.publish(e)
+	}
+}
@@ -19,15 +19,44 @@ import (
 	"net/http"
 
 	"github.com/alecthomas/kingpin"
+	"github.com/andres-erbsen/clock"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
 )
 
 func main() {
-	eventFile := kingpin.Arg("events", "Network event file").Required().File()
+	eventFile := kingpin.Arg(
+		"events", "Network event file. Optional if events are collected live").File()
+	host := kingpin.Flag("host", "listening host").Default("localhost").String()
 	port := kingpin.Flag("port", "listening port").Default("3000").Int()
+	replayEvents := kingpin.Flag(
+		"replay", "replay the event file as live events instead of serving it in full").Bool()
+	replaySpeed := kingpin.Flag(
+		"replay-speed", "replay speed multiplier, where 0 replays without delays").
+		Default("1").Float64()
+	maxHistory := kingpin.Flag(
+		"max-history", "maximum number of live events recorded per torrent").
+		Default("1000000").Int()
+	streamTTL := kingpin.Flag(
+		"stream-ttl", "duration after which live events of idle, unwatched torrents are dropped").
+		Default("1h").Duration()
 	kingpin.Parse()
 
-	s := newServer(*eventFile)
-	addr := fmt.Sprintf("localhost:%d", *port)
+	h := newHub(clock.New(), *maxHistory, *streamTTL)
+	go h.evictIdleLoop()
+
+	var events []*networkevent.Event
+	if *eventFile != nil {
+		events = readEvents(*eventFile)
+		(*eventFile).Close()
+	}
+	if *replayEvents {
+		go replay(h, events, *replaySpeed)
+		events = nil
+	}
+
+	s := newServer(events, h)
+	addr := fmt.Sprintf("%s:%d", *host, *port)
 	log.Printf("Listening on %s ...", addr)
 	log.Fatal(http.ListenAndServe(addr, s.handler()))
 }
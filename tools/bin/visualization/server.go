@@ -17,23 +17,35 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
 
 	"github.com/uber/kraken/lib/torrent/networkevent"
 )
 
 type server struct {
+	// Events loaded from an event file, served in full by /events.
 	events []*networkevent.Event
+
+	// Live events, streamed by /live.
+	hub *hub
+}
+
+func newServer(events []*networkevent.Event, h *hub) *server {
+	return &server{events, h}
 }
 
-func newServer(eventFile *os.File) *server {
+// readEvents reads the events rendered by the visualization from a network
+// event log, sorted by time.
+func readEvents(r io.Reader) []*networkevent.Event {
 	var events []*networkevent.Event
 
-	scanner := bufio.NewScanner(eventFile)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		var event networkevent.Event
@@ -43,18 +55,10 @@ func newServer(eventFile *os.File) *server {
 		}
 		events = append(events, &event)
 	}
-	events = networkevent.Filter(
-		events,
-		networkevent.AddTorrent,
-		networkevent.AddActiveConn,
-		networkevent.DropActiveConn,
-		networkevent.BlacklistConn,
-		networkevent.ReceivePiece,
-		networkevent.TorrentComplete,
-		networkevent.TorrentCancelled)
+	events = networkevent.Filter(events, graphEvents...)
 	networkevent.Sort(events)
 
-	return &server{events}
+	return events
 }
 
 func (s *server) handler() http.Handler {
@@ -69,6 +73,10 @@ func (s *server) handler() http.Handler {
 
 	r.HandleFunc("/events", s.getEvents)
 
+	r.HandleFunc("/torrents", s.getTorrents)
+	r.Handle(networkevent.CollectPath, websocket.Handler(s.collect))
+	r.Handle("/live/{torrent}", websocket.Handler(s.live))
+
 	return r
 }
 
@@ -80,6 +88,62 @@ func (s *server) getEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *server) getTorrents(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(s.hub.list()); err != nil {
+		log.Printf("Error encoding torrents: %s\n", err)
+		http.Error(w, fmt.Sprintf("encode torrents: %s", err), 500)
+		return
+	}
+}
+
+// collect receives events streamed by agents and origins.
+func (s *server) collect(conn *websocket.Conn) {
+	for {
+		var event networkevent.Event
+		if err := websocket.JSON.Receive(conn, &event); err != nil {
+			if err != io.EOF {
+				log.Printf("Error receiving event from %s: %s\n", conn.Request().RemoteAddr, err)
+			}
+			return
+		}
+		s.hub.publish(&event)
+	}
+}
+
+// live streams the events of a torrent, starting with all recorded events.
+func (s *server) live(conn *websocket.Conn) {
+	torrent := mux.Vars(conn.Request())["torrent"]
+
+	history, events, unsubscribe := s.hub.subscribe(torrent)
+	defer unsubscribe()
+
+	// Detect closed connections, since the client never sends.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+
+	for _, event := range history {
+		if err := websocket.JSON.Send(conn, event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(conn, event); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
 type byTime []networkevent.Event
 
 func (s byTime) Len() int           { return len(s) }
@@ -0,0 +1,230 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/willf/bitset"
+	"golang.org/x/net/websocket"
+)
+
+// eventLogFixture returns a recorded event log of two peers downloading a
+// torrent, and the events the visualization renders from it.
+func eventLogFixture(t *testing.T, h core.InfoHash) ([]byte, []*networkevent.Event) {
+	seeder := core.PeerIDFixture()
+	leecher := core.PeerIDFixture()
+
+	start := time.Now().Add(-time.Minute)
+	events := []*networkevent.Event{
+		networkevent.AddTorrentEvent(h, seeder, bitset.New(2).Set(0).Set(1), 10),
+		networkevent.AddTorrentEvent(h, leecher, bitset.New(2), 10),
+		networkevent.AddActiveConnEvent(h, leecher, seeder),
+		networkevent.RequestPieceEvent(h, leecher, seeder, 0),
+		networkevent.ReceivePieceEvent(h, leecher, seeder, 0),
+		networkevent.ReceivePieceEvent(h, leecher, seeder, 1),
+		networkevent.TorrentCompleteEvent(h, leecher),
+	}
+	var b bytes.Buffer
+	for i, e := range events {
+		e.Time = start.Add(time.Duration(i) * time.Millisecond)
+		require.NoError(t, json.NewEncoder(&b).Encode(e))
+	}
+	// Piece requests are not rendered.
+	return b.Bytes(), networkevent.Filter(events, graphEvents...)
+}
+
+func dialLive(t *testing.T, addr string, h core.InfoHash) *websocket.Conn {
+	conn, err := websocket.Dial(
+		fmt.Sprintf("ws://%s/live/%s", addr, h), "", "http://localhost/")
+	require.NoError(t, err)
+	return conn
+}
+
+func receiveEvents(t *testing.T, conn *websocket.Conn, n int) []*networkevent.Event {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var events []*networkevent.Event
+	for i := 0; i < n; i++ {
+		e := new(networkevent.Event)
+		require.NoError(t, websocket.JSON.Receive(conn, e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func checkEvents(t *testing.T, expected, result []*networkevent.Event) {
+	t.Helper()
+
+	require.Equal(t, len(expected), len(result))
+	for i := range expected {
+		require.Equal(t, expected[i].Name, result[i].Name)
+		require.Equal(t, expected[i].Self, result[i].Self)
+		require.Equal(t, expected[i].Peer, result[i].Peer)
+		require.Equal(t, expected[i].Piece, result[i].Piece)
+		require.True(t, expected[i].Time.Equal(result[i].Time))
+	}
+}
+
+func TestLiveReplaysRecordedLog(t *testing.T) {
+	h := core.InfoHashFixture()
+	log, expected := eventLogFixture(t, h)
+
+	hub := newHub(clock.New(), 1000, time.Hour)
+	addr, stop := testutil.StartServer(newServer(nil, hub).handler())
+	defer stop()
+
+	// Subscribe before the replay, such that all events are streamed live.
+	conn := dialLive(t, addr, h)
+	defer conn.Close()
+
+	// Events of other torrents are not streamed.
+	otherLog, _ := eventLogFixture(t, core.InfoHashFixture())
+
+	go replay(hub, readEvents(bytes.NewReader(append(log, otherLog...))), 0)
+
+	checkEvents(t, expected, receiveEvents(t, conn, len(expected)))
+}
+
+func TestLiveSendsRecordedEventsToNewSubscribers(t *testing.T) {
+	h := core.InfoHashFixture()
+	log, expected := eventLogFixture(t, h)
+
+	hub := newHub(clock.New(), 1000, time.Hour)
+	replay(hub, readEvents(bytes.NewReader(log)), 0)
+
+	addr, stop := testutil.StartServer(newServer(nil, hub).handler())
+	defer stop()
+
+	conn := dialLive(t, addr, h)
+	defer conn.Close()
+
+	checkEvents(t, expected, receiveEvents(t, conn, len(expected)))
+}
+
+func TestCollect(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	_, expected := eventLogFixture(t, h)
+
+	hub := newHub(clock.New(), 1000, time.Hour)
+	addr, stop := testutil.StartServer(newServer(nil, hub).handler())
+	defer stop()
+
+	live := dialLive(t, addr, h)
+	defer live.Close()
+
+	// Agents stream events to the collect endpoint.
+	p, err := networkevent.NewProducer(networkevent.Config{
+		Collector: networkevent.CollectorConfig{Addr: addr},
+	})
+	require.NoError(err)
+	defer p.Close()
+
+	for _, e := range expected {
+		p.Produce(e)
+	}
+
+	checkEvents(t, expected, receiveEvents(t, live, len(expected)))
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/torrents", addr))
+	require.NoError(err)
+	defer resp.Body.Close()
+	var torrents []torrentSummary
+	require.NoError(json.NewDecoder(resp.Body).Decode(&torrents))
+	require.Len(torrents, 1)
+	require.Equal(h.String(), torrents[0].Torrent)
+	require.Equal(len(expected), torrents[0].NumEvents)
+}
+
+func TestHubDisconnectsSlowSubscribers(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	hub := newHub(clock.New(), 1000, time.Hour)
+
+	_, events, unsubscribe := hub.subscribe(h.String())
+	defer unsubscribe()
+
+	e := networkevent.TorrentCompleteEvent(h, core.PeerIDFixture())
+	for i := 0; i < subscriberBuffer+1; i++ {
+		hub.publish(e)
+	}
+	for i := 0; i < subscriberBuffer; i++ {
+		<-events
+	}
+	_, ok := <-events
+	require.False(ok)
+}
+
+func TestHubSubscribeDoesNotRecordTorrent(t *testing.T) {
+	require := require.New(t)
+
+	hub := newHub(clock.New(), 1000, time.Hour)
+
+	history, _, unsubscribe := hub.subscribe(core.InfoHashFixture().String())
+	require.Empty(history)
+	require.Empty(hub.list())
+
+	unsubscribe()
+	require.Empty(hub.torrents)
+	require.Empty(hub.subscribers)
+}
+
+func TestHubEvictsIdleTorrentsWithoutSubscribers(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	hub := newHub(clk, 1000, time.Hour)
+
+	watched := core.InfoHashFixture()
+	unwatched := core.InfoHashFixture()
+	for _, h := range []core.InfoHash{watched, unwatched} {
+		hub.publish(networkevent.TorrentCompleteEvent(h, core.PeerIDFixture()))
+	}
+	_, _, unsubscribe := hub.subscribe(watched.String())
+
+	clk.Add(30 * time.Minute)
+	hub.evictIdle()
+	require.Len(hub.list(), 2)
+
+	clk.Add(time.Hour)
+	hub.evictIdle()
+	torrents := hub.list()
+	require.Len(torrents, 1)
+	require.Equal(watched.String(), torrents[0].Torrent)
+
+	// The ttl restarts once the last subscriber leaves.
+	unsubscribe()
+	clk.Add(30 * time.Minute)
+	hub.evictIdle()
+	require.Len(hub.list(), 1)
+
+	clk.Add(time.Hour)
+	hub.evictIdle()
+	require.Empty(hub.list())
+}
@@ -315,56 +315,55 @@ class Graph {
   }
 }
 
-d3.request('http://' + location.host + '/events').get(req => {
-  var events = JSON.parse(req.response);
-  var graph = new Graph(events[0].torrent, Date.parse(events[0].ts));
-
-  // Maps peer id to list of events which occurred before the peer was added
-  // to the graph. Early events are possible in cases where a connection is
-  // added before the torrent is opened, which is valid.
-  var earlyEvents = {};
-
-  function applyEvent(event) {
-    try {
-      switch (event.event) {
-        case 'add_torrent':
-          graph.addPeer(event.self, event.bitfield);
-          if (event.self in earlyEvents) {
-            earlyEvents[event.self].forEach(e => applyEvent(e))
-          }
-          break;
-        case 'add_active_conn':
-          graph.addActiveConn(event.self, event.peer);
-          break;
-        case 'drop_active_conn':
-          graph.removeActiveConn(event.self, event.peer);
-          break;
-        case 'receive_piece':
-          graph.receivePiece(event.self, event.piece);
-          break;
-        case 'torrent_complete':
-          graph.completePeer(event.self);
-          break;
-        case 'blacklist_conn':
-          graph.blacklistConn(event.self, event.peer, parseInt(event.duration_ms));
-          break;
-      }
-    } catch (err) {
-      if (err.message == 'not found') {
-        if (!(err.peer in earlyEvents)) {
-          earlyEvents[err.peer] = [];
+// Applies event to graph. Maps peer id to list of events which occurred before
+// the peer was added to the graph. Early events are possible in cases where a
+// connection is added before the torrent is opened, which is valid.
+function applyEvent(graph, earlyEvents, event) {
+  try {
+    switch (event.event) {
+      case 'add_torrent':
+        graph.addPeer(event.self, event.bitfield);
+        if (event.self in earlyEvents) {
+          earlyEvents[event.self].forEach(e => applyEvent(graph, earlyEvents, e))
         }
-        earlyEvents[err.peer].push(event);
-      } else {
-        console.log('unhandled error: ' + err);
+        break;
+      case 'add_active_conn':
+        graph.addActiveConn(event.self, event.peer);
+        break;
+      case 'drop_active_conn':
+        graph.removeActiveConn(event.self, event.peer);
+        break;
+      case 'receive_piece':
+        graph.receivePiece(event.self, event.piece);
+        break;
+      case 'torrent_complete':
+        graph.completePeer(event.self);
+        break;
+      case 'blacklist_conn':
+        graph.blacklistConn(event.self, event.peer, parseInt(event.duration_ms));
+        break;
+    }
+  } catch (err) {
+    if (err.message == 'not found') {
+      if (!(err.peer in earlyEvents)) {
+        earlyEvents[err.peer] = [];
       }
+      earlyEvents[err.peer].push(event);
+    } else {
+      console.log('unhandled error: ' + err);
     }
   }
+}
 
-  // Every interval seconds, we read all events that occur within that interval
-  // and apply them to the graph. This gives the illusion of events occuring in
-  // real-time.
-  const interval = 100;
+// Every interval milliseconds, the graph is updated with new events.
+const interval = 100;
+
+// Replays recorded events. Every interval, we read all events that occur within
+// that interval and apply them to the graph. This gives the illusion of events
+// occuring in real-time.
+function replayEvents(events) {
+  var graph = new Graph(events[0].torrent, Date.parse(events[0].ts));
+  var earlyEvents = {};
 
   function readEvents(i, until) {
     if (i >= events.length) {
@@ -372,7 +371,7 @@ d3.request('http://' + location.host + '/events').get(req => {
     }
     graph.setTime(until);
     while (i < events.length && Date.parse(events[i].ts) < until) {
-      applyEvent(events[i]);
+      applyEvent(graph, earlyEvents, events[i]);
       i++;
     }
     graph.update();
@@ -380,4 +379,63 @@ d3.request('http://' + location.host + '/events').get(req => {
   }
 
   readEvents(0, Date.parse(events[0].ts) + interval);
-});
+}
+
+// Streams live events of torrent. The server first sends all recorded events
+// of the torrent, followed by events as they are collected.
+function streamEvents(torrent) {
+  var graph = null;
+  var earlyEvents = {};
+  var pending = [];
+
+  var socket = new WebSocket('ws://' + location.host + '/live/' + torrent);
+  socket.onmessage = msg => pending.push(JSON.parse(msg.data));
+  socket.onclose = () => console.log('live event stream closed');
+
+  function readEvents() {
+    if (pending.length > 0) {
+      if (graph == null) {
+        graph = new Graph(torrent, Date.parse(pending[0].ts));
+      }
+      pending.forEach(e => applyEvent(graph, earlyEvents, e));
+      graph.setTime(Date.parse(pending[pending.length - 1].ts));
+      pending = [];
+      graph.update();
+    }
+    setTimeout(readEvents, interval);
+  }
+
+  readEvents();
+}
+
+// Lists torrents with live events.
+function listTorrents() {
+  d3.request('http://' + location.host + '/torrents').get(req => {
+    var torrents = JSON.parse(req.response) || [];
+    var list = d3.select('#graph').append('div').attr('class', 'header');
+    list.append('p').append('pre').text(
+      torrents.length > 0 ? 'Live torrents:' : 'No live torrents yet, refresh to update.');
+    list.selectAll('.torrent')
+      .data(torrents)
+      .enter()
+      .append('p')
+      .attr('class', 'torrent')
+      .append('a')
+      .attr('href', t => '?torrent=' + t.torrent)
+      .text(t => t.torrent + ' (' + t.num_events + ' events)');
+  });
+}
+
+var liveTorrent = new URLSearchParams(location.search).get('torrent');
+if (liveTorrent) {
+  streamEvents(liveTorrent);
+} else {
+  d3.request('http://' + location.host + '/events').get(req => {
+    var events = JSON.parse(req.response);
+    if (!events || events.length == 0) {
+      listTorrents();
+      return;
+    }
+    replayEvents(events);
+  });
+}
@@ -19,12 +19,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/hashring"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
 )
 
 // ErrDisabled is returned when announce is disabled.
@@ -36,6 +39,10 @@ type Request struct {
 	Digest   *core.Digest   `json:"digest"` // Optional (for now).
 	InfoHash core.InfoHash  `json:"info_hash"`
 	Peer     *core.PeerInfo `json:"peer"`
+
+	// Exclude lists peers which must be left out of the handout, such as peers
+	// the announcing peer is already connected to.
+	Exclude []core.PeerID `json:"exclude,omitempty"`
 }
 
 // GetDigest is a backwards compatible accessor of the request digest.
@@ -54,6 +61,10 @@ func (r *Request) GetDigest() (core.Digest, error) {
 type Response struct {
 	Peers    []*core.PeerInfo `json:"peers"`
 	Interval time.Duration    `json:"interval"`
+
+	// ObservedIP is the source address of the announce as seen by the tracker.
+	// It differs from the announced addresses of peers behind a NAT.
+	ObservedIP string `json:"observed_ip,omitempty"`
 }
 
 // Client defines a client for announcing and getting peers.
@@ -62,18 +73,79 @@ type Client interface {
 		d core.Digest,
 		h core.InfoHash,
 		complete bool,
-		version int) ([]*core.PeerInfo, time.Duration, error)
+		version int,
+		exclude []core.PeerID) ([]*core.PeerInfo, time.Duration, error)
 }
 
 type client struct {
+	ring          hashring.PassiveRing
+	tls           *tls.Config
+	useObservedIP bool
+
+	mu   sync.Mutex // Protects pctx.
 	pctx core.PeerContext
-	ring hashring.PassiveRing
-	tls  *tls.Config
+}
+
+// Option allows setting optional client parameters.
+type Option func(*client)
+
+// WithObservedIP configures the client to announce the address observed by the
+// tracker in place of its own, which is necessary for peers behind a NAT.
+func WithObservedIP() Option {
+	return func(c *client) { c.useObservedIP = true }
 }
 
 // New creates a new client.
-func New(pctx core.PeerContext, ring hashring.PassiveRing, tls *tls.Config) Client {
-	return &client{pctx, ring, tls}
+func New(
+	pctx core.PeerContext,
+	ring hashring.PassiveRing,
+	tls *tls.Config,
+	opts ...Option) Client {
+
+	c := &client{ring: ring, tls: tls, pctx: pctx}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *client) peerInfo(complete bool) *core.PeerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return core.PeerInfoFromContext(c.pctx, complete)
+}
+
+// adoptObservedIP replaces the announced address in the IP family of observed,
+// or adds observed as the secondary address if there is none.
+func (c *client) adoptObservedIP(observed string) {
+	ip := net.ParseIP(observed)
+	if ip == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if core.PeerInfoFromContext(c.pctx, false).HasIP(observed) {
+		return
+	}
+	old := c.pctx.IP
+	switch {
+	case sameFamily(ip, c.pctx.IP) || net.ParseIP(c.pctx.IP) == nil:
+		c.pctx.IP = observed
+	case sameFamily(ip, c.pctx.SecondaryIP) || c.pctx.SecondaryIP == "":
+		old = c.pctx.SecondaryIP
+		c.pctx.SecondaryIP = observed
+	default:
+		return
+	}
+	log.With("old_ip", old, "observed_ip", observed).Info("Announcing observed ip")
+}
+
+func sameFamily(ip net.IP, addr string) bool {
+	other := net.ParseIP(addr)
+	return other != nil && (ip.To4() == nil) == (other.To4() == nil)
 }
 
 // Announce versionss.
@@ -91,18 +163,21 @@ func getEndpoint(version int, addr string, h core.InfoHash) (method, url string)
 
 // Announce announces the torrent identified by (d, h) with the number of
 // downloaded bytes. Returns a list of all other peers announcing for said torrent,
-// sorted by priority, and the interval for the next announce.
+// except those in exclude, sorted by priority, and the interval for the next
+// announce.
 func (c *client) Announce(
 	d core.Digest,
 	h core.InfoHash,
 	complete bool,
-	version int) (peers []*core.PeerInfo, interval time.Duration, err error) {
+	version int,
+	exclude []core.PeerID) (peers []*core.PeerInfo, interval time.Duration, err error) {
 
 	body, err := json.Marshal(&Request{
 		Name:     d.Hex(), // For backwards compatability. TODO(codyg): Remove.
 		Digest:   &d,
 		InfoHash: h,
-		Peer:     core.PeerInfoFromContext(c.pctx, complete),
+		Peer:     c.peerInfo(complete),
+		Exclude:  exclude,
 	})
 	if err != nil {
 		return nil, 0, fmt.Errorf("marshal request: %s", err)
@@ -128,6 +203,9 @@ func (c *client) Announce(
 		if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
 			return nil, 0, fmt.Errorf("decode response: %s", err)
 		}
+		if c.useObservedIP && resp.ObservedIP != "" {
+			c.adoptObservedIP(resp.ObservedIP)
+		}
 		return resp.Peers, resp.Interval, nil
 	}
 	return nil, 0, err
@@ -144,7 +222,11 @@ func Disabled() Client {
 
 // Announce always returns error.
 func (c DisabledClient) Announce(
-	d core.Digest, h core.InfoHash, complete bool, version int) ([]*core.PeerInfo, time.Duration, error) {
+	d core.Digest,
+	h core.InfoHash,
+	complete bool,
+	version int,
+	exclude []core.PeerID) ([]*core.PeerInfo, time.Duration, error) {
 
 	return nil, 0, ErrDisabled
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/prefetchstore"
 	"github.com/uber/kraken/tracker/trackerserver"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/log"
@@ -127,6 +128,12 @@ func Run(flags *Flags, opts ...Option) {
 	}
 	defer peerStore.Close()
 
+	prefetchStore, err := prefetchstore.New(config.PrefetchStore)
+	if err != nil {
+		log.Fatalf("Could not create PrefetchStore: %s", err)
+	}
+	defer prefetchStore.Close()
+
 	tls, err := config.TLS.BuildClient()
 	if err != nil {
 		log.Fatalf("Error building client tls config: %s", err)
@@ -149,7 +156,7 @@ func Run(flags *Flags, opts ...Option) {
 	originCluster := blobclient.NewClusterClient(r)
 
 	server := trackerserver.New(
-		config.TrackerServer, stats, policy, peerStore, originStore, originCluster)
+		config.TrackerServer, stats, policy, peerStore, originStore, prefetchStore, originCluster)
 	go func() {
 		log.Fatal(server.ListenAndServe())
 	}()
@@ -22,6 +22,7 @@ import (
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/prefetchstore"
 	"github.com/uber/kraken/tracker/trackerserver"
 	"github.com/uber/kraken/utils/httputil"
 )
@@ -31,6 +32,7 @@ type Config struct {
 	ZapLogging        zap.Config               `yaml:"zap"`
 	PeerStore         peerstore.Config         `yaml:"peerstore"`
 	OriginStore       originstore.Config       `yaml:"originstore"`
+	PrefetchStore     prefetchstore.Config     `yaml:"prefetchstore"`
 	TrackerServer     trackerserver.Config     `yaml:"trackerserver"`
 	PeerHandoutPolicy peerhandoutpolicy.Config `yaml:"peerhandoutpolicy"`
 	Origin            upstream.ActiveConfig    `yaml:"origin"`
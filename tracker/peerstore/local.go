@@ -54,11 +54,13 @@ type peerGroup struct {
 }
 
 type peerEntry struct {
-	id        core.PeerID
-	ip        string
-	port      int
-	complete  bool
-	expiresAt time.Time
+	id          core.PeerID
+	ip          string
+	secondaryIP string
+	addresses   []core.PeerAddress
+	port        int
+	complete    bool
+	expiresAt   time.Time
 }
 
 // NewLocalStore creates a new LocalStore.
@@ -110,7 +112,10 @@ func (s *LocalStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error)
 		// Note, we elect to return slightly expired entries rather than iterate
 		// until we find n valid entries.
 		e := g.peerList[i]
-		result = append(result, core.NewPeerInfo(e.id, e.ip, e.port, false /* origin */, e.complete))
+		p := core.NewPeerInfo(e.id, e.ip, e.port, false /* origin */, e.complete)
+		p.SecondaryIP = e.secondaryIP
+		p.Addresses = e.addresses
+		result = append(result, p)
 	}
 	return result, nil
 }
@@ -128,6 +133,8 @@ func (s *LocalStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	}
 	e.id = p.PeerID
 	e.ip = p.IP
+	e.secondaryIP = p.SecondaryIP
+	e.addresses = p.Addresses
 	e.port = p.Port
 	e.complete = p.Complete
 	e.expiresAt = s.clk.Now().Add(s.config.TTL)
@@ -143,3 +143,22 @@ func TestLocalStoreConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestLocalStoreMultiHomedPeer(t *testing.T) {
+	require := require.New(t)
+
+	s := NewLocalStore(LocalConfig{}, clock.New())
+	defer s.Close()
+
+	h := core.InfoHashFixture()
+
+	p := core.PeerInfoFixture()
+	p.IP = "2001:db8::1"
+	p.SecondaryIP = "10.0.0.1"
+	p.Addresses = []core.PeerAddress{{Network: "storage", IP: "192.168.0.1"}}
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+}
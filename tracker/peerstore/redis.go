@@ -31,36 +31,88 @@ func peerSetKey(h core.InfoHash, window int64) string {
 	return fmt.Sprintf("peerset:%s:%d", h.String(), window)
 }
 
+// serializePeer encodes p as "pid:ip:port:complete". The ip of dual-stack
+// peers is encoded as "ip,secondaryip", and the addresses of multi-homed peers
+// are appended as ",network=ip". Note, IPv6 addresses contain colons, so the
+// encoding must be parsed from both ends.
 func serializePeer(p *core.PeerInfo) string {
 	var completeBit int
 	if p.Complete {
 		completeBit = 1
 	}
-	return fmt.Sprintf("%s:%s:%d:%d", p.PeerID.String(), p.IP, p.Port, completeBit)
+	ip := p.IP
+	if p.SecondaryIP != "" {
+		ip += "," + p.SecondaryIP
+	}
+	for _, a := range p.Addresses {
+		ip += "," + a.Network + "=" + a.IP
+	}
+	return fmt.Sprintf("%s:%s:%d:%d", p.PeerID.String(), ip, p.Port, completeBit)
 }
 
 type peerIdentity struct {
-	peerID core.PeerID
-	ip     string
-	port   int
+	peerID      core.PeerID
+	ip          string
+	secondaryIP string
+	port        int
+
+	// networks holds the raw ",network=ip" addresses, such that peerIdentity
+	// remains comparable.
+	networks string
+}
+
+func (id peerIdentity) addresses() []core.PeerAddress {
+	var addrs []core.PeerAddress
+	for _, a := range strings.Split(id.networks, ",") {
+		if n := strings.Index(a, "="); n != -1 {
+			addrs = append(addrs, core.PeerAddress{Network: a[:n], IP: a[n+1:]})
+		}
+	}
+	return addrs
 }
 
 func deserializePeer(s string) (id peerIdentity, complete bool, err error) {
-	parts := strings.Split(s, ":")
-	if len(parts) != 4 {
-		return id, false, fmt.Errorf("invalid peer encoding: expected 'pid:ip:port:complete'")
+	errInvalid := fmt.Errorf("invalid peer encoding: expected 'pid:ip:port:complete'")
+
+	// Split "pid" and "complete" off of the ends.
+	i := strings.Index(s, ":")
+	j := strings.LastIndex(s, ":")
+	if i == -1 || i == j {
+		return id, false, errInvalid
 	}
-	peerID, err := core.NewPeerID(parts[0])
+	peerID, err := core.NewPeerID(s[:i])
 	if err != nil {
 		return id, false, fmt.Errorf("parse peer id: %s", err)
 	}
-	ip := parts[1]
-	port, err := strconv.Atoi(parts[2])
+	complete = s[j+1:] == "1"
+
+	// Split "port" off of the end of "ip:port".
+	addr := s[i+1 : j]
+	k := strings.LastIndex(addr, ":")
+	if k == -1 {
+		return id, false, errInvalid
+	}
+	port, err := strconv.Atoi(addr[k+1:])
 	if err != nil {
 		return id, false, fmt.Errorf("parse port: %s", err)
 	}
-	id = peerIdentity{peerID, ip, port}
-	complete = parts[3] == "1"
+	ip := addr[:k]
+	var networks string
+	if n := strings.Index(ip, "="); n != -1 {
+		n = strings.LastIndex(ip[:n], ",")
+		if n == -1 {
+			return id, false, errInvalid
+		}
+		ip, networks = ip[:n], ip[n:]
+	}
+	var secondaryIP string
+	if n := strings.Index(ip, ","); n != -1 {
+		ip, secondaryIP = ip[:n], ip[n+1:]
+	}
+	if ip == "" {
+		return id, false, errInvalid
+	}
+	id = peerIdentity{peerID, ip, secondaryIP, port, networks}
 	return id, complete, nil
 }
 
@@ -193,6 +245,8 @@ func (s *RedisStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error)
 	var peers []*core.PeerInfo
 	for id, complete := range selected {
 		p := core.NewPeerInfo(id.peerID, id.ip, id.port, false, complete)
+		p.SecondaryIP = id.secondaryIP
+		p.Addresses = id.addresses()
 		peers = append(peers, p)
 	}
 	return peers, nil
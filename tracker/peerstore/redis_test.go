@@ -56,6 +56,118 @@ func TestRedisStoreGetPeersPopulatesPeerInfoFields(t *testing.T) {
 	require.Equal(peers, []*core.PeerInfo{p})
 }
 
+func TestRedisStoreGetPeersIPv6(t *testing.T) {
+	tests := []struct {
+		desc        string
+		ip          string
+		secondaryIP string
+	}{
+		{"ipv6", "2001:db8::1", ""},
+		{"dual stack", "2001:db8::1", "10.0.0.1"},
+		{"dual stack ipv4 primary", "10.0.0.1", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			s, err := NewRedisStore(redisConfigFixture(), clock.New())
+			require.NoError(err)
+
+			h := core.InfoHashFixture()
+
+			p := core.PeerInfoFixture()
+			p.IP = test.ip
+			p.SecondaryIP = test.secondaryIP
+
+			require.NoError(s.UpdatePeer(h, p))
+
+			peers, err := s.GetPeers(h, 1)
+			require.NoError(err)
+			require.Equal(peers, []*core.PeerInfo{p})
+		})
+	}
+}
+
+func TestRedisStoreGetPeersMultiHomed(t *testing.T) {
+	require := require.New(t)
+
+	s, err := NewRedisStore(redisConfigFixture(), clock.New())
+	require.NoError(err)
+
+	h := core.InfoHashFixture()
+
+	p := core.PeerInfoFixture()
+	p.SecondaryIP = "2001:db8::1"
+	p.Addresses = []core.PeerAddress{
+		{Network: "storage", IP: "192.168.0.1"},
+		{Network: "backbone", IP: "fd00::1"},
+	}
+
+	require.NoError(s.UpdatePeer(h, p))
+
+	peers, err := s.GetPeers(h, 1)
+	require.NoError(err)
+	require.Equal(peers, []*core.PeerInfo{p})
+}
+
+func TestDeserializePeer(t *testing.T) {
+	pid := core.PeerIDFixture()
+
+	tests := []struct {
+		s        string
+		expected peerIdentity
+		complete bool
+	}{
+		{pid.String() + ":10.0.0.1:8000:1", peerIdentity{pid, "10.0.0.1", "", 8000, ""}, true},
+		{
+			pid.String() + ":2001:db8::1:8000:0",
+			peerIdentity{pid, "2001:db8::1", "", 8000, ""}, false,
+		},
+		{
+			pid.String() + ":2001:db8::1,10.0.0.1:8000:0",
+			peerIdentity{pid, "2001:db8::1", "10.0.0.1", 8000, ""}, false,
+		},
+		{
+			pid.String() + ":10.0.0.1,storage=192.168.0.1:8000:0",
+			peerIdentity{pid, "10.0.0.1", "", 8000, ",storage=192.168.0.1"}, false,
+		},
+		{
+			pid.String() + ":2001:db8::1,10.0.0.1,a=192.168.0.1,b=fd00::1:8000:1",
+			peerIdentity{pid, "2001:db8::1", "10.0.0.1", 8000, ",a=192.168.0.1,b=fd00::1"}, true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			require := require.New(t)
+
+			id, complete, err := deserializePeer(test.s)
+			require.NoError(err)
+			require.Equal(test.expected, id)
+			require.Equal(test.complete, complete)
+		})
+	}
+}
+
+func TestDeserializePeerErrors(t *testing.T) {
+	pid := core.PeerIDFixture().String()
+
+	for _, s := range []string{
+		"",
+		pid,
+		pid + ":1",
+		pid + ":8000:1",
+		pid + ":10.0.0.1:port:1",
+		pid + "::8000:1",
+		pid + ":storage=10.0.0.1:8000:1",
+		"pid:10.0.0.1:8000:1",
+	} {
+		t.Run(s, func(t *testing.T) {
+			_, _, err := deserializePeer(s)
+			require.Error(t, err)
+		})
+	}
+}
+
 func TestRedisStoreGetPeersFromMultipleWindows(t *testing.T) {
 	require := require.New(t)
 
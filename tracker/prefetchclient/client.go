@@ -0,0 +1,169 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetchclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// ErrNotFound is returned when a prefetch does not exist.
+var ErrNotFound = errors.New("prefetch not found")
+
+// _ringKey is the key all prefetch requests are routed by, such that creators
+// and agents agree on the tracker which holds prefetches.
+const _ringKey = "prefetches"
+
+// CreateRequest defines a request to prefetch the image of Tag on all agents
+// whose hostname matches the Hosts regular expression.
+type CreateRequest struct {
+	Tag   string `json:"tag"`
+	Hosts string `json:"hosts"`
+}
+
+// PendingPrefetch is the part of a prefetch an agent needs to run it. The
+// statuses of other hosts are left out, such that polls stay cheap regardless
+// of how many agents a prefetch targets.
+type PendingPrefetch struct {
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+
+	// Status is the status last reported by the requesting host, if any.
+	Status *prefetchstore.HostStatus `json:"status,omitempty"`
+}
+
+// NewPendingPrefetch returns the view of p for hostname.
+func NewPendingPrefetch(p *prefetchstore.Prefetch, hostname string) *PendingPrefetch {
+	return &PendingPrefetch{
+		ID:     p.ID,
+		Tag:    p.Tag,
+		Status: p.Statuses[hostname],
+	}
+}
+
+// PendingResponse defines the prefetches an agent must run, and the interval
+// the agent should wait before polling again.
+type PendingResponse struct {
+	Prefetches []*PendingPrefetch `json:"prefetches"`
+	Interval   time.Duration      `json:"interval"`
+}
+
+// Client defines a client for creating prefetches and reporting their status.
+type Client interface {
+	Create(tag, hosts string) (*prefetchstore.Prefetch, error)
+	Get(id string) (*prefetchstore.Prefetch, error)
+	Pending(hostname string) (*PendingResponse, error)
+	UpdateStatus(id, hostname string, status *prefetchstore.HostStatus) error
+}
+
+type client struct {
+	ring hashring.PassiveRing
+	tls  *tls.Config
+}
+
+// New creates a new Client.
+func New(ring hashring.PassiveRing, tls *tls.Config) Client {
+	return &client{ring, tls}
+}
+
+// send sends a request to the tracker responsible for prefetches, failing over
+// to the next tracker on network errors.
+func (c *client) send(method, path string, body interface{}, result interface{}) error {
+
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("json marshal: %s", err)
+		}
+	}
+	d, err := core.NewDigester().FromBytes([]byte(_ringKey))
+	if err != nil {
+		return fmt.Errorf("digest key: %s", err)
+	}
+	for _, addr := range c.ring.Locations(d) {
+		var resp *http.Response
+		resp, err = httputil.Send(
+			method,
+			fmt.Sprintf("http://%s%s", addr, path),
+			httputil.SendBody(bytes.NewReader(b)),
+			httputil.SendTimeout(10*time.Second),
+			httputil.SendTLS(c.tls))
+		if err != nil {
+			if httputil.IsNetworkError(err) {
+				c.ring.Failed(addr)
+				continue
+			}
+			if httputil.IsNotFound(err) {
+				return ErrNotFound
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		if result != nil {
+			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+				return fmt.Errorf("decode response: %s", err)
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// Create creates a new prefetch of tag for hosts.
+func (c *client) Create(tag, hosts string) (*prefetchstore.Prefetch, error) {
+	var p prefetchstore.Prefetch
+	req := &CreateRequest{Tag: tag, Hosts: hosts}
+	if err := c.send("POST", "/prefetches", req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Get returns the prefetch of id, including the status of each host.
+func (c *client) Get(id string) (*prefetchstore.Prefetch, error) {
+	var p prefetchstore.Prefetch
+	if err := c.send("GET", "/prefetches/"+url.PathEscape(id), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Pending returns the prefetches hostname has not yet finished.
+func (c *client) Pending(hostname string) (*PendingResponse, error) {
+	var resp PendingResponse
+	path := "/prefetches/pending?hostname=" + url.QueryEscape(hostname)
+	if err := c.send("GET", path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateStatus reports the status of hostname for the prefetch of id.
+func (c *client) UpdateStatus(id, hostname string, status *prefetchstore.HostStatus) error {
+	path := fmt.Sprintf("/prefetches/%s/hosts/%s", url.PathEscape(id), url.PathEscape(hostname))
+	return c.send("PUT", path, status, nil)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetchstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// LocalStore is an in-memory Store implementation.
+type LocalStore struct {
+	config LocalConfig
+	clk    clock.Clock
+
+	mu         sync.Mutex
+	prefetches map[string]*Prefetch
+}
+
+// NewLocalStore creates a new LocalStore.
+func NewLocalStore(config LocalConfig, clk clock.Clock) *LocalStore {
+	config.applyDefaults()
+	return &LocalStore{
+		config:     config,
+		clk:        clk,
+		prefetches: make(map[string]*Prefetch),
+	}
+}
+
+// Close implements Store.
+func (s *LocalStore) Close() {}
+
+// Add implements Store.
+func (s *LocalStore) Add(p *Prefetch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteExpired()
+	if _, ok := s.prefetches[p.ID]; ok {
+		return fmt.Errorf("prefetch %s already exists", p.ID)
+	}
+	s.prefetches[p.ID] = copyPrefetch(p)
+	return nil
+}
+
+// Get implements Store.
+func (s *LocalStore) Get(id string) (*Prefetch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteExpired()
+	p, ok := s.prefetches[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPrefetch(p), nil
+}
+
+// List implements Store.
+func (s *LocalStore) List() ([]*Prefetch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteExpired()
+	var result []*Prefetch
+	for _, p := range s.prefetches {
+		result = append(result, copyPrefetch(p))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// UpdateStatus implements Store.
+func (s *LocalStore) UpdateStatus(id, hostname string, status *HostStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteExpired()
+	p, ok := s.prefetches[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c := *status
+	p.Statuses[hostname] = &c
+	return nil
+}
+
+// deleteExpired must be called with s.mu held.
+func (s *LocalStore) deleteExpired() {
+	for id, p := range s.prefetches {
+		if s.clk.Now().Sub(p.CreatedAt) > s.config.TTL {
+			delete(s.prefetches, id)
+		}
+	}
+}
+
+func copyPrefetch(p *Prefetch) *Prefetch {
+	c := *p
+	c.Statuses = make(map[string]*HostStatus, len(p.Statuses))
+	for host, status := range p.Statuses {
+		sc := *status
+		c.Statuses[host] = &sc
+	}
+	return &c
+}
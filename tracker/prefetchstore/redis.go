@@ -0,0 +1,196 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetchstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/garyburd/redigo/redis"
+)
+
+const _prefetchSetKey = "prefetches"
+
+func prefetchKey(id string) string {
+	return fmt.Sprintf("prefetch:%s", id)
+}
+
+func statusesKey(id string) string {
+	return fmt.Sprintf("prefetch:%s:statuses", id)
+}
+
+// RedisStore is a Store backed by Redis, which allows prefetches to be shared
+// by multiple trackers.
+type RedisStore struct {
+	config RedisConfig
+	pool   *redis.Pool
+	clk    clock.Clock
+}
+
+// NewRedisStore creates a new RedisStore.
+func NewRedisStore(config RedisConfig, clk clock.Clock) (*RedisStore, error) {
+	config.applyDefaults()
+
+	if config.Addr == "" {
+		return nil, errors.New("invalid config: missing addr")
+	}
+
+	s := &RedisStore{
+		config: config,
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial(
+					"tcp",
+					config.Addr,
+					redis.DialConnectTimeout(config.DialTimeout),
+					redis.DialReadTimeout(config.ReadTimeout),
+					redis.DialWriteTimeout(config.WriteTimeout))
+			},
+			MaxIdle:     config.MaxIdleConns,
+			MaxActive:   config.MaxActiveConns,
+			IdleTimeout: config.IdleConnTimeout,
+			Wait:        true,
+		},
+		clk: clk,
+	}
+
+	// Ensure we can connect to Redis.
+	c, err := s.pool.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("dial redis: %s", err)
+	}
+	c.Close()
+
+	return s, nil
+}
+
+// Close implements Store.
+func (s *RedisStore) Close() {}
+
+// Add implements Store.
+func (s *RedisStore) Add(p *Prefetch) error {
+	c := s.pool.Get()
+	defer c.Close()
+
+	spec := *p
+	spec.Statuses = nil
+	b, err := json.Marshal(&spec)
+	if err != nil {
+		return fmt.Errorf("json marshal: %s", err)
+	}
+	ttl := int64(s.config.TTL.Seconds())
+	ok, err := redis.String(c.Do("SET", prefetchKey(p.ID), b, "EX", ttl, "NX"))
+	if err == redis.ErrNil {
+		return fmt.Errorf("prefetch %s already exists", p.ID)
+	} else if err != nil {
+		return fmt.Errorf("SET: %s", err)
+	} else if ok != "OK" {
+		return fmt.Errorf("SET: unexpected reply %q", ok)
+	}
+	if _, err := c.Do("SADD", _prefetchSetKey, p.ID); err != nil {
+		return fmt.Errorf("SADD: %s", err)
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *RedisStore) Get(id string) (*Prefetch, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	return s.get(c, id)
+}
+
+func (s *RedisStore) get(c redis.Conn, id string) (*Prefetch, error) {
+	b, err := redis.Bytes(c.Do("GET", prefetchKey(id)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("GET: %s", err)
+	}
+	var p Prefetch
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("json unmarshal prefetch: %s", err)
+	}
+	statuses, err := redis.StringMap(c.Do("HGETALL", statusesKey(id)))
+	if err != nil && err != redis.ErrNil {
+		return nil, fmt.Errorf("HGETALL: %s", err)
+	}
+	p.Statuses = make(map[string]*HostStatus, len(statuses))
+	for host, raw := range statuses {
+		var status HostStatus
+		if err := json.Unmarshal([]byte(raw), &status); err != nil {
+			return nil, fmt.Errorf("json unmarshal status of %s: %s", host, err)
+		}
+		p.Statuses[host] = &status
+	}
+	return &p, nil
+}
+
+// List implements Store. Expired prefetches are removed from the prefetch set
+// as a side effect.
+func (s *RedisStore) List() ([]*Prefetch, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	ids, err := redis.Strings(c.Do("SMEMBERS", _prefetchSetKey))
+	if err != nil && err != redis.ErrNil {
+		return nil, fmt.Errorf("SMEMBERS: %s", err)
+	}
+	var result []*Prefetch
+	for _, id := range ids {
+		p, err := s.get(c, id)
+		if err == ErrNotFound {
+			if _, err := c.Do("SREM", _prefetchSetKey, id); err != nil {
+				return nil, fmt.Errorf("SREM: %s", err)
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// UpdateStatus implements Store.
+func (s *RedisStore) UpdateStatus(id, hostname string, status *HostStatus) error {
+	c := s.pool.Get()
+	defer c.Close()
+
+	exists, err := redis.Bool(c.Do("EXISTS", prefetchKey(id)))
+	if err != nil {
+		return fmt.Errorf("EXISTS: %s", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("json marshal: %s", err)
+	}
+	if _, err := c.Do("HSET", statusesKey(id), hostname, b); err != nil {
+		return fmt.Errorf("HSET: %s", err)
+	}
+	if _, err := c.Do("EXPIRE", statusesKey(id), int64(s.config.TTL.Seconds())); err != nil {
+		return fmt.Errorf("EXPIRE: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetchstore
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber/kraken/utils/log"
+)
+
+// ErrNotFound is returned when a prefetch does not exist.
+var ErrNotFound = errors.New("prefetch not found")
+
+// Host states reported by agents.
+const (
+	StateRunning  = "running"
+	StateComplete = "complete"
+	StateFailed   = "failed"
+)
+
+// HostStatus is the progress of a prefetch on a single host.
+type HostStatus struct {
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Done returns true if the host will make no further progress.
+func (s *HostStatus) Done() bool {
+	return s.State == StateComplete || s.State == StateFailed
+}
+
+// Prefetch is a request for all agents matching Hosts to download the image
+// identified by Tag ahead of time.
+type Prefetch struct {
+	ID        string                 `json:"id"`
+	Tag       string                 `json:"tag"`
+	Hosts     string                 `json:"hosts"`
+	CreatedAt time.Time              `json:"created_at"`
+	Statuses  map[string]*HostStatus `json:"statuses"`
+}
+
+// Matches returns true if hostname is targeted by p.
+func (p *Prefetch) Matches(hostname string) (bool, error) {
+	re, err := regexp.Compile(p.Hosts)
+	if err != nil {
+		return false, fmt.Errorf("compile hosts regexp: %s", err)
+	}
+	return re.MatchString(hostname), nil
+}
+
+// ParseTag returns the repository of tag, which must be of the form repo:tag.
+// The repository may include a registry host with a port.
+func ParseTag(tag string) (string, error) {
+	i := strings.LastIndex(tag, ":")
+	if i <= 0 || i == len(tag)-1 || strings.Contains(tag[i+1:], "/") {
+		return "", fmt.Errorf("invalid tag %q: expected repo:tag", tag)
+	}
+	return tag[:i], nil
+}
+
+// Store provides storage for prefetch requests and their per-host status.
+type Store interface {
+	// Close cleans up any Store resources.
+	Close()
+
+	// Add adds a new prefetch.
+	Add(p *Prefetch) error
+
+	// Get returns the prefetch for id, including all host statuses.
+	Get(id string) (*Prefetch, error)
+
+	// List returns all prefetches which have not expired.
+	List() ([]*Prefetch, error)
+
+	// UpdateStatus sets the status of hostname for the prefetch of id.
+	UpdateStatus(id, hostname string, status *HostStatus) error
+}
+
+// Pending returns the prefetches in s which target hostname and which
+// hostname has not completed. Failed prefetches remain pending such that
+// hostname may retry them.
+func Pending(s Store, hostname string) ([]*Prefetch, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Prefetch
+	for _, p := range all {
+		ok, err := p.Matches(hostname)
+		if err != nil {
+			log.With("id", p.ID).Errorf("Error matching prefetch hosts: %s", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if status, ok := p.Statuses[hostname]; ok && status.State == StateComplete {
+			continue
+		}
+		pending = append(pending, p)
+	}
+	return pending, nil
+}
+
+// New creates a new Store implementation based on config.
+func New(config Config) (Store, error) {
+	if config.Redis.Enabled {
+		log.Info("Redis prefetch store enabled")
+		s, err := NewRedisStore(config.Redis, clock.New())
+		if err != nil {
+			return nil, fmt.Errorf("new redis store: %s", err)
+		}
+		return s, nil
+	}
+	log.Info("Defaulting to local prefetch store")
+	return NewLocalStore(config.Local, clock.New()), nil
+}
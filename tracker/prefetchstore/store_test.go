@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package prefetchstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func redisConfigFixture() RedisConfig {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	return RedisConfig{Addr: s.Addr()}
+}
+
+func storesFixture(t *testing.T) map[string]Store {
+	r, err := NewRedisStore(redisConfigFixture(), clock.New())
+	require.NoError(t, err)
+	return map[string]Store{
+		"local": NewLocalStore(LocalConfig{}, clock.New()),
+		"redis": r,
+	}
+}
+
+func TestStoreAddGetUpdateStatus(t *testing.T) {
+	for name, s := range storesFixture(t) {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			p := PrefetchFixture("^host-[0-9]+$")
+
+			_, err := s.Get(p.ID)
+			require.Equal(ErrNotFound, err)
+
+			require.NoError(s.Add(p))
+			require.Error(s.Add(p))
+
+			result, err := s.Get(p.ID)
+			require.NoError(err)
+			require.Equal(p, result)
+
+			status := &HostStatus{
+				State:     StateComplete,
+				UpdatedAt: time.Now().Truncate(time.Second),
+			}
+			require.NoError(s.UpdateStatus(p.ID, "host-1", status))
+
+			result, err = s.Get(p.ID)
+			require.NoError(err)
+			require.Equal(status.State, result.Statuses["host-1"].State)
+			require.True(status.UpdatedAt.Equal(result.Statuses["host-1"].UpdatedAt))
+
+			require.Equal(ErrNotFound, s.UpdateStatus("unknown", "host-1", status))
+		})
+	}
+}
+
+func TestPendingFiltersByHostAndStatus(t *testing.T) {
+	for name, s := range storesFixture(t) {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			p1 := PrefetchFixture("^host-1$")
+			p2 := PrefetchFixture("^host-[0-9]+$")
+			p3 := PrefetchFixture("^other$")
+			for _, p := range []*Prefetch{p1, p2, p3} {
+				require.NoError(s.Add(p))
+			}
+
+			pending, err := Pending(s, "host-1")
+			require.NoError(err)
+			require.Len(pending, 2)
+
+			require.NoError(s.UpdateStatus(p1.ID, "host-1", &HostStatus{State: StateFailed}))
+			require.NoError(s.UpdateStatus(p2.ID, "host-1", &HostStatus{State: StateComplete}))
+
+			// Failed prefetches remain pending so they are retried.
+			pending, err = Pending(s, "host-1")
+			require.NoError(err)
+			require.Len(pending, 1)
+			require.Equal(p1.ID, pending[0].ID)
+
+			pending, err = Pending(s, "host-2")
+			require.NoError(err)
+			require.Len(pending, 1)
+			require.Equal(p2.ID, pending[0].ID)
+		})
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		repo string
+	}{
+		{"repo:tag", "repo"},
+		{"namespace/repo:tag", "namespace/repo"},
+		{"localhost:5000/namespace/repo:tag", "localhost:5000/namespace/repo"},
+	}
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			repo, err := ParseTag(test.tag)
+			require.NoError(t, err)
+			require.Equal(t, test.repo, repo)
+		})
+	}
+}
+
+func TestParseTagErrors(t *testing.T) {
+	for _, tag := range []string{"", "repo", ":tag", "repo:", "localhost:5000/repo"} {
+		t.Run(tag, func(t *testing.T) {
+			_, err := ParseTag(tag)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLocalStoreExpiresPrefetches(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+
+	s := NewLocalStore(LocalConfig{TTL: time.Hour}, clk)
+
+	p := PrefetchFixture(".*")
+	p.CreatedAt = clk.Now()
+	require.NoError(s.Add(p))
+
+	clk.Add(2 * time.Hour)
+
+	_, err := s.Get(p.ID)
+	require.Equal(ErrNotFound, err)
+
+	ps, err := s.List()
+	require.NoError(err)
+	require.Empty(ps)
+}
@@ -16,6 +16,7 @@ package trackerserver
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/uber/kraken/core"
@@ -35,7 +36,7 @@ func (s *Server) announceHandlerV1(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, req.InfoHash, req.Peer)
+	resp, err := s.announce(d, req.InfoHash, req.Peer, req.Exclude, observedIP(r))
 	if err != nil {
 		return err
 	}
@@ -62,7 +63,7 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, h, req.Peer)
+	resp, err := s.announce(d, h, req.Peer, req.Exclude, observedIP(r))
 	if err != nil {
 		return err
 	}
@@ -73,25 +74,54 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 }
 
 func (s *Server) announce(
-	d core.Digest, h core.InfoHash, peer *core.PeerInfo) (*announceclient.Response, error) {
+	d core.Digest,
+	h core.InfoHash,
+	peer *core.PeerInfo,
+	exclude []core.PeerID,
+	observed string) (*announceclient.Response, error) {
 
+	if observed != "" && !peer.HasIP(observed) {
+		// The peer is likely behind a NAT or advertising the wrong interface.
+		s.stats.Counter("observed_ip_mismatch").Inc(1)
+	}
 	if err := s.peerStore.UpdatePeer(h, peer); err != nil {
 		log.With(
 			"hash", h,
 			"peer_id", peer.PeerID).Errorf("Error updating peer: %s", err)
 	}
-	peers, err := s.getPeerHandout(d, h, peer)
+	peers, err := s.getPeerHandout(d, h, peer, exclude)
 	if err != nil {
 		return nil, err
 	}
 	return &announceclient.Response{
-		Peers:    peers,
-		Interval: s.config.AnnounceInterval,
+		Peers:      peers,
+		Interval:   s.config.AnnounceInterval,
+		ObservedIP: observed,
 	}, nil
 }
 
+// observedIP returns the source address of an announce request as seen by the
+// tracker, preferring the X-Real-IP header set by the nginx proxy. Returns
+// empty string if the address is unknown.
+func observedIP(r *http.Request) string {
+	if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
 func (s *Server) getPeerHandout(
-	d core.Digest, h core.InfoHash, peer *core.PeerInfo) ([]*core.PeerInfo, error) {
+	d core.Digest,
+	h core.InfoHash,
+	peer *core.PeerInfo,
+	exclude []core.PeerID) ([]*core.PeerInfo, error) {
 
 	if peer.Complete {
 		// If the peer is announcing as complete, don't return a peer handout since
@@ -99,7 +129,9 @@ func (s *Server) getPeerHandout(
 		return nil, nil
 	}
 	var errs []error
-	peers, err := s.peerStore.GetPeers(h, s.config.PeerHandoutLimit)
+	// Sample extra peers to make up for excluded ones, within reason.
+	limit := s.config.PeerHandoutLimit + min(len(exclude), s.config.PeerHandoutLimit)
+	peers, err := s.peerStore.GetPeers(h, limit)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("peer store: %s", err))
 	}
@@ -107,9 +139,56 @@ func (s *Server) getPeerHandout(
 	if err != nil {
 		errs = append(errs, fmt.Errorf("origin store: %s", err))
 	}
-	peers = append(peers, origins...)
-	if len(peers) == 0 {
+	peers = excludePeers(peers, exclude)
+	if len(peers) > s.config.PeerHandoutLimit {
+		peers = peers[:s.config.PeerHandoutLimit]
+	}
+	peers = append(peers, excludePeers(origins, exclude)...)
+	peers = s.reachablePeers(peer, peers)
+	if len(peers) == 0 && len(exclude) == 0 {
+		// Handouts emptied by exclude are expected, since the peer already knows
+		// the excluded peers.
 		return nil, handler.Errorf("no peers available: %s", errutil.Join(errs))
 	}
 	return s.policy.SortPeers(peer, peers), nil
 }
+
+// excludePeers filters out peers whose ids are in exclude.
+func excludePeers(peers []*core.PeerInfo, exclude []core.PeerID) []*core.PeerInfo {
+	if len(exclude) == 0 {
+		return peers
+	}
+	excluded := make(map[core.PeerID]bool, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+	result := make([]*core.PeerInfo, 0, len(peers))
+	for _, p := range peers {
+		if !excluded[p.PeerID] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// reachablePeers filters out peers which peer cannot reach because they share
+// no IP family, and resolves dual-stack peers to a single reachable address.
+func (s *Server) reachablePeers(peer *core.PeerInfo, peers []*core.PeerInfo) []*core.PeerInfo {
+	result := make([]*core.PeerInfo, 0, len(peers))
+	for _, p := range peers {
+		r, ok := p.ReachableFrom(peer)
+		if !ok {
+			s.stats.Counter("unreachable_peers").Inc(1)
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
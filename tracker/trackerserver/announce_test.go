@@ -16,6 +16,7 @@ package trackerserver
 import (
 	"errors"
 	"fmt"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func newAnnounceClient(pctx core.PeerContext, addr string) announceclient.Client {
@@ -60,7 +62,7 @@ func TestAnnounceSinglePeerResponse(t *testing.T) {
 				blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
 
 			result, interval, err := client.Announce(
-				blob.Digest, blob.MetaInfo.InfoHash(), false, version)
+				blob.Digest, blob.MetaInfo.InfoHash(), false, version, nil)
 			require.NoError(err)
 			require.Equal(peers, result)
 			require.Equal(config.AnnounceInterval, interval)
@@ -92,7 +94,7 @@ func TestAnnounceUnavailablePeerStoreCanStillProvideOrigins(t *testing.T) {
 	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(origins, nil)
 
 	result, _, err := client.Announce(
-		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2, nil)
 	require.NoError(err)
 	require.Equal(origins, result)
 }
@@ -120,11 +122,54 @@ func TestAnnouceUnavailableOriginClusterCanStillProvidePeers(t *testing.T) {
 	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, errors.New("some error"))
 
 	result, _, err := client.Announce(
-		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2)
+		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2, nil)
 	require.NoError(err)
 	require.Equal(peers, result)
 }
 
+func TestAnnounceHandsOutReachableAddresses(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	// IPv4-only peer.
+	pctx := core.PeerContextFixture()
+	pctx.IP = "10.0.0.1"
+	blob := core.NewBlobFixture()
+
+	client := newAnnounceClient(pctx, addr)
+
+	ipv4Peer := core.PeerInfoFixture()
+	ipv4Peer.IP = "10.0.0.2"
+	ipv6Peer := core.PeerInfoFixture()
+	ipv6Peer.IP = "2001:db8::3"
+	dualStackPeer := core.PeerInfoFixture()
+	dualStackPeer.IP = "2001:db8::4"
+	dualStackPeer.SecondaryIP = "10.0.0.4"
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+	mocks.peerStore.EXPECT().GetPeers(
+		blob.MetaInfo.InfoHash(), gomock.Any()).Return(
+		[]*core.PeerInfo{ipv4Peer, ipv6Peer, dualStackPeer}, nil)
+	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+
+	result, _, err := client.Announce(
+		blob.Digest, blob.MetaInfo.InfoHash(), false, announceclient.V2, nil)
+	require.NoError(err)
+
+	var ips []string
+	for _, p := range result {
+		ips = append(ips, p.IP)
+		require.Empty(p.SecondaryIP)
+	}
+	require.ElementsMatch([]string{"10.0.0.2", "10.0.0.4"}, ips)
+}
+
 func TestAnnounceRequestGetDigestBackwardsCompatibility(t *testing.T) {
 	d := core.DigestFixture()
 	h := core.InfoHashFixture()
@@ -168,3 +213,104 @@ func TestAnnounceRequestGetDigestBackwardsCompatibility(t *testing.T) {
 		})
 	}
 }
+
+func TestAnnounceExcludesPeers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	blob := core.NewBlobFixture()
+	h := blob.MetaInfo.InfoHash()
+
+	client := newAnnounceClient(pctx, addr)
+
+	connected := core.PeerInfoFixture()
+	other := core.PeerInfoFixture()
+	origin := core.OriginPeerInfoFixture()
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		h, core.PeerInfoFromContext(pctx, false)).Return(nil).Times(2)
+	mocks.peerStore.EXPECT().GetPeers(h, gomock.Any()).Return(
+		[]*core.PeerInfo{connected, other}, nil).Times(2)
+	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(
+		[]*core.PeerInfo{origin}, nil).Times(2)
+
+	result, _, err := client.Announce(
+		blob.Digest, h, false, announceclient.V2, []core.PeerID{connected.PeerID})
+	require.NoError(err)
+	require.ElementsMatch([]*core.PeerInfo{other, origin}, result)
+
+	// Excluding every peer results in an empty handout, not an error.
+	result, _, err = client.Announce(
+		blob.Digest, h, false, announceclient.V2,
+		[]core.PeerID{connected.PeerID, other.PeerID, origin.PeerID})
+	require.NoError(err)
+	require.Empty(result)
+}
+
+func TestAnnounceClientAdoptsObservedIP(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	// Announced address is not the address the tracker sees, as if the peer
+	// were behind a NAT.
+	pctx := core.PeerContextFixture()
+	pctx.IP = "10.0.0.1"
+	blob := core.NewBlobFixture()
+	h := blob.MetaInfo.InfoHash()
+
+	client := announceclient.New(
+		pctx, hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil,
+		announceclient.WithObservedIP())
+
+	observed := core.PeerInfoFromContext(pctx, true)
+	observed.IP = "127.0.0.1"
+
+	gomock.InOrder(
+		mocks.peerStore.EXPECT().UpdatePeer(h, core.PeerInfoFromContext(pctx, true)).Return(nil),
+		mocks.peerStore.EXPECT().UpdatePeer(h, observed).Return(nil),
+	)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := client.Announce(blob.Digest, h, true, announceclient.V2, nil)
+		require.NoError(err)
+	}
+	counters := mocks.stats.(tally.TestScope).Snapshot().Counters()
+	require.Equal(
+		int64(1), counters["testing.observed_ip_mismatch+module=trackerserver"].Value())
+}
+
+func TestObservedIP(t *testing.T) {
+	tests := []struct {
+		desc       string
+		remoteAddr string
+		realIP     string
+		expected   string
+	}{
+		{"remote addr", "10.0.0.1:5000", "", "10.0.0.1"},
+		{"ipv6 remote addr", "[2001:db8::1]:5000", "", "2001:db8::1"},
+		{"real ip header", "127.0.0.1:5000", "10.0.0.2", "10.0.0.2"},
+		{"invalid real ip header", "10.0.0.1:5000", "foo", "10.0.0.1"},
+		{"unknown", "foo", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/announce", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			require.Equal(t, test.expected, observedIP(r))
+		})
+	}
+}
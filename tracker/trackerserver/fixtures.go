@@ -16,11 +16,13 @@ package trackerserver
 import (
 	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/prefetchstore"
 )
 
 // Fixture is a test utility which returns a tracker server with in-memory storage.
@@ -31,5 +33,6 @@ func Fixture() *Server {
 	}
 	return New(
 		config, tally.NoopScope, policy,
-		peerstore.NewTestStore(), originstore.NewNoopStore(), nil)
+		peerstore.NewTestStore(), originstore.NewNoopStore(),
+		prefetchstore.NewLocalStore(prefetchstore.LocalConfig{}, clock.New()), nil)
 }
@@ -0,0 +1,129 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/randutil"
+)
+
+// createPrefetchHandler registers a prefetch of an image tag for all agents
+// matching a hostname selector. Agents learn about the prefetch when polling
+// for pending prefetches.
+func (s *Server) createPrefetchHandler(w http.ResponseWriter, r *http.Request) error {
+	var req prefetchclient.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if _, err := prefetchstore.ParseTag(req.Tag); err != nil {
+		return handler.Errorf("%s", err).Status(http.StatusBadRequest)
+	}
+	if _, err := regexp.Compile(req.Hosts); err != nil {
+		return handler.Errorf("invalid hosts regexp: %s", err).Status(http.StatusBadRequest)
+	}
+	p := &prefetchstore.Prefetch{
+		ID:        randutil.Hex(16),
+		Tag:       req.Tag,
+		Hosts:     req.Hosts,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Statuses:  make(map[string]*prefetchstore.HostStatus),
+	}
+	if err := s.prefetchStore.Add(p); err != nil {
+		return handler.Errorf("prefetch store: %s", err)
+	}
+	s.stats.Counter("prefetches_created").Inc(1)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+// getPrefetchHandler returns a prefetch along with the status of each host
+// which has reported progress.
+func (s *Server) getPrefetchHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := httputil.ParseParam(r, "id")
+	if err != nil {
+		return err
+	}
+	p, err := s.prefetchStore.Get(id)
+	if err != nil {
+		if err == prefetchstore.ErrNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("prefetch store: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+// getPendingPrefetchesHandler returns the prefetches which the requesting host
+// has not finished, along with the interval the host should poll at. Only the
+// status of the requesting host is included.
+func (s *Server) getPendingPrefetchesHandler(w http.ResponseWriter, r *http.Request) error {
+	hostname := httputil.GetQueryArg(r, "hostname", "")
+	if hostname == "" {
+		return handler.Errorf("missing hostname query arg").Status(http.StatusBadRequest)
+	}
+	pending, err := prefetchstore.Pending(s.prefetchStore, hostname)
+	if err != nil {
+		return handler.Errorf("prefetch store: %s", err)
+	}
+	resp := &prefetchclient.PendingResponse{
+		Prefetches: make([]*prefetchclient.PendingPrefetch, len(pending)),
+		Interval:   s.config.AnnounceInterval,
+	}
+	for i, p := range pending {
+		resp.Prefetches[i] = prefetchclient.NewPendingPrefetch(p, hostname)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
+
+// updatePrefetchStatusHandler records the progress of a host on a prefetch.
+func (s *Server) updatePrefetchStatusHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := httputil.ParseParam(r, "id")
+	if err != nil {
+		return err
+	}
+	hostname, err := httputil.ParseParam(r, "hostname")
+	if err != nil {
+		return err
+	}
+	var status prefetchstore.HostStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := s.prefetchStore.UpdateStatus(id, hostname, &status); err != nil {
+		if err == prefetchstore.ErrNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("prefetch store: %s", err)
+	}
+	s.stats.Tagged(map[string]string{
+		"state": status.State,
+	}).Counter("prefetch_status_updates").Inc(1)
+	return nil
+}
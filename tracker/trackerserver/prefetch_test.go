@@ -0,0 +1,199 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/prefetchclient"
+	"github.com/uber/kraken/tracker/prefetchstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newPrefetchClient(addrs ...string) prefetchclient.Client {
+	return prefetchclient.New(hashring.NoopPassiveRing(hostlist.Fixture(addrs...)), nil)
+}
+
+func TestPrefetchLifecycle(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{AnnounceInterval: 5 * time.Second}
+	mocks, cleanup := newServerMocks(t, config)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newPrefetchClient(addr)
+
+	tag := core.TagFixture()
+
+	p, err := client.Create(tag, "^agent-[0-9]+$")
+	require.NoError(err)
+	require.Equal(tag, p.Tag)
+
+	resp, err := client.Pending("agent-1")
+	require.NoError(err)
+	require.Equal(config.AnnounceInterval, resp.Interval)
+	require.Len(resp.Prefetches, 1)
+	require.Equal(p.ID, resp.Prefetches[0].ID)
+	require.Equal(tag, resp.Prefetches[0].Tag)
+	require.Nil(resp.Prefetches[0].Status)
+
+	resp, err = client.Pending("build-host")
+	require.NoError(err)
+	require.Empty(resp.Prefetches)
+
+	require.NoError(client.UpdateStatus(p.ID, "agent-1", &prefetchstore.HostStatus{
+		State: prefetchstore.StateRunning,
+	}))
+	require.NoError(client.UpdateStatus(p.ID, "agent-2", &prefetchstore.HostStatus{
+		State: prefetchstore.StateFailed,
+		Error: "some error",
+	}))
+
+	// Only the status of the requesting host is returned.
+	resp, err = client.Pending("agent-2")
+	require.NoError(err)
+	require.Len(resp.Prefetches, 1)
+	require.Equal(prefetchstore.StateFailed, resp.Prefetches[0].Status.State)
+	require.Equal("some error", resp.Prefetches[0].Status.Error)
+
+	require.NoError(client.UpdateStatus(p.ID, "agent-1", &prefetchstore.HostStatus{
+		State: prefetchstore.StateComplete,
+	}))
+
+	resp, err = client.Pending("agent-1")
+	require.NoError(err)
+	require.Empty(resp.Prefetches)
+
+	result, err := client.Get(p.ID)
+	require.NoError(err)
+	require.Equal(prefetchstore.StateComplete, result.Statuses["agent-1"].State)
+	require.Equal(prefetchstore.StateFailed, result.Statuses["agent-2"].State)
+	require.Equal("some error", result.Statuses["agent-2"].Error)
+}
+
+func TestPrefetchesRoutedToSingleTracker(t *testing.T) {
+	require := require.New(t)
+
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		mocks, cleanup := newServerMocks(t, Config{})
+		defer cleanup()
+
+		addr, stop := testutil.StartServer(mocks.handler())
+		defer stop()
+
+		addrs = append(addrs, addr)
+	}
+
+	client := newPrefetchClient(addrs...)
+
+	var ids []string
+	for i := 0; i < 10; i++ {
+		p, err := client.Create(core.TagFixture(), ".*")
+		require.NoError(err)
+		ids = append(ids, p.ID)
+	}
+
+	for i := 0; i < 10; i++ {
+		hostname := fmt.Sprintf("agent-%d", i)
+		resp, err := client.Pending(hostname)
+		require.NoError(err)
+		var pending []string
+		for _, p := range resp.Prefetches {
+			pending = append(pending, p.ID)
+		}
+		require.ElementsMatch(ids, pending)
+
+		for _, id := range ids {
+			require.NoError(client.UpdateStatus(id, hostname, &prefetchstore.HostStatus{
+				State: prefetchstore.StateComplete,
+			}))
+		}
+	}
+
+	for _, id := range ids {
+		p, err := client.Get(id)
+		require.NoError(err)
+		require.Len(p.Statuses, 10)
+	}
+}
+
+func TestPrefetchNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newPrefetchClient(addr)
+
+	_, err := client.Get("unknown")
+	require.Equal(prefetchclient.ErrNotFound, err)
+
+	require.Equal(prefetchclient.ErrNotFound, client.UpdateStatus(
+		"unknown", "agent-1", &prefetchstore.HostStatus{State: prefetchstore.StateRunning}))
+}
+
+func TestCreatePrefetchAcceptsRegistryHostWithPort(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	p, err := newPrefetchClient(addr).Create("localhost:5000/repo:tag", ".*")
+	require.NoError(err)
+	require.Equal("localhost:5000/repo:tag", p.Tag)
+}
+
+func TestCreatePrefetchRejectsInvalidRequests(t *testing.T) {
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newPrefetchClient(addr)
+
+	tests := []struct {
+		desc  string
+		tag   string
+		hosts string
+	}{
+		{"invalid tag", "no-tag", ".*"},
+		{"tag missing from registry host", "localhost:5000/repo", ".*"},
+		{"invalid hosts", core.TagFixture(), "("},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := client.Create(test.tag, test.hosts)
+			require.True(t, httputil.IsStatus(err, 400))
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/prefetchstore"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/listener"
 	"github.com/uber/kraken/utils/log"
@@ -37,9 +38,10 @@ type Server struct {
 	config Config
 	stats  tally.Scope
 
-	peerStore   peerstore.Store
-	originStore originstore.Store
-	policy      *peerhandoutpolicy.PriorityPolicy
+	peerStore     peerstore.Store
+	originStore   originstore.Store
+	prefetchStore prefetchstore.Store
+	policy        *peerhandoutpolicy.PriorityPolicy
 
 	originCluster blobclient.ClusterClient
 }
@@ -51,6 +53,7 @@ func New(
 	policy *peerhandoutpolicy.PriorityPolicy,
 	peerStore peerstore.Store,
 	originStore originstore.Store,
+	prefetchStore prefetchstore.Store,
 	originCluster blobclient.ClusterClient) *Server {
 
 	config = config.applyDefaults()
@@ -64,6 +67,7 @@ func New(
 		stats:         stats,
 		peerStore:     peerStore,
 		originStore:   originStore,
+		prefetchStore: prefetchStore,
 		policy:        policy,
 		originCluster: originCluster,
 	}
@@ -81,6 +85,11 @@ func (s *Server) Handler() http.Handler {
 	r.Post("/announce/{infohash}", handler.Wrap(s.announceHandlerV2))
 	r.Get("/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.getMetaInfoHandler))
 
+	r.Post("/prefetches", handler.Wrap(s.createPrefetchHandler))
+	r.Get("/prefetches/pending", handler.Wrap(s.getPendingPrefetchesHandler))
+	r.Get("/prefetches/{id}", handler.Wrap(s.getPrefetchHandler))
+	r.Put("/prefetches/{id}/hosts/{hostname}", handler.Wrap(s.updatePrefetchStatusHandler))
+
 	r.Mount("/debug", chimiddleware.Profiler())
 
 	return r
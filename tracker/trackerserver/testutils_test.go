@@ -21,7 +21,9 @@ import (
 	"github.com/uber/kraken/mocks/tracker/originstore"
 	"github.com/uber/kraken/mocks/tracker/peerstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
+	"github.com/uber/kraken/tracker/prefetchstore"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/uber-go/tally"
 )
@@ -32,6 +34,7 @@ type serverMocks struct {
 	ctrl          *gomock.Controller
 	peerStore     *mockpeerstore.MockStore
 	originStore   *mockoriginstore.MockStore
+	prefetchStore prefetchstore.Store
 	originCluster *mockblobclient.MockClusterClient
 	stats         tally.Scope
 }
@@ -43,6 +46,7 @@ func newServerMocks(t *testing.T, config Config) (*serverMocks, func()) {
 		policy:        peerhandoutpolicy.DefaultPriorityPolicyFixture(),
 		peerStore:     mockpeerstore.NewMockStore(ctrl),
 		originStore:   mockoriginstore.NewMockStore(ctrl),
+		prefetchStore: prefetchstore.NewLocalStore(prefetchstore.LocalConfig{}, clock.New()),
 		originCluster: mockblobclient.NewMockClusterClient(ctrl),
 		stats:         tally.NewTestScope("testing", nil),
 	}, ctrl.Finish
@@ -55,5 +59,6 @@ func (m *serverMocks) handler() http.Handler {
 		m.policy,
 		m.peerStore,
 		m.originStore,
+		m.prefetchStore,
 		m.originCluster).Handler()
 }
@@ -50,3 +50,31 @@ func ManifestFixture(config core.Digest, layer1 core.Digest, layer2 core.Digest)
 
 	return d, raw
 }
+
+// ReferrerManifestFixture creates an OCI artifact manifest blob of artifactType
+// which refers to subject, for testing purposes.
+func ReferrerManifestFixture(subject core.Digest, artifactType string) (core.Digest, []byte) {
+	raw := []byte(fmt.Sprintf(`{
+	   "schemaVersion": 2,
+	   "mediaType": "application/vnd.oci.image.manifest.v1+json",
+	   "artifactType": "%s",
+	   "config": {
+		  "mediaType": "application/vnd.oci.empty.v1+json",
+		  "size": 2,
+		  "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	   },
+	   "layers": [],
+	   "subject": {
+		  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+		  "size": 1024,
+		  "digest": "%s"
+	   }
+	}`, artifactType, subject))
+
+	d, err := core.NewDigester().FromBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return d, raw
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/uber/kraken/core"
+)
+
+// MediaTypeImageIndex is the media type of OCI image indexes, which are used
+// to list the referrers of a manifest.
+const MediaTypeImageIndex = "application/vnd.oci.image.index.v1+json"
+
+// Descriptor describes content referenced by an image index.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageIndex is an OCI image index. It is the response body of the referrers
+// API and the content stored under referrers tags.
+type ImageIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// NewImageIndex returns an empty ImageIndex.
+func NewImageIndex() *ImageIndex {
+	return &ImageIndex{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageIndex,
+		Manifests:     []Descriptor{},
+	}
+}
+
+// ParseImageIndex parses an ImageIndex from b.
+func ParseImageIndex(b []byte) (*ImageIndex, error) {
+	index := NewImageIndex()
+	if err := json.Unmarshal(b, index); err != nil {
+		return nil, fmt.Errorf("json: %s", err)
+	}
+	if index.Manifests == nil {
+		index.Manifests = []Descriptor{}
+	}
+	return index, nil
+}
+
+// Add adds desc to the index. Returns false if a descriptor with the same
+// digest already exists.
+func (i *ImageIndex) Add(desc Descriptor) bool {
+	for _, m := range i.Manifests {
+		if m.Digest == desc.Digest {
+			return false
+		}
+	}
+	i.Manifests = append(i.Manifests, desc)
+	return true
+}
+
+// Filter returns a copy of the index which only contains descriptors of the
+// given artifactType.
+func (i *ImageIndex) Filter(artifactType string) *ImageIndex {
+	filtered := NewImageIndex()
+	for _, m := range i.Manifests {
+		if m.ArtifactType == artifactType {
+			filtered.Manifests = append(filtered.Manifests, m)
+		}
+	}
+	return filtered
+}
+
+// ReferrersTag returns the tag under which the referrers index of d is stored,
+// as defined by the referrers tag schema fallback of the OCI distribution
+// spec, e.g. "sha256-<hex>".
+func ReferrersTag(d core.Digest) string {
+	return fmt.Sprintf("%s-%s", d.Algo(), d.Hex())
+}
+
+// ParseReferrersTag parses the subject digest of tag (either "repo:tag" or
+// "tag") if it follows the referrers tag schema.
+func ParseReferrersTag(tag string) (core.Digest, bool) {
+	if i := strings.LastIndex(tag, ":"); i != -1 {
+		tag = tag[i+1:]
+	}
+	if !strings.HasPrefix(tag, core.SHA256+"-") {
+		return core.Digest{}, false
+	}
+	d, err := core.NewSHA256DigestFromHex(strings.TrimPrefix(tag, core.SHA256+"-"))
+	if err != nil {
+		return core.Digest{}, false
+	}
+	return d, true
+}
+
+// IsGeneratedReferrersIndex returns true if b is exactly the referrers index
+// which is generated for subject from the manifests it lists, i.e. it is not
+// arbitrary content pushed under a referrers tag. Listed manifests are fetched
+// using getManifest.
+func IsGeneratedReferrersIndex(
+	subject core.Digest, b []byte, getManifest func(core.Digest) ([]byte, error)) (bool, error) {
+
+	index, err := ParseImageIndex(b)
+	if err != nil || index.MediaType != MediaTypeImageIndex {
+		return false, nil
+	}
+	generated := NewImageIndex()
+	for _, m := range index.Manifests {
+		d, err := core.ParseSHA256Digest(m.Digest)
+		if err != nil {
+			return false, nil
+		}
+		manifest, err := getManifest(d)
+		if err != nil {
+			return false, fmt.Errorf("get manifest %s: %s", d, err)
+		}
+		s, desc, ok, err := ParseReferrer(d, manifest)
+		if err != nil || !ok || s != subject {
+			return false, nil
+		}
+		generated.Add(desc)
+	}
+	expected, err := json.Marshal(generated)
+	if err != nil {
+		return false, fmt.Errorf("json: %s", err)
+	}
+	return bytes.Equal(expected, b), nil
+}
+
+type referrerManifest struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType"`
+	Config       *Descriptor       `json:"config"`
+	Subject      *Descriptor       `json:"subject"`
+	Annotations  map[string]string `json:"annotations"`
+}
+
+// ParseReferrer parses the manifest b with digest d. If the manifest has a
+// subject, returns the subject digest and a descriptor of the manifest to be
+// added to the subject's referrers index. Returns false if the manifest has
+// no subject.
+func ParseReferrer(d core.Digest, b []byte) (core.Digest, Descriptor, bool, error) {
+	var m referrerManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return core.Digest{}, Descriptor{}, false, fmt.Errorf("json: %s", err)
+	}
+	if m.Subject == nil {
+		return core.Digest{}, Descriptor{}, false, nil
+	}
+	subject, err := core.ParseSHA256Digest(m.Subject.Digest)
+	if err != nil {
+		return core.Digest{}, Descriptor{}, false, fmt.Errorf("parse subject digest: %s", err)
+	}
+	artifactType := m.ArtifactType
+	if artifactType == "" && m.Config != nil {
+		artifactType = m.Config.MediaType
+	}
+	desc := Descriptor{
+		MediaType:    m.MediaType,
+		Digest:       d.String(),
+		Size:         int64(len(b)),
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}
+	return subject, desc, true, nil
+}
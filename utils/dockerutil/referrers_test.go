@@ -0,0 +1,153 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dockerutil
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReferrer(t *testing.T) {
+	require := require.New(t)
+
+	subject := core.DigestFixture()
+	d, manifest := ReferrerManifestFixture(subject, "application/spdx+json")
+
+	result, desc, ok, err := ParseReferrer(d, manifest)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(subject, result)
+	require.Equal(Descriptor{
+		MediaType:    "application/vnd.oci.image.manifest.v1+json",
+		Digest:       d.String(),
+		Size:         int64(len(manifest)),
+		ArtifactType: "application/spdx+json",
+	}, desc)
+}
+
+func TestParseReferrerNoSubject(t *testing.T) {
+	require := require.New(t)
+
+	d, manifest := ManifestFixture(core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+
+	_, _, ok, err := ParseReferrer(d, manifest)
+	require.NoError(err)
+	require.False(ok)
+}
+
+func TestImageIndexAddAndFilter(t *testing.T) {
+	require := require.New(t)
+
+	index := NewImageIndex()
+	sbom := Descriptor{
+		Digest:       core.DigestFixture().String(),
+		ArtifactType: "application/spdx+json",
+	}
+	sig := Descriptor{
+		Digest:       core.DigestFixture().String(),
+		ArtifactType: "application/vnd.dev.sig",
+	}
+
+	require.True(index.Add(sbom))
+	require.True(index.Add(sig))
+	require.False(index.Add(sbom))
+	require.Equal([]Descriptor{sbom, sig}, index.Manifests)
+
+	require.Equal([]Descriptor{sig}, index.Filter("application/vnd.dev.sig").Manifests)
+	require.Empty(index.Filter("unknown").Manifests)
+}
+
+func TestReferrersTag(t *testing.T) {
+	require := require.New(t)
+
+	d := core.DigestFixture()
+	tag := ReferrersTag(d)
+	require.Equal("sha256-"+d.Hex(), tag)
+
+	for _, t := range []string{tag, "some/repo:" + tag} {
+		subject, ok := ParseReferrersTag(t)
+		require.True(ok)
+		require.Equal(d, subject)
+	}
+	invalid := []string{"some/repo:latest", "some/repo:sha256-foo", "some/repo:" + tag + ".sig"}
+	for _, t := range invalid {
+		_, ok := ParseReferrersTag(t)
+		require.False(ok)
+	}
+}
+
+func TestIsGeneratedReferrersIndex(t *testing.T) {
+	subject := core.DigestFixture()
+	referrer, referrerManifest := ReferrerManifestFixture(subject, "application/spdx+json")
+	other, otherManifest := ReferrerManifestFixture(core.DigestFixture(), "application/spdx+json")
+	image, imageManifest := ManifestFixture(
+		core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+
+	manifests := map[core.Digest][]byte{
+		referrer: referrerManifest,
+		other:    otherManifest,
+		image:    imageManifest,
+	}
+	getManifest := func(d core.Digest) ([]byte, error) {
+		b, ok := manifests[d]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return b, nil
+	}
+
+	indexOf := func(digests ...core.Digest) []byte {
+		index := NewImageIndex()
+		for _, d := range digests {
+			_, desc, ok, err := ParseReferrer(d, manifests[d])
+			require.NoError(t, err)
+			if !ok {
+				desc = Descriptor{
+					MediaType: "application/vnd.docker.distribution.manifest.v2+json",
+					Digest:    d.String(),
+					Size:      int64(len(manifests[d])),
+				}
+			}
+			index.Add(desc)
+		}
+		b, err := json.Marshal(index)
+		require.NoError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		desc     string
+		index    []byte
+		expected bool
+	}{
+		{"generated", indexOf(referrer), true},
+		{"empty", indexOf(), true},
+		{"referrer of other subject", indexOf(referrer, other), false},
+		{"regular image", indexOf(image), false},
+		{"not an index", imageManifest, false},
+		{"reformatted", append(indexOf(referrer), '\n'), false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ok, err := IsGeneratedReferrersIndex(subject, test.index, getManifest)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, ok)
+		})
+	}
+}
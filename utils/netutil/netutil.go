@@ -64,8 +64,46 @@ func GetIP(host string) (net.IP, error) {
 	return nil, errors.New("no ips found")
 }
 
-// GetLocalIP returns the ip address of the local machine.
+// GetLocalIP returns the ip address of the local machine. IPv4 addresses are
+// preferred, falling back to IPv6 addresses on IPv6-only machines.
 func GetLocalIP() (string, error) {
+	ip, err := getLocalIP(false)
+	if err != nil {
+		return getLocalIP(true)
+	}
+	return ip, nil
+}
+
+// GetSecondaryLocalIP returns the ip address of a dual-stack local machine in
+// the IP family other than primary's.
+func GetSecondaryLocalIP(primary string) (string, error) {
+	ip := net.ParseIP(primary)
+	if ip == nil {
+		return "", fmt.Errorf("invalid primary ip %q", primary)
+	}
+	return getLocalIP(ip.To4() != nil)
+}
+
+// GetInterfaceIP returns the ip address of the named local interface. IPv4
+// addresses are preferred, falling back to IPv6 addresses.
+func GetInterfaceIP(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("interface: %s", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("addrs: %s", err)
+	}
+	for _, ipv6 := range []bool{false, true} {
+		if ip := firstIP(addrs, ipv6); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no ip found on interface %s", name)
+}
+
+func getLocalIP(ipv6 bool) (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return "", fmt.Errorf("interfaces: %s", err)
@@ -76,23 +114,8 @@ func GetLocalIP() (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("addrs: %s", err)
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.IsLoopback() {
-				continue
-			}
-			ip = ip.To4()
-			if ip == nil {
-				continue
-			}
+		if ip := firstIP(addrs, ipv6); ip != nil {
 			ips[i.Name] = ip.String()
-			break
 		}
 	}
 	for _, i := range _supportedInterfaces {
@@ -102,3 +125,32 @@ func GetLocalIP() (string, error) {
 	}
 	return "", errors.New("no ip found")
 }
+
+// firstIP returns the first non-loopback ip of addrs in the given family.
+func firstIP(addrs []net.Addr, ipv6 bool) net.IP {
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+		if ipv6 {
+			// Link-local addresses are not reachable by other hosts.
+			if ip.To4() != nil || !ip.IsGlobalUnicast() {
+				continue
+			}
+		} else {
+			ip = ip.To4()
+			if ip == nil {
+				continue
+			}
+		}
+		return ip
+	}
+	return nil
+}